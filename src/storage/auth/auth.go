@@ -0,0 +1,203 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"hypertube_storage/parser/env"
+
+	"github.com/sirupsen/logrus"
+)
+
+var ErrUnauthorized = errors.New("user is not authorized")
+
+// токены auth сервиса не отзываются, поэтому проверенные можно недолго помнить и не
+// ходить в auth на каждый range запрос плеера
+const tokenCacheTtl = time.Minute * 5
+
+// ссылка должна пережить просмотр длинного фильма с паузами
+const SignedUrlTtl = time.Hour * 6
+
+// Checker validates access tokens of the auth service and HMAC signed urls which
+// are used where the token can't be sent, e.g. in <video src>
+type Checker struct {
+	authHost     string
+	serverPasswd string
+	secret       []byte
+	client       *http.Client
+
+	mu     sync.Mutex
+	tokens map[string]cachedToken
+}
+
+type cachedToken struct {
+	userId  uint
+	checked time.Time
+}
+
+// SignedAccess is the query of a signed url: "user", "expires" and "signature"
+type SignedAccess struct {
+	UserId    uint   `json:"user"`
+	Expires   int64  `json:"expires"`
+	Signature string `json:"signature"`
+	Query     string `json:"query"`
+}
+
+var (
+	checker     *Checker
+	checkerOnce sync.Once
+)
+
+func GetChecker() *Checker {
+	checkerOnce.Do(func() {
+		parser := env.GetParser()
+		checker = NewChecker(parser.GetAuthServiceHost(), parser.GetAuthServerPasswd(), parser.GetUrlSignSecret())
+	})
+	return checker
+}
+
+func NewChecker(authHost, serverPasswd, secret string) *Checker {
+	c := &Checker{
+		authHost:     authHost,
+		serverPasswd: serverPasswd,
+		secret:       []byte(secret),
+		client:       &http.Client{Timeout: time.Second * 10},
+		tokens:       make(map[string]cachedToken),
+	}
+	if len(c.secret) == 0 {
+		// подписанные ссылки перестанут работать после рестарта, но не будут подделаны
+		logrus.Warn("STORAGE_URL_SECRET is not set, signed urls are valid until restart")
+		c.secret = make([]byte, 32)
+		if _, err := rand.Read(c.secret); err != nil {
+			logrus.Fatalf("Error generating url sign secret: %v", err)
+		}
+	}
+	return c
+}
+
+// TokenUserId reads user id from the header of the access token without checking
+// the signature
+func TokenUserId(accessToken string) (uint, error) {
+	decoded, err := base64.StdEncoding.DecodeString(accessToken)
+	if err != nil {
+		return 0, fmt.Errorf("%w: token is not base64", ErrUnauthorized)
+	}
+	parts := strings.Split(string(decoded), ".")
+	if len(parts) != 2 {
+		return 0, fmt.Errorf("%w: token should have 2 parts, got %v", ErrUnauthorized, len(parts))
+	}
+	headerJson, err := base64.StdEncoding.DecodeString(parts[0])
+	if err != nil {
+		return 0, fmt.Errorf("%w: token header is not base64", ErrUnauthorized)
+	}
+	var header struct {
+		UserId uint `json:"userId"`
+	}
+	if err := json.Unmarshal(headerJson, &header); err != nil || header.UserId == 0 {
+		return 0, fmt.Errorf("%w: invalid token header", ErrUnauthorized)
+	}
+	return header.UserId, nil
+}
+
+// CheckAccessToken asks the auth service to check the token signature and returns
+// the user of the token
+func (c *Checker) CheckAccessToken(ctx context.Context, accessToken string) (uint, error) {
+	c.mu.Lock()
+	cached, ok := c.tokens[accessToken]
+	c.mu.Unlock()
+	if ok && time.Since(cached.checked) < tokenCacheTtl {
+		return cached.userId, nil
+	}
+
+	userId, err := TokenUserId(accessToken)
+	if err != nil {
+		return 0, err
+	}
+
+	body, _ := json.Marshal(map[string]string{"serverPasswd": c.serverPasswd, "accessToken": accessToken})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf("http://%s/api/auth/check", c.authHost), bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("error calling auth service: %v", err)
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusUnauthorized:
+		return 0, fmt.Errorf("%w: token signature check failed", ErrUnauthorized)
+	default:
+		return 0, fmt.Errorf("not ok status from auth service: %v", resp.Status)
+	}
+
+	c.mu.Lock()
+	now := time.Now()
+	for token, entry := range c.tokens {
+		if now.Sub(entry.checked) >= tokenCacheTtl {
+			delete(c.tokens, token)
+		}
+	}
+	c.tokens[accessToken] = cachedToken{userId: userId, checked: now}
+	c.mu.Unlock()
+	return userId, nil
+}
+
+func (c *Checker) sign(userId uint, fileId string, expires int64) string {
+	mac := hmac.New(sha256.New, c.secret)
+	_, _ = fmt.Fprintf(mac, "%d:%s:%d", userId, fileId, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// SignFileAccess allows the user to read the record without the token until expires
+func (c *Checker) SignFileAccess(userId uint, fileId string, expires time.Time) SignedAccess {
+	access := SignedAccess{UserId: userId, Expires: expires.Unix()}
+	access.Signature = c.sign(userId, fileId, access.Expires)
+	access.Query = url.Values{
+		"user":      {strconv.FormatUint(uint64(userId), 10)},
+		"expires":   {strconv.FormatInt(access.Expires, 10)},
+		"signature": {access.Signature},
+	}.Encode()
+	return access
+}
+
+func IsSignedQuery(query url.Values) bool {
+	return query.Get("signature") != ""
+}
+
+// CheckSignedQuery validates "user", "expires" and "signature" params of the url,
+// the signature is bound to the record
+func (c *Checker) CheckSignedQuery(query url.Values, fileId string) (uint, error) {
+	userId, err := strconv.ParseUint(query.Get("user"), 10, 64)
+	if err != nil || userId == 0 {
+		return 0, fmt.Errorf("%w: invalid user of signed url", ErrUnauthorized)
+	}
+	expires, err := strconv.ParseInt(query.Get("expires"), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w: invalid expires of signed url", ErrUnauthorized)
+	}
+	if time.Now().Unix() > expires {
+		return 0, fmt.Errorf("%w: signed url expired", ErrUnauthorized)
+	}
+	expected := c.sign(uint(userId), fileId, expires)
+	if !hmac.Equal([]byte(expected), []byte(query.Get("signature"))) {
+		return 0, fmt.Errorf("%w: invalid signature", ErrUnauthorized)
+	}
+	return uint(userId), nil
+}
@@ -0,0 +1,88 @@
+package auth
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func makeToken(userId uint, signature string) string {
+	header := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf(`{"userId":%d}`, userId)))
+	return base64.StdEncoding.EncodeToString([]byte(header + "." + signature))
+}
+
+func TestCheckAccessToken(t *testing.T) {
+	checks := 0
+	authService := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			ServerPasswd string `json:"serverPasswd"`
+			AccessToken  string `json:"accessToken"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		checks++
+		if r.URL.Path != "/api/auth/check" || body.ServerPasswd != "passwd" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if body.AccessToken != makeToken(7, "good") {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer authService.Close()
+	checker := NewChecker(strings.TrimPrefix(authService.URL, "http://"), "passwd", "secret")
+
+	for i := 0; i < 2; i++ {
+		userId, err := checker.CheckAccessToken(context.Background(), makeToken(7, "good"))
+		if err != nil || userId != 7 {
+			t.Fatalf("got user %v, err %v", userId, err)
+		}
+	}
+	if checks != 1 {
+		t.Errorf("auth service called %v times, expected 1", checks)
+	}
+
+	if _, err := checker.CheckAccessToken(context.Background(), makeToken(7, "bad")); !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("expected unauthorized, got %v", err)
+	}
+	if _, err := checker.CheckAccessToken(context.Background(), "not a token"); !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("expected unauthorized for garbage, got %v", err)
+	}
+	if _, err := NewChecker(strings.TrimPrefix(authService.URL, "http://"), "wrong", "secret").CheckAccessToken(context.Background(), makeToken(7, "good")); err == nil || errors.Is(err, ErrUnauthorized) {
+		t.Errorf("expected auth service error, got %v", err)
+	}
+}
+
+func TestSignedQuery(t *testing.T) {
+	checker := NewChecker("", "", "secret")
+	access := checker.SignFileAccess(7, "file1", time.Now().Add(time.Hour))
+	query, _ := url.ParseQuery(access.Query)
+
+	if userId, err := checker.CheckSignedQuery(query, "file1"); err != nil || userId != 7 {
+		t.Errorf("got user %v, err %v", userId, err)
+	}
+	if _, err := checker.CheckSignedQuery(query, "file2"); !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("signature of other file accepted: %v", err)
+	}
+	if _, err := NewChecker("", "", "other").CheckSignedQuery(query, "file1"); !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("signature with other secret accepted: %v", err)
+	}
+
+	tampered, _ := url.ParseQuery(access.Query)
+	tampered.Set("user", "8")
+	if _, err := checker.CheckSignedQuery(tampered, "file1"); !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("tampered user accepted: %v", err)
+	}
+
+	expired, _ := url.ParseQuery(checker.SignFileAccess(7, "file1", time.Now().Add(-time.Second)).Query)
+	if _, err := checker.CheckSignedQuery(expired, "file1"); !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("expired url accepted: %v", err)
+	}
+}
@@ -2,6 +2,8 @@ package dao
 
 import (
 	"context"
+	"io"
+	"os"
 
 	"hypertube_storage/model"
 )
@@ -16,21 +18,53 @@ type LoadedFilesDbManager interface {
 	UpdateLastWatchedDate(fileId string)
 }
 
+type UserSubtitlesDbManager interface {
+	AddUserSubtitles(subs model.UserSubtitles) error
+	GetUserSubtitles(fileId string) ([]model.UserSubtitles, error)
+	GetUserSubtitlesById(fileId, subtitlesId string) (model.UserSubtitles, error)
+	DeleteUserSubtitles(fileId, subtitlesId string) error
+}
+
+type WatchProgressDbManager interface {
+	SaveProgress(progress model.WatchProgress) error
+	SaveBytePosition(userId uint, fileId string, positionBytes int64) error
+	GetProgress(userId uint, fileId string) (model.WatchProgress, error)
+	GetRecentProgress(userId uint, limit int) ([]model.WatchProgress, error)
+}
+
+// SubtitleProvider searches subtitles in an external catalog by hash of the video
+// and IMDb id, ids of the results are the provider's own
+type SubtitleProvider interface {
+	Name() string
+	Search(ctx context.Context, query model.SubtitlesQuery) ([]model.ExternalSubtitles, error)
+	Download(ctx context.Context, providerId string) ([]byte, error)
+}
+
 type FileReader interface {
-	WaitForFilePart(ctx context.Context, fileName string, start int64, expectedLen int64) ([]byte, int64, error)
-	WaitForWholeFileWritten(ctx context.Context, fileName string) ([]byte, error)
-	GetFileInRange(fileName string, start int64, expectedLen int64) (result []byte, totalLength int64, err error)
-	ReadWholeFile(fileName string) ([]byte, error)
-	HasNullBytes(src []byte) bool
-	HasNotNullBytes(src []byte) bool
-	IsPartWritten(fileName string, part []byte, start int64) bool
+	OpenFile(fileName string) (*os.File, error)
+	OpenStream(ctx context.Context, fileId string, file model.FileInfo, isLoaded bool) (FileStream, error)
+	WriteFile(fileName string, data []byte) error
 	RemoveFile(fileName string) bool
+	RangeCacheStats() model.RangeCacheStats
+}
+
+// FileStream reads a file which may be still downloading, Read and ReadAt block
+// until the loader reports the pieces under the read position as written.
+// NextDownloaded lets the demuxer skip not written parts instead of waiting
+type FileStream interface {
+	io.ReadSeeker
+	io.ReaderAt
+	io.Closer
+	WaitRange(start, end int64) error
+	WriteRange(w io.Writer, start, length int64) (int64, error)
+	NextDownloaded(offset int64) (start, end int64)
 }
 
 type LoaderStateDbManager interface {
 	InitConnection()
 	CloseConnection()
 
-	GetSliceIndexesForFile(fileName string) []int64
+	GetCompletedRanges(fileName string) model.ByteRanges
+	WatchCompletedRanges(ctx context.Context) chan model.RangesUpdate
 	PubPriorityByteIdx(fileId, fileName string, idx int64)
 }
@@ -10,6 +10,14 @@ func GetLoadedFilesManager() dao.LoadedFilesDbManager {
 	return &postgres.Manager
 }
 
+func GetUserSubtitlesManager() dao.UserSubtitlesDbManager {
+	return &postgres.Manager
+}
+
+func GetWatchProgressManager() dao.WatchProgressDbManager {
+	return &postgres.Manager
+}
+
 func GetLoadedStateDb() dao.LoaderStateDbManager  {
 	return &redis.Manager
 }
\ No newline at end of file
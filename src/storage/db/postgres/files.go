@@ -10,10 +10,12 @@ import (
 
 func (d *manager) GetFileInfoById(id string) (info model.LoadInfo, err error)  {
 	query := `
-SELECT coalesce(file_name, ''), file_length, in_progress, is_loaded FROM %s WHERE file_id LIKE $1`
+SELECT coalesce(file_name, ''), file_length, original_file_name, file_extension, in_progress, is_loaded
+FROM %s WHERE file_id LIKE $1`
 
 	err = d.conn.QueryRow(fmt.Sprintf(query, d.LoadedFilesTablePath()), id).Scan(
-		&info.VideoFile.Name, &info.VideoFile.Length, &info.InProgress, &info.IsLoaded)
+		&info.VideoFile.Name, &info.VideoFile.Length, &info.VideoFile.OriginalName, &info.VideoFile.Extension,
+		&info.InProgress, &info.IsLoaded)
 	return info, err
 }
 
@@ -42,7 +44,7 @@ SELECT file_id FROM %s WHERE in_progress=true`
 }
 
 func (d *manager) UpdateLastWatchedDate(fileId string) {
-	query := `UPDATE %s SET last_watched = now()::timestamp WHERE file_id=$1`
+	query := `UPDATE %s SET last_watched = now()::timestamp, hits = hits + 1 WHERE file_id=$1`
 
 	if _, err := d.conn.Exec(fmt.Sprintf(query, d.LoadedFilesTablePath()), fileId); err != nil {
 		logrus.Errorf("Error deleteing loaded file record: %v", err)
@@ -13,8 +13,10 @@ var Manager manager
 type manager struct {
 	conn *sqlx.DB
 
-	schemaName       string
-	loadedFilesTable string
+	schemaName         string
+	loadedFilesTable   string
+	userSubtitlesTable string
+	watchProgressTable string
 }
 
 func (d *manager) InitTables() {
@@ -45,12 +47,95 @@ func (d *manager) InitTables() {
 		logrus.Fatalf("Error creating table %v: %v", d.LoadedFilesTablePath(), err)
 	}
 
+	query = `alter table %s add column if not exists hits bigint default 0 not null`
+
+	if _, err := d.conn.Exec(fmt.Sprintf(query, d.LoadedFilesTablePath())); err != nil {
+		logrus.Fatalf("Error migrating table %v: %v", d.LoadedFilesTablePath(), err)
+	}
+
+	query = `alter table %s add column if not exists info_hash varchar(64) default ''::character varying not null`
+
+	if _, err := d.conn.Exec(fmt.Sprintf(query, d.LoadedFilesTablePath())); err != nil {
+		logrus.Fatalf("Error migrating table %v: %v", d.LoadedFilesTablePath(), err)
+	}
+
+	query = `alter table %s add column if not exists original_file_name varchar(256) default ''::character varying not null,
+	add column if not exists file_extension varchar(16) default ''::character varying not null`
+
+	if _, err := d.conn.Exec(fmt.Sprintf(query, d.LoadedFilesTablePath())); err != nil {
+		logrus.Fatalf("Error migrating table %v: %v", d.LoadedFilesTablePath(), err)
+	}
+
+	// файлы записи на диске для eraser, записи из локальных файлов заново не скачать
+	query = `alter table %s add column if not exists disk_files text default ''::text not null,
+	add column if not exists is_local boolean default false not null`
+
+	if _, err := d.conn.Exec(fmt.Sprintf(query, d.LoadedFilesTablePath())); err != nil {
+		logrus.Fatalf("Error migrating table %v: %v", d.LoadedFilesTablePath(), err)
+	}
+
+	query = `create index if not exists %s_info_hash_idx on %s (info_hash)`
+
+	if _, err := d.conn.Exec(fmt.Sprintf(query, d.loadedFilesTable, d.LoadedFilesTablePath())); err != nil {
+		logrus.Fatalf("Error creating index on %v: %v", d.LoadedFilesTablePath(), err)
+	}
+
+	query = `create table if not exists %s
+(
+    id           serial                                      not null
+        constraint %s_pk
+            primary key,
+    subtitles_id varchar(64)   unique                        not null,
+    file_id      varchar(64)                                 not null,
+    language     varchar(16)   default ''::character varying not null,
+    label        varchar(64)   default ''::character varying not null,
+    format       varchar(16)   default ''::character varying not null,
+    uploader_id  bigint                                      not null,
+    size         bigint        default 0                     not null,
+    created_at   timestamp     default now()::timestamp      not null
+)`
+
+	if _, err := d.conn.Exec(fmt.Sprintf(query, d.UserSubtitlesTablePath(), d.userSubtitlesTable)); err != nil {
+		logrus.Fatalf("Error creating table %v: %v", d.UserSubtitlesTablePath(), err)
+	}
+
+	query = `create index if not exists %s_file_id_idx on %s (file_id)`
+
+	if _, err := d.conn.Exec(fmt.Sprintf(query, d.userSubtitlesTable, d.UserSubtitlesTablePath())); err != nil {
+		logrus.Fatalf("Error creating index on %v: %v", d.UserSubtitlesTablePath(), err)
+	}
+
+	query = `create table if not exists %s
+(
+    user_id        bigint                                     not null,
+    file_id        varchar(64)                                not null,
+    position       double precision default 0                 not null,
+    position_bytes bigint           default 0                 not null,
+    duration       double precision default 0                 not null,
+    completed      boolean          default false             not null,
+    updated_at     timestamp        default now()::timestamp  not null,
+    constraint %s_pk
+        primary key (user_id, file_id)
+)`
+
+	if _, err := d.conn.Exec(fmt.Sprintf(query, d.WatchProgressTablePath(), d.watchProgressTable)); err != nil {
+		logrus.Fatalf("Error creating table %v: %v", d.WatchProgressTablePath(), err)
+	}
+
+	query = `create index if not exists %s_updated_at_idx on %s (user_id, updated_at desc)`
+
+	if _, err := d.conn.Exec(fmt.Sprintf(query, d.watchProgressTable, d.WatchProgressTablePath())); err != nil {
+		logrus.Fatalf("Error creating index on %v: %v", d.WatchProgressTablePath(), err)
+	}
+
 }
 
 func (d *manager) InitConnection(connStr string) {
 	db := manager{
-		schemaName:       "hypertube",
-		loadedFilesTable: "loaded_files",
+		schemaName:         "hypertube",
+		loadedFilesTable:   "loaded_files",
+		userSubtitlesTable: "user_subtitles",
+		watchProgressTable: "watch_progress",
 	}
 	conn, err := sqlx.Open("postgres", connStr)
 	if err != nil {
@@ -0,0 +1,71 @@
+package postgres
+
+import (
+	"fmt"
+
+	"hypertube_storage/model"
+)
+
+// SaveProgress writes the heartbeat of the player, the byte position is kept
+// when the player doesn't know it
+func (d *manager) SaveProgress(progress model.WatchProgress) error {
+	query := `
+INSERT INTO %s AS p (user_id, file_id, position, position_bytes, duration, completed)
+VALUES ($1, $2, $3, $4, $5, $6)
+ON CONFLICT (user_id, file_id) DO UPDATE SET
+	position = excluded.position,
+	position_bytes = CASE WHEN excluded.position_bytes > 0 THEN excluded.position_bytes ELSE p.position_bytes END,
+	duration = CASE WHEN excluded.duration > 0 THEN excluded.duration ELSE p.duration END,
+	completed = excluded.completed,
+	updated_at = now()::timestamp`
+
+	_, err := d.conn.Exec(fmt.Sprintf(query, d.WatchProgressTablePath()), progress.UserId, progress.FileId,
+		progress.Position, progress.PositionBytes, progress.Duration, progress.Completed)
+	return err
+}
+
+func (d *manager) SaveBytePosition(userId uint, fileId string, positionBytes int64) error {
+	query := `
+INSERT INTO %s (user_id, file_id, position_bytes)
+VALUES ($1, $2, $3)
+ON CONFLICT (user_id, file_id) DO UPDATE SET
+	position_bytes = excluded.position_bytes,
+	updated_at = now()::timestamp`
+
+	_, err := d.conn.Exec(fmt.Sprintf(query, d.WatchProgressTablePath()), userId, fileId, positionBytes)
+	return err
+}
+
+func (d *manager) GetProgress(userId uint, fileId string) (progress model.WatchProgress, err error) {
+	query := `
+SELECT user_id, file_id, position, position_bytes, duration, completed, updated_at
+FROM %s WHERE user_id=$1 AND file_id=$2`
+
+	err = d.conn.QueryRow(fmt.Sprintf(query, d.WatchProgressTablePath()), userId, fileId).Scan(
+		&progress.UserId, &progress.FileId, &progress.Position, &progress.PositionBytes,
+		&progress.Duration, &progress.Completed, &progress.UpdatedAt)
+	return progress, err
+}
+
+func (d *manager) GetRecentProgress(userId uint, limit int) ([]model.WatchProgress, error) {
+	query := `
+SELECT user_id, file_id, position, position_bytes, duration, completed, updated_at
+FROM %s WHERE user_id=$1 ORDER BY updated_at DESC LIMIT $2`
+
+	rows, err := d.conn.Query(fmt.Sprintf(query, d.WatchProgressTablePath()), userId, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make([]model.WatchProgress, 0, limit)
+	for rows.Next() {
+		var progress model.WatchProgress
+		if err := rows.Scan(&progress.UserId, &progress.FileId, &progress.Position, &progress.PositionBytes,
+			&progress.Duration, &progress.Completed, &progress.UpdatedAt); err != nil {
+			return nil, err
+		}
+		result = append(result, progress)
+	}
+	return result, rows.Err()
+}
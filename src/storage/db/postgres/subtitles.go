@@ -0,0 +1,58 @@
+package postgres
+
+import (
+	"fmt"
+
+	"hypertube_storage/model"
+)
+
+func (d *manager) AddUserSubtitles(subs model.UserSubtitles) error {
+	query := `
+INSERT INTO %s (subtitles_id, file_id, language, label, format, uploader_id, size)
+VALUES ($1, $2, $3, $4, $5, $6, $7)`
+
+	_, err := d.conn.Exec(fmt.Sprintf(query, d.UserSubtitlesTablePath()),
+		subs.Id, subs.FileId, subs.Language, subs.Label, subs.Format, subs.UploaderId, subs.Size)
+	return err
+}
+
+func (d *manager) GetUserSubtitles(fileId string) ([]model.UserSubtitles, error) {
+	query := `
+SELECT subtitles_id, file_id, language, label, format, uploader_id, size, created_at
+FROM %s WHERE file_id=$1 ORDER BY created_at`
+
+	rows, err := d.conn.Query(fmt.Sprintf(query, d.UserSubtitlesTablePath()), fileId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make([]model.UserSubtitles, 0, 4)
+	for rows.Next() {
+		var subs model.UserSubtitles
+		if err := rows.Scan(&subs.Id, &subs.FileId, &subs.Language, &subs.Label, &subs.Format,
+			&subs.UploaderId, &subs.Size, &subs.CreatedAt); err != nil {
+			return nil, err
+		}
+		result = append(result, subs)
+	}
+	return result, rows.Err()
+}
+
+func (d *manager) GetUserSubtitlesById(fileId, subtitlesId string) (subs model.UserSubtitles, err error) {
+	query := `
+SELECT subtitles_id, file_id, language, label, format, uploader_id, size, created_at
+FROM %s WHERE file_id=$1 AND subtitles_id=$2`
+
+	err = d.conn.QueryRow(fmt.Sprintf(query, d.UserSubtitlesTablePath()), fileId, subtitlesId).Scan(
+		&subs.Id, &subs.FileId, &subs.Language, &subs.Label, &subs.Format,
+		&subs.UploaderId, &subs.Size, &subs.CreatedAt)
+	return subs, err
+}
+
+func (d *manager) DeleteUserSubtitles(fileId, subtitlesId string) error {
+	query := `DELETE FROM %s WHERE file_id=$1 AND subtitles_id=$2`
+
+	_, err := d.conn.Exec(fmt.Sprintf(query, d.UserSubtitlesTablePath()), fileId, subtitlesId)
+	return err
+}
@@ -3,3 +3,12 @@ package postgres
 func (d *manager) LoadedFilesTablePath() string  {
 	return d.schemaName + "." + d.loadedFilesTable
 }
+
+
+func (d *manager) UserSubtitlesTablePath() string  {
+	return d.schemaName + "." + d.userSubtitlesTable
+}
+
+func (d *manager) WatchProgressTablePath() string  {
+	return d.schemaName + "." + d.watchProgressTable
+}
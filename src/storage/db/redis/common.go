@@ -1,8 +1,11 @@
 package redis
 
 import (
+	"context"
 	"fmt"
+	"strings"
 
+	"hypertube_storage/model"
 	"hypertube_storage/parser/env"
 
 	"github.com/go-redis/redis"
@@ -10,7 +13,7 @@ import (
 )
 
 const (
-	fileSliceIndexes       = "slices"
+	completedRanges       = "ranges"
 )
 
 type manager struct {
@@ -19,17 +22,51 @@ type manager struct {
 
 var Manager manager
 
-func (m *manager) GetSliceIndexesKey(fileName string) string {
-	return fmt.Sprintf("%s:%s", fileSliceIndexes, fileName)
+func (m *manager) GetCompletedRangesKey(fileName string) string {
+	return fmt.Sprintf("%s:%s", completedRanges, fileName)
 }
 
-func (m *manager) GetSliceIndexesForFile(fileName string) (slices []int64) {
-	err := m.conn.Sort(m.GetSliceIndexesKey(fileName), &redis.Sort{Order: "ASC"}).ScanSlice(&slices)
+func (m *manager) GetCompletedRanges(fileName string) model.ByteRanges {
+	encoded, err := m.conn.Get(m.GetCompletedRangesKey(fileName)).Result()
+	if err != nil && err != redis.Nil {
+		logrus.Errorf("Error GetCompletedRanges: %v", err)
+	}
+	ranges, err := model.ParseByteRanges(encoded)
 	if err != nil {
-		logrus.Errorf("Error GetSliceIndexesForFile: %v", err)
+		logrus.Errorf("Error parsing completed ranges of %v: %v", fileName, err)
 	}
+	return ranges
+}
 
-	return slices
+// WatchCompletedRanges returns updates of files whose completed ranges changed,
+// one subscription serves all readers. The eraser publishes empty ranges when
+// it deletes the file
+func (m *manager) WatchCompletedRanges(ctx context.Context) chan model.RangesUpdate {
+	sub := m.conn.PSubscribe(m.GetCompletedRangesKey("*"))
+	updatesChan := make(chan model.RangesUpdate, 100)
+
+	go func() {
+		defer sub.Close()
+		defer close(updatesChan)
+		messages := sub.Channel()
+		for {
+			select {
+			case <- ctx.Done():
+				return
+			case msg, ok := <- messages:
+				if !ok {
+					logrus.Errorf("Completed ranges subscription is closed")
+					return
+				}
+				updatesChan <- model.RangesUpdate{
+					FileName: strings.TrimPrefix(msg.Channel, m.GetCompletedRangesKey("")),
+					Erased:   msg.Payload == "",
+				}
+			}
+		}
+	}()
+
+	return updatesChan
 }
 
 func (m *manager) PubPriorityByteIdx(fileId, fileName string, idx int64) {
@@ -0,0 +1,192 @@
+package demuxer
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+const (
+	ContainerMatroska = "matroska"
+	ContainerMp4      = "mp4"
+)
+
+// text formats understood by subtitlesManager
+const (
+	FormatSrt = "srt"
+	FormatAss = "ass"
+	FormatVtt = "vtt"
+)
+
+// subtitles blocks without duration are shown this long
+const defaultCueDuration = 3 * time.Second
+
+// the largest header element (Tracks, moov) read into memory
+const maxHeaderElementSize = 64 << 20
+
+type Track struct {
+	Id       int    `json:"id"`
+	Codec    string `json:"codec"`
+	Format   string `json:"format,omitempty"` // пусто, если кодек не поддерживается
+	Language string `json:"language,omitempty"`
+	Name     string `json:"name,omitempty"`
+	Default  bool   `json:"default"`
+	Forced   bool   `json:"forced"`
+
+	codecPrivate []byte
+}
+
+type Cue struct {
+	Start time.Duration
+	End   time.Duration
+	Text  string
+}
+
+type Subtitles struct {
+	Track    Track
+	Cues     []Cue
+	Complete bool // false if some blocks were in not yet downloaded parts
+}
+
+var ErrUnsupportedContainer = errors.New("unsupported container")
+
+// NotAvailableError means that the data needed to continue lies in the part of
+// the file which is not downloaded yet
+type NotAvailableError struct {
+	Offset int64
+}
+
+func (e *NotAvailableError) Error() string {
+	return fmt.Sprintf("data at offset %v is not downloaded yet", e.Offset)
+}
+
+// Downloaded is implemented by readers of files which may be still downloading,
+// their ReadAt blocks until the data is written. NextDownloaded returns the
+// downloaded range containing offset or the first one after it, end is 0 when
+// there is no such range
+type Downloaded interface {
+	NextDownloaded(offset int64) (start, end int64)
+}
+
+// downloadedReader reads only what is already written and fails with
+// NotAvailableError instead of waiting, for media data scattered over the whole
+// file which is useful only if it is here already
+type downloadedReader struct {
+	r          io.ReaderAt
+	downloaded Downloaded
+}
+
+// withoutWaiting wraps readers of files which may be still downloading, other
+// readers are complete and returned as is
+func withoutWaiting(r io.ReaderAt) io.ReaderAt {
+	if downloaded, ok := r.(Downloaded); ok {
+		return &downloadedReader{r: r, downloaded: downloaded}
+	}
+	return r
+}
+
+func (d *downloadedReader) ReadAt(p []byte, off int64) (int, error) {
+	start, end := d.downloaded.NextDownloaded(off)
+	if start > off || end <= off {
+		return 0, &NotAvailableError{Offset: off}
+	}
+	if off + int64(len(p)) <= end {
+		return d.r.ReadAt(p, off)
+	}
+	n, err := d.r.ReadAt(p[:end - off], off)
+	if err == nil {
+		err = &NotAvailableError{Offset: end}
+	}
+	return n, err
+}
+
+// nextDownloaded is the start of the first downloaded part from offset, -1 if
+// nothing is downloaded after it
+func nextDownloaded(r io.ReaderAt, offset int64) int64 {
+	d, ok := r.(*downloadedReader)
+	if !ok {
+		return offset
+	}
+	start, end := d.downloaded.NextDownloaded(offset)
+	if end <= start {
+		return -1
+	}
+	return start
+}
+
+// DetectContainer checks file magic bytes
+func DetectContainer(r io.ReaderAt) (string, error) {
+	head := make([]byte, 12)
+	if _, err := r.ReadAt(head, 0); err != nil {
+		return "", fmt.Errorf("error reading file header: %v", err)
+	}
+	switch {
+	case bytes.Equal(head[:4], []byte{0x1A, 0x45, 0xDF, 0xA3}):
+		return ContainerMatroska, nil
+	case bytes.Equal(head[4:8], []byte("ftyp")):
+		return ContainerMp4, nil
+	}
+	return "", ErrUnsupportedContainer
+}
+
+// ListSubtitleTracks returns text tracks of matroska or mp4 file
+func ListSubtitleTracks(r io.ReaderAt, size int64) ([]Track, error) {
+	container, err := DetectContainer(r)
+	if err != nil {
+		return nil, err
+	}
+	switch container {
+	case ContainerMatroska:
+		mkv, err := openMatroska(r, size)
+		if err != nil {
+			return nil, err
+		}
+		return mkv.subtitleTracks(), nil
+	default:
+		mp4, err := openMp4(r, size)
+		if err != nil {
+			return nil, err
+		}
+		return mp4.subtitleTracks(), nil
+	}
+}
+
+// ExtractSubtitles reads all cues of the track. Headers are waited for, not
+// downloaded parts with media data are skipped and the result is marked
+// incomplete
+func ExtractSubtitles(r io.ReaderAt, size int64, trackId int) (*Subtitles, error) {
+	container, err := DetectContainer(r)
+	if err != nil {
+		return nil, err
+	}
+	switch container {
+	case ContainerMatroska:
+		mkv, err := openMatroska(r, size)
+		if err != nil {
+			return nil, err
+		}
+		mkv.r = withoutWaiting(r)
+		return mkv.extractSubtitles(trackId)
+	default:
+		mp4, err := openMp4(r, size)
+		if err != nil {
+			return nil, err
+		}
+		mp4.r = withoutWaiting(r)
+		return mp4.extractSubtitles(trackId)
+	}
+}
+
+func findTrack(tracks []Track, trackId int) (Track, error) {
+	for _, track := range tracks {
+		if track.Id == trackId {
+			if track.Format == "" {
+				return track, fmt.Errorf("subtitles codec %v is not supported", track.Codec)
+			}
+			return track, nil
+		}
+	}
+	return Track{}, fmt.Errorf("subtitles track %v not found", trackId)
+}
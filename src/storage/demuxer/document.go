@@ -0,0 +1,74 @@
+package demuxer
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const assEventsFormat = "Format: Layer, Start, End, Style, Name, MarginL, MarginR, MarginV, Effect, Text"
+
+// Document assembles extracted cues into a standalone subtitles file of
+// Track.Format, so it can be converted like a sidecar file
+func (s *Subtitles) Document() []byte {
+	var buf bytes.Buffer
+
+	switch s.Track.Format {
+	case FormatAss:
+		s.writeAss(&buf)
+	case FormatVtt:
+		buf.WriteString("WEBVTT\n\n")
+		for _, cue := range s.Cues {
+			fmt.Fprintf(&buf, "%s --> %s\n%s\n\n", formatVttTime(cue.Start), formatVttTime(cue.End), strings.TrimSpace(cue.Text))
+		}
+	default:
+		for idx, cue := range s.Cues {
+			fmt.Fprintf(&buf, "%d\n%s --> %s\n%s\n\n", idx + 1, formatSrtTime(cue.Start), formatSrtTime(cue.End), strings.TrimSpace(cue.Text))
+		}
+	}
+	return buf.Bytes()
+}
+
+// в matroska заголовок ASS лежит в CodecPrivate, а блок содержит
+// "ReadOrder, Layer, Style, Name, MarginL, MarginR, MarginV, Effect, Text"
+func (s *Subtitles) writeAss(buf *bytes.Buffer) {
+	header := strings.TrimSpace(string(s.Track.codecPrivate))
+	buf.WriteString(header)
+	if !strings.Contains(header, "[Events]") {
+		buf.WriteString("\n\n[Events]\n" + assEventsFormat)
+	}
+	buf.WriteString("\n")
+
+	for _, cue := range s.Cues {
+		fields := strings.SplitN(cue.Text, ",", 9)
+		if len(fields) < 9 {
+			continue
+		}
+		fmt.Fprintf(buf, "Dialogue: %s,%s,%s,%s\n", fields[1], formatAssTime(cue.Start), formatAssTime(cue.End),
+			strings.Join(fields[2:], ","))
+	}
+}
+
+func splitDuration(d time.Duration) (hours, minutes, seconds, millis int64) {
+	if d < 0 {
+		d = 0
+	}
+	ms := int64(d / time.Millisecond)
+	return ms / 3600000, ms / 60000 % 60, ms / 1000 % 60, ms % 1000
+}
+
+func formatSrtTime(d time.Duration) string {
+	h, m, s, ms := splitDuration(d)
+	return fmt.Sprintf("%02d:%02d:%02d,%03d", h, m, s, ms)
+}
+
+func formatVttTime(d time.Duration) string {
+	h, m, s, ms := splitDuration(d)
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", h, m, s, ms)
+}
+
+func formatAssTime(d time.Duration) string {
+	h, m, s, ms := splitDuration(d)
+	return fmt.Sprintf("%d:%02d:%02d.%02d", h, m, s, ms / 10)
+}
@@ -0,0 +1,207 @@
+package demuxer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sort"
+)
+
+// ebml builds an element with 8 byte size, children are concatenated
+func ebml(id uint32, children ...[]byte) []byte {
+	var buf bytes.Buffer
+	idBytes := make([]byte, 4)
+	binary.BigEndian.PutUint32(idBytes, id)
+	for len(idBytes) > 1 && idBytes[0] == 0 {
+		idBytes = idBytes[1:]
+	}
+	buf.Write(idBytes)
+
+	size := 0
+	for _, child := range children {
+		size += len(child)
+	}
+	sizeBytes := make([]byte, 8)
+	binary.BigEndian.PutUint64(sizeBytes, uint64(size))
+	sizeBytes[0] = 0x01
+	buf.Write(sizeBytes)
+	for _, child := range children {
+		buf.Write(child)
+	}
+	return buf.Bytes()
+}
+
+func ebmlUint(id uint32, value uint64) []byte {
+	data := make([]byte, 8)
+	binary.BigEndian.PutUint64(data, value)
+	return ebml(id, data)
+}
+
+func ebmlString(id uint32, value string) []byte {
+	return ebml(id, []byte(value))
+}
+
+// mkvBlock is a SimpleBlock of the track with the time relative to the cluster
+func mkvBlock(track int, relative int16, keyframe bool, payload []byte) []byte {
+	data := []byte{0x80 | byte(track), byte(uint16(relative) >> 8), byte(relative), 0}
+	if keyframe {
+		data[3] = 0x80
+	}
+	return ebml(idSimpleBlock, append(data, payload...))
+}
+
+type mkvCluster struct {
+	timecode uint64
+	blocks   [][]byte
+}
+
+// buildMatroska makes a file with a video track 1 and srt subtitles track 2,
+// timecodes are milliseconds. Cues are found by SeekHead. Offsets of clusters
+// are returned
+func buildMatroska(clusters []mkvCluster, withCues bool) ([]byte, []int64) {
+	tracks := ebml(idTracks,
+		ebml(idTrackEntry,
+			ebmlUint(idTrackNumber, 1),
+			ebmlUint(idTrackType, mkvTrackTypeVideo),
+			ebmlString(idCodecId, "V_MPEG4/ISO/AVC"),
+			// длинная серия нулей в заголовке, раньше принималась за недокачанный кусок
+			ebml(idCodecPrivate, make([]byte, 20 << 10)),
+			ebml(idVideo, ebmlUint(idPixelWidth, 640), ebmlUint(idPixelHeight, 360))),
+		ebml(idTrackEntry,
+			ebmlUint(idTrackNumber, 2),
+			ebmlUint(idTrackType, mkvTrackTypeSubtitle),
+			ebmlString(idCodecId, "S_TEXT/UTF8"),
+			ebmlString(idLanguage, "rus")))
+	info := ebml(idInfo, ebmlUint(idTimecodeScale, 1000000))
+
+	// размер SeekHead не зависит от позиции, она пишется в 8 байт
+	seekHead := func(position int) []byte {
+		return ebml(idSeekHead, ebml(idSeek, ebmlUint(idSeekId, idCues), ebmlUint(idSeekPosition, uint64(position))))
+	}
+	var body [][]byte
+	if withCues {
+		body = append(body, seekHead(0))
+	}
+	body = append(body, info, tracks)
+	headerLen := 0
+	for _, element := range body {
+		headerLen += len(element)
+	}
+
+	var clusterData [][]byte
+	for _, cluster := range clusters {
+		children := [][]byte{ebmlUint(idTimecode, cluster.timecode)}
+		children = append(children, cluster.blocks...)
+		clusterData = append(clusterData, ebml(idCluster, children...))
+	}
+
+	// Cues пишем в конце, позиции кластеров от начала данных сегмента
+	var offsets []int64
+	position := headerLen
+	var cuePoints [][]byte
+	for i, data := range clusterData {
+		offsets = append(offsets, int64(position))
+		cuePoints = append(cuePoints, ebml(idCuePoint,
+			ebmlUint(idCueTime, clusters[i].timecode),
+			ebml(idCuePositions, ebmlUint(idCueTrack, 1), ebmlUint(idCueClusterPos, uint64(position)))))
+		position += len(data)
+	}
+	body = append(body, clusterData...)
+	if withCues {
+		body[0] = seekHead(position)
+		body = append(body, ebml(idCues, cuePoints...))
+	}
+
+	file := ebml(idEbml, ebmlString(0x4282, "matroska"))
+	segment := ebml(idSegment, body...)
+	segmentData := int64(len(file) + len(segment) - len(bytes.Join(body, nil)))
+	for i := range offsets {
+		offsets[i] += segmentData
+	}
+	return append(file, segment...), offsets
+}
+
+// partialFile is a downloading file, ReadAt of not downloaded parts fails
+// the test instead of blocking
+type partialFile struct {
+	data   []byte
+	ranges [][2]int64
+}
+
+func (p *partialFile) NextDownloaded(offset int64) (int64, int64) {
+	sort.Slice(p.ranges, func(i, j int) bool { return p.ranges[i][0] < p.ranges[j][0] })
+	for _, r := range p.ranges {
+		if r[1] > offset {
+			if r[0] < offset {
+				return offset, r[1]
+			}
+			return r[0], r[1]
+		}
+	}
+	return 0, 0
+}
+
+func (p *partialFile) ReadAt(b []byte, off int64) (int, error) {
+	start, end := p.NextDownloaded(off)
+	if start != off || end < off + int64(len(b)) {
+		panic("read of not downloaded part")
+	}
+	return bytes.NewReader(p.data).ReadAt(b, off)
+}
+
+func atom(boxType string, payload ...[]byte) []byte {
+	data := bytes.Join(payload, nil)
+	header := make([]byte, 8)
+	binary.BigEndian.PutUint32(header, uint32(8 + len(data)))
+	copy(header[4:], boxType)
+	return append(header, data...)
+}
+
+// u32s is a payload of a full box with version and flags 0
+func u32s(values ...uint32) []byte {
+	data := make([]byte, 4 * (len(values) + 1))
+	for i, value := range values {
+		binary.BigEndian.PutUint32(data[4 * (i + 1):], value)
+	}
+	return data
+}
+
+// buildMp4 makes a file with one video track of samples by one second with
+// timescale 1000, perChunk samples in a chunk. syncSamples are numbered from 1,
+// nil means no stss. Sample offsets are returned
+func buildMp4(samples int, sampleSize int, perChunk int, syncSamples []uint32) ([]byte, []int64) {
+	ftyp := atom("ftyp", []byte("isom"), make([]byte, 4))
+	mdatStart := int64(len(ftyp) + 8)
+	mdat := atom("mdat", bytes.Repeat([]byte{0xAB}, samples * sampleSize))
+
+	var offsets []int64
+	var chunkOffsets, sizes []uint32
+	for i := 0; i < samples; i++ {
+		offset := mdatStart + int64(i * sampleSize)
+		offsets = append(offsets, offset)
+		if i % perChunk == 0 {
+			chunkOffsets = append(chunkOffsets, uint32(offset))
+		}
+		sizes = append(sizes, uint32(sampleSize))
+	}
+
+	stbl := [][]byte{
+		atom("stsd", u32s(1), atom("avc1", make([]byte, 78))),
+		atom("stts", u32s(1, uint32(samples), 1000)),
+		atom("stsc", u32s(1, 1, uint32(perChunk), 1)),
+		atom("stsz", u32s(append([]uint32{0, uint32(samples)}, sizes...)...)),
+		atom("stco", u32s(append([]uint32{uint32(len(chunkOffsets))}, chunkOffsets...)...)),
+	}
+	if syncSamples != nil {
+		stbl = append(stbl, atom("stss", u32s(append([]uint32{uint32(len(syncSamples))}, syncSamples...)...)))
+	}
+	duration := uint32(samples * 1000)
+	trak := atom("trak",
+		// флаг enabled и id дорожки
+		atom("tkhd", []byte{0, 0, 0, 1}, u32s(0, 1, 0, duration)[4:], make([]byte, 60)),
+		atom("mdia",
+			atom("mdhd", u32s(0, 0, 1000, duration), []byte{0x55, 0xC4, 0, 0}),
+			atom("hdlr", u32s(0), []byte("vide"), make([]byte, 13)),
+			atom("minf", atom("stbl", stbl...))))
+	moov := atom("moov", atom("mvhd", u32s(0, 0, 1000, duration), make([]byte, 80)), trak)
+	return bytes.Join([][]byte{ftyp, mdat, moov}, nil), offsets
+}
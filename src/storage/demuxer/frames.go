@@ -0,0 +1,408 @@
+package demuxer
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+// MediaTrack is an audio or video track with the codec setup needed to put its
+// frames into another container
+type MediaTrack struct {
+	Id              int
+	Type            string // StreamVideo или StreamAudio
+	Codec           string // CodecID из matroska
+	CodecPrivate    []byte
+	Language        string
+	Default         bool
+	DefaultDuration time.Duration
+	Width           int
+	Height          int
+	Channels        int
+	SampleRate      int
+}
+
+// Frame is a single coded frame, laced blocks are split into frames
+type Frame struct {
+	Track    int
+	Time     time.Duration // время показа
+	Duration time.Duration // 0, если в файле не указана
+	Keyframe bool
+	Data     []byte
+}
+
+// CuePoint points to the cluster with a keyframe of the track
+type CuePoint struct {
+	Time    time.Duration
+	Track   int
+	Cluster int64 // смещение кластера от начала файла
+}
+
+// Matroska reads frames cluster by cluster. It expects a reader which blocks
+// until the data is downloaded, zero runs are not treated as missing pieces
+type Matroska struct {
+	mkv    *matroska
+	tracks map[int]mkvTrack
+}
+
+func OpenMatroska(r io.ReaderAt, size int64) (*Matroska, error) {
+	container, err := DetectContainer(r)
+	if err != nil {
+		return nil, err
+	}
+	if container != ContainerMatroska {
+		return nil, ErrUnsupportedContainer
+	}
+	mkv, err := openMatroska(r, size)
+	if err != nil {
+		return nil, err
+	}
+	if mkv.firstCluster == 0 {
+		return nil, fmt.Errorf("matroska clusters not found")
+	}
+
+	tracks := make(map[int]mkvTrack, len(mkv.tracks))
+	for _, track := range mkv.tracks {
+		tracks[track.Id] = track
+	}
+	return &Matroska{mkv: mkv, tracks: tracks}, nil
+}
+
+func (m *Matroska) Duration() time.Duration {
+	return time.Duration(m.mkv.duration * float64(m.mkv.timecodeScale))
+}
+
+// Tracks returns audio and video tracks in the file order
+func (m *Matroska) Tracks() []MediaTrack {
+	res := make([]MediaTrack, 0, len(m.mkv.tracks))
+	for _, track := range m.mkv.tracks {
+		media := MediaTrack{
+			Id:              track.Id,
+			Codec:           track.Codec,
+			CodecPrivate:    track.codecPrivate,
+			Language:        track.Language,
+			Default:         track.Default,
+			DefaultDuration: track.defaultDuration,
+		}
+		switch track.trackType {
+		case mkvTrackTypeVideo:
+			media.Type, media.Width, media.Height = StreamVideo, track.width, track.height
+		case mkvTrackTypeAudio:
+			media.Type, media.Channels, media.SampleRate = StreamAudio, track.channels, track.sampleRate
+		default:
+			continue
+		}
+		res = append(res, media)
+	}
+	return res
+}
+
+// Cues reads the index of keyframes, nil if the file has no index
+func (m *Matroska) Cues() ([]CuePoint, error) {
+	position, known := m.mkv.seeks[idCues]
+	if !known {
+		return nil, nil
+	}
+	element, err := readElementHeader(m.mkv.r, m.mkv.segmentData + position, m.mkv.segmentEnd)
+	if err != nil {
+		return nil, err
+	}
+	if element.Id != idCues {
+		return nil, fmt.Errorf("broken matroska seek head")
+	}
+	data, err := readElementData(m.mkv.r, element, m.mkv.segmentEnd)
+	if err != nil {
+		return nil, err
+	}
+
+	scale := time.Duration(m.mkv.timecodeScale)
+	var cues []CuePoint
+	walkChildren(data, func(id uint32, point []byte) {
+		if id != idCuePoint {
+			return
+		}
+		var cueTime time.Duration
+		walkChildren(point, func(id uint32, value []byte) {
+			switch id {
+			case idCueTime:
+				cueTime = time.Duration(readUint(value)) * scale
+			case idCuePositions:
+				cue := CuePoint{Time: cueTime, Cluster: -1}
+				walkChildren(value, func(id uint32, value []byte) {
+					switch id {
+					case idCueTrack:
+						cue.Track = int(readUint(value))
+					case idCueClusterPos:
+						cue.Cluster = m.mkv.segmentData + int64(readUint(value))
+					}
+				})
+				if cue.Cluster >= 0 {
+					cues = append(cues, cue)
+				}
+			}
+		})
+	})
+	sort.SliceStable(cues, func(i, j int) bool {
+		return cues[i].Time < cues[j].Time
+	})
+	return cues, nil
+}
+
+// SeekCluster finds the cluster to start playing the track from the position,
+// returns its offset and time. Without Cues the offset is estimated by the
+// average bitrate
+func (m *Matroska) SeekCluster(position time.Duration, trackId int) (int64, time.Duration, error) {
+	if position <= 0 {
+		return m.mkv.firstCluster, 0, nil
+	}
+
+	cues, err := m.Cues()
+	if err != nil {
+		return 0, 0, err
+	}
+	found := false
+	var best CuePoint
+	for _, cue := range cues {
+		if cue.Time > position {
+			break
+		}
+		if cue.Track == trackId || !found {
+			best, found = cue, true
+		}
+	}
+	if found {
+		return best.Cluster, best.Time, nil
+	}
+	if len(cues) > 0 {
+		return m.mkv.firstCluster, 0, nil
+	}
+
+	duration := m.Duration()
+	if duration <= 0 {
+		return m.mkv.firstCluster, 0, nil
+	}
+	clustersSize := m.mkv.segmentEnd - m.mkv.firstCluster
+	estimate := m.mkv.firstCluster + int64(float64(clustersSize) * position.Seconds() / duration.Seconds())
+	offset := m.mkv.syncToCluster(estimate)
+	if offset < 0 {
+		return m.mkv.firstCluster, 0, nil
+	}
+	clusterTime, err := m.clusterTime(offset)
+	if err != nil {
+		return 0, 0, err
+	}
+	return offset, clusterTime, nil
+}
+
+func (m *Matroska) clusterTime(offset int64) (time.Duration, error) {
+	cluster, err := readElementHeader(m.mkv.r, offset, m.mkv.segmentEnd)
+	if err != nil {
+		return 0, err
+	}
+	element, err := readElementHeader(m.mkv.r, cluster.DataOffset, cluster.End(m.mkv.segmentEnd))
+	if err != nil {
+		return 0, err
+	}
+	if element.Id != idTimecode {
+		return 0, fmt.Errorf("cluster at %v starts without timecode", offset)
+	}
+	data, err := readElementData(m.mkv.r, element, m.mkv.segmentEnd)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(readUint(data)) * time.Duration(m.mkv.timecodeScale), nil
+}
+
+// ReadCluster returns frames of the cluster at the offset and the offset of the
+// next one, io.EOF after the last cluster. Other top level elements between
+// clusters are skipped
+func (m *Matroska) ReadCluster(offset int64) ([]Frame, int64, error) {
+	end := m.mkv.segmentEnd
+	for {
+		if offset >= end {
+			return nil, end, io.EOF
+		}
+		cluster, err := readElementHeader(m.mkv.r, offset, end)
+		if err != nil {
+			return nil, 0, err
+		}
+		if cluster.Id == idCluster {
+			return m.readClusterFrames(cluster)
+		}
+		if !topLevelIds[cluster.Id] && cluster.Id != idVoid || cluster.Size < 0 {
+			return nil, 0, fmt.Errorf("unexpected element %x at %v", cluster.Id, offset)
+		}
+		offset = cluster.End(end)
+	}
+}
+
+func (m *Matroska) readClusterFrames(cluster ebmlElement) ([]Frame, int64, error) {
+	end := cluster.End(m.mkv.segmentEnd)
+	var clusterTimecode uint64
+	frames := make([]Frame, 0, 64)
+
+	for offset := cluster.DataOffset; offset < end; {
+		element, err := readElementHeader(m.mkv.r, offset, end)
+		if err != nil {
+			return nil, 0, err
+		}
+		if cluster.Size < 0 && topLevelIds[element.Id] {
+			return frames, element.Offset, nil
+		}
+
+		switch element.Id {
+		case idTimecode, idSimpleBlock, idBlockGroup:
+			data, err := readElementData(m.mkv.r, element, end)
+			if err != nil {
+				return nil, 0, err
+			}
+			switch element.Id {
+			case idTimecode:
+				clusterTimecode = readUint(data)
+			case idSimpleBlock:
+				frames, err = m.appendBlockFrames(frames, data, clusterTimecode, -1, isSimpleKeyframe(data))
+			default:
+				frames, err = m.appendGroupFrames(frames, data, clusterTimecode)
+			}
+			if err != nil {
+				return nil, 0, fmt.Errorf("broken block at %v: %v", element.Offset, err)
+			}
+		}
+		offset = element.End(end)
+	}
+	return frames, end, nil
+}
+
+func isSimpleKeyframe(block []byte) bool {
+	_, trackLen, err := readVint(block)
+	return err == nil && len(block) > trackLen + 2 && block[trackLen + 2] & 0x80 != 0
+}
+
+// appendGroupFrames reads Block of BlockGroup, the frame is a keyframe when it
+// has no references
+func (m *Matroska) appendGroupFrames(frames []Frame, group []byte, clusterTimecode uint64) ([]Frame, error) {
+	var block []byte
+	duration := int64(-1)
+	keyframe := true
+	walkChildren(group, func(id uint32, value []byte) {
+		switch id {
+		case idBlock:
+			block = value
+		case idBlockDuration:
+			duration = int64(readUint(value))
+		case idReferenceBlk:
+			keyframe = false
+		}
+	})
+	if block == nil {
+		return frames, nil
+	}
+	return m.appendBlockFrames(frames, block, clusterTimecode, duration, keyframe)
+}
+
+// appendBlockFrames splits the block by its lacing, frames of a lace follow each
+// other with the default duration of the track
+func (m *Matroska) appendBlockFrames(frames []Frame, block []byte, clusterTimecode uint64, duration int64, keyframe bool) ([]Frame, error) {
+	trackId, trackLen, err := readVint(block)
+	if err != nil {
+		return frames, err
+	}
+	if len(block) < trackLen + 3 {
+		return frames, fmt.Errorf("short block header")
+	}
+	track, known := m.tracks[int(trackId)]
+	if !known || track.trackType != mkvTrackTypeVideo && track.trackType != mkvTrackTypeAudio {
+		return frames, nil
+	}
+
+	relative := int16(binary.BigEndian.Uint16(block[trackLen:]))
+	flags := block[trackLen + 2]
+	laces, err := splitLaces(block[trackLen + 3:], flags >> 1 & 0x03)
+	if err != nil {
+		return frames, err
+	}
+
+	scale := time.Duration(m.mkv.timecodeScale)
+	start := time.Duration(int64(clusterTimecode) + int64(relative)) * scale
+	frameDuration := track.defaultDuration
+	if duration >= 0 {
+		frameDuration = time.Duration(duration) * scale / time.Duration(len(laces))
+	}
+	for i, data := range laces {
+		frames = append(frames, Frame{
+			Track:    track.Id,
+			Time:     start + frameDuration * time.Duration(i),
+			Duration: frameDuration,
+			Keyframe: keyframe,
+			Data:     data,
+		})
+	}
+	return frames, nil
+}
+
+// splitLaces returns frames of the block payload, lacing is 0 - none, 1 - Xiph,
+// 2 - fixed size, 3 - EBML
+func splitLaces(payload []byte, lacing byte) ([][]byte, error) {
+	if lacing == 0 {
+		return [][]byte{payload}, nil
+	}
+	if len(payload) == 0 {
+		return nil, fmt.Errorf("empty laced block")
+	}
+	count := int(payload[0]) + 1
+	payload = payload[1:]
+	sizes := make([]int, count)
+
+	switch lacing {
+	case 1:
+		for i := 0; i < count - 1; i++ {
+			for {
+				if len(payload) == 0 {
+					return nil, fmt.Errorf("broken xiph lacing")
+				}
+				b := payload[0]
+				payload = payload[1:]
+				sizes[i] += int(b)
+				if b != 0xFF {
+					break
+				}
+			}
+		}
+	case 2:
+		if len(payload) % count != 0 {
+			return nil, fmt.Errorf("broken fixed lacing")
+		}
+		for i := range sizes[:count - 1] {
+			sizes[i] = len(payload) / count
+		}
+	case 3:
+		first, length, err := readVint(payload)
+		if err != nil {
+			return nil, err
+		}
+		payload = payload[length:]
+		sizes[0] = int(first)
+		for i := 1; i < count - 1; i++ {
+			raw, length, err := readVint(payload)
+			if err != nil {
+				return nil, err
+			}
+			payload = payload[length:]
+			// разница с прошлым размером хранится со сдвигом вместо знака
+			diff := int64(raw) - (int64(1) << uint(7 * length - 1) - 1)
+			sizes[i] = sizes[i - 1] + int(diff)
+		}
+	}
+
+	laces := make([][]byte, count)
+	for i := 0; i < count - 1; i++ {
+		if sizes[i] < 0 || sizes[i] > len(payload) {
+			return nil, fmt.Errorf("lace size %v is out of block", sizes[i])
+		}
+		laces[i], payload = payload[:sizes[i]], payload[sizes[i]:]
+	}
+	laces[count - 1] = payload
+	return laces, nil
+}
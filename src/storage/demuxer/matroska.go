@@ -0,0 +1,662 @@
+package demuxer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"time"
+)
+
+// EBML element ids, https://www.matroska.org/technical/elements.html
+const (
+	idEbml          = 0x1A45DFA3
+	idSegment       = 0x18538067
+	idSeekHead      = 0x114D9B74
+	idSeek          = 0x4DBB
+	idSeekId        = 0x53AB
+	idSeekPosition  = 0x53AC
+	idInfo          = 0x1549A966
+	idTimecodeScale = 0x2AD7B1
+	idDuration      = 0x4489
+	idTracks        = 0x1654AE6B
+	idTrackEntry    = 0xAE
+	idTrackNumber   = 0xD7
+	idTrackType     = 0x83
+	idFlagDefault   = 0x88
+	idFlagForced    = 0x55AA
+	idCodecId       = 0x86
+	idCodecPrivate  = 0x63A2
+	idLanguage      = 0x22B59C
+	idLanguageIETF  = 0x22B59D
+	idName          = 0x536E
+	idDefaultDur    = 0x23E383
+	idVideo         = 0xE0
+	idPixelWidth    = 0xB0
+	idPixelHeight   = 0xBA
+	idAudio         = 0xE1
+	idSamplingFreq  = 0xB5
+	idChannels      = 0x9F
+	idCluster       = 0x1F43B675
+	idTimecode      = 0xE7
+	idSimpleBlock   = 0xA3
+	idBlockGroup    = 0xA0
+	idBlock         = 0xA1
+	idBlockDuration = 0x9B
+	idReferenceBlk  = 0xFB
+	idVoid          = 0xEC
+	idCues          = 0x1C53BB6B
+	idCuePoint      = 0xBB
+	idCueTime       = 0xB3
+	idCuePositions  = 0xB7
+	idCueTrack      = 0xF7
+	idCueClusterPos = 0xF1
+	idTags          = 0x1254C367
+	idChapters      = 0x1043A770
+	idAttachments   = 0x1941A469
+)
+
+const (
+	mkvTrackTypeVideo    = 1
+	mkvTrackTypeAudio    = 2
+	mkvTrackTypeSubtitle = 17
+)
+
+var topLevelIds = map[uint32]bool{
+	idSeekHead: true, idInfo: true, idTracks: true, idCluster: true, idCues: true,
+	idTags: true, idChapters: true, idAttachments: true,
+}
+
+var mkvSubtitleFormats = map[string]string{
+	"S_TEXT/UTF8":   FormatSrt,
+	"S_TEXT/ASCII":  FormatSrt,
+	"S_TEXT/ASS":    FormatAss,
+	"S_TEXT/SSA":    FormatAss,
+	"S_ASS":         FormatAss,
+	"S_SSA":         FormatAss,
+	"S_TEXT/WEBVTT": FormatVtt,
+}
+
+type ebmlElement struct {
+	Id         uint32
+	Offset     int64
+	DataOffset int64
+	Size       int64 // -1 для элементов неизвестной длины
+}
+
+func (e ebmlElement) End(parentEnd int64) int64 {
+	if e.Size < 0 || e.DataOffset + e.Size > parentEnd {
+		return parentEnd
+	}
+	return e.DataOffset + e.Size
+}
+
+type mkvTrack struct {
+	Track
+	trackType       uint64
+	defaultDuration time.Duration
+	width           int
+	height          int
+	channels        int
+	sampleRate      int
+}
+
+type matroska struct {
+	r             io.ReaderAt
+	size          int64
+	segmentData   int64
+	segmentEnd    int64
+	timecodeScale uint64
+	duration      float64
+	tracks        []mkvTrack
+	firstCluster  int64
+	seeks         map[uint32]int64
+}
+
+func openMatroska(r io.ReaderAt, size int64) (*matroska, error) {
+	header, err := readElementHeader(r, 0, size)
+	if err != nil {
+		return nil, err
+	}
+	if header.Id != idEbml {
+		return nil, fmt.Errorf("not a matroska file")
+	}
+	segment, err := readElementHeader(r, header.End(size), size)
+	if err != nil {
+		return nil, err
+	}
+	if segment.Id != idSegment {
+		return nil, fmt.Errorf("matroska segment not found")
+	}
+
+	mkv := &matroska{
+		r:             r,
+		size:          size,
+		segmentData:   segment.DataOffset,
+		segmentEnd:    segment.End(size),
+		timecodeScale: 1000000,
+	}
+	if err := mkv.readHeaders(); err != nil {
+		return nil, err
+	}
+	return mkv, nil
+}
+
+// readHeaders walks top level elements up to the first cluster, Info and Tracks
+// written after clusters are found by SeekHead
+func (m *matroska) readHeaders() error {
+	seeks := make(map[uint32]int64)
+	foundInfo, foundTracks := false, false
+
+	for offset := m.segmentData; offset < m.segmentEnd; {
+		element, err := readElementHeader(m.r, offset, m.segmentEnd)
+		if err != nil {
+			return err
+		}
+
+		switch element.Id {
+		case idSeekHead:
+			data, err := readElementData(m.r, element, m.segmentEnd)
+			if err != nil {
+				return err
+			}
+			m.parseSeekHead(data, seeks)
+		case idInfo:
+			if err := m.readInfo(element); err != nil {
+				return err
+			}
+			foundInfo = true
+		case idTracks:
+			if err := m.readTracks(element); err != nil {
+				return err
+			}
+			foundTracks = true
+		case idCluster:
+			if m.firstCluster == 0 {
+				m.firstCluster = element.Offset
+			}
+		}
+		if m.firstCluster != 0 || element.Size < 0 {
+			break
+		}
+		offset = element.End(m.segmentEnd)
+	}
+
+	for _, target := range []struct {
+		id    uint32
+		found *bool
+		read  func(ebmlElement) error
+	}{{idInfo, &foundInfo, m.readInfo}, {idTracks, &foundTracks, m.readTracks}} {
+		position, known := seeks[target.id]
+		if *target.found || !known {
+			continue
+		}
+		element, err := readElementHeader(m.r, m.segmentData + position, m.segmentEnd)
+		if err != nil {
+			return err
+		}
+		if element.Id != target.id {
+			return fmt.Errorf("broken matroska seek head")
+		}
+		if err := target.read(element); err != nil {
+			return err
+		}
+		*target.found = true
+	}
+	if !foundTracks {
+		return fmt.Errorf("matroska tracks not found")
+	}
+	if m.firstCluster == 0 {
+		if position, known := seeks[idCluster]; known {
+			m.firstCluster = m.segmentData + position
+		}
+	}
+	m.seeks = seeks
+	return nil
+}
+
+func (m *matroska) parseSeekHead(data []byte, seeks map[uint32]int64) {
+	walkChildren(data, func(id uint32, seek []byte) {
+		if id != idSeek {
+			return
+		}
+		var seekId uint32
+		var position int64 = -1
+		walkChildren(seek, func(id uint32, value []byte) {
+			switch id {
+			case idSeekId:
+				seekId = uint32(readUint(value))
+			case idSeekPosition:
+				position = int64(readUint(value))
+			}
+		})
+		if _, exists := seeks[seekId]; !exists && position >= 0 {
+			seeks[seekId] = position
+		}
+	})
+}
+
+func (m *matroska) readInfo(element ebmlElement) error {
+	data, err := readElementData(m.r, element, m.segmentEnd)
+	if err != nil {
+		return err
+	}
+	walkChildren(data, func(id uint32, value []byte) {
+		switch id {
+		case idTimecodeScale:
+			if scale := readUint(value); scale > 0 {
+				m.timecodeScale = scale
+			}
+		case idDuration:
+			m.duration = readFloat(value)
+		}
+	})
+	return nil
+}
+
+func (m *matroska) readTracks(element ebmlElement) error {
+	data, err := readElementData(m.r, element, m.segmentEnd)
+	if err != nil {
+		return err
+	}
+	m.tracks = m.tracks[:0]
+	walkChildren(data, func(id uint32, entry []byte) {
+		if id != idTrackEntry {
+			return
+		}
+		track := mkvTrack{Track: Track{Language: "eng", Default: true}}
+		ietfLanguage := ""
+		walkChildren(entry, func(id uint32, value []byte) {
+			switch id {
+			case idTrackNumber:
+				track.Id = int(readUint(value))
+			case idTrackType:
+				track.trackType = readUint(value)
+			case idFlagDefault:
+				track.Default = readUint(value) != 0
+			case idFlagForced:
+				track.Forced = readUint(value) != 0
+			case idCodecId:
+				track.Codec = readString(value)
+			case idCodecPrivate:
+				track.codecPrivate = append([]byte(nil), value...)
+			case idLanguage:
+				track.Language = readString(value)
+			case idLanguageIETF:
+				ietfLanguage = readString(value)
+			case idName:
+				track.Name = readString(value)
+			case idDefaultDur:
+				track.defaultDuration = time.Duration(readUint(value))
+			case idVideo:
+				walkChildren(value, func(id uint32, value []byte) {
+					switch id {
+					case idPixelWidth:
+						track.width = int(readUint(value))
+					case idPixelHeight:
+						track.height = int(readUint(value))
+					}
+				})
+			case idAudio:
+				walkChildren(value, func(id uint32, value []byte) {
+					switch id {
+					case idSamplingFreq:
+						track.sampleRate = int(readFloat(value))
+					case idChannels:
+						track.channels = int(readUint(value))
+					}
+				})
+			}
+		})
+		track.Format = mkvSubtitleFormats[track.Codec]
+		// BCP 47 точнее устаревшего Language, если есть оба
+		if ietfLanguage != "" {
+			track.Language = ietfLanguage
+		}
+		if track.Language == "und" {
+			track.Language = ""
+		}
+		m.tracks = append(m.tracks, track)
+	})
+	return nil
+}
+
+func (m *matroska) subtitleTracks() []Track {
+	res := make([]Track, 0, len(m.tracks))
+	for _, track := range m.tracks {
+		if track.trackType == mkvTrackTypeSubtitle {
+			res = append(res, track.Track)
+		}
+	}
+	return res
+}
+
+func (m *matroska) extractSubtitles(trackId int) (*Subtitles, error) {
+	track, err := findTrack(m.subtitleTracks(), trackId)
+	if err != nil {
+		return nil, err
+	}
+	var defaultDuration time.Duration
+	for _, t := range m.tracks {
+		if t.Id == trackId {
+			defaultDuration = t.defaultDuration
+		}
+	}
+
+	result := &Subtitles{Track: track, Complete: true}
+	if m.firstCluster == 0 {
+		return result, nil
+	}
+
+	offset := m.firstCluster
+	for offset < m.segmentEnd {
+		cluster, err := readElementHeader(m.r, offset, m.segmentEnd)
+		if err == nil && cluster.Id != idCluster {
+			if !topLevelIds[cluster.Id] {
+				err = fmt.Errorf("unexpected element %x", cluster.Id)
+			} else {
+				offset = cluster.End(m.segmentEnd)
+				if cluster.Size < 0 {
+					break
+				}
+				continue
+			}
+		}
+		if err != nil {
+			// кусок файла еще не скачан или испорчен, ищем следующий кластер
+			result.Complete = false
+			if offset = m.syncToCluster(offset + 1); offset < 0 {
+				break
+			}
+			continue
+		}
+
+		clusterEnd, complete := m.readCluster(cluster, track.Id, defaultDuration, result)
+		if !complete {
+			result.Complete = false
+		}
+		offset = clusterEnd
+	}
+
+	fixCueEnds(result.Cues)
+	return result, nil
+}
+
+// readCluster collects blocks of the track, returns the cluster end which for
+// clusters of unknown size is the next top level element
+func (m *matroska) readCluster(cluster ebmlElement, trackId int, defaultDuration time.Duration, result *Subtitles) (int64, bool) {
+	end := cluster.End(m.segmentEnd)
+	var clusterTimecode uint64
+	complete := true
+
+	for offset := cluster.DataOffset; offset < end; {
+		element, err := readElementHeader(m.r, offset, end)
+		if err != nil {
+			next := m.syncToCluster(offset + 1)
+			if next < 0 {
+				next = m.segmentEnd
+			}
+			return next, false
+		}
+		if cluster.Size < 0 && topLevelIds[element.Id] {
+			return element.Offset, complete
+		}
+
+		switch element.Id {
+		case idTimecode:
+			data, err := readElementData(m.r, element, end)
+			if err != nil {
+				return m.nextAfterGap(element.End(end)), false
+			}
+			clusterTimecode = readUint(data)
+		case idSimpleBlock, idBlockGroup:
+			cue, found, err := m.readBlock(element, end, trackId, clusterTimecode)
+			if err != nil {
+				complete = false
+			} else if found {
+				if cue.End <= cue.Start && defaultDuration > 0 {
+					cue.End = cue.Start + defaultDuration
+				}
+				result.Cues = append(result.Cues, cue)
+			}
+		}
+		offset = element.End(end)
+	}
+	return end, complete
+}
+
+func (m *matroska) nextAfterGap(offset int64) int64 {
+	if next := m.syncToCluster(offset); next >= 0 {
+		return next
+	}
+	return m.segmentEnd
+}
+
+func (m *matroska) readBlock(element ebmlElement, end int64, trackId int, clusterTimecode uint64) (Cue, bool, error) {
+	var block []byte
+	var duration uint64
+	hasDuration := false
+
+	if element.Id == idSimpleBlock {
+		// номер дорожки в начале блока, остальное читаем только для нужной
+		head := make([]byte, 12)
+		n, _ := m.r.ReadAt(head, element.DataOffset)
+		track, _, err := readVint(head[:n])
+		if err != nil {
+			return Cue{}, false, err
+		}
+		if int(track) != trackId {
+			return Cue{}, false, nil
+		}
+		if block, err = readElementData(m.r, element, end); err != nil {
+			return Cue{}, false, err
+		}
+	} else {
+		group, err := readElementData(m.r, element, end)
+		if err != nil {
+			return Cue{}, false, err
+		}
+		walkChildren(group, func(id uint32, value []byte) {
+			switch id {
+			case idBlock:
+				block = value
+			case idBlockDuration:
+				duration, hasDuration = readUint(value), true
+			}
+		})
+	}
+
+	track, trackLen, err := readVint(block)
+	if err != nil || int(track) != trackId || len(block) < trackLen + 3 {
+		return Cue{}, false, err
+	}
+	relative := int16(binary.BigEndian.Uint16(block[trackLen:]))
+	flags := block[trackLen + 2]
+	if flags & 0x06 != 0 {
+		// lacing для субтитров не используется
+		return Cue{}, false, nil
+	}
+	payload := block[trackLen + 3:]
+
+	scale := time.Duration(m.timecodeScale)
+	cue := Cue{
+		Start: time.Duration(int64(clusterTimecode) + int64(relative)) * scale,
+		Text:  string(payload),
+	}
+	if hasDuration {
+		cue.End = cue.Start + time.Duration(duration) * scale
+	}
+	return cue, true, nil
+}
+
+// syncToCluster searches cluster id from the offset in downloaded parts, -1 if
+// there is no more
+func (m *matroska) syncToCluster(offset int64) int64 {
+	clusterId := []byte{0x1F, 0x43, 0xB6, 0x75}
+	buf := make([]byte, 256 << 10)
+
+	for offset < m.segmentEnd {
+		if offset = nextDownloaded(m.r, offset); offset < 0 {
+			return -1
+		}
+		n, err := m.r.ReadAt(buf, offset)
+		var notAvailable *NotAvailableError
+		if errors.As(err, &notAvailable) && n < len(clusterId) {
+			// id не поместился до конца скачанного куска
+			offset = notAvailable.Offset
+			continue
+		}
+		if n < len(clusterId) {
+			return -1
+		}
+		for start := 0; ; {
+			idx := bytes.Index(buf[start:n], clusterId)
+			if idx < 0 {
+				break
+			}
+			candidate := offset + int64(start + idx)
+			if element, err := readElementHeader(m.r, candidate, m.segmentEnd); err == nil && element.Id == idCluster {
+				return candidate
+			}
+			start += idx + 1
+		}
+		if err != nil && !errors.As(err, &notAvailable) {
+			return -1
+		}
+		offset += int64(n - len(clusterId) + 1)
+	}
+	return -1
+}
+
+// fixCueEnds sets end of cues without duration to the next cue start
+func fixCueEnds(cues []Cue) {
+	for i := range cues {
+		if cues[i].End > cues[i].Start {
+			continue
+		}
+		cues[i].End = cues[i].Start + defaultCueDuration
+		if i + 1 < len(cues) && cues[i + 1].Start > cues[i].Start && cues[i + 1].Start < cues[i].End {
+			cues[i].End = cues[i + 1].Start
+		}
+	}
+}
+
+func readElementHeader(r io.ReaderAt, offset int64, end int64) (ebmlElement, error) {
+	head := make([]byte, 12)
+	n, err := r.ReadAt(head, offset)
+	if n == 0 {
+		return ebmlElement{}, fmt.Errorf("error reading element at %v: %v", offset, err)
+	}
+	head = head[:n]
+
+	idLen := vintLength(head[0])
+	if idLen == 0 || idLen > 4 || idLen >= len(head) {
+		return ebmlElement{}, fmt.Errorf("invalid element id at %v", offset)
+	}
+	var id uint32
+	for _, b := range head[:idLen] {
+		id = id << 8 | uint32(b)
+	}
+
+	size, sizeLen, err := readVint(head[idLen:])
+	if err != nil {
+		return ebmlElement{}, fmt.Errorf("invalid element size at %v: %v", offset, err)
+	}
+	element := ebmlElement{
+		Id:         id,
+		Offset:     offset,
+		DataOffset: offset + int64(idLen + sizeLen),
+		Size:       int64(size),
+	}
+	if size == 1 << uint(7 * sizeLen) - 1 {
+		element.Size = -1
+	} else if element.DataOffset + element.Size > end {
+		return ebmlElement{}, fmt.Errorf("element at %v exceeds its parent", offset)
+	}
+	return element, nil
+}
+
+func readElementData(r io.ReaderAt, element ebmlElement, end int64) ([]byte, error) {
+	size := element.End(end) - element.DataOffset
+	if size > maxHeaderElementSize {
+		return nil, fmt.Errorf("element %x is too large (%v bytes)", element.Id, size)
+	}
+	data := make([]byte, size)
+	if _, err := r.ReadAt(data, element.DataOffset); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return data, nil
+}
+
+func walkChildren(data []byte, callback func(id uint32, value []byte)) {
+	for len(data) > 0 {
+		idLen := vintLength(data[0])
+		if idLen == 0 || idLen > 4 || idLen > len(data) {
+			return
+		}
+		var id uint32
+		for _, b := range data[:idLen] {
+			id = id << 8 | uint32(b)
+		}
+		size, sizeLen, err := readVint(data[idLen:])
+		if err != nil {
+			return
+		}
+		start := idLen + sizeLen
+		if size > uint64(len(data) - start) {
+			size = uint64(len(data) - start)
+		}
+		callback(id, data[start:start + int(size)])
+		data = data[start + int(size):]
+	}
+}
+
+func vintLength(first byte) int {
+	for i := 0; i < 8; i++ {
+		if first & (0x80 >> uint(i)) != 0 {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// readVint reads variable size integer without length marker
+func readVint(data []byte) (uint64, int, error) {
+	if len(data) == 0 {
+		return 0, 0, fmt.Errorf("empty vint")
+	}
+	length := vintLength(data[0])
+	if length == 0 || length > len(data) {
+		return 0, 0, fmt.Errorf("invalid vint")
+	}
+	value := uint64(data[0] & (0xFF >> uint(length)))
+	for _, b := range data[1:length] {
+		value = value << 8 | uint64(b)
+	}
+	return value, length, nil
+}
+
+func readUint(data []byte) uint64 {
+	var value uint64
+	for _, b := range data {
+		value = value << 8 | uint64(b)
+	}
+	return value
+}
+
+func readFloat(data []byte) float64 {
+	switch len(data) {
+	case 4:
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(data)))
+	case 8:
+		return math.Float64frombits(binary.BigEndian.Uint64(data))
+	}
+	return 0
+}
+
+func readString(data []byte) string {
+	return strings.TrimRight(string(data), "\x00")
+}
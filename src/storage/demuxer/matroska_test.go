@@ -0,0 +1,60 @@
+package demuxer
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func subtitlesFixture() ([]byte, []int64) {
+	// кадр видео из нулей - нормальные данные, а не недокачанный кусок
+	zeroFrame := make([]byte, 64 << 10)
+	return buildMatroska([]mkvCluster{
+		{timecode: 0, blocks: [][]byte{mkvBlock(1, 0, true, zeroFrame), mkvBlock(2, 1000, false, []byte("first"))}},
+		{timecode: 10000, blocks: [][]byte{mkvBlock(1, 0, true, zeroFrame), mkvBlock(2, 500, false, []byte("second"))}},
+		{timecode: 20000, blocks: [][]byte{mkvBlock(1, 0, true, zeroFrame), mkvBlock(2, 0, false, []byte("third"))}},
+	}, true)
+}
+
+func TestListSubtitleTracks(t *testing.T) {
+	data, _ := subtitlesFixture()
+	tracks, err := ListSubtitleTracks(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("Error listing tracks: %v", err)
+	}
+	if len(tracks) != 1 || tracks[0].Id != 2 || tracks[0].Format != FormatSrt || tracks[0].Language != "rus" {
+		t.Errorf("Unexpected tracks: %+v", tracks)
+	}
+}
+
+func TestExtractSubtitlesWithZeroData(t *testing.T) {
+	data, _ := subtitlesFixture()
+	subs, err := ExtractSubtitles(bytes.NewReader(data), int64(len(data)), 2)
+	if err != nil {
+		t.Fatalf("Error extracting: %v", err)
+	}
+	if !subs.Complete || len(subs.Cues) != 3 {
+		t.Fatalf("Expected 3 cues of complete file, got %+v", subs)
+	}
+	if subs.Cues[1].Text != "second" || subs.Cues[1].Start != 10500 * time.Millisecond {
+		t.Errorf("Unexpected cue: %+v", subs.Cues[1])
+	}
+}
+
+func TestExtractSubtitlesSkipsNotDownloaded(t *testing.T) {
+	data, clusters := subtitlesFixture()
+	size := int64(len(data))
+	// второй кластер не скачан, заголовки и Cues в конце есть
+	file := &partialFile{data: data, ranges: [][2]int64{{0, clusters[1] + 100}, {clusters[2] - 10, size}}}
+
+	subs, err := ExtractSubtitles(file, size, 2)
+	if err != nil {
+		t.Fatalf("Error extracting: %v", err)
+	}
+	if subs.Complete {
+		t.Errorf("Result with missing cluster is complete")
+	}
+	if len(subs.Cues) != 2 || subs.Cues[0].Text != "first" || subs.Cues[1].Text != "third" {
+		t.Errorf("Unexpected cues: %+v", subs.Cues)
+	}
+}
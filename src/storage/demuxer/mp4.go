@@ -0,0 +1,412 @@
+package demuxer
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+var mp4SubtitleHandlers = map[string]bool{"sbtl": true, "text": true, "subt": true}
+
+// tx3g и QuickTime text хранят в сэмпле длину и текст, остальное - стили
+var mp4SubtitleFormats = map[string]string{
+	"tx3g": FormatSrt,
+	"text": FormatSrt,
+}
+
+type mp4Box struct {
+	Type       string
+	Offset     int64
+	DataOffset int64
+	End        int64
+}
+
+type mp4Sample struct {
+	Offset   int64
+	Size     int64
+	Start    uint64 // в единицах timescale дорожки
+	Duration uint64
+	Sync     bool
+}
+
+type mp4Track struct {
+	Track
+	handler     string
+	timescale   uint32
+	duration    uint64
+	samples     []mp4Sample
+	sampleEntry []byte // первая запись stsd без размера и типа
+}
+
+type mp4File struct {
+	r          io.ReaderAt
+	size       int64
+	timescale  uint32
+	duration   uint64
+	fragmented bool
+	tracks     []mp4Track
+}
+
+func openMp4(r io.ReaderAt, size int64) (*mp4File, error) {
+	file := &mp4File{r: r, size: size}
+
+	for offset := int64(0); offset < size; {
+		box, err := readBoxHeader(r, offset, size)
+		if err != nil {
+			return nil, err
+		}
+		if box.Type == "moov" {
+			if box.End - box.DataOffset > maxHeaderElementSize {
+				return nil, fmt.Errorf("moov box is too large")
+			}
+			data := make([]byte, box.End - box.DataOffset)
+			if _, err := r.ReadAt(data, box.DataOffset); err != nil && err != io.EOF {
+				return nil, err
+			}
+			file.parseMoov(data)
+			return file, nil
+		}
+		offset = box.End
+	}
+	return nil, fmt.Errorf("moov box not found")
+}
+
+func (f *mp4File) parseMoov(data []byte) {
+	walkBoxes(data, func(boxType string, payload []byte) {
+		switch boxType {
+		case "mvhd":
+			f.timescale, f.duration = parseMediaHeader(payload)
+		case "mvex":
+			f.fragmented = true
+		case "trak":
+			f.tracks = append(f.tracks, parseTrak(payload))
+		}
+	})
+}
+
+// parseMediaHeader reads timescale and duration from mvhd and mdhd, they differ
+// only by fields after duration
+func parseMediaHeader(payload []byte) (uint32, uint64) {
+	if len(payload) < 4 {
+		return 0, 0
+	}
+	if payload[0] == 1 && len(payload) >= 32 {
+		return binary.BigEndian.Uint32(payload[20:]), binary.BigEndian.Uint64(payload[24:])
+	}
+	if len(payload) >= 20 {
+		return binary.BigEndian.Uint32(payload[12:]), uint64(binary.BigEndian.Uint32(payload[16:]))
+	}
+	return 0, 0
+}
+
+func parseTrak(data []byte) mp4Track {
+	track := mp4Track{}
+	var stbl []byte
+
+	walkBoxes(data, func(boxType string, payload []byte) {
+		switch boxType {
+		case "tkhd":
+			if len(payload) >= 24 && payload[0] == 1 {
+				track.Id = int(binary.BigEndian.Uint32(payload[20:]))
+			} else if len(payload) >= 16 {
+				track.Id = int(binary.BigEndian.Uint32(payload[12:]))
+			}
+			// флаг enabled
+			track.Default = len(payload) >= 4 && payload[3] & 0x1 != 0
+		case "mdia":
+			walkBoxes(payload, func(boxType string, payload []byte) {
+				switch boxType {
+				case "mdhd":
+					track.timescale, track.duration = parseMediaHeader(payload)
+					track.Language = parseMp4Language(payload)
+				case "hdlr":
+					if len(payload) >= 12 {
+						track.handler = string(payload[8:12])
+					}
+					if len(payload) > 24 {
+						track.Name = parseHandlerName(payload[24:])
+					}
+				case "minf":
+					walkBoxes(payload, func(boxType string, payload []byte) {
+						if boxType == "stbl" {
+							stbl = payload
+						}
+					})
+				}
+			})
+		}
+	})
+
+	if stbl != nil {
+		track.parseSampleTable(stbl)
+	}
+	return track
+}
+
+func parseMp4Language(mdhd []byte) string {
+	offset := 20
+	if len(mdhd) > 0 && mdhd[0] == 1 {
+		offset = 32
+	}
+	if len(mdhd) < offset + 2 {
+		return ""
+	}
+	packed := binary.BigEndian.Uint16(mdhd[offset:])
+	if packed == 0 || packed == 0x7FFF {
+		return ""
+	}
+	code := []byte{
+		byte(packed >> 10 & 0x1F) + 0x60,
+		byte(packed >> 5 & 0x1F) + 0x60,
+		byte(packed & 0x1F) + 0x60,
+	}
+	if string(code) == "und" {
+		return ""
+	}
+	return string(code)
+}
+
+func parseHandlerName(name []byte) string {
+	// в QuickTime имя - pascal строка с длиной в первом байте
+	if len(name) > 0 && int(name[0]) == len(name) - 1 {
+		name = name[1:]
+	}
+	result := strings.TrimRight(string(name), "\x00")
+	if strings.HasSuffix(result, "Handler") || strings.HasPrefix(result, "Core Media") {
+		// имена по умолчанию от муксеров ничего не говорят пользователю
+		return ""
+	}
+	return result
+}
+
+func (t *mp4Track) parseSampleTable(stbl []byte) {
+	var chunkOffsets []int64
+	var sampleSizes []int64
+	type stscEntry struct{ firstChunk, samplesPerChunk uint32 }
+	var stsc []stscEntry
+	type sttsEntry struct{ count, delta uint32 }
+	var stts []sttsEntry
+	// без stss все сэмплы ключевые
+	var syncSamples []uint32
+	hasSyncTable := false
+
+	walkBoxes(stbl, func(boxType string, payload []byte) {
+		if len(payload) < 8 {
+			return
+		}
+		count := int(binary.BigEndian.Uint32(payload[4:]))
+		body := payload[8:]
+		switch boxType {
+		case "stsd":
+			if len(body) >= 8 {
+				t.Codec = string(body[4:8])
+				if entrySize := int(binary.BigEndian.Uint32(body)); entrySize >= 8 && entrySize <= len(body) {
+					t.sampleEntry = body[8:entrySize]
+				}
+			}
+		case "stts":
+			for i := 0; i < count && len(body) >= 8 * (i + 1); i++ {
+				stts = append(stts, sttsEntry{binary.BigEndian.Uint32(body[8 * i:]), binary.BigEndian.Uint32(body[8 * i + 4:])})
+			}
+		case "stsc":
+			for i := 0; i < count && len(body) >= 12 * (i + 1); i++ {
+				stsc = append(stsc, stscEntry{binary.BigEndian.Uint32(body[12 * i:]), binary.BigEndian.Uint32(body[12 * i + 4:])})
+			}
+		case "stsz":
+			if len(payload) < 12 {
+				return
+			}
+			fixedSize := int64(binary.BigEndian.Uint32(payload[4:]))
+			count = int(binary.BigEndian.Uint32(payload[8:]))
+			body = payload[12:]
+			for i := 0; i < count; i++ {
+				if fixedSize != 0 {
+					sampleSizes = append(sampleSizes, fixedSize)
+				} else if len(body) >= 4 * (i + 1) {
+					sampleSizes = append(sampleSizes, int64(binary.BigEndian.Uint32(body[4 * i:])))
+				}
+			}
+		case "stss":
+			hasSyncTable = true
+			for i := 0; i < count && len(body) >= 4 * (i + 1); i++ {
+				syncSamples = append(syncSamples, binary.BigEndian.Uint32(body[4 * i:]))
+			}
+		case "stco":
+			for i := 0; i < count && len(body) >= 4 * (i + 1); i++ {
+				chunkOffsets = append(chunkOffsets, int64(binary.BigEndian.Uint32(body[4 * i:])))
+			}
+		case "co64":
+			for i := 0; i < count && len(body) >= 8 * (i + 1); i++ {
+				chunkOffsets = append(chunkOffsets, int64(binary.BigEndian.Uint64(body[8 * i:])))
+			}
+		}
+	})
+
+	t.samples = make([]mp4Sample, 0, len(sampleSizes))
+	sampleIdx := 0
+	for chunkIdx, chunkOffset := range chunkOffsets {
+		perChunk := uint32(0)
+		for _, entry := range stsc {
+			if entry.firstChunk > uint32(chunkIdx + 1) {
+				break
+			}
+			perChunk = entry.samplesPerChunk
+		}
+		offset := chunkOffset
+		for i := uint32(0); i < perChunk && sampleIdx < len(sampleSizes); i++ {
+			t.samples = append(t.samples, mp4Sample{Offset: offset, Size: sampleSizes[sampleIdx], Sync: !hasSyncTable})
+			offset += sampleSizes[sampleIdx]
+			sampleIdx++
+		}
+	}
+
+	for _, number := range syncSamples {
+		// номера сэмплов в stss начинаются с 1
+		if number > 0 && int(number) <= len(t.samples) {
+			t.samples[number - 1].Sync = true
+		}
+	}
+
+	var elapsed uint64
+	sampleIdx = 0
+	for _, entry := range stts {
+		for i := uint32(0); i < entry.count && sampleIdx < len(t.samples); i++ {
+			t.samples[sampleIdx].Start = elapsed
+			t.samples[sampleIdx].Duration = uint64(entry.delta)
+			elapsed += uint64(entry.delta)
+			sampleIdx++
+		}
+	}
+}
+
+func (t *mp4Track) toDuration(value uint64) time.Duration {
+	if t.timescale == 0 {
+		return 0
+	}
+	return time.Duration(float64(value) / float64(t.timescale) * float64(time.Second))
+}
+
+func (f *mp4File) subtitleTracks() []Track {
+	res := make([]Track, 0, len(f.tracks))
+	for _, track := range f.tracks {
+		if mp4SubtitleHandlers[track.handler] {
+			track.Format = mp4SubtitleFormats[track.Codec]
+			res = append(res, track.Track)
+		}
+	}
+	return res
+}
+
+func (f *mp4File) extractSubtitles(trackId int) (*Subtitles, error) {
+	track, err := findTrack(f.subtitleTracks(), trackId)
+	if err != nil {
+		return nil, err
+	}
+	var mp4Track *mp4Track
+	for i := range f.tracks {
+		if f.tracks[i].Id == trackId {
+			mp4Track = &f.tracks[i]
+		}
+	}
+	if len(mp4Track.samples) == 0 && f.fragmented {
+		return nil, fmt.Errorf("subtitles in fragmented mp4 are not supported")
+	}
+
+	result := &Subtitles{Track: track, Complete: true}
+	for _, sample := range mp4Track.samples {
+		if sample.Size <= 2 || sample.Size > 1 << 20 {
+			continue
+		}
+		data := make([]byte, sample.Size)
+		if _, err := f.r.ReadAt(data, sample.Offset); err != nil && err != io.EOF {
+			var notAvailable *NotAvailableError
+			if !errors.As(err, &notAvailable) {
+				return nil, err
+			}
+			result.Complete = false
+			continue
+		}
+
+		textLen := int(binary.BigEndian.Uint16(data))
+		if textLen == 0 {
+			// пустой сэмпл убирает предыдущий текст с экрана
+			continue
+		}
+		if textLen > len(data) - 2 {
+			textLen = len(data) - 2
+		}
+		text := decodeTx3gText(data[2:2 + textLen])
+		result.Cues = append(result.Cues, Cue{
+			Start: mp4Track.toDuration(sample.Start),
+			End:   mp4Track.toDuration(sample.Start + sample.Duration),
+			Text:  strings.ReplaceAll(text, "\r\n", "\n"),
+		})
+	}
+
+	fixCueEnds(result.Cues)
+	return result, nil
+}
+
+func decodeTx3gText(data []byte) string {
+	if len(data) >= 2 && data[0] == 0xFE && data[1] == 0xFF {
+		units := make([]uint16, (len(data) - 2) / 2)
+		for i := range units {
+			units[i] = binary.BigEndian.Uint16(data[2 + 2 * i:])
+		}
+		return string(utf16.Decode(units))
+	}
+	return string(data)
+}
+
+func readBoxHeader(r io.ReaderAt, offset int64, end int64) (mp4Box, error) {
+	head := make([]byte, 16)
+	n, err := r.ReadAt(head, offset)
+	if n < 8 {
+		return mp4Box{}, fmt.Errorf("error reading box at %v: %v", offset, err)
+	}
+	box := mp4Box{
+		Type:       string(head[4:8]),
+		Offset:     offset,
+		DataOffset: offset + 8,
+	}
+	size := int64(binary.BigEndian.Uint32(head))
+	switch size {
+	case 0:
+		box.End = end
+	case 1:
+		if n < 16 {
+			return mp4Box{}, fmt.Errorf("error reading large box size at %v", offset)
+		}
+		box.DataOffset = offset + 16
+		box.End = offset + int64(binary.BigEndian.Uint64(head[8:]))
+	default:
+		box.End = offset + size
+	}
+	if box.End < box.DataOffset || box.End > end {
+		return mp4Box{}, fmt.Errorf("invalid %v box size at %v", box.Type, offset)
+	}
+	return box, nil
+}
+
+// walkBoxes iterates over child boxes read into memory
+func walkBoxes(data []byte, callback func(boxType string, payload []byte)) {
+	for len(data) >= 8 {
+		size := uint64(binary.BigEndian.Uint32(data))
+		headerLen := uint64(8)
+		if size == 1 && len(data) >= 16 {
+			size = binary.BigEndian.Uint64(data[8:])
+			headerLen = 16
+		} else if size == 0 {
+			size = uint64(len(data))
+		}
+		if size < headerLen || size > uint64(len(data)) {
+			return
+		}
+		callback(string(data[4:8]), data[headerLen:size])
+		data = data[size:]
+	}
+}
@@ -0,0 +1,233 @@
+package demuxer
+
+import (
+	"encoding/binary"
+	"io"
+	"strings"
+)
+
+const (
+	StreamVideo     = "video"
+	StreamAudio     = "audio"
+	StreamSubtitles = "subtitles"
+)
+
+// короткие имена кодеков, как их понимают браузеры и ffprobe
+var codecNames = map[string]string{
+	"V_MPEG4/ISO/AVC":  "h264",
+	"V_MPEGH/ISO/HEVC": "hevc",
+	"V_VP8":            "vp8",
+	"V_VP9":            "vp9",
+	"V_AV1":            "av1",
+	"V_MPEG4/ISO/ASP":  "mpeg4",
+	"V_MPEG2":          "mpeg2video",
+	"A_AAC":            "aac",
+	"A_AC3":            "ac3",
+	"A_EAC3":           "eac3",
+	"A_DTS":            "dts",
+	"A_TRUEHD":         "truehd",
+	"A_OPUS":           "opus",
+	"A_VORBIS":         "vorbis",
+	"A_FLAC":           "flac",
+	"A_MPEG/L3":        "mp3",
+	"A_MPEG/L2":        "mp2",
+	"avc1":             "h264",
+	"avc3":             "h264",
+	"hev1":             "hevc",
+	"hvc1":             "hevc",
+	"vp08":             "vp8",
+	"vp09":             "vp9",
+	"av01":             "av1",
+	"mp4v":             "mpeg4",
+	"mp4a":             "aac",
+	"ac-3":             "ac3",
+	"ec-3":             "eac3",
+	"Opus":             "opus",
+	"fLaC":             "flac",
+	".mp3":             "mp3",
+}
+
+type StreamInfo struct {
+	Id         int    `json:"id"`
+	Type       string `json:"type"`
+	Codec      string `json:"codec"`
+	Language   string `json:"language,omitempty"`
+	Width      int    `json:"width,omitempty"`
+	Height     int    `json:"height,omitempty"`
+	Channels   int    `json:"channels,omitempty"`
+	SampleRate int    `json:"sampleRate,omitempty"`
+}
+
+// MediaInfo describes the video for players, bitrate is the average one of the
+// whole file
+type MediaInfo struct {
+	Container  string       `json:"container"`
+	Duration   float64      `json:"duration"` // секунды
+	Bitrate    int64        `json:"bitrate"`  // бит в секунду
+	VideoCodec string       `json:"videoCodec,omitempty"`
+	AudioCodec string       `json:"audioCodec,omitempty"`
+	Width      int          `json:"width,omitempty"`
+	Height     int          `json:"height,omitempty"`
+	Streams    []StreamInfo `json:"streams"`
+}
+
+// Probe reads container headers of matroska or mp4 file
+func Probe(r io.ReaderAt, size int64) (*MediaInfo, error) {
+	container, err := DetectContainer(r)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &MediaInfo{Container: container, Streams: make([]StreamInfo, 0, 4)}
+	switch container {
+	case ContainerMatroska:
+		mkv, err := openMatroska(r, size)
+		if err != nil {
+			return nil, err
+		}
+		mkv.probe(info)
+	default:
+		mp4, err := openMp4(r, size)
+		if err != nil {
+			return nil, err
+		}
+		mp4.probe(info)
+	}
+
+	for _, stream := range info.Streams {
+		if stream.Type == StreamVideo && info.VideoCodec == "" {
+			info.VideoCodec, info.Width, info.Height = stream.Codec, stream.Width, stream.Height
+		}
+		if stream.Type == StreamAudio && info.AudioCodec == "" {
+			info.AudioCodec = stream.Codec
+		}
+	}
+	if info.Duration > 0 {
+		info.Bitrate = int64(float64(size) * 8 / info.Duration)
+	}
+	return info, nil
+}
+
+func codecName(codec string) string {
+	if name, known := codecNames[codec]; known {
+		return name
+	}
+	return strings.ToLower(strings.TrimSpace(codec))
+}
+
+func (m *matroska) probe(info *MediaInfo) {
+	info.Duration = m.duration * float64(m.timecodeScale) / 1e9
+	for _, track := range m.tracks {
+		stream := StreamInfo{Id: track.Id, Codec: codecName(track.Codec), Language: track.Language}
+		switch track.trackType {
+		case mkvTrackTypeVideo:
+			stream.Type, stream.Width, stream.Height = StreamVideo, track.width, track.height
+		case mkvTrackTypeAudio:
+			stream.Type, stream.Channels, stream.SampleRate = StreamAudio, track.channels, track.sampleRate
+		case mkvTrackTypeSubtitle:
+			stream.Type = StreamSubtitles
+		default:
+			continue
+		}
+		info.Streams = append(info.Streams, stream)
+	}
+}
+
+func (f *mp4File) probe(info *MediaInfo) {
+	if f.timescale > 0 {
+		info.Duration = float64(f.duration) / float64(f.timescale)
+	}
+	for _, track := range f.tracks {
+		stream := StreamInfo{Id: track.Id, Codec: codecName(track.Codec), Language: track.Language}
+		entry := track.sampleEntry
+		switch {
+		case track.handler == "vide":
+			stream.Type = StreamVideo
+			if len(entry) >= 28 {
+				stream.Width = int(binary.BigEndian.Uint16(entry[24:]))
+				stream.Height = int(binary.BigEndian.Uint16(entry[26:]))
+			}
+		case track.handler == "soun":
+			stream.Type = StreamAudio
+			if len(entry) >= 28 {
+				stream.Channels = int(binary.BigEndian.Uint16(entry[16:]))
+				stream.SampleRate = int(binary.BigEndian.Uint16(entry[24:]))
+			}
+		case mp4SubtitleHandlers[track.handler]:
+			stream.Type = StreamSubtitles
+		default:
+			continue
+		}
+		info.Streams = append(info.Streams, stream)
+	}
+}
+
+// ContentType detects the media type by magic bytes of the file start, the
+// extension is used when the start is not downloaded or not recognized
+func ContentType(head []byte, extension string) string {
+	if contentType := sniffContentType(head); contentType != "" {
+		return contentType
+	}
+	if contentType, known := contentTypesByExtension[strings.ToLower(strings.TrimPrefix(extension, "."))]; known {
+		return contentType
+	}
+	return "application/octet-stream"
+}
+
+var contentTypesByExtension = map[string]string{
+	"mp4":  "video/mp4",
+	"m4v":  "video/mp4",
+	"mov":  "video/quicktime",
+	"mkv":  "video/x-matroska",
+	"webm": "video/webm",
+	"avi":  "video/x-msvideo",
+	"ts":   "video/mp2t",
+	"m2ts": "video/mp2t",
+	"mts":  "video/mp2t",
+	"mpg":  "video/mpeg",
+	"mpeg": "video/mpeg",
+	"vob":  "video/mpeg",
+	"flv":  "video/x-flv",
+	"wmv":  "video/x-ms-wmv",
+	"ogv":  "video/ogg",
+	"3gp":  "video/3gpp",
+}
+
+func sniffContentType(head []byte) string {
+	switch {
+	case len(head) >= 4 && string(head[:4]) == "\x1A\x45\xDF\xA3":
+		// DocType лежит в заголовке EBML в первых байтах
+		if strings.Contains(string(head[:minInt(len(head), 64)]), "webm") {
+			return "video/webm"
+		}
+		return "video/x-matroska"
+	case len(head) >= 12 && string(head[4:8]) == "ftyp":
+		switch string(head[8:12]) {
+		case "qt  ":
+			return "video/quicktime"
+		case "3gp4", "3gp5", "3gp6", "3g2a":
+			return "video/3gpp"
+		}
+		return "video/mp4"
+	case len(head) >= 12 && string(head[:4]) == "RIFF" && string(head[8:12]) == "AVI ":
+		return "video/x-msvideo"
+	case len(head) >= 189 && head[0] == 0x47 && head[188] == 0x47:
+		return "video/mp2t"
+	case len(head) >= 4 && string(head[:4]) == "\x00\x00\x01\xBA":
+		return "video/mpeg"
+	case len(head) >= 3 && string(head[:3]) == "FLV":
+		return "video/x-flv"
+	case len(head) >= 4 && string(head[:4]) == "\x30\x26\xB2\x75":
+		return "video/x-ms-wmv"
+	case len(head) >= 4 && string(head[:4]) == "OggS":
+		return "video/ogg"
+	}
+	return ""
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
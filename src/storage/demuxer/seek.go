@@ -0,0 +1,124 @@
+package demuxer
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+// SeekPoint is a keyframe of the video and the byte offset to read it from:
+// the cluster in matroska, the sample itself in mp4
+type SeekPoint struct {
+	Time   time.Duration
+	Offset int64
+}
+
+// SeekIndex maps time to keyframe offsets, files without the index are
+// estimated by the average bitrate
+type SeekIndex struct {
+	Duration  time.Duration
+	Points    []SeekPoint
+	dataStart int64
+	dataEnd   int64
+}
+
+// BuildSeekIndex reads matroska Cues or mp4 sample tables of the first video
+// track, the reader should wait for not downloaded parts
+func BuildSeekIndex(r io.ReaderAt, size int64) (*SeekIndex, error) {
+	container, err := DetectContainer(r)
+	if err != nil {
+		return nil, err
+	}
+	if container == ContainerMatroska {
+		mkv, err := OpenMatroska(r, size)
+		if err != nil {
+			return nil, err
+		}
+		return mkv.seekIndex()
+	}
+
+	mp4, err := openMp4(r, size)
+	if err != nil {
+		return nil, err
+	}
+	return mp4.seekIndex()
+}
+
+func (m *Matroska) seekIndex() (*SeekIndex, error) {
+	index := &SeekIndex{Duration: m.Duration(), dataStart: m.mkv.firstCluster, dataEnd: m.mkv.segmentEnd}
+	cues, err := m.Cues()
+	if err != nil {
+		return nil, err
+	}
+
+	videoTrack := -1
+	for _, track := range m.Tracks() {
+		if track.Type == StreamVideo {
+			videoTrack = track.Id
+			break
+		}
+	}
+	for _, cue := range cues {
+		if cue.Track == videoTrack {
+			index.Points = append(index.Points, SeekPoint{Time: cue.Time, Offset: cue.Cluster})
+		}
+	}
+	if len(index.Points) == 0 {
+		// ключевые кадры отмечены только для других дорожек
+		for _, cue := range cues {
+			index.Points = append(index.Points, SeekPoint{Time: cue.Time, Offset: cue.Cluster})
+		}
+	}
+	return index, nil
+}
+
+func (f *mp4File) seekIndex() (*SeekIndex, error) {
+	index := &SeekIndex{dataStart: 0, dataEnd: f.size}
+	if f.timescale > 0 {
+		index.Duration = time.Duration(float64(f.duration) / float64(f.timescale) * float64(time.Second))
+	}
+	for _, track := range f.tracks {
+		if track.handler != "vide" || len(track.samples) == 0 {
+			continue
+		}
+		for _, sample := range track.samples {
+			if sample.Sync {
+				index.Points = append(index.Points, SeekPoint{Time: track.toDuration(sample.Start), Offset: sample.Offset})
+			}
+		}
+		index.dataStart, index.dataEnd = track.samples[0].Offset, f.size
+		if index.Duration == 0 {
+			last := track.samples[len(track.samples) - 1]
+			index.Duration = track.toDuration(last.Start + last.Duration)
+		}
+		break
+	}
+	if len(index.Points) == 0 && !f.fragmented {
+		return nil, fmt.Errorf("mp4 has no video samples")
+	}
+	return index, nil
+}
+
+// Find returns the last keyframe before the position, exact is false when the
+// file has no index and the offset is estimated
+func (ix *SeekIndex) Find(position time.Duration) (point SeekPoint, exact bool) {
+	if len(ix.Points) == 0 {
+		if ix.Duration <= 0 || position <= 0 {
+			return SeekPoint{Offset: ix.dataStart}, false
+		}
+		ratio := position.Seconds() / ix.Duration.Seconds()
+		if ratio > 1 {
+			ratio = 1
+		}
+		return SeekPoint{Time: position, Offset: ix.dataStart + int64(float64(ix.dataEnd - ix.dataStart) * ratio)}, false
+	}
+
+	idx := sort.Search(len(ix.Points), func(i int) bool {
+		return ix.Points[i].Time > position
+	})
+	if idx == 0 {
+		return ix.Points[0], true
+	}
+	return ix.Points[idx - 1], true
+}
@@ -0,0 +1,98 @@
+package demuxer
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestSeekIndexMatroska(t *testing.T) {
+	data, clusters := subtitlesFixture()
+	index, err := BuildSeekIndex(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("Error building index: %v", err)
+	}
+	if len(index.Points) != 3 {
+		t.Fatalf("Expected a point per cluster, got %+v", index.Points)
+	}
+
+	for _, test := range []struct {
+		position time.Duration
+		cluster  int
+	}{
+		{0, 0},
+		{9 * time.Second, 0},
+		{10 * time.Second, 1},
+		{15 * time.Second, 1},
+		{time.Hour, 2},
+	} {
+		point, exact := index.Find(test.position)
+		if !exact || point.Offset != clusters[test.cluster] || point.Time != time.Duration(test.cluster) * 10 * time.Second {
+			t.Errorf("At %v expected cluster %v at %v, got %+v", test.position, test.cluster, clusters[test.cluster], point)
+		}
+	}
+}
+
+func TestSeekIndexMp4(t *testing.T) {
+	data, offsets := buildMp4(10, 100, 4, []uint32{1, 4, 8})
+	index, err := BuildSeekIndex(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("Error building index: %v", err)
+	}
+	if index.Duration != 10 * time.Second || len(index.Points) != 3 {
+		t.Fatalf("Unexpected index: %v, %+v", index.Duration, index.Points)
+	}
+
+	for _, test := range []struct {
+		position time.Duration
+		sample   int
+	}{
+		{0, 0},
+		{2 * time.Second, 0},
+		{5 * time.Second, 3},
+		{7 * time.Second, 7},
+		{time.Hour, 7},
+	} {
+		point, exact := index.Find(test.position)
+		if !exact || point.Offset != offsets[test.sample] || point.Time != time.Duration(test.sample) * time.Second {
+			t.Errorf("At %v expected sample %v at %v, got %+v", test.position, test.sample, offsets[test.sample], point)
+		}
+	}
+}
+
+func TestSeekIndexMp4WithoutSyncTable(t *testing.T) {
+	// без stss ключевые все сэмплы
+	data, offsets := buildMp4(5, 50, 5, nil)
+	index, err := BuildSeekIndex(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("Error building index: %v", err)
+	}
+	if point, _ := index.Find(2500 * time.Millisecond); len(index.Points) != 5 || point.Offset != offsets[2] {
+		t.Errorf("Unexpected point %+v of %v", point, len(index.Points))
+	}
+}
+
+func TestSeekIndexEstimated(t *testing.T) {
+	index := &SeekIndex{Duration: 100 * time.Second, dataStart: 1000, dataEnd: 11000}
+	for _, test := range []struct {
+		position time.Duration
+		offset   int64
+	}{
+		{0, 1000},
+		{25 * time.Second, 3500},
+		{200 * time.Second, 11000},
+	} {
+		if point, exact := index.Find(test.position); exact || point.Offset != test.offset {
+			t.Errorf("At %v expected estimated %v, got %+v", test.position, test.offset, point)
+		}
+	}
+}
+
+func TestSeekIndexWithoutVideo(t *testing.T) {
+	data, _ := buildMp4(3, 10, 3, nil)
+	// дорожка становится звуковой
+	data = bytes.Replace(data, []byte("vide"), []byte("soun"), 1)
+	if _, err := BuildSeekIndex(bytes.NewReader(data), int64(len(data))); err == nil {
+		t.Errorf("Expected error for mp4 without video")
+	}
+}
@@ -0,0 +1,177 @@
+package filesReader
+
+import (
+	"container/list"
+	"sync"
+
+	"hypertube_storage/metrics"
+	"hypertube_storage/model"
+	"hypertube_storage/parser/env"
+)
+
+// блоки выровнены, поэтому разные range запросы к одному месту попадают в
+// одни и те же блоки
+const rangeBlockSize = 256 << 10
+
+// запоминаем вдвое больше блоков, чем помещается в кэш
+const seenBlocksFactor = 2
+
+type blockKey struct {
+	fileName string
+	idx      int64
+}
+
+type cachedBlock struct {
+	key  blockKey
+	data []byte
+}
+
+// rangeCache is the LRU of written file blocks shared by all requests. A block
+// gets in only when it is requested the second time, the single viewer of a
+// film is served with sendfile and doesn't push hot blocks out
+type rangeCache struct {
+	sync.Mutex
+	capacity  int64
+	size      int64
+	blocks    map[blockKey]*list.Element
+	lru       *list.List
+	seen      map[blockKey]*list.Element
+	seenLru   *list.List
+	hits      int64
+	misses    int64
+	evictions int64
+}
+
+var cache = newRangeCache(env.GetParser().GetRangeCacheSize())
+
+func newRangeCache(capacity int64) *rangeCache {
+	return &rangeCache{
+		capacity: capacity,
+		blocks:   make(map[blockKey]*list.Element),
+		lru:      list.New(),
+		seen:     make(map[blockKey]*list.Element),
+		seenLru:  list.New(),
+	}
+}
+
+func (c *rangeCache) enabled() bool {
+	return c.capacity >= rangeBlockSize
+}
+
+// get returns the block data, it must not be changed by the caller
+func (c *rangeCache) get(key blockKey) ([]byte, bool) {
+	if !c.enabled() {
+		return nil, false
+	}
+	c.Lock()
+	defer c.Unlock()
+
+	element, ok := c.blocks[key]
+	if !ok {
+		c.misses++
+		metrics.RangeCacheRequests.Inc("miss")
+		return nil, false
+	}
+	c.hits++
+	metrics.RangeCacheRequests.Inc("hit")
+	c.lru.MoveToFront(element)
+	return element.Value.(*cachedBlock).data, true
+}
+
+// admit tells if the missed block is hot enough to be read into the cache
+func (c *rangeCache) admit(key blockKey) bool {
+	if !c.enabled() {
+		return false
+	}
+	c.Lock()
+	defer c.Unlock()
+
+	if element, ok := c.seen[key]; ok {
+		c.seenLru.Remove(element)
+		delete(c.seen, key)
+		return true
+	}
+	c.seen[key] = c.seenLru.PushFront(key)
+	for int64(c.seenLru.Len()) > c.capacity / rangeBlockSize * seenBlocksFactor {
+		oldest := c.seenLru.Back()
+		c.seenLru.Remove(oldest)
+		delete(c.seen, oldest.Value.(blockKey))
+	}
+	return false
+}
+
+func (c *rangeCache) put(key blockKey, data []byte) {
+	if int64(len(data)) > c.capacity {
+		return
+	}
+	c.Lock()
+	defer c.Unlock()
+
+	if element, ok := c.blocks[key]; ok {
+		// блок уже прочитал параллельный запрос
+		c.lru.MoveToFront(element)
+		return
+	}
+	c.blocks[key] = c.lru.PushFront(&cachedBlock{key: key, data: data})
+	c.size += int64(len(data))
+	for c.size > c.capacity {
+		c.removeElement(c.lru.Back())
+		c.evictions++
+		metrics.RangeCacheEvictions.Inc()
+	}
+	metrics.RangeCacheBytes.Set(float64(c.size))
+}
+
+// dropFile forgets the blocks of the file deleted by the eraser, the loader may
+// write it again with other content
+func (c *rangeCache) dropFile(fileName string) {
+	c.Lock()
+	defer c.Unlock()
+
+	for element := c.lru.Front(); element != nil; {
+		next := element.Next()
+		if element.Value.(*cachedBlock).key.fileName == fileName {
+			c.removeElement(element)
+		}
+		element = next
+	}
+	for element := c.seenLru.Front(); element != nil; {
+		next := element.Next()
+		if key := element.Value.(blockKey); key.fileName == fileName {
+			c.seenLru.Remove(element)
+			delete(c.seen, key)
+		}
+		element = next
+	}
+	metrics.RangeCacheBytes.Set(float64(c.size))
+}
+
+func (c *rangeCache) removeElement(element *list.Element) {
+	block := element.Value.(*cachedBlock)
+	c.lru.Remove(element)
+	delete(c.blocks, block.key)
+	c.size -= int64(len(block.data))
+}
+
+func (c *rangeCache) stats() model.RangeCacheStats {
+	c.Lock()
+	defer c.Unlock()
+
+	stats := model.RangeCacheStats{
+		CapacityBytes: c.capacity,
+		UsedBytes:     c.size,
+		Blocks:        c.lru.Len(),
+		BlockSize:     rangeBlockSize,
+		Hits:          c.hits,
+		Misses:        c.misses,
+		Evictions:     c.evictions,
+	}
+	if lookups := c.hits + c.misses; lookups > 0 {
+		stats.HitRate = float64(c.hits) / float64(lookups)
+	}
+	return stats
+}
+
+func (f *fileReader) RangeCacheStats() model.RangeCacheStats {
+	return cache.stats()
+}
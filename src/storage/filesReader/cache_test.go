@@ -0,0 +1,135 @@
+package filesReader
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path"
+	"testing"
+
+	"hypertube_storage/model"
+)
+
+func testBlock(fill byte) []byte {
+	return bytes.Repeat([]byte{fill}, rangeBlockSize)
+}
+
+func TestRangeCacheAdmission(t *testing.T) {
+	c := newRangeCache(4 * rangeBlockSize)
+	key := blockKey{fileName: "video", idx: 1}
+	if c.admit(key) {
+		t.Errorf("Block is admitted on the first request")
+	}
+	if !c.admit(key) {
+		t.Errorf("Block is not admitted on the second request")
+	}
+	// после допуска счет начинается заново
+	if c.admit(key) {
+		t.Errorf("Admitted block is still remembered")
+	}
+
+	disabled := newRangeCache(rangeBlockSize - 1)
+	disabled.put(key, []byte{1})
+	if disabled.admit(key) || disabled.admit(key) {
+		t.Errorf("Disabled cache admits blocks")
+	}
+	if _, ok := disabled.get(key); ok {
+		t.Errorf("Disabled cache returns blocks")
+	}
+}
+
+func TestRangeCacheSeenLimit(t *testing.T) {
+	// помним вдвое больше блоков, чем помещается
+	c := newRangeCache(rangeBlockSize)
+	for idx := int64(0); idx < 3; idx++ {
+		c.admit(blockKey{fileName: "video", idx: idx})
+	}
+	if c.admit(blockKey{fileName: "video", idx: 0}) {
+		t.Errorf("The oldest seen block is not forgotten")
+	}
+	if !c.admit(blockKey{fileName: "video", idx: 2}) {
+		t.Errorf("Recently seen block is forgotten")
+	}
+}
+
+func TestRangeCacheEviction(t *testing.T) {
+	c := newRangeCache(2 * rangeBlockSize)
+	a, b, d := blockKey{"video", 0}, blockKey{"video", 1}, blockKey{"video", 2}
+	c.put(a, testBlock(1))
+	c.put(b, testBlock(2))
+	if data, ok := c.get(a); !ok || data[0] != 1 {
+		t.Fatalf("Block a is not cached")
+	}
+	c.put(d, testBlock(3))
+
+	if _, ok := c.get(b); ok {
+		t.Errorf("Least recently used block is not evicted")
+	}
+	if _, ok := c.get(a); !ok {
+		t.Errorf("Recently used block is evicted")
+	}
+	stats := c.stats()
+	if stats.Blocks != 2 || stats.UsedBytes != 2 * rangeBlockSize || stats.Evictions != 1 || stats.Hits != 2 || stats.Misses != 1 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+
+	c.put(blockKey{"video", 3}, make([]byte, 3 * rangeBlockSize))
+	if c.stats().Blocks != 2 {
+		t.Errorf("Block larger than the cache is put")
+	}
+}
+
+func TestRangeCacheDropFile(t *testing.T) {
+	c := newRangeCache(4 * rangeBlockSize)
+	c.put(blockKey{"erased", 0}, testBlock(1))
+	c.put(blockKey{"erased", 1}, testBlock(2))
+	c.put(blockKey{"kept", 0}, testBlock(3))
+	c.admit(blockKey{"erased", 2})
+
+	c.dropFile("erased")
+	if _, ok := c.get(blockKey{"erased", 0}); ok {
+		t.Errorf("Block of the erased file is cached")
+	}
+	if _, ok := c.get(blockKey{"kept", 0}); !ok {
+		t.Errorf("Block of other file is dropped")
+	}
+	if c.admit(blockKey{"erased", 2}) {
+		t.Errorf("Seen block of the erased file is remembered")
+	}
+	if stats := c.stats(); stats.Blocks != 1 || stats.UsedBytes != rangeBlockSize {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+}
+
+func TestWriteRangeUsesCache(t *testing.T) {
+	previous := cache
+	cache = newRangeCache(4 * rangeBlockSize)
+	t.Cleanup(func() { cache = previous })
+
+	data := testData(2 * rangeBlockSize)
+	stream := openTestStream(t, context.Background(), "cached", data, model.ByteRange{Start: 0, End: int64(len(data))})
+	for i := 0; i < 2; i++ {
+		var out bytes.Buffer
+		if n, err := stream.WriteRange(&out, 100, 1000); err != nil || n != 1000 || !bytes.Equal(out.Bytes(), data[100:1100]) {
+			t.Fatalf("Error writing range: %v, %v", n, err)
+		}
+	}
+	if stats := cache.stats(); stats.Blocks != 1 {
+		t.Fatalf("Block requested twice is not cached: %+v", stats)
+	}
+
+	// файл изменился, но блок отдается из памяти
+	if err := os.WriteFile(path.Join(filesDir, "cached"), make([]byte, len(data)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if _, err := stream.WriteRange(&out, 0, 10); err != nil || !bytes.Equal(out.Bytes(), data[:10]) {
+		t.Errorf("Block is not served from the cache: %v", err)
+	}
+
+	cache.dropFile("cached")
+	out.Reset()
+	if _, err := stream.WriteRange(&out, 0, 10); err != nil || !bytes.Equal(out.Bytes(), make([]byte, 10)) {
+		t.Errorf("Dropped block is served: %v", err)
+	}
+}
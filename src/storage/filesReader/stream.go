@@ -0,0 +1,401 @@
+package filesReader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"hypertube_storage/dao"
+	"hypertube_storage/db"
+	"hypertube_storage/metrics"
+	"hypertube_storage/model"
+
+	"github.com/sirupsen/logrus"
+)
+
+// pub/sub сообщение может потеряться при переподключении, поэтому иногда
+// перечитываем диапазоны сами
+const rangesRecheckInterval = time.Second * 10
+const partWaitTimeout = time.Second * 1800
+
+// скачанные диапазоны и приоритеты загрузки лежат в redis
+var loaderState = db.GetLoadedStateDb()
+
+var copyBuffers = sync.Pool{New: func() interface{} {
+	buf := make([]byte, 64 << 10)
+	return &buf
+}}
+
+// notifier fans out completed ranges updates of the single redis subscription
+// to streams waiting for the file
+var notifier = struct {
+	sync.Mutex
+	once    sync.Once
+	waiters map[string]map[chan struct{}]struct{}
+}{waiters: make(map[string]map[chan struct{}]struct{})}
+
+// watchRanges starts the only subscription, it also drops erased files from the
+// cache so the first stream of loaded files starts it too
+func watchRanges() {
+	notifier.once.Do(func() {
+		go notifyWaiters(loaderState.WatchCompletedRanges(context.Background()))
+	})
+}
+
+// erasedCallbacks are called for files deleted by the eraser
+var erasedCallbacks = struct {
+	sync.Mutex
+	callbacks []func(fileName string)
+}{}
+
+// OnErased lets caches of data read from files forget the deleted ones
+func OnErased(callback func(fileName string)) {
+	watchRanges()
+
+	erasedCallbacks.Lock()
+	erasedCallbacks.callbacks = append(erasedCallbacks.callbacks, callback)
+	erasedCallbacks.Unlock()
+}
+
+func subscribeRanges(fileName string) chan struct{} {
+	watchRanges()
+
+	updates := make(chan struct{}, 1)
+	notifier.Lock()
+	if notifier.waiters[fileName] == nil {
+		notifier.waiters[fileName] = make(map[chan struct{}]struct{})
+	}
+	notifier.waiters[fileName][updates] = struct{}{}
+	notifier.Unlock()
+	return updates
+}
+
+func unsubscribeRanges(fileName string, updates chan struct{}) {
+	notifier.Lock()
+	delete(notifier.waiters[fileName], updates)
+	if len(notifier.waiters[fileName]) == 0 {
+		delete(notifier.waiters, fileName)
+	}
+	notifier.Unlock()
+}
+
+func notifyWaiters(updatesChan chan model.RangesUpdate) {
+	for update := range updatesChan {
+		if update.Erased {
+			cache.dropFile(update.FileName)
+			erasedCallbacks.Lock()
+			callbacks := erasedCallbacks.callbacks
+			erasedCallbacks.Unlock()
+			for _, callback := range callbacks {
+				callback(update.FileName)
+			}
+		}
+		notifier.Lock()
+		for updates := range notifier.waiters[update.FileName] {
+			select {
+			case updates <- struct{}{}:
+			default:
+				// читатель еще не забрал прошлое уведомление
+			}
+		}
+		notifier.Unlock()
+	}
+}
+
+type fileStream struct {
+	ctx         context.Context
+	fileId      string
+	file        model.FileInfo
+	isLoaded    bool
+
+	osFile      *os.File
+	pos         int64
+	ranges      model.ByteRanges
+	updates     chan struct{}
+	prioritized int64
+}
+
+func (f *fileReader) OpenStream(ctx context.Context, fileId string, file model.FileInfo, isLoaded bool) (dao.FileStream, error) {
+	stream := &fileStream{ctx: ctx, fileId: fileId, file: file, isLoaded: isLoaded, prioritized: -1}
+	watchRanges()
+	if isLoaded {
+		osFile, err := f.OpenFile(file.Name)
+		if err != nil {
+			return nil, err
+		}
+		stream.osFile = osFile
+		return stream, nil
+	}
+
+	stream.updates = subscribeRanges(file.Name)
+	stream.ranges = loaderState.GetCompletedRanges(file.Name)
+	return stream, nil
+}
+
+func (s *fileStream) Read(p []byte) (int, error) {
+	if s.pos >= s.file.Length {
+		return 0, io.EOF
+	}
+	if rest := s.file.Length - s.pos; int64(len(p)) > rest {
+		p = p[:rest]
+	}
+	if !s.isLoaded {
+		available, err := s.waitAvailable(s.pos)
+		if err != nil {
+			return 0, err
+		}
+		if int64(len(p)) > available {
+			p = p[:available]
+		}
+	}
+	if err := s.openFile(); err != nil {
+		return 0, err
+	}
+
+	n, err := s.osFile.ReadAt(p, s.pos)
+	s.pos += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+// ReadAt blocks until the whole [off, off + len(p)) is written, the read
+// position is not changed
+func (s *fileStream) ReadAt(p []byte, off int64) (int, error) {
+	if off >= s.file.Length {
+		return 0, io.EOF
+	}
+	requested := len(p)
+	if rest := s.file.Length - off; int64(len(p)) > rest {
+		p = p[:rest]
+	}
+	if err := s.WaitRange(off, off + int64(len(p))); err != nil {
+		return 0, err
+	}
+	if err := s.openFile(); err != nil {
+		return 0, err
+	}
+
+	n, err := s.osFile.ReadAt(p, off)
+	if err == nil && n < requested {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// WriteRange copies [start, start + length) to w. Hot blocks are taken from the
+// cache, the rest goes from the file with io.Copy, which turns into sendfile
+// when w is the connection. Not downloaded parts are waited like in Read
+func (s *fileStream) WriteRange(w io.Writer, start, length int64) (int64, error) {
+	end := start + length
+	if end > s.file.Length {
+		end = s.file.Length
+	}
+	var written int64
+	for pos := start; pos < end; {
+		idx := pos / rangeBlockSize
+		blockStart := idx * rangeBlockSize
+		blockEnd := blockStart + rangeBlockSize
+		if blockEnd > s.file.Length {
+			blockEnd = s.file.Length
+		}
+		segmentEnd := blockEnd
+		if segmentEnd > end {
+			segmentEnd = end
+		}
+
+		key := blockKey{fileName: s.file.Name, idx: idx}
+		data, cached := cache.get(key)
+		if !cached && s.blockWritten(blockStart, blockEnd) && cache.admit(key) {
+			block, err := s.readBlock(blockStart, blockEnd)
+			if err != nil {
+				return written, err
+			}
+			cache.put(key, block)
+			data, cached = block, true
+		}
+
+		var n int64
+		var err error
+		if cached {
+			var copied int
+			copied, err = w.Write(data[pos - blockStart:segmentEnd - blockStart])
+			n = int64(copied)
+		} else {
+			n, err = s.copyFromFile(w, pos, segmentEnd)
+		}
+		written += n
+		pos += n
+		s.pos = pos
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+func (s *fileStream) blockWritten(start, end int64) bool {
+	return s.isLoaded || s.ranges.Covers(start, end)
+}
+
+func (s *fileStream) readBlock(start, end int64) ([]byte, error) {
+	if err := s.openFile(); err != nil {
+		return nil, err
+	}
+	block := make([]byte, end - start)
+	if _, err := s.osFile.ReadAt(block, start); err != nil {
+		return nil, err
+	}
+	return block, nil
+}
+
+// copyFromFile writes the part from pos to end which is already downloaded, the
+// buffer is used only when w can't read from the file itself
+func (s *fileStream) copyFromFile(w io.Writer, pos, end int64) (int64, error) {
+	if !s.isLoaded {
+		available, err := s.waitAvailable(pos)
+		if err != nil {
+			return 0, err
+		}
+		if pos + available < end {
+			end = pos + available
+		}
+	}
+	if err := s.openFile(); err != nil {
+		return 0, err
+	}
+	if _, err := s.osFile.Seek(pos, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	buf := copyBuffers.Get().(*[]byte)
+	defer copyBuffers.Put(buf)
+	n, err := io.CopyBuffer(w, &io.LimitedReader{R: s.osFile, N: end - pos}, *buf)
+	if err == nil && n < end - pos {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// openFile opens the file on the first read, the loader creates it on the first
+// write so it may not exist when the stream is opened
+func (s *fileStream) openFile() error {
+	if s.osFile != nil {
+		return nil
+	}
+	osFile, err := os.Open(path.Join(filesDir, s.file.Name))
+	if err != nil {
+		return err
+	}
+	s.osFile = osFile
+	return nil
+}
+
+func (s *fileStream) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += s.pos
+	case io.SeekEnd:
+		offset += s.file.Length
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	s.pos = offset
+	return offset, nil
+}
+
+// WaitRange blocks until [start, end) is written, the loader is asked to
+// download it first
+func (s *fileStream) WaitRange(start, end int64) error {
+	if s.isLoaded {
+		return nil
+	}
+	if end > s.file.Length {
+		end = s.file.Length
+	}
+	for pos := start; pos < end; {
+		available, err := s.waitAvailable(pos)
+		if err != nil {
+			return err
+		}
+		pos += available
+	}
+	return nil
+}
+
+// NextDownloaded doesn't wait, it tells which part from offset is written by
+// the ranges known to the stream
+func (s *fileStream) NextDownloaded(offset int64) (int64, int64) {
+	if offset >= s.file.Length {
+		return 0, 0
+	}
+	if s.isLoaded {
+		return offset, s.file.Length
+	}
+	next, ok := s.ranges.Next(offset)
+	if !ok {
+		return 0, 0
+	}
+	if next.Start < offset {
+		next.Start = offset
+	}
+	return next.Start, next.End
+}
+
+func (s *fileStream) Close() error {
+	if s.updates != nil {
+		unsubscribeRanges(s.file.Name, s.updates)
+	}
+	if s.osFile != nil {
+		return s.osFile.Close()
+	}
+	return nil
+}
+
+// waitAvailable returns how many bytes from pos are already written
+func (s *fileStream) waitAvailable(pos int64) (int64, error) {
+	if available := s.ranges.AvailableFrom(pos); available > 0 {
+		return available, nil
+	}
+
+	waitStart := time.Now()
+	waited := false
+	waitCtx, waitCancel := context.WithTimeout(s.ctx, partWaitTimeout)
+	defer waitCancel()
+
+	for {
+		s.ranges = loaderState.GetCompletedRanges(s.file.Name)
+		if available := s.ranges.AvailableFrom(pos); available > 0 {
+			if waited {
+				metrics.FilePartWaitSeconds.Observe(time.Since(waitStart).Seconds(), "done")
+			}
+			return available, nil
+		}
+
+		if s.prioritized != pos {
+			logrus.Debugf("Waiting for %v part at %v", s.file.Name, pos)
+			loaderState.PubPriorityByteIdx(s.fileId, s.file.Name, pos)
+			s.prioritized = pos
+		}
+
+		waited = true
+		select {
+		case <- waitCtx.Done():
+			metrics.FilePartWaitSeconds.Observe(time.Since(waitStart).Seconds(), "timeout")
+			metrics.FilePartWaitTimeouts.Inc()
+			return 0, fmt.Errorf("waiting for %v at %v: %v", s.file.Name, pos, waitCtx.Err())
+		case <- s.updates:
+		case <- time.After(rangesRecheckInterval):
+		}
+	}
+}
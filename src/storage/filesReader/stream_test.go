@@ -0,0 +1,231 @@
+package filesReader
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path"
+	"sync"
+	"testing"
+	"time"
+
+	"hypertube_storage/model"
+)
+
+// testLoaderState keeps completed ranges in memory, the subscription of the
+// notifier is started once for all tests
+type testLoaderState struct {
+	sync.Mutex
+	ranges     map[string]model.ByteRanges
+	priorities chan int64
+	updates    chan model.RangesUpdate
+}
+
+var testLoader = &testLoaderState{
+	ranges:     make(map[string]model.ByteRanges),
+	priorities: make(chan int64, 16),
+	updates:    make(chan model.RangesUpdate, 16),
+}
+
+func (l *testLoaderState) InitConnection()  {}
+func (l *testLoaderState) CloseConnection() {}
+
+func (l *testLoaderState) GetCompletedRanges(fileName string) model.ByteRanges {
+	l.Lock()
+	defer l.Unlock()
+	return l.ranges[fileName]
+}
+
+func (l *testLoaderState) WatchCompletedRanges(ctx context.Context) chan model.RangesUpdate {
+	return l.updates
+}
+
+func (l *testLoaderState) PubPriorityByteIdx(fileId, fileName string, idx int64) {
+	l.priorities <- idx
+}
+
+// complete publishes new ranges of the file like the loader does
+func (l *testLoaderState) complete(fileName string, ranges ...model.ByteRange) {
+	l.Lock()
+	l.ranges[fileName] = ranges
+	l.Unlock()
+	l.updates <- model.RangesUpdate{FileName: fileName}
+}
+
+// openTestStream writes the whole file, but only ranges are reported as
+// downloaded
+func openTestStream(t *testing.T, ctx context.Context, name string, data []byte, ranges ...model.ByteRange) *fileStream {
+	loaderState = testLoader
+	filesDir = t.TempDir()
+	if err := os.WriteFile(path.Join(filesDir, name), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	testLoader.Lock()
+	testLoader.ranges[name] = ranges
+	testLoader.Unlock()
+
+	stream, err := GetManager().OpenStream(ctx, "file-id", model.FileInfo{Name: name, Length: int64(len(data))}, false)
+	if err != nil {
+		t.Fatalf("Error opening stream: %v", err)
+	}
+	t.Cleanup(func() { stream.Close() })
+	return stream.(*fileStream)
+}
+
+func testData(size int) []byte {
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	return data
+}
+
+func expectPriority(t *testing.T, expected int64) {
+	select {
+	case idx := <-testLoader.priorities:
+		if idx != expected {
+			t.Errorf("Expected priority at %v, got %v", expected, idx)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("No priority at %v", expected)
+	}
+}
+
+func TestStreamReadWaitsForRanges(t *testing.T) {
+	data := testData(1000)
+	stream := openTestStream(t, context.Background(), "read", data, model.ByteRange{Start: 0, End: 100})
+
+	buf := make([]byte, 300)
+	n, err := stream.Read(buf)
+	if err != nil || n != 100 || !bytes.Equal(buf[:n], data[:100]) {
+		t.Fatalf("Expected downloaded 100 bytes, got %v, %v", n, err)
+	}
+
+	done := make(chan error)
+	go func() {
+		n, err := stream.Read(buf)
+		if err == nil && (n != 200 || !bytes.Equal(buf[:n], data[100:300])) {
+			t.Errorf("Unexpected read of %v bytes", n)
+		}
+		done <- err
+	}()
+	expectPriority(t, 100)
+	select {
+	case <-done:
+		t.Fatalf("Read returned before the part is written")
+	default:
+	}
+
+	testLoader.complete("read", model.ByteRange{Start: 0, End: 300})
+	if err := <-done; err != nil {
+		t.Fatalf("Error reading: %v", err)
+	}
+}
+
+func TestStreamReadAtWaitsForWholeRange(t *testing.T) {
+	data := testData(1000)
+	stream := openTestStream(t, context.Background(), "readat", data,
+		model.ByteRange{Start: 0, End: 100}, model.ByteRange{Start: 200, End: 1000})
+
+	done := make(chan error)
+	buf := make([]byte, 300)
+	go func() {
+		_, err := stream.ReadAt(buf, 50)
+		done <- err
+	}()
+	// первая часть есть, ждем дыру между диапазонами
+	expectPriority(t, 100)
+
+	testLoader.complete("readat", model.ByteRange{Start: 0, End: 1000})
+	if err := <-done; err != nil {
+		t.Fatalf("Error reading: %v", err)
+	}
+	if !bytes.Equal(buf, data[50:350]) {
+		t.Errorf("Unexpected data")
+	}
+	if stream.pos != 0 {
+		t.Errorf("ReadAt moved the position to %v", stream.pos)
+	}
+
+	if n, err := stream.ReadAt(buf, 900); n != 100 || err != io.EOF {
+		t.Errorf("Expected 100 bytes and EOF at the end, got %v, %v", n, err)
+	}
+}
+
+func TestStreamWaitRangeCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	stream := openTestStream(t, ctx, "cancel", testData(1000))
+
+	done := make(chan error)
+	go func() {
+		done <- stream.WaitRange(0, 10)
+	}()
+	expectPriority(t, 0)
+	cancel()
+
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Errorf("WaitRange succeeded after cancel")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("WaitRange is not cancelled")
+	}
+}
+
+func TestNotifierFanOut(t *testing.T) {
+	data := testData(1000)
+	first := openTestStream(t, context.Background(), "shared", data)
+	second, err := GetManager().OpenStream(context.Background(), "file-id", first.file, false)
+	if err != nil {
+		t.Fatalf("Error opening stream: %v", err)
+	}
+
+	done := make(chan error, 2)
+	go func() { done <- first.WaitRange(0, 500) }()
+	expectPriority(t, 0)
+	go func() { done <- second.WaitRange(0, 500) }()
+	expectPriority(t, 0)
+
+	// одно уведомление будит всех читателей файла
+	testLoader.complete("shared", model.ByteRange{Start: 0, End: 1000})
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Errorf("Error waiting: %v", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Stream %v is not notified", i)
+		}
+	}
+
+	second.Close()
+	first.Close()
+	notifier.Lock()
+	waiters := len(notifier.waiters["shared"])
+	notifier.Unlock()
+	if waiters != 0 {
+		t.Errorf("Closed streams are still subscribed: %v", waiters)
+	}
+}
+
+func TestStreamNextDownloaded(t *testing.T) {
+	stream := openTestStream(t, context.Background(), "next", testData(1000),
+		model.ByteRange{Start: 100, End: 200}, model.ByteRange{Start: 500, End: 600})
+
+	for _, test := range []struct {
+		offset, start, end int64
+	}{
+		{0, 100, 200},
+		{150, 150, 200},
+		{200, 500, 600},
+		{600, 0, 0},
+		{1000, 0, 0},
+	} {
+		if start, end := stream.NextDownloaded(test.offset); start != test.start || end != test.end {
+			t.Errorf("At %v expected %v-%v, got %v-%v", test.offset, test.start, test.end, start, end)
+		}
+	}
+}
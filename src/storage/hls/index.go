@@ -0,0 +1,371 @@
+package hls
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sync"
+	"time"
+
+	"hypertube_storage/dao"
+
+	"github.com/asticode/go-astits"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	targetSegmentDuration = 6 * time.Second
+	// начало и конец файла читаем целиком: PAT/PMT и первые и последние PTS
+	probeWindow = 2 << 20
+	// дальше ключевой кадр не ищем и режем сегмент где пришлось
+	maxKeyframeSearch = 16 << 20
+	ptsWrap           = 1 << 33
+)
+
+var ErrNotTransportStream = errors.New("file is not mpeg transport stream")
+
+// Index splits a transport stream into segments of about the same duration.
+// Boundaries are estimated by bytes and moved to the next keyframe, so the
+// playlist is known before the whole file is downloaded
+type Index struct {
+	Duration time.Duration
+	Bitrate  int64
+	Segments int
+
+	firstPacket int64
+	end         int64
+	packetSize  int
+	psi         []byte // PAT и PMT, ими начинается каждый сегмент
+	videoPid    uint16
+	streamType  astits.StreamType
+
+	firstPts    int64
+	lastPts     int64
+
+	mu         sync.Mutex
+	boundaries map[int]boundary
+}
+
+// boundary is the first packet of a segment and the PTS of its first frame,
+// pts is -1 when there was no timestamp in the searched window
+type boundary struct {
+	offset int64
+	pts    int64
+}
+
+// BuildIndex reads PAT, PMT and timestamps from the start and the end of the
+// file, the loader is asked to download them first
+func BuildIndex(ctx context.Context, stream dao.FileStream, size int64) (*Index, error) {
+	head, err := readWindow(stream, 0, size)
+	if err != nil {
+		return nil, err
+	}
+	firstPacket, packetSize, ok := detectPacketSize(head)
+	if !ok {
+		return nil, ErrNotTransportStream
+	}
+
+	ix := &Index{
+		firstPacket: firstPacket,
+		end:         firstPacket + (size - firstPacket) / int64(packetSize) * int64(packetSize),
+		packetSize:  packetSize,
+		boundaries:  make(map[int]boundary),
+	}
+	firstPts, err := ix.readProgram(ctx, head[firstPacket:])
+	if err != nil {
+		return nil, err
+	}
+
+	tailStart := size - probeWindow
+	if tailStart < 0 {
+		tailStart = 0
+	}
+	tail, err := readWindow(stream, tailStart, size)
+	if err != nil {
+		return nil, err
+	}
+	lastPts, ok := ix.findLastPts(ctx, tail)
+	if !ok {
+		return nil, fmt.Errorf("no timestamps at the end of the stream")
+	}
+	if lastPts < firstPts {
+		lastPts += ptsWrap
+	}
+
+	ix.firstPts, ix.lastPts = firstPts, lastPts
+	ix.Duration = time.Duration(lastPts - firstPts) * time.Second / 90000
+	if ix.Duration <= 0 {
+		return nil, fmt.Errorf("invalid stream duration %v", ix.Duration)
+	}
+	ix.Segments = int(math.Ceil(float64(ix.Duration) / float64(targetSegmentDuration)))
+	ix.Bitrate = int64(float64(size) * 8 / ix.Duration.Seconds())
+	logrus.Debugf("Indexed ts: duration=%v, segments=%v, pid=%v, packet=%v", ix.Duration, ix.Segments, ix.videoPid, packetSize)
+	return ix, nil
+}
+
+func readWindow(stream dao.FileStream, start, size int64) ([]byte, error) {
+	end := start + probeWindow
+	if end > size {
+		end = size
+	}
+	if err := stream.WaitRange(start, end); err != nil {
+		return nil, err
+	}
+	if _, err := stream.Seek(start, io.SeekStart); err != nil {
+		return nil, err
+	}
+	data := make([]byte, end - start)
+	if _, err := io.ReadFull(stream, data); err != nil {
+		return nil, fmt.Errorf("error reading ts at %v: %v", start, err)
+	}
+	return data, nil
+}
+
+// readProgram chooses the video stream of the first program and keeps its PAT
+// and PMT packets, returns the first PTS of the stream
+func (ix *Index) readProgram(ctx context.Context, head []byte) (int64, error) {
+	pmtPid := -1
+	found := false
+	// astits отдает PES раньше таблиц, поэтому запоминаем PTS всех потоков
+	firstPts := make(map[uint16]int64)
+
+	walkData(ctx, head, ix.packetSize, func(d *astits.DemuxerData) {
+		switch {
+		case d.PAT != nil && pmtPid < 0:
+			for _, program := range d.PAT.Programs {
+				if program.ProgramNumber > 0 {
+					pmtPid = int(program.ProgramMapID)
+					break
+				}
+			}
+		case d.PMT != nil && int(d.PID) == pmtPid && !found:
+			for _, es := range d.PMT.ElementaryStreams {
+				// без видео сегменты режем по первому потоку
+				if !found || isVideoStream(es.StreamType) && !isVideoStream(ix.streamType) {
+					ix.videoPid, ix.streamType, found = es.ElementaryPID, es.StreamType, true
+				}
+			}
+		case d.PES != nil:
+			if _, known := firstPts[d.PID]; !known {
+				if header := d.PES.Header.OptionalHeader; header != nil && header.PTS != nil {
+					firstPts[d.PID] = header.PTS.Base
+				}
+			}
+		}
+	})
+	if !found {
+		return 0, fmt.Errorf("program map table is not found")
+	}
+	pts, known := firstPts[ix.videoPid]
+	if !known {
+		return 0, fmt.Errorf("no timestamps at the start of the stream")
+	}
+
+	ix.psi = make([]byte, 0, 2 * tsPacketSize)
+	havePat, havePmt := false, false
+	for offset := 0; offset + ix.packetSize <= len(head) && !(havePat && havePmt); offset += ix.packetSize {
+		packet := head[offset + ix.packetSize - tsPacketSize:offset + ix.packetSize]
+		pid := int(packet[1] & 0x1F) << 8 | int(packet[2])
+		if pid == int(astits.PIDPAT) && !havePat || pid == pmtPid && !havePmt {
+			ix.psi = append(ix.psi, packet...)
+			havePat, havePmt = havePat || pid == int(astits.PIDPAT), havePmt || pid == pmtPid
+		}
+	}
+	return pts, nil
+}
+
+func (ix *Index) findLastPts(ctx context.Context, tail []byte) (int64, bool) {
+	start := findPacketStart(tail, ix.packetSize)
+	if start < 0 {
+		return 0, false
+	}
+	lastPts := int64(-1)
+	walkData(ctx, tail[start:], ix.packetSize, func(d *astits.DemuxerData) {
+		if d.PES != nil && d.PID == ix.videoPid {
+			// B-кадры идут не по порядку PTS, берем максимальный
+			if header := d.PES.Header.OptionalHeader; header != nil && header.PTS != nil && header.PTS.Base > lastPts {
+				lastPts = header.PTS.Base
+			}
+		}
+	})
+	return lastPts, lastPts >= 0
+}
+
+// walkData passes parsed PSI and PES to the callback, broken data at the window
+// edges is skipped
+func walkData(ctx context.Context, data []byte, packetSize int, callback func(d *astits.DemuxerData)) {
+	demuxer := astits.NewDemuxer(ctx, newTsReader(bytes.NewReader(data), packetSize), astits.DemuxerOptPacketSize(tsPacketSize))
+	for {
+		d, err := demuxer.NextData()
+		if errors.Is(err, astits.ErrNoMorePackets) || ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			continue
+		}
+		callback(d)
+	}
+}
+
+// SegmentDuration is the time between the first frames of the segment and of
+// the next one. Until both boundaries are found it is estimated as an equal
+// part of the stream
+func (ix *Index) SegmentDuration(segment int) time.Duration {
+	ix.mu.Lock()
+	start, foundStart := ix.knownBoundary(segment)
+	end, foundEnd := ix.knownBoundary(segment + 1)
+	ix.mu.Unlock()
+
+	if foundStart && foundEnd && start.pts >= 0 && end.pts >= 0 {
+		pts := end.pts - start.pts
+		if pts < 0 {
+			pts += ptsWrap
+		}
+		// переполнение PTS бывает один раз, разница больше половины - мусор
+		if pts > 0 && pts < ptsWrap / 2 {
+			return time.Duration(pts) * time.Second / 90000
+		}
+	}
+
+	duration := ix.Duration / time.Duration(ix.Segments)
+	if segment == ix.Segments - 1 {
+		return ix.Duration - duration * time.Duration(ix.Segments - 1)
+	}
+	return duration
+}
+
+// knownBoundary doesn't read the stream, the caller holds mu
+func (ix *Index) knownBoundary(segment int) (boundary, bool) {
+	if segment <= 0 {
+		return boundary{offset: ix.firstPacket, pts: ix.firstPts}, true
+	}
+	if segment >= ix.Segments {
+		return boundary{offset: ix.end, pts: ix.lastPts}, true
+	}
+	found, cached := ix.boundaries[segment]
+	return found, cached
+}
+
+// estimate is the position of the segment if bytes were spread evenly in time
+func (ix *Index) estimate(segment int) int64 {
+	packets := (ix.end - ix.firstPacket) / int64(ix.packetSize)
+	return ix.firstPacket + packets * int64(segment) / int64(ix.Segments) * int64(ix.packetSize)
+}
+
+// segmentStart is the first keyframe after the estimated position, reading
+// waits for pieces which are not downloaded yet
+func (ix *Index) segmentStart(ctx context.Context, stream dao.FileStream, segment int) (int64, error) {
+	found, _, err := ix.locate(ctx, stream, segment, true)
+	return found.offset, err
+}
+
+// locate searches the keyframe between the estimates of the segment and of the
+// next one, so boundaries always grow and a GOP longer than a segment doesn't
+// give an empty segment. Without a keyframe the segment is cut at its
+// estimate. Without waiting only downloaded data is read and false is returned
+// when it is not enough
+func (ix *Index) locate(ctx context.Context, stream dao.FileStream, segment int, wait bool) (boundary, bool, error) {
+	ix.mu.Lock()
+	found, known := ix.knownBoundary(segment)
+	ix.mu.Unlock()
+	if known {
+		return found, true, nil
+	}
+
+	estimate := ix.estimate(segment)
+	limit := ix.estimate(segment + 1)
+	if limit > estimate + maxKeyframeSearch {
+		limit = estimate + maxKeyframeSearch
+	}
+	complete := true
+	if !wait {
+		start, end := stream.NextDownloaded(estimate)
+		if start != estimate || end <= estimate {
+			return boundary{}, false, nil
+		}
+		if end < limit {
+			limit, complete = end, false
+		}
+	}
+
+	found = boundary{offset: estimate, pts: -1}
+	keyframe := false
+	reader := newTsReader(io.NewSectionReader(stream, estimate, limit - estimate), ix.packetSize)
+	demuxer := astits.NewDemuxer(ctx, reader, astits.DemuxerOptPacketSize(tsPacketSize))
+	for idx := int64(0); ; idx++ {
+		packet, err := demuxer.NextPacket()
+		if errors.Is(err, astits.ErrNoMorePackets) {
+			break
+		}
+		if errors.Is(err, astits.ErrPacketMustStartWithASyncByte) {
+			continue
+		}
+		if err != nil {
+			return boundary{}, false, err
+		}
+		if packet.Header.PID != ix.videoPid {
+			continue
+		}
+		pts, hasPts := packetPts(packet)
+		if isKeyframePacket(packet, ix.streamType) {
+			found, keyframe = boundary{offset: estimate + idx * int64(ix.packetSize), pts: -1}, true
+			if hasPts {
+				found.pts = pts
+			}
+			break
+		}
+		// время разреза - первый кадр после него
+		if hasPts && found.pts < 0 {
+			found.pts = pts
+		}
+	}
+	if !keyframe {
+		if !complete {
+			return boundary{}, false, nil
+		}
+		logrus.Debugf("No keyframe in %v-%v, cutting segment %v there", estimate, limit, segment)
+	}
+
+	ix.mu.Lock()
+	ix.boundaries[segment] = found
+	ix.mu.Unlock()
+	return found, true, nil
+}
+
+// locateDownloaded finds boundaries in the downloaded part of the stream, so
+// the playlist has real durations of these segments
+func (ix *Index) locateDownloaded(ctx context.Context, stream dao.FileStream) {
+	for segment := 1; segment < ix.Segments && ctx.Err() == nil; segment++ {
+		if _, _, err := ix.locate(ctx, stream, segment, false); err != nil {
+			logrus.Debugf("Error locating segment %v: %v", segment, err)
+			return
+		}
+	}
+}
+
+// WriteSegment writes PAT, PMT and packets of the segment in 188 bytes format
+func (ix *Index) WriteSegment(ctx context.Context, stream dao.FileStream, segment int, w io.Writer) error {
+	if segment < 0 || segment >= ix.Segments {
+		return fmt.Errorf("segment %v is out of %v", segment, ix.Segments)
+	}
+	start, err := ix.segmentStart(ctx, stream, segment)
+	if err != nil {
+		return err
+	}
+	end, err := ix.segmentStart(ctx, stream, segment + 1)
+	if err != nil {
+		return err
+	}
+
+	if _, err := w.Write(ix.psi); err != nil {
+		return err
+	}
+	if _, err := stream.Seek(start, io.SeekStart); err != nil {
+		return err
+	}
+	_, err = io.Copy(w, newTsReader(io.LimitReader(stream, end - start), ix.packetSize))
+	return err
+}
@@ -0,0 +1,206 @@
+package hls
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/asticode/go-astits"
+)
+
+const (
+	testVideoPid  = 0x100
+	testFrameTime = 3600 // 25 кадров в секунду
+	testFrameSize = 4000
+)
+
+// buildTs muxes h264 frames with a keyframe every gop frames, gop 0 means the
+// only keyframe at the start
+func buildTs(t *testing.T, frames, gop int) []byte {
+	var buf bytes.Buffer
+	muxer := astits.NewMuxer(context.Background(), &buf)
+	if err := muxer.AddElementaryStream(astits.PMTElementaryStream{ElementaryPID: testVideoPid, StreamType: astits.StreamTypeH264Video}); err != nil {
+		t.Fatalf("Error adding stream: %v", err)
+	}
+	muxer.SetPCRPID(testVideoPid)
+
+	for i := 0; i < frames; i++ {
+		keyframe := i == 0 || gop > 0 && i % gop == 0
+		data := bytes.Repeat([]byte{0xAA}, testFrameSize)
+		copy(data, []byte{0, 0, 0, 1, 0x41})
+		var af *astits.PacketAdaptationField
+		if keyframe {
+			data[4] = 0x65
+			af = &astits.PacketAdaptationField{RandomAccessIndicator: true}
+		}
+		_, err := muxer.WriteData(&astits.MuxerData{
+			PID:             testVideoPid,
+			AdaptationField: af,
+			PES: &astits.PESData{
+				Header: &astits.PESHeader{OptionalHeader: &astits.PESOptionalHeader{
+					MarkerBits:      2,
+					PTSDTSIndicator: astits.PTSDTSIndicatorOnlyPTS,
+					PTS:             &astits.ClockReference{Base: int64(90000 + i * testFrameTime)},
+				}},
+				Data: data,
+			},
+		})
+		if err != nil {
+			t.Fatalf("Error writing frame %v: %v", i, err)
+		}
+	}
+	return buf.Bytes()
+}
+
+// memStream is a file in memory, reading not downloaded parts fails the test
+// instead of blocking
+type memStream struct {
+	*bytes.Reader
+	data   []byte
+	ranges [][2]int64 // nil - файл скачан целиком
+}
+
+func newMemStream(data []byte, ranges [][2]int64) *memStream {
+	return &memStream{Reader: bytes.NewReader(data), data: data, ranges: ranges}
+}
+
+func (m *memStream) NextDownloaded(offset int64) (int64, int64) {
+	if m.ranges == nil {
+		return offset, int64(len(m.data))
+	}
+	for _, r := range m.ranges {
+		if r[1] > offset {
+			if r[0] < offset {
+				return offset, r[1]
+			}
+			return r[0], r[1]
+		}
+	}
+	return 0, 0
+}
+
+func (m *memStream) WaitRange(start, end int64) error {
+	if available, availableEnd := m.NextDownloaded(start); available != start || availableEnd < end {
+		panic(fmt.Sprintf("waiting for not downloaded %v-%v", start, end))
+	}
+	return nil
+}
+
+func (m *memStream) ReadAt(b []byte, off int64) (int, error) {
+	end := off + int64(len(b))
+	if end > int64(len(m.data)) {
+		end = int64(len(m.data))
+	}
+	if err := m.WaitRange(off, end); err != nil {
+		return 0, err
+	}
+	return m.Reader.ReadAt(b, off)
+}
+
+func (m *memStream) WriteRange(w io.Writer, start, length int64) (int64, error) {
+	return io.Copy(w, io.NewSectionReader(m, start, length))
+}
+
+func (m *memStream) Close() error {
+	return nil
+}
+
+func TestSegmentDurationFromKeyframes(t *testing.T) {
+	ctx := context.Background()
+	// 60 секунд, ключевой кадр каждые 2 секунды
+	data := buildTs(t, 1500, 50)
+	stream := newMemStream(data, nil)
+	ix, err := BuildIndex(ctx, stream, int64(len(data)))
+	if err != nil {
+		t.Fatalf("Error indexing: %v", err)
+	}
+	if ix.Duration != 1499 * testFrameTime * time.Second / 90000 || ix.Segments != 10 {
+		t.Fatalf("Unexpected duration %v and segments %v", ix.Duration, ix.Segments)
+	}
+
+	playlist := ix.MediaPlaylist(ctx, stream, "")
+	var total time.Duration
+	for segment := 0; segment < ix.Segments; segment++ {
+		duration := ix.SegmentDuration(segment)
+		total += duration
+		if segment < ix.Segments - 1 && duration % (2 * time.Second) != 0 {
+			t.Errorf("Segment %v is %v, not a number of GOPs", segment, duration)
+		}
+		if !strings.Contains(playlist, fmt.Sprintf("#EXTINF:%.3f,\n%d.ts\n", duration.Seconds(), segment)) {
+			t.Errorf("Segment %v with %v is not in playlist:\n%v", segment, duration, playlist)
+		}
+	}
+	if total != ix.Duration {
+		t.Errorf("Segments take %v of %v", total, ix.Duration)
+	}
+}
+
+func TestSegmentsOfLongGop(t *testing.T) {
+	ctx := context.Background()
+	// ключевой кадр только в начале, все оценки попадают в один GOP
+	data := buildTs(t, 1500, 0)
+	stream := newMemStream(data, nil)
+	ix, err := BuildIndex(ctx, stream, int64(len(data)))
+	if err != nil {
+		t.Fatalf("Error indexing: %v", err)
+	}
+
+	written := int64(0)
+	previous := int64(-1)
+	for segment := 0; segment < ix.Segments; segment++ {
+		start, err := ix.segmentStart(ctx, stream, segment)
+		if err != nil {
+			t.Fatalf("Error locating segment %v: %v", segment, err)
+		}
+		if start <= previous {
+			t.Errorf("Segment %v starts at %v after %v", segment, start, previous)
+		}
+		previous = start
+
+		var buf bytes.Buffer
+		if err := ix.WriteSegment(ctx, stream, segment, &buf); err != nil {
+			t.Fatalf("Error writing segment %v: %v", segment, err)
+		}
+		if buf.Len() <= len(ix.psi) {
+			t.Errorf("Segment %v is empty", segment)
+		}
+		written += int64(buf.Len() - len(ix.psi))
+	}
+	if written != ix.end - ix.firstPacket {
+		t.Errorf("Segments have %v bytes of %v", written, ix.end - ix.firstPacket)
+	}
+}
+
+func TestPlaylistOfDownloadingStream(t *testing.T) {
+	ctx := context.Background()
+	data := buildTs(t, 1500, 50)
+	size := int64(len(data))
+	// середина не скачана, плейлист не должен ее ждать
+	stream := newMemStream(data, [][2]int64{{0, probeWindow}, {size - probeWindow, size}})
+	ix, err := BuildIndex(ctx, stream, size)
+	if err != nil {
+		t.Fatalf("Error indexing: %v", err)
+	}
+
+	playlist := ix.MediaPlaylist(ctx, stream, "")
+	estimated := ix.Duration / time.Duration(ix.Segments)
+	missing := 0
+	for segment := 1; segment < ix.Segments - 1; segment++ {
+		if _, found := ix.knownBoundary(segment); !found {
+			missing++
+			if duration := ix.SegmentDuration(segment - 1); duration != estimated {
+				t.Errorf("Segment %v before not located one is %v, expected %v", segment - 1, duration, estimated)
+			}
+		}
+	}
+	if missing == 0 {
+		t.Errorf("All boundaries are located without the middle of the file")
+	}
+	if !strings.HasSuffix(playlist, "#EXT-X-ENDLIST\n") {
+		t.Errorf("Unexpected playlist:\n%v", playlist)
+	}
+}
@@ -0,0 +1,63 @@
+package hls
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"hypertube_storage/dao"
+)
+
+// ссылки относительные, плейлисты и сегменты лежат рядом
+const (
+	MediaPlaylistName = "index.m3u8"
+	segmentNameFormat = "%d.ts"
+)
+
+// withQuery keeps params of the playlist request (the signed url) in links
+func withQuery(uri string, query string) string {
+	if query == "" {
+		return uri
+	}
+	return uri + "?" + query
+}
+
+// MasterPlaylist has the only variant, the stream is not transcoded, query is
+// appended to the link
+func (ix *Index) MasterPlaylist(query string) string {
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n")
+	b.WriteString("#EXT-X-VERSION:3\n")
+	fmt.Fprintf(&b, "#EXT-X-STREAM-INF:BANDWIDTH=%d\n", ix.Bitrate)
+	b.WriteString(withQuery(MediaPlaylistName, query) + "\n")
+	return b.String()
+}
+
+// MediaPlaylist lists all segments, durations are exact for segments whose
+// boundaries are downloaded and estimated for the rest
+func (ix *Index) MediaPlaylist(ctx context.Context, stream dao.FileStream, query string) string {
+	ix.locateDownloaded(ctx, stream)
+	durations := make([]time.Duration, ix.Segments)
+	var target time.Duration
+	for segment := range durations {
+		durations[segment] = ix.SegmentDuration(segment)
+		if durations[segment] > target {
+			target = durations[segment]
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n")
+	b.WriteString("#EXT-X-VERSION:3\n")
+	fmt.Fprintf(&b, "#EXT-X-TARGETDURATION:%d\n", int(math.Ceil(target.Seconds())))
+	b.WriteString("#EXT-X-MEDIA-SEQUENCE:0\n")
+	b.WriteString("#EXT-X-PLAYLIST-TYPE:VOD\n")
+	for segment := 0; segment < ix.Segments; segment++ {
+		fmt.Fprintf(&b, "#EXTINF:%.3f,\n", durations[segment].Seconds())
+		b.WriteString(withQuery(fmt.Sprintf(segmentNameFormat, segment), query) + "\n")
+	}
+	b.WriteString("#EXT-X-ENDLIST\n")
+	return b.String()
+}
@@ -0,0 +1,144 @@
+package hls
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/asticode/go-astits"
+)
+
+const (
+	tsPacketSize   = astits.MpegTsPacketSize
+	m2tsPacketSize = tsPacketSize + 4 // blu-ray добавляет 4 байта таймкода перед пакетом
+	syncByte       = 0x47
+	// столько подряд синхробайтов считаем началом пакетов, а не случайным 0x47
+	syncChecks     = 4
+)
+
+// tsReader yields plain 188 bytes packets from ts or m2ts data, so astits and
+// players always get the same format
+type tsReader struct {
+	r          io.Reader
+	packetSize int
+	packet     []byte
+	pending    []byte
+}
+
+func newTsReader(r io.Reader, packetSize int) *tsReader {
+	return &tsReader{r: r, packetSize: packetSize, packet: make([]byte, packetSize)}
+}
+
+func (t *tsReader) Read(p []byte) (int, error) {
+	if len(t.pending) == 0 {
+		if _, err := io.ReadFull(t.r, t.packet); err != nil {
+			if err == io.ErrUnexpectedEOF {
+				err = io.EOF
+			}
+			return 0, err
+		}
+		t.pending = t.packet[t.packetSize - tsPacketSize:]
+	}
+	n := copy(p, t.pending)
+	t.pending = t.pending[n:]
+	return n, nil
+}
+
+// detectPacketSize finds the first packet of the file and the packet size
+func detectPacketSize(data []byte) (offset int64, packetSize int, ok bool) {
+	for _, size := range []int{tsPacketSize, m2tsPacketSize} {
+		if start := findPacketStart(data, size); start >= 0 && start < size {
+			return int64(start), size, true
+		}
+	}
+	return 0, 0, false
+}
+
+// findPacketStart returns the first position of data from which packets follow
+// each other, or -1
+func findPacketStart(data []byte, packetSize int) int {
+	prefix := packetSize - tsPacketSize
+	for start := 0; start + prefix + packetSize * syncChecks <= len(data); start++ {
+		synced := true
+		for i := 0; i < syncChecks; i++ {
+			if data[start + prefix + i * packetSize] != syncByte {
+				synced = false
+				break
+			}
+		}
+		if synced {
+			return start
+		}
+	}
+	return -1
+}
+
+var startCode3 = []byte{0, 0, 1}
+
+// isKeyframePacket checks the first packet of a video PES: the random access
+// flag or a start code of sequence header / IDR picture in its payload
+func isKeyframePacket(packet *astits.Packet, streamType astits.StreamType) bool {
+	if !packet.Header.PayloadUnitStartIndicator {
+		return false
+	}
+	if packet.AdaptationField != nil && packet.AdaptationField.RandomAccessIndicator {
+		return true
+	}
+	payload := packet.Payload
+	if len(payload) < 9 || !bytes.HasPrefix(payload, startCode3) || 9 + int(payload[8]) > len(payload) {
+		return false
+	}
+	// пропускаем заголовок PES
+	payload = payload[9 + int(payload[8]):]
+
+	for {
+		idx := bytes.Index(payload, startCode3)
+		if idx < 0 || idx + 3 >= len(payload) {
+			return false
+		}
+		nal := payload[idx + 3]
+		payload = payload[idx + 3:]
+
+		switch streamType {
+		case astits.StreamTypeH264Video:
+			// IDR или SPS
+			if nalType := nal & 0x1F; nalType == 5 || nalType == 7 {
+				return true
+			}
+		case astits.StreamTypeH265Video:
+			// IDR_W_RADL, IDR_N_LP, CRA или VPS
+			if nalType := nal >> 1 & 0x3F; nalType >= 19 && nalType <= 21 || nalType == 32 {
+				return true
+			}
+		case astits.StreamTypeMPEG1Video, astits.StreamTypeMPEG2Video:
+			// sequence header
+			if nal == 0xB3 {
+				return true
+			}
+		default:
+			return false
+		}
+	}
+}
+
+// packetPts reads PTS from the PES header at the start of the packet payload
+func packetPts(packet *astits.Packet) (int64, bool) {
+	payload := packet.Payload
+	if !packet.Header.PayloadUnitStartIndicator || len(payload) < 14 || !bytes.HasPrefix(payload, startCode3) {
+		return 0, false
+	}
+	if payload[7] & 0x80 == 0 {
+		return 0, false
+	}
+	pts := int64(payload[9] >> 1 & 0x07) << 30 | int64(payload[10]) << 22 | int64(payload[11] >> 1) << 15 |
+		int64(payload[12]) << 7 | int64(payload[13] >> 1)
+	return pts, true
+}
+
+func isVideoStream(streamType astits.StreamType) bool {
+	switch streamType {
+	case astits.StreamTypeH264Video, astits.StreamTypeH265Video, astits.StreamTypeMPEG1Video,
+		astits.StreamTypeMPEG2Video, astits.StreamTypeMPEG4Video:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,119 @@
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/sirupsen/logrus"
+)
+
+// Metrics of the service live in their own registry. The wrappers take label
+// values as arguments and drop a sample with a wrong number of labels instead of
+// panicking, a metric call must not break the request which makes it
+
+var registry = prometheus.NewRegistry()
+
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
+
+func dropSample(name string, err error) {
+	logrus.Errorf("Metric %s: %v: dropping sample", name, err)
+}
+
+type Counter struct {
+	name string
+	vec  *prometheus.CounterVec
+}
+
+func NewCounter(name, help string, labelNames ...string) *Counter {
+	c := &Counter{name: name, vec: prometheus.NewCounterVec(prometheus.CounterOpts{Name: name, Help: help}, labelNames)}
+	registry.MustRegister(c.vec)
+	if len(labelNames) == 0 {
+		// без меток метрика видна с нуля, а не с первого события
+		c.vec.WithLabelValues()
+	}
+	return c
+}
+
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+func (c *Counter) Add(delta float64, labelValues ...string) {
+	if delta < 0 {
+		return
+	}
+	counter, err := c.vec.GetMetricWithLabelValues(labelValues...)
+	if err != nil {
+		dropSample(c.name, err)
+		return
+	}
+	counter.Add(delta)
+}
+
+type Gauge struct {
+	name string
+	vec  *prometheus.GaugeVec
+}
+
+func NewGauge(name, help string, labelNames ...string) *Gauge {
+	g := &Gauge{name: name, vec: prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: name, Help: help}, labelNames)}
+	registry.MustRegister(g.vec)
+	if len(labelNames) == 0 {
+		g.vec.WithLabelValues()
+	}
+	return g
+}
+
+func (g *Gauge) Inc(labelValues ...string) {
+	g.add(1, labelValues)
+}
+
+func (g *Gauge) Dec(labelValues ...string) {
+	g.add(-1, labelValues)
+}
+
+func (g *Gauge) add(delta float64, labelValues []string) {
+	gauge, err := g.vec.GetMetricWithLabelValues(labelValues...)
+	if err != nil {
+		dropSample(g.name, err)
+		return
+	}
+	gauge.Add(delta)
+}
+
+func (g *Gauge) Set(val float64, labelValues ...string) {
+	gauge, err := g.vec.GetMetricWithLabelValues(labelValues...)
+	if err != nil {
+		dropSample(g.name, err)
+		return
+	}
+	gauge.Set(val)
+}
+
+// NewGaugeFunc registers a gauge which value is calculated at scrape time
+func NewGaugeFunc(name, help string, fn func() float64) {
+	registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{Name: name, Help: help}, fn))
+}
+
+type Histogram struct {
+	name string
+	vec  *prometheus.HistogramVec
+}
+
+func NewHistogram(name, help string, buckets []float64, labelNames ...string) *Histogram {
+	h := &Histogram{name: name, vec: prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: name, Help: help, Buckets: buckets}, labelNames)}
+	registry.MustRegister(h.vec)
+	return h
+}
+
+func (h *Histogram) Observe(val float64, labelValues ...string) {
+	observer, err := h.vec.GetMetricWithLabelValues(labelValues...)
+	if err != nil {
+		dropSample(h.name, err)
+		return
+	}
+	observer.Observe(val)
+}
@@ -0,0 +1,31 @@
+package metrics
+
+var (
+	RangeRequestSeconds = NewHistogram(
+		"hypertube_storage_range_request_seconds",
+		"Time to answer a video range request",
+		[]float64{.01, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60, 300},
+		"status")
+	BytesServed = NewCounter(
+		"hypertube_storage_bytes_served_total",
+		"Bytes written to clients",
+		"type")
+	FilePartWaitSeconds = NewHistogram(
+		"hypertube_storage_file_part_wait_seconds",
+		"Time readers waited for the loader to report pieces as written",
+		[]float64{.1, .5, 1, 5, 10, 30, 60, 120, 300, 600, 1800},
+		"result")
+	FilePartWaitTimeouts = NewCounter(
+		"hypertube_storage_file_part_wait_timeouts_total",
+		"Reader waits which gave up before pieces were written")
+	RangeCacheRequests = NewCounter(
+		"hypertube_storage_range_cache_requests_total",
+		"Lookups of file blocks in the hot ranges cache",
+		"result")
+	RangeCacheEvictions = NewCounter(
+		"hypertube_storage_range_cache_evictions_total",
+		"Blocks evicted from the hot ranges cache to fit new ones")
+	RangeCacheBytes = NewGauge(
+		"hypertube_storage_range_cache_bytes",
+		"Size of blocks in the hot ranges cache")
+)
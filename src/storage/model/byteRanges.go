@@ -0,0 +1,71 @@
+package model
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type ByteRange struct {
+	Start	int64
+	End		int64 // не включительно
+}
+
+// ByteRanges are downloaded parts of a file published by the loader as sorted
+// not overlapping ranges
+type ByteRanges []ByteRange
+
+// RangesUpdate is a change of completed ranges of the file published by the
+// loader, Erased is set when the eraser deleted the file
+type RangesUpdate struct {
+	FileName	string
+	Erased		bool
+}
+
+func ParseByteRanges(encoded string) (ByteRanges, error) {
+	res := make(ByteRanges, 0, 8)
+	if encoded == "" {
+		return res, nil
+	}
+	for _, part := range strings.Split(encoded, ",") {
+		bounds := strings.Split(part, "-")
+		if len(bounds) != 2 {
+			return nil, fmt.Errorf("invalid range %#v", part)
+		}
+		start, err := strconv.ParseInt(bounds[0], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid range start %#v: %v", part, err)
+		}
+		end, err := strconv.ParseInt(bounds[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid range end %#v: %v", part, err)
+		}
+		res = append(res, ByteRange{Start: start, End: end})
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Start < res[j].Start })
+	return res, nil
+}
+
+// AvailableFrom returns how many bytes starting from offset are downloaded
+func (r ByteRanges) AvailableFrom(offset int64) int64 {
+	idx := sort.Search(len(r), func(i int) bool { return r[i].End > offset })
+	if idx == len(r) || r[idx].Start > offset {
+		return 0
+	}
+	return r[idx].End - offset
+}
+
+// Next returns the downloaded range containing offset or the first one after it
+func (r ByteRanges) Next(offset int64) (ByteRange, bool) {
+	idx := sort.Search(len(r), func(i int) bool { return r[i].End > offset })
+	if idx == len(r) {
+		return ByteRange{}, false
+	}
+	return r[idx], true
+}
+
+// Covers checks that [start, end) is downloaded entirely
+func (r ByteRanges) Covers(start, end int64) bool {
+	return end <= start || r.AvailableFrom(start) >= end - start
+}
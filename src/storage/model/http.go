@@ -14,3 +14,11 @@ type LoaderTaskResponse struct {
 		FileLength	int			`json:"fileLength"`
 	}
 }
+
+type LoaderFilesResponse struct {
+	Status bool        `json:"status"`
+	Data	struct{
+		Files		[]TorrentFileEntry	`json:"files"`
+		Episodes	[]TorrentFileEntry	`json:"episodes"`
+	}
+}
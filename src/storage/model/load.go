@@ -1,5 +1,10 @@
 package model
 
+import (
+	"path"
+	"strings"
+)
+
 type LoadInfo struct {
 	VideoFile	FileInfo
 	IsLoaded	bool
@@ -7,6 +12,27 @@ type LoadInfo struct {
 }
 
 type FileInfo struct {
-	Name		string
-	Length		int64
+	Name			string
+	Length			int64
+	OriginalName	string // имя внутри торрента, на диске файл назван md5 пути
+	Extension		string
+}
+
+type TorrentFileEntry struct {
+	Index		int			`json:"index"`
+	Path		[]string	`json:"path"`
+	FileName	string		`json:"fileName"`
+	Length		int64		`json:"length"`
+	MediaType	string		`json:"mediaType"`
+	Season		int			`json:"season,omitempty"`
+	Episode		int			`json:"episode,omitempty"`
+}
+
+func (e TorrentFileEntry) ToFileInfo() FileInfo {
+	info := FileInfo{Name: e.FileName, Length: e.Length}
+	if len(e.Path) > 0 {
+		info.OriginalName = e.Path[len(e.Path) - 1]
+		info.Extension = strings.ToLower(strings.TrimPrefix(path.Ext(info.OriginalName), "."))
+	}
+	return info
 }
@@ -0,0 +1,15 @@
+package model
+
+import "time"
+
+// WatchProgress is the playback state of the record for the user: position in
+// seconds from player heartbeats and in bytes from range requests
+type WatchProgress struct {
+	UserId			uint		`json:"-"`
+	FileId			string		`json:"fileId"`
+	Position		float64		`json:"position"`
+	PositionBytes	int64		`json:"positionBytes"`
+	Duration		float64		`json:"duration"`
+	Completed		bool		`json:"completed"`
+	UpdatedAt		time.Time	`json:"updatedAt"`
+}
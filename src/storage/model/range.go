@@ -1,42 +1,114 @@
 package model
 
 import (
+	"errors"
 	"fmt"
 	"strconv"
 	"strings"
+)
+
+// больше диапазонов в одном запросе не обслуживаем, это скорее атака, чем плеер
+const maxRangesInRequest = 16
 
-	"github.com/sirupsen/logrus"
+var (
+	ErrRangeNotSatisfiable = errors.New("range not satisfiable")
+	ErrRangeIgnored        = errors.New("range header is ignored")
 )
 
 type FileRangeDescription struct {
 	Start	int64
-	End		int64
+	End		int64 // включительно
+}
+
+func (f FileRangeDescription) Length() int64 {
+	return f.End - f.Start + 1
+}
+
+func (f FileRangeDescription) ContentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", f.Start, f.End, size)
 }
 
-func (f *FileRangeDescription) ParseHeader(header string) error {
-	parts := strings.Split(header, "=")
-	if len(parts) != 2 || parts[0] != "bytes" {
-		return fmt.Errorf("range header is incorrect: '%v'", header)
+// ParseRangeHeader parses RFC 7233 byte ranges for the file of the size.
+// ErrRangeIgnored means that the whole file should be sent, as for malformed or
+// too fragmented header, ErrRangeNotSatisfiable - none of ranges overlap the file
+func ParseRangeHeader(header string, size int64) ([]FileRangeDescription, error) {
+	const prefix = "bytes="
+	if !strings.HasPrefix(header, prefix) {
+		return nil, ErrRangeIgnored
 	}
-	limits := strings.Split(parts[1], "-")
-	if len(limits) != 2 {
-		logrus.Errorf("Error parsing range header! Len != 2: %v", header)
-		return fmt.Errorf("len after split != 2")
+
+	specs := strings.Split(header[len(prefix):], ",")
+	if len(specs) > maxRangesInRequest {
+		return nil, ErrRangeIgnored
 	}
 
-	if start, err := strconv.ParseInt(limits[0], 10, 64); err != nil {
-		logrus.Errorf("Error parsing start in range header! err: %v; header: %v", err, header)
-		f.Start = -1
-	} else {
-		f.Start = start
+	ranges := make([]FileRangeDescription, 0, len(specs))
+	validSpecs := 0
+	for _, spec := range specs {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		validSpecs++
+		dash := strings.Index(spec, "-")
+		if dash < 0 {
+			return nil, ErrRangeIgnored
+		}
+		rawStart, rawEnd := strings.TrimSpace(spec[:dash]), strings.TrimSpace(spec[dash + 1:])
+
+		var fileRange FileRangeDescription
+		if rawStart == "" {
+			// суффикс: последние N байт
+			suffix, err := strconv.ParseInt(rawEnd, 10, 64)
+			if err != nil || suffix < 0 {
+				return nil, ErrRangeIgnored
+			}
+			if suffix == 0 || size == 0 {
+				continue
+			}
+			if suffix > size {
+				suffix = size
+			}
+			fileRange = FileRangeDescription{Start: size - suffix, End: size - 1}
+		} else {
+			start, err := strconv.ParseInt(rawStart, 10, 64)
+			if err != nil || start < 0 {
+				return nil, ErrRangeIgnored
+			}
+			end := size - 1
+			if rawEnd != "" {
+				if end, err = strconv.ParseInt(rawEnd, 10, 64); err != nil || end < start {
+					return nil, ErrRangeIgnored
+				}
+				if end >= size {
+					end = size - 1
+				}
+			}
+			if start >= size {
+				continue
+			}
+			fileRange = FileRangeDescription{Start: start, End: end}
+		}
+		ranges = append(ranges, fileRange)
 	}
 
-	if end, err := strconv.ParseInt(limits[1], 10, 64); err != nil {
-		logrus.Debugf("Failed to parse end in range header. Err: %v; Header: %v", err, header)
-	} else  {
-		f.End = -1
-		f.End = end
+	if validSpecs == 0 {
+		return nil, ErrRangeIgnored
 	}
+	if len(ranges) == 0 {
+		return nil, ErrRangeNotSatisfiable
+	}
+	return ranges, nil
+}
 
-	return nil
+// RangeCacheStats describes the in-memory cache of hot file blocks
+type RangeCacheStats struct {
+	CapacityBytes	int64	`json:"capacityBytes"`
+	UsedBytes		int64	`json:"usedBytes"`
+	Blocks			int		`json:"blocks"`
+	BlockSize		int64	`json:"blockSize"`
+	Hits			int64	`json:"hits"`
+	Misses			int64	`json:"misses"`
+	Evictions		int64	`json:"evictions"`
+	HitRate			float64	`json:"hitRate"`
 }
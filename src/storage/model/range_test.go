@@ -0,0 +1,57 @@
+package model
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseRangeHeader(t *testing.T) {
+	const size = 1000
+	tooMany := "bytes=" + strings.Repeat("0-1,", maxRangesInRequest) + "0-1"
+
+	for _, test := range []struct {
+		name     string
+		header   string
+		size     int64
+		expected []FileRangeDescription
+		err      error
+	}{
+		{"single", "bytes=0-99", size, []FileRangeDescription{{0, 99}}, nil},
+		{"end beyond size", "bytes=900-2000", size, []FileRangeDescription{{900, 999}}, nil},
+		{"open-ended", "bytes=500-", size, []FileRangeDescription{{500, 999}}, nil},
+		{"suffix", "bytes=-100", size, []FileRangeDescription{{900, 999}}, nil},
+		{"suffix longer than file", "bytes=-5000", size, []FileRangeDescription{{0, 999}}, nil},
+		{"multiple", "bytes=0-9, 20-29", size, []FileRangeDescription{{0, 9}, {20, 29}}, nil},
+		// пересекающиеся диапазоны отдаем как просили, не склеивая
+		{"overlapping", "bytes=0-99,50-149", size, []FileRangeDescription{{0, 99}, {50, 149}}, nil},
+		{"unsatisfiable part skipped", "bytes=1000-1100,0-1", size, []FileRangeDescription{{0, 1}}, nil},
+		{"unsatisfiable", "bytes=1000-", size, nil, ErrRangeNotSatisfiable},
+		{"zero suffix", "bytes=-0", size, nil, ErrRangeNotSatisfiable},
+		{"empty file", "bytes=0-", 0, nil, ErrRangeNotSatisfiable},
+		{"other unit", "items=0-1", size, nil, ErrRangeIgnored},
+		{"no dash", "bytes=abc", size, nil, ErrRangeIgnored},
+		{"end before start", "bytes=5-1", size, nil, ErrRangeIgnored},
+		{"bad suffix", "bytes=-x", size, nil, ErrRangeIgnored},
+		{"bad end", "bytes=1-2-3", size, nil, ErrRangeIgnored},
+		{"negative start", "bytes=-1-5", size, nil, ErrRangeIgnored},
+		{"no specs", "bytes=, ,", size, nil, ErrRangeIgnored},
+		{"too many", tooMany, size, nil, ErrRangeIgnored},
+	} {
+		ranges, err := ParseRangeHeader(test.header, test.size)
+		if err != test.err {
+			t.Errorf("%v: expected error %v, got %v", test.name, test.err, err)
+			continue
+		}
+		if err == nil && !reflect.DeepEqual(ranges, test.expected) {
+			t.Errorf("%v: expected %v, got %v", test.name, test.expected, ranges)
+		}
+	}
+}
+
+func TestFileRangeDescription(t *testing.T) {
+	fileRange := FileRangeDescription{Start: 10, End: 19}
+	if fileRange.Length() != 10 || fileRange.ContentRange(100) != "bytes 10-19/100" {
+		t.Errorf("Unexpected length %v and content range %v", fileRange.Length(), fileRange.ContentRange(100))
+	}
+}
@@ -0,0 +1,38 @@
+package model
+
+import "time"
+
+// UserSubtitles is a subtitles file uploaded by a user for the record, the file
+// is stored in FILES_DIR under its id
+type UserSubtitles struct {
+	Id			string		`json:"id"`
+	FileId		string		`json:"-"`
+	Language	string		`json:"language"`
+	Label		string		`json:"label,omitempty"`
+	Format		string		`json:"format"`
+	UploaderId	uint		`json:"uploaderId"`
+	Size		int64		`json:"size"`
+	CreatedAt	time.Time	`json:"createdAt"`
+}
+
+// SubtitlesQuery describes the video for external subtitle providers, empty
+// fields are not used in the search
+type SubtitlesQuery struct {
+	MovieHash	string
+	MovieSize	int64
+	ImdbId		string
+	Languages	[]string
+}
+
+// ExternalSubtitles is a search result of an external provider, Id is the one
+// served by storage and ProviderId is the id to download it from the provider
+type ExternalSubtitles struct {
+	Id			string	`json:"id"`
+	Provider	string	`json:"provider"`
+	ProviderId	string	`json:"providerId"`
+	Language	string	`json:"language"`
+	Release		string	`json:"release,omitempty"`
+	Format		string	`json:"format,omitempty"`
+	Downloads	int		`json:"downloads"`
+	HashMatch	bool	`json:"hashMatch"`
+}
@@ -3,8 +3,14 @@ package impl
 import (
 	"fmt"
 	"os"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
 )
 
+const defaultRangeCacheSize = 256 << 20
+
 type Parser struct {
 }
 
@@ -38,6 +44,57 @@ func (p *Parser) GetLoaderServiceHost() string {
 	return os.Getenv("LOADER_SERVICE_ADDR")
 }
 
+func (p *Parser) GetAuthServiceHost() string {
+	return os.Getenv("AUTH_SERVICE_ADDR")
+}
+
+// GetAuthServerPasswd is the password of /api/auth/check for internal services
+func (p *Parser) GetAuthServerPasswd() string {
+	return os.Getenv("AUTH_SERVER_PASSWD")
+}
+
+func (p *Parser) GetUrlSignSecret() string {
+	return os.Getenv("STORAGE_URL_SECRET")
+}
+
+func (p *Parser) GetOpenSubtitlesApiUrl() string {
+	if url := os.Getenv("OPENSUBTITLES_API_URL"); url != "" {
+		return url
+	}
+	return "https://api.opensubtitles.com/api/v1"
+}
+
+// GetOpenSubtitlesApiKey is empty when the provider is not configured
+func (p *Parser) GetOpenSubtitlesApiKey() string {
+	return os.Getenv("OPENSUBTITLES_API_KEY")
+}
+
+// GetRangeCacheSize returns max size of the hot ranges cache in bytes, 0 turns
+// the cache off. Accepts plain bytes or K, M, G suffixes: "512M", "1G"
+func (p *Parser) GetRangeCacheSize() int64 {
+	src := strings.ToUpper(strings.TrimSpace(os.Getenv("STORAGE_CACHE_SIZE")))
+	if src == "" {
+		return defaultRangeCacheSize
+	}
+
+	multiplier := int64(1)
+	suffixes := map[string]int64{"K": 1 << 10, "M": 1 << 20, "G": 1 << 30}
+	for suffix, value := range suffixes {
+		if strings.HasSuffix(src, suffix) {
+			multiplier = value
+			src = strings.TrimSuffix(src, suffix)
+			break
+		}
+	}
+
+	size, err := strconv.ParseInt(src, 10, 64)
+	if err != nil || size < 0 {
+		logrus.Errorf("Error parsing range cache size: %v; src: %v", err, os.Getenv("STORAGE_CACHE_SIZE"))
+		return defaultRangeCacheSize
+	}
+	return size * multiplier
+}
+
 func (p *Parser) IsDevMode() bool {
 	return os.Getenv("DEV_MODE") == "on"
 }
@@ -16,6 +16,12 @@ type Parser interface {
 	IsDevMode() bool
 	GetFilesDir() string
 	GetLoaderServiceHost() string
+	GetOpenSubtitlesApiUrl() string
+	GetOpenSubtitlesApiKey() string
+	GetAuthServiceHost() string
+	GetAuthServerPasswd() string
+	GetUrlSignSecret() string
+	GetRangeCacheSize() int64
 }
 
 func GetParser() Parser {
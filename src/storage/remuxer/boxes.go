@@ -0,0 +1,231 @@
+package remuxer
+
+import (
+	"encoding/binary"
+)
+
+// ISO/IEC 14496-12 boxes of fragmented mp4, the movie has no samples and all
+// of them come in moof/mdat pairs
+
+const movieTimescale = 1000
+
+// единичная матрица преобразования из tkhd и mvhd
+var unityMatrix = []uint32{0x00010000, 0, 0, 0, 0x00010000, 0, 0, 0, 0x40000000}
+
+// boxWriter collects the payload of a box
+type boxWriter struct {
+	buf []byte
+}
+
+func (b *boxWriter) u8(v uint8) *boxWriter {
+	b.buf = append(b.buf, v)
+	return b
+}
+
+func (b *boxWriter) u16(v uint16) *boxWriter {
+	b.buf = append(b.buf, byte(v >> 8), byte(v))
+	return b
+}
+
+func (b *boxWriter) u24(v uint32) *boxWriter {
+	b.buf = append(b.buf, byte(v >> 16), byte(v >> 8), byte(v))
+	return b
+}
+
+func (b *boxWriter) u32(v uint32) *boxWriter {
+	b.buf = append(b.buf, byte(v >> 24), byte(v >> 16), byte(v >> 8), byte(v))
+	return b
+}
+
+func (b *boxWriter) u64(v uint64) *boxWriter {
+	return b.u32(uint32(v >> 32)).u32(uint32(v))
+}
+
+func (b *boxWriter) bytes(v ...[]byte) *boxWriter {
+	for _, data := range v {
+		b.buf = append(b.buf, data...)
+	}
+	return b
+}
+
+func (b *boxWriter) zeros(n int) *boxWriter {
+	b.buf = append(b.buf, make([]byte, n)...)
+	return b
+}
+
+func (b *boxWriter) matrix() *boxWriter {
+	for _, v := range unityMatrix {
+		b.u32(v)
+	}
+	return b
+}
+
+func box(boxType string, payload ...[]byte) []byte {
+	size := 8
+	for _, data := range payload {
+		size += len(data)
+	}
+	res := make([]byte, 8, size)
+	binary.BigEndian.PutUint32(res, uint32(size))
+	copy(res[4:], boxType)
+	for _, data := range payload {
+		res = append(res, data...)
+	}
+	return res
+}
+
+func fullBox(boxType string, version uint8, flags uint32, payload ...[]byte) []byte {
+	header := (&boxWriter{}).u8(version).u24(flags).buf
+	return box(boxType, append([][]byte{header}, payload...)...)
+}
+
+func ftyp() []byte {
+	// iso6 разрешает отрицательные сдвиги композиции в trun версии 1
+	return box("ftyp", []byte("isom"), (&boxWriter{}).u32(0x200).buf, []byte("isomiso6iso2avc1mp41"))
+}
+
+func moov(duration uint64, tracks []*outputTrack) []byte {
+	nextTrackId := uint32(1)
+	for _, track := range tracks {
+		if track.id >= nextTrackId {
+			nextTrackId = track.id + 1
+		}
+	}
+
+	mvhd := (&boxWriter{}).
+		u32(0).u32(0). // время создания и изменения
+		u32(movieTimescale).u32(0).
+		u32(0x00010000).u16(0x0100).zeros(10).
+		matrix().zeros(24).
+		u32(nextTrackId).buf
+
+	children := [][]byte{fullBox("mvhd", 0, 0, mvhd)}
+	for _, track := range tracks {
+		children = append(children, trak(track))
+	}
+
+	mvex := make([][]byte, 0, len(tracks) + 1)
+	if duration > 0 {
+		mvex = append(mvex, fullBox("mehd", 1, 0, (&boxWriter{}).u64(duration).buf))
+	}
+	for _, track := range tracks {
+		trex := (&boxWriter{}).u32(track.id).u32(1).u32(0).u32(0).u32(0).buf
+		mvex = append(mvex, fullBox("trex", 0, 0, trex))
+	}
+	children = append(children, box("mvex", mvex...))
+	return box("moov", children...)
+}
+
+func trak(track *outputTrack) []byte {
+	var volume uint16
+	if track.audio {
+		volume = 0x0100
+	}
+	tkhd := (&boxWriter{}).
+		u32(0).u32(0).
+		u32(track.id).u32(0).u32(0). // id, reserved, длительность
+		zeros(8).u16(0).u16(0).u16(volume).u16(0).
+		matrix().
+		u32(uint32(track.width) << 16).u32(uint32(track.height) << 16).buf
+
+	mdhd := (&boxWriter{}).
+		u32(0).u32(0).
+		u32(track.timescale).u32(0).
+		u16(packLanguage(track.language)).u16(0).buf
+
+	handler, name, mediaHeader := "vide", "VideoHandler", fullBox("vmhd", 0, 1, make([]byte, 8))
+	if track.audio {
+		handler, name, mediaHeader = "soun", "SoundHandler", fullBox("smhd", 0, 0, make([]byte, 4))
+	}
+	hdlr := (&boxWriter{}).u32(0).bytes([]byte(handler)).zeros(12).bytes([]byte(name)).u8(0).buf
+
+	dref := fullBox("dref", 0, 0, (&boxWriter{}).u32(1).buf, fullBox("url ", 0, 1))
+	stbl := box("stbl",
+		fullBox("stsd", 0, 0, (&boxWriter{}).u32(1).buf, track.sampleEntry),
+		fullBox("stts", 0, 0, make([]byte, 4)),
+		fullBox("stsc", 0, 0, make([]byte, 4)),
+		fullBox("stsz", 0, 0, make([]byte, 8)),
+		fullBox("stco", 0, 0, make([]byte, 4)),
+	)
+
+	return box("trak",
+		fullBox("tkhd", 0, 3, tkhd), // включена и используется в фильме
+		box("mdia",
+			fullBox("mdhd", 0, 0, mdhd),
+			fullBox("hdlr", 0, 0, hdlr),
+			box("minf", mediaHeader, box("dinf", dref), stbl),
+		),
+	)
+}
+
+// packLanguage packs ISO 639-2 code into 15 bits, other codes become "und"
+func packLanguage(language string) uint16 {
+	if len(language) != 3 {
+		language = "und"
+	}
+	var res uint16
+	for _, c := range []byte(language) {
+		if c < 'a' || c > 'z' {
+			return packLanguage("und")
+		}
+		res = res << 5 | uint16(c - 0x60)
+	}
+	return res
+}
+
+func visualSampleEntry(format string, width, height int, config []byte) []byte {
+	entry := (&boxWriter{}).
+		zeros(6).u16(1). // reserved, data_reference_index
+		zeros(16).
+		u16(uint16(width)).u16(uint16(height)).
+		u32(0x00480000).u32(0x00480000).u32(0). // 72 dpi
+		u16(1).zeros(32).
+		u16(0x0018).u16(0xFFFF).buf
+	return box(format, entry, config)
+}
+
+func audioSampleEntry(format string, channels, sampleRate int, config []byte) []byte {
+	if sampleRate > 0xFFFF {
+		// частота в формате 16.16 не помещается, декодер берет ее из config
+		sampleRate = 0
+	}
+	entry := (&boxWriter{}).
+		zeros(6).u16(1).
+		zeros(8).
+		u16(uint16(channels)).u16(16).u16(0).u16(0).
+		u32(uint32(sampleRate) << 16).buf
+	return box(format, entry, config)
+}
+
+// esds describes mpeg-4 audio, decoderConfig is AudioSpecificConfig for AAC
+// and empty for mp3
+func esds(objectType uint8, decoderConfig []byte) []byte {
+	decoderSpecific := []byte(nil)
+	if len(decoderConfig) > 0 {
+		decoderSpecific = descriptor(0x05, decoderConfig)
+	}
+	decoderConfigDescriptor := descriptor(0x04,
+		(&boxWriter{}).u8(objectType).u8(0x15).u24(0).u32(0).u32(0).buf, // audio stream
+		decoderSpecific,
+	)
+	es := descriptor(0x03,
+		(&boxWriter{}).u16(0).u8(0).buf, // ES_ID не используется в mp4
+		decoderConfigDescriptor,
+		descriptor(0x06, []byte{0x02}),
+	)
+	return fullBox("esds", 0, 0, es)
+}
+
+func descriptor(tag uint8, payload ...[]byte) []byte {
+	size := 0
+	for _, data := range payload {
+		size += len(data)
+	}
+	// длина записывается 4 байтами, так пишут большинство муксеров
+	res := []byte{tag, byte(size >> 21 & 0x7F) | 0x80, byte(size >> 14 & 0x7F) | 0x80,
+		byte(size >> 7 & 0x7F) | 0x80, byte(size & 0x7F)}
+	for _, data := range payload {
+		res = append(res, data...)
+	}
+	return res
+}
@@ -0,0 +1,386 @@
+package remuxer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"hypertube_storage/demuxer"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	videoTimescale = 90000
+	aacFrameSamples = 1024
+	// длительность последнего кадра, если ее неоткуда взять
+	fallbackFrameDuration = 40 * time.Millisecond
+)
+
+// флаги сэмплов из trun: зависимость от других кадров и признак не ключевого
+const (
+	sampleFlagsKeyframe = 0x02000000
+	sampleFlagsDelta    = 0x01010000
+)
+
+var ErrUnsupportedCodec = errors.New("codec can not be put into mp4 without transcoding")
+
+// частоты дискретизации AAC по индексу из AudioSpecificConfig
+var aacSampleRates = []int{96000, 88200, 64000, 48000, 44100, 32000, 24000, 22050, 16000, 12000, 11025, 8000, 7350}
+
+var aacProfiles = map[string]byte{"MAIN": 1, "LC": 2, "SSR": 3, "LTP": 4, "SBR": 2}
+
+type outputTrack struct {
+	id          uint32
+	source      demuxer.MediaTrack
+	audio       bool
+	timescale   uint32
+	width       int
+	height      int
+	language    string
+	sampleEntry []byte
+	// у AAC все кадры по 1024 отсчета, таймкоды matroska округлены до мс
+	frameSamples int64
+}
+
+type sample struct {
+	dts      int64
+	cto      int64
+	duration int64
+	keyframe bool
+	data     []byte
+}
+
+// Remuxer puts H.264/HEVC video and AAC/MP3 audio of a matroska file into
+// fragmented mp4: the init segment and a moof/mdat pair per cluster
+type Remuxer struct {
+	mkv      *demuxer.Matroska
+	video    *outputTrack
+	audio    *outputTrack
+	sequence uint32
+	// до первого ключевого кадра видео ничего не отдаем
+	startTime time.Duration
+	started   bool
+}
+
+// New chooses the first supported video track and the requested audio track,
+// or the default one if audioTrack is 0
+func New(mkv *demuxer.Matroska, audioTrack int) (*Remuxer, error) {
+	r := &Remuxer{mkv: mkv}
+	var videoCodecs, audioCodecs []string
+
+	for _, track := range mkv.Tracks() {
+		switch track.Type {
+		case demuxer.StreamVideo:
+			if r.video != nil {
+				continue
+			}
+			output, err := newVideoTrack(track)
+			if err != nil {
+				videoCodecs = append(videoCodecs, track.Codec)
+				continue
+			}
+			r.video = output
+		case demuxer.StreamAudio:
+			if audioTrack > 0 && track.Id != audioTrack || audioTrack == 0 && r.audio != nil && (r.audio.source.Default || !track.Default) {
+				continue
+			}
+			output, err := newAudioTrack(track)
+			if err != nil {
+				if audioTrack > 0 {
+					return nil, err
+				}
+				audioCodecs = append(audioCodecs, track.Codec)
+				continue
+			}
+			r.audio = output
+		}
+	}
+
+	if r.video == nil {
+		return nil, fmt.Errorf("%w: no supported video among %v", ErrUnsupportedCodec, videoCodecs)
+	}
+	r.video.id = 1
+	if r.audio == nil {
+		if audioTrack > 0 {
+			return nil, fmt.Errorf("audio track %v not found", audioTrack)
+		}
+		logrus.Debugf("Remuxing video without audio, unsupported codecs: %v", audioCodecs)
+	} else {
+		r.audio.id = 2
+	}
+	return r, nil
+}
+
+func newVideoTrack(track demuxer.MediaTrack) (*outputTrack, error) {
+	var format, config string
+	switch track.Codec {
+	case "V_MPEG4/ISO/AVC":
+		format, config = "avc1", "avcC"
+	case "V_MPEGH/ISO/HEVC":
+		format, config = "hvc1", "hvcC"
+	default:
+		return nil, fmt.Errorf("%w: %v", ErrUnsupportedCodec, track.Codec)
+	}
+	if len(track.CodecPrivate) == 0 {
+		return nil, fmt.Errorf("%w: %v without decoder configuration", ErrUnsupportedCodec, track.Codec)
+	}
+
+	return &outputTrack{
+		source:      track,
+		timescale:   videoTimescale,
+		width:       track.Width,
+		height:      track.Height,
+		language:    track.Language,
+		sampleEntry: visualSampleEntry(format, track.Width, track.Height, box(config, track.CodecPrivate)),
+	}, nil
+}
+
+func newAudioTrack(track demuxer.MediaTrack) (*outputTrack, error) {
+	output := &outputTrack{source: track, audio: true, timescale: uint32(track.SampleRate), language: track.Language}
+	if output.timescale == 0 {
+		return nil, fmt.Errorf("%w: audio track %v without sampling frequency", ErrUnsupportedCodec, track.Id)
+	}
+	switch {
+	case strings.HasPrefix(track.Codec, "A_AAC"):
+		config := track.CodecPrivate
+		if len(config) == 0 {
+			var err error
+			if config, err = aacConfig(track); err != nil {
+				return nil, err
+			}
+		}
+		output.frameSamples = aacFrameSamples
+		output.sampleEntry = audioSampleEntry("mp4a", track.Channels, track.SampleRate, esds(0x40, config))
+	case track.Codec == "A_MPEG/L3":
+		output.sampleEntry = audioSampleEntry("mp4a", track.Channels, track.SampleRate, esds(0x6B, nil))
+	default:
+		return nil, fmt.Errorf("%w: %v", ErrUnsupportedCodec, track.Codec)
+	}
+	return output, nil
+}
+
+// aacConfig builds AudioSpecificConfig for old style codec ids like
+// A_AAC/MPEG4/LC which have no CodecPrivate
+func aacConfig(track demuxer.MediaTrack) ([]byte, error) {
+	parts := strings.Split(track.Codec, "/")
+	profile, known := aacProfiles[parts[len(parts) - 1]]
+	if !known {
+		profile = aacProfiles["LC"]
+	}
+	for idx, rate := range aacSampleRates {
+		if rate == track.SampleRate {
+			return []byte{profile << 3 | byte(idx) >> 1, byte(idx) & 1 << 7 | byte(track.Channels) & 0x0F << 3}, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: AAC sampling frequency %v", ErrUnsupportedCodec, track.SampleRate)
+}
+
+func (r *Remuxer) tracks() []*outputTrack {
+	if r.audio == nil {
+		return []*outputTrack{r.video}
+	}
+	return []*outputTrack{r.video, r.audio}
+}
+
+// InitSegment is ftyp and moov with the codec configurations
+func (r *Remuxer) InitSegment() []byte {
+	duration := uint64(r.mkv.Duration() / time.Millisecond)
+	return append(ftyp(), moov(duration, r.tracks())...)
+}
+
+// Seek finds the cluster with the keyframe before the position by the Cues,
+// returns its offset and time
+func (r *Remuxer) Seek(position time.Duration) (int64, time.Duration, error) {
+	return r.mkv.SeekCluster(position, r.video.source.Id)
+}
+
+// Stream writes the init segment and fragments from the cluster at the offset
+// to the end of the file, each fragment is written at once
+func (r *Remuxer) Stream(ctx context.Context, w io.Writer, offset int64) error {
+	if _, err := w.Write(r.InitSegment()); err != nil {
+		return err
+	}
+	for ctx.Err() == nil {
+		frames, next, err := r.mkv.ReadCluster(offset)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("error reading cluster at %v: %v", offset, err)
+		}
+		if fragment := r.fragment(frames); fragment != nil {
+			if _, err := w.Write(fragment); err != nil {
+				return err
+			}
+		}
+		offset = next
+	}
+	return ctx.Err()
+}
+
+// fragment builds moof and mdat of the cluster frames, nil if there is nothing
+// to play in it
+func (r *Remuxer) fragment(frames []demuxer.Frame) []byte {
+	var videoFrames, audioFrames []demuxer.Frame
+	for _, frame := range frames {
+		switch {
+		case frame.Track == r.video.source.Id:
+			if !r.started {
+				if !frame.Keyframe {
+					continue
+				}
+				r.started, r.startTime = true, frame.Time
+			}
+			videoFrames = append(videoFrames, frame)
+		case r.audio != nil && frame.Track == r.audio.source.Id:
+			audioFrames = append(audioFrames, frame)
+		}
+	}
+	if !r.started {
+		return nil
+	}
+	// звук до первого кадра видео все равно не будет слышен
+	for len(audioFrames) > 0 && audioFrames[0].Time < r.startTime {
+		audioFrames = audioFrames[1:]
+	}
+
+	type trackSamples struct {
+		track   *outputTrack
+		samples []sample
+	}
+	parts := []trackSamples{{r.video, videoSamples(r.video, videoFrames)}}
+	if r.audio != nil {
+		parts = append(parts, trackSamples{r.audio, audioSamples(r.audio, audioFrames)})
+	}
+
+	// размер moof не зависит от смещений, поэтому собираем его дважды
+	build := func(dataStart int64) ([]byte, int64) {
+		children := [][]byte{fullBox("mfhd", 0, 0, (&boxWriter{}).u32(r.sequence).buf)}
+		offset := dataStart
+		for _, part := range parts {
+			if len(part.samples) == 0 {
+				continue
+			}
+			children = append(children, traf(part.track, part.samples, offset))
+			for _, s := range part.samples {
+				offset += int64(len(s.data))
+			}
+		}
+		return box("moof", children...), offset - dataStart
+	}
+
+	r.sequence++
+	moof, dataSize := build(0)
+	if dataSize == 0 {
+		r.sequence--
+		return nil
+	}
+	moof, _ = build(int64(len(moof)) + 8)
+
+	res := make([]byte, 0, len(moof) + 8 + int(dataSize))
+	res = append(res, moof...)
+	res = append(res, (&boxWriter{}).u32(uint32(dataSize + 8)).bytes([]byte("mdat")).buf...)
+	for _, part := range parts {
+		for _, s := range part.samples {
+			res = append(res, s.data...)
+		}
+	}
+	return res
+}
+
+// videoSamples restores decode timestamps: frames are stored in decode order
+// with presentation times, so sorted presentation times are used as decode ones
+func videoSamples(track *outputTrack, frames []demuxer.Frame) []sample {
+	if len(frames) == 0 {
+		return nil
+	}
+	pts := make([]int64, len(frames))
+	for i, frame := range frames {
+		pts[i] = track.ticks(frame.Time)
+	}
+	dts := append([]int64(nil), pts...)
+	sort.Slice(dts, func(i, j int) bool { return dts[i] < dts[j] })
+
+	samples := make([]sample, len(frames))
+	for i, frame := range frames {
+		samples[i] = sample{dts: dts[i], cto: pts[i] - dts[i], keyframe: frame.Keyframe, data: frame.Data}
+	}
+	setDurations(track, samples, frames[len(frames) - 1].Duration)
+	return samples
+}
+
+func audioSamples(track *outputTrack, frames []demuxer.Frame) []sample {
+	if len(frames) == 0 {
+		return nil
+	}
+	samples := make([]sample, len(frames))
+	for i, frame := range frames {
+		samples[i] = sample{dts: track.ticks(frame.Time), keyframe: true, data: frame.Data}
+	}
+	if track.frameSamples > 0 {
+		for i := range samples {
+			samples[i].dts = samples[0].dts + int64(i) * track.frameSamples
+			samples[i].duration = track.frameSamples
+		}
+		return samples
+	}
+	setDurations(track, samples, frames[len(frames) - 1].Duration)
+	return samples
+}
+
+// setDurations takes durations from decode timestamps, the last one from the
+// block or the previous frame
+func setDurations(track *outputTrack, samples []sample, lastDuration time.Duration) {
+	last := len(samples) - 1
+	for i := 0; i < last; i++ {
+		samples[i].duration = samples[i + 1].dts - samples[i].dts
+	}
+	switch {
+	case lastDuration > 0:
+		samples[last].duration = track.ticks(lastDuration)
+	case last > 0:
+		samples[last].duration = samples[last - 1].duration
+	default:
+		samples[last].duration = track.ticks(fallbackFrameDuration)
+	}
+}
+
+func (t *outputTrack) ticks(d time.Duration) int64 {
+	return int64(d / time.Second) * int64(t.timescale) + int64(d % time.Second) * int64(t.timescale) / int64(time.Second)
+}
+
+// traf describes samples of the track in the fragment, dataOffset is counted
+// from the moof start
+func traf(track *outputTrack, samples []sample, dataOffset int64) []byte {
+	baseDts := samples[0].dts
+	if baseDts < 0 {
+		baseDts = 0
+	}
+	tfhd := fullBox("tfhd", 0, 0x020000, (&boxWriter{}).u32(track.id).buf) // default-base-is-moof
+	tfdt := fullBox("tfdt", 1, 0, (&boxWriter{}).u64(uint64(baseDts)).buf)
+
+	// есть смещение данных, длительности, размеры и флаги сэмплов
+	flags := uint32(0x000001 | 0x000100 | 0x000200 | 0x000400)
+	version := uint8(0)
+	if !track.audio {
+		// сдвиги композиции со знаком из-за B-кадров
+		flags, version = flags | 0x000800, 1
+	}
+	trun := (&boxWriter{}).u32(uint32(len(samples))).u32(uint32(dataOffset))
+	for _, s := range samples {
+		sampleFlags := uint32(sampleFlagsDelta)
+		if s.keyframe {
+			sampleFlags = sampleFlagsKeyframe
+		}
+		trun.u32(uint32(s.duration)).u32(uint32(len(s.data))).u32(sampleFlags)
+		if !track.audio {
+			trun.u32(uint32(int32(s.cto)))
+		}
+	}
+	return box("traf", tfhd, tfdt, fullBox("trun", version, flags, trun.buf))
+}
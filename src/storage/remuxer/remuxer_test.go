@@ -0,0 +1,335 @@
+package remuxer
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"hypertube_storage/demuxer"
+)
+
+// id элементов matroska, которые нужны тестовому файлу
+const (
+	mkvEbml          = 0x1A45DFA3
+	mkvDocType       = 0x4282
+	mkvSegment       = 0x18538067
+	mkvInfo          = 0x1549A966
+	mkvTimecodeScale = 0x2AD7B1
+	mkvDuration      = 0x4489
+	mkvTracks        = 0x1654AE6B
+	mkvTrackEntry    = 0xAE
+	mkvTrackNumber   = 0xD7
+	mkvTrackType     = 0x83
+	mkvFlagDefault   = 0x88
+	mkvCodecId       = 0x86
+	mkvCodecPrivate  = 0x63A2
+	mkvVideo         = 0xE0
+	mkvPixelWidth    = 0xB0
+	mkvPixelHeight   = 0xBA
+	mkvAudio         = 0xE1
+	mkvSamplingFreq  = 0xB5
+	mkvChannels      = 0x9F
+	mkvCluster       = 0x1F43B675
+	mkvTimecode      = 0xE7
+	mkvSimpleBlock   = 0xA3
+)
+
+var (
+	testAvcConfig = []byte{1, 0x64, 0, 0x1F, 0xFF, 0xE1, 0, 4, 0x67, 0x64, 0, 0x1F, 1, 0, 2, 0x68, 0xEE}
+	testAacConfig = []byte{0x12, 0x10}
+)
+
+func ebml(id uint32, children ...[]byte) []byte {
+	var buf bytes.Buffer
+	idBytes := make([]byte, 4)
+	binary.BigEndian.PutUint32(idBytes, id)
+	for len(idBytes) > 1 && idBytes[0] == 0 {
+		idBytes = idBytes[1:]
+	}
+	buf.Write(idBytes)
+
+	payload := bytes.Join(children, nil)
+	size := make([]byte, 8)
+	binary.BigEndian.PutUint64(size, uint64(len(payload)))
+	size[0] = 0x01
+	buf.Write(size)
+	buf.Write(payload)
+	return buf.Bytes()
+}
+
+func ebmlUint(id uint32, value uint64) []byte {
+	data := make([]byte, 8)
+	binary.BigEndian.PutUint64(data, value)
+	return ebml(id, data)
+}
+
+func ebmlFloat(id uint32, value float64) []byte {
+	data := make([]byte, 8)
+	binary.BigEndian.PutUint64(data, math.Float64bits(value))
+	return ebml(id, data)
+}
+
+func simpleBlock(track int, relative int16, keyframe bool, payload string) []byte {
+	data := []byte{0x80 | byte(track), byte(uint16(relative) >> 8), byte(relative), 0}
+	if keyframe {
+		data[3] = 0x80
+	}
+	return ebml(mkvSimpleBlock, append(data, payload...))
+}
+
+// buildTestMatroska has AVC video 1, AAC audio 2 and AC-3 audio 3, timecodes
+// are milliseconds
+func buildTestMatroska(clusters ...[]byte) []byte {
+	tracks := ebml(mkvTracks,
+		ebml(mkvTrackEntry,
+			ebmlUint(mkvTrackNumber, 1), ebmlUint(mkvTrackType, 1),
+			ebml(mkvCodecId, []byte("V_MPEG4/ISO/AVC")), ebml(mkvCodecPrivate, testAvcConfig),
+			ebml(mkvVideo, ebmlUint(mkvPixelWidth, 640), ebmlUint(mkvPixelHeight, 360))),
+		ebml(mkvTrackEntry,
+			ebmlUint(mkvTrackNumber, 2), ebmlUint(mkvTrackType, 2), ebmlUint(mkvFlagDefault, 1),
+			ebml(mkvCodecId, []byte("A_AAC")), ebml(mkvCodecPrivate, testAacConfig),
+			ebml(mkvAudio, ebmlFloat(mkvSamplingFreq, 44100), ebmlUint(mkvChannels, 2))),
+		ebml(mkvTrackEntry,
+			ebmlUint(mkvTrackNumber, 3), ebmlUint(mkvTrackType, 2), ebmlUint(mkvFlagDefault, 0),
+			ebml(mkvCodecId, []byte("A_AC3")),
+			ebml(mkvAudio, ebmlFloat(mkvSamplingFreq, 48000), ebmlUint(mkvChannels, 6))))
+	info := ebml(mkvInfo, ebmlUint(mkvTimecodeScale, 1000000), ebmlFloat(mkvDuration, 2000))
+
+	body := append([][]byte{info, tracks}, clusters...)
+	return append(ebml(mkvEbml, ebml(mkvDocType, []byte("matroska"))), ebml(mkvSegment, body...)...)
+}
+
+func testClusters() [][]byte {
+	return [][]byte{
+		ebml(mkvCluster, ebmlUint(mkvTimecode, 0),
+			// кадр до первого ключевого не отдается, как и звук до начала видео
+			simpleBlock(1, 0, false, "skipped"),
+			simpleBlock(2, 0, true, "early"),
+			simpleBlock(1, 40, true, "key"),
+			simpleBlock(2, 46, true, "aac1"),
+			// B-кадр: в порядке декодирования 120 раньше 80
+			simpleBlock(1, 120, false, "p"),
+			simpleBlock(1, 80, false, "b"),
+			simpleBlock(2, 92, true, "aac2")),
+		ebml(mkvCluster, ebmlUint(mkvTimecode, 1000),
+			simpleBlock(1, 0, true, "key2"),
+			simpleBlock(2, 0, true, "aac3")),
+	}
+}
+
+type mp4Box struct {
+	boxType string
+	payload []byte
+}
+
+func readBoxes(data []byte) []mp4Box {
+	var boxes []mp4Box
+	for len(data) >= 8 {
+		size := int(binary.BigEndian.Uint32(data))
+		if size < 8 || size > len(data) {
+			return boxes
+		}
+		boxes = append(boxes, mp4Box{string(data[4:8]), data[8:size]})
+		data = data[size:]
+	}
+	return boxes
+}
+
+func childBoxes(boxes []mp4Box, boxType string) []mp4Box {
+	var res []mp4Box
+	for _, b := range boxes {
+		if b.boxType == boxType {
+			res = append(res, b)
+		}
+	}
+	return res
+}
+
+type trunSample struct {
+	duration, size, flags uint32
+	cto                   int32
+}
+
+// readTraf returns the track id, base decode time, data offset and samples
+func readTraf(t *testing.T, traf []byte) (uint32, uint64, int32, []trunSample) {
+	children := readBoxes(traf)
+	tfhd, tfdt, trun := childBoxes(children, "tfhd"), childBoxes(children, "tfdt"), childBoxes(children, "trun")
+	if len(tfhd) != 1 || len(tfdt) != 1 || len(trun) != 1 {
+		t.Fatalf("Unexpected traf children: %v", children)
+	}
+	trackId := binary.BigEndian.Uint32(tfhd[0].payload[4:])
+	baseDts := binary.BigEndian.Uint64(tfdt[0].payload[4:])
+
+	payload := trun[0].payload
+	withCto := payload[2] & 0x08 != 0
+	count := int(binary.BigEndian.Uint32(payload[4:]))
+	dataOffset := int32(binary.BigEndian.Uint32(payload[8:]))
+	payload = payload[12:]
+	samples := make([]trunSample, count)
+	for i := range samples {
+		samples[i] = trunSample{
+			duration: binary.BigEndian.Uint32(payload),
+			size:     binary.BigEndian.Uint32(payload[4:]),
+			flags:    binary.BigEndian.Uint32(payload[8:]),
+		}
+		payload = payload[12:]
+		if withCto {
+			samples[i].cto = int32(binary.BigEndian.Uint32(payload))
+			payload = payload[4:]
+		}
+	}
+	return trackId, baseDts, dataOffset, samples
+}
+
+func openTestRemuxer(t *testing.T, audioTrack int) (*Remuxer, *demuxer.Matroska) {
+	data := buildTestMatroska(testClusters()...)
+	mkv, err := demuxer.OpenMatroska(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("Error opening matroska: %v", err)
+	}
+	remuxer, err := New(mkv, audioTrack)
+	if err != nil {
+		t.Fatalf("Error creating remuxer: %v", err)
+	}
+	return remuxer, mkv
+}
+
+func TestNewChoosesTracks(t *testing.T) {
+	remuxer, mkv := openTestRemuxer(t, 0)
+	if remuxer.video.source.Id != 1 || remuxer.audio == nil || remuxer.audio.source.Id != 2 {
+		t.Errorf("Unexpected tracks: video %+v, audio %+v", remuxer.video, remuxer.audio)
+	}
+	if remuxer.audio.timescale != 44100 || remuxer.audio.frameSamples != aacFrameSamples {
+		t.Errorf("Unexpected audio track: %+v", remuxer.audio)
+	}
+
+	if _, err := New(mkv, 3); !errors.Is(err, ErrUnsupportedCodec) {
+		t.Errorf("Expected unsupported codec for AC-3, got %v", err)
+	}
+	if _, err := New(mkv, 5); err == nil {
+		t.Errorf("Expected error for missing audio track")
+	}
+}
+
+func TestAacConfig(t *testing.T) {
+	config, err := aacConfig(demuxer.MediaTrack{Codec: "A_AAC/MPEG4/LC", SampleRate: 44100, Channels: 2})
+	if err != nil || !bytes.Equal(config, testAacConfig) {
+		t.Errorf("Unexpected config %x, %v", config, err)
+	}
+	if _, err := aacConfig(demuxer.MediaTrack{Codec: "A_AAC/MPEG4/LC", SampleRate: 12345}); !errors.Is(err, ErrUnsupportedCodec) {
+		t.Errorf("Expected error for unknown frequency, got %v", err)
+	}
+}
+
+func TestInitSegment(t *testing.T) {
+	remuxer, _ := openTestRemuxer(t, 0)
+	boxes := readBoxes(remuxer.InitSegment())
+	if len(boxes) != 2 || boxes[0].boxType != "ftyp" || boxes[1].boxType != "moov" {
+		t.Fatalf("Unexpected init segment: %v", boxes)
+	}
+	moov := readBoxes(boxes[1].payload)
+	if traks := childBoxes(moov, "trak"); len(traks) != 2 {
+		t.Errorf("Expected 2 tracks, got %v", len(traks))
+	}
+	if len(childBoxes(moov, "mvex")) != 1 {
+		t.Errorf("Fragmented movie without mvex")
+	}
+	if !bytes.Contains(boxes[1].payload, append([]byte("avcC"), testAvcConfig...)) {
+		t.Errorf("No avcC with the codec private data")
+	}
+	if !bytes.Contains(boxes[1].payload, []byte("esds")) || !bytes.Contains(boxes[1].payload, testAacConfig) {
+		t.Errorf("No esds with AudioSpecificConfig")
+	}
+}
+
+func TestStreamFragments(t *testing.T) {
+	remuxer, _ := openTestRemuxer(t, 0)
+	offset, position, err := remuxer.Seek(0)
+	if err != nil || position != 0 {
+		t.Fatalf("Error seeking: %v, %v", position, err)
+	}
+
+	var out bytes.Buffer
+	if err := remuxer.Stream(context.Background(), &out, offset); err != nil {
+		t.Fatalf("Error streaming: %v", err)
+	}
+	boxes := readBoxes(out.Bytes())
+	var types []string
+	for _, b := range boxes {
+		types = append(types, b.boxType)
+	}
+	// init, и по moof/mdat на кластер
+	if len(boxes) != 6 || types[2] != "moof" || types[3] != "mdat" || types[4] != "moof" || types[5] != "mdat" {
+		t.Fatalf("Unexpected boxes: %v", types)
+	}
+
+	moof := readBoxes(boxes[2].payload)
+	mfhd := childBoxes(moof, "mfhd")
+	if len(mfhd) != 1 || binary.BigEndian.Uint32(mfhd[0].payload[4:]) != 1 {
+		t.Errorf("Unexpected first sequence number")
+	}
+	trafs := childBoxes(moof, "traf")
+	if len(trafs) != 2 {
+		t.Fatalf("Expected video and audio traf, got %v", len(trafs))
+	}
+
+	trackId, baseDts, dataOffset, video := readTraf(t, trafs[0].payload)
+	if trackId != 1 || baseDts != 3600 || len(video) != 3 {
+		t.Fatalf("Unexpected video traf: track %v, dts %v, samples %+v", trackId, baseDts, video)
+	}
+	// время показа 40, 120, 80 мс, декодирования 40, 80, 120
+	for i, expected := range []trunSample{
+		{duration: 3600, size: 3, flags: sampleFlagsKeyframe, cto: 0},
+		{duration: 3600, size: 1, flags: sampleFlagsDelta, cto: 3600},
+		{duration: 3600, size: 1, flags: sampleFlagsDelta, cto: -3600},
+	} {
+		if video[i] != expected {
+			t.Errorf("Video sample %v: expected %+v, got %+v", i, expected, video[i])
+		}
+	}
+
+	trackId, baseDts, audioOffset, audio := readTraf(t, trafs[1].payload)
+	if trackId != 2 || baseDts != 2028 || len(audio) != 2 || audio[0].duration != aacFrameSamples || audio[1].size != 4 {
+		t.Errorf("Unexpected audio traf: track %v, dts %v, samples %+v", trackId, baseDts, audio)
+	}
+
+	// смещения данных считаются от начала moof и попадают в mdat
+	mdatStart := int32(8 + len(boxes[2].payload) + 8)
+	if dataOffset != mdatStart || audioOffset != mdatStart + 5 {
+		t.Errorf("Data offsets %v and %v, mdat data at %v", dataOffset, audioOffset, mdatStart)
+	}
+	if string(boxes[3].payload) != "keypbaac1aac2" {
+		t.Errorf("Unexpected mdat: %q", boxes[3].payload)
+	}
+
+	_, baseDts, _, _ = readTraf(t, childBoxes(readBoxes(boxes[4].payload), "traf")[0].payload)
+	if baseDts != uint64(videoTimescale) {
+		t.Errorf("Second fragment starts at %v", baseDts)
+	}
+}
+
+func TestStreamStopsOnCancel(t *testing.T) {
+	remuxer, _ := openTestRemuxer(t, 0)
+	offset, _, _ := remuxer.Seek(0)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	var out bytes.Buffer
+	if err := remuxer.Stream(ctx, &out, offset); err != context.Canceled {
+		t.Errorf("Expected cancel error, got %v", err)
+	}
+	if boxes := readBoxes(out.Bytes()); len(boxes) != 2 {
+		t.Errorf("Only init segment expected, got %v boxes", len(boxes))
+	}
+}
+
+func TestTicks(t *testing.T) {
+	track := &outputTrack{timescale: 44100}
+	if ticks := track.ticks(1500 * time.Millisecond); ticks != 66150 {
+		t.Errorf("Unexpected ticks: %v", ticks)
+	}
+}
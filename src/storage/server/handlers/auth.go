@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"hypertube_storage/auth"
+	"hypertube_storage/parser/env"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+)
+
+type userIdKey struct{}
+
+// AuthMiddleware lets in requests with the access token of the auth service in
+// "accessToken" header or cookie, and GET requests with the signed url of the
+// record. Dev mode has no auth
+func AuthMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if env.GetParser().IsDevMode() {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		userId, err := authenticate(r)
+		if errors.Is(err, auth.ErrUnauthorized) {
+			logrus.Debugf("Unauthorized request %v: %v", r.URL.Path, err)
+			SendFailResponseWithCode(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		if err != nil {
+			logrus.Errorf("Error checking auth of %v: %v", r.URL.Path, err)
+			SendFailResponseWithCode(w, "Failed to check authorization", http.StatusServiceUnavailable)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), userIdKey{}, userId)))
+	})
+}
+
+func authenticate(r *http.Request) (uint, error) {
+	if auth.IsSignedQuery(r.URL.Query()) {
+		// подпись только для плеера, менять что-то по ней нельзя
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			return 0, fmt.Errorf("%w: signed urls are read only", auth.ErrUnauthorized)
+		}
+		return auth.GetChecker().CheckSignedQuery(r.URL.Query(), mux.Vars(r)["file_id"])
+	}
+
+	accessToken := r.Header.Get("accessToken")
+	if accessToken == "" {
+		if cookie, err := r.Cookie("accessToken"); err == nil {
+			// фронт кладет токен в куку через encodeURIComponent
+			if accessToken, err = url.QueryUnescape(cookie.Value); err != nil {
+				accessToken = cookie.Value
+			}
+		}
+	}
+	if accessToken == "" {
+		return 0, auth.ErrUnauthorized
+	}
+	return auth.GetChecker().CheckAccessToken(r.Context(), accessToken)
+}
+
+// signedQuery is the signature of the request to put in links of the response,
+// empty for requests with the token
+func signedQuery(r *http.Request) string {
+	query := r.URL.Query()
+	if !auth.IsSignedQuery(query) {
+		return ""
+	}
+	return url.Values{
+		"user":      {query.Get("user")},
+		"expires":   {query.Get("expires")},
+		"signature": {query.Get("signature")},
+	}.Encode()
+}
+
+// requestUserId is the authorized user, in dev mode it is taken from the
+// "userId" form value
+func requestUserId(r *http.Request) (uint, error) {
+	if userId, ok := r.Context().Value(userIdKey{}).(uint); ok {
+		return userId, nil
+	}
+	return parseUserId(r.FormValue("userId"))
+}
+
+// SignUrlHandler gives the query to append to video and subtitles urls of the
+// record for players which can't send the token
+func SignUrlHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		SendFailResponseWithCode(w, "Incorrect method", http.StatusMethodNotAllowed)
+		return
+	}
+	if auth.IsSignedQuery(r.URL.Query()) {
+		SendFailResponseWithCode(w, "Signed url can't be signed again, access token is required", http.StatusForbidden)
+		return
+	}
+	userId, err := requestUserId(r)
+	if err != nil {
+		SendFailResponseWithCode(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	fileId := mux.Vars(r)["file_id"]
+	SendDataResponse(w, auth.GetChecker().SignFileAccess(userId, fileId, time.Now().Add(auth.SignedUrlTtl)))
+}
@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"container/list"
+	"sync"
+
+	"hypertube_storage/filesReader"
+)
+
+// сколько записей держат кэши обработчиков, самые давние вытесняются
+const (
+	torrentFilesCacheSize = 256
+	hlsIndexCacheSize     = 64
+	seekIndexCacheSize    = 64
+	movieHashCacheSize    = 1024
+	externalIdsCacheSize  = 1024
+)
+
+type lruEntry struct {
+	key   string
+	value interface{}
+}
+
+// lruCache keeps values computed once per torrent or file. Caches keyed by file
+// name also forget the files deleted by the eraser, the loader may write them
+// again with other content
+type lruCache struct {
+	sync.Mutex
+	limit   int
+	byFile  bool
+	watched sync.Once
+	items   map[string]*list.Element
+	lru     *list.List
+}
+
+func newLruCache(limit int) *lruCache {
+	return &lruCache{limit: limit, items: make(map[string]*list.Element), lru: list.New()}
+}
+
+func newFileCache(limit int) *lruCache {
+	c := newLruCache(limit)
+	c.byFile = true
+	return c
+}
+
+func (c *lruCache) get(key string) (interface{}, bool) {
+	c.Lock()
+	defer c.Unlock()
+
+	element, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	c.lru.MoveToFront(element)
+	return element.Value.(*lruEntry).value, true
+}
+
+func (c *lruCache) put(key string, value interface{}) {
+	if c.byFile {
+		// подписываемся при первой записи, пустому кэшу удалять нечего
+		c.watched.Do(func() { filesReader.OnErased(c.drop) })
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	if element, ok := c.items[key]; ok {
+		element.Value.(*lruEntry).value = value
+		c.lru.MoveToFront(element)
+		return
+	}
+	c.items[key] = c.lru.PushFront(&lruEntry{key: key, value: value})
+	for c.lru.Len() > c.limit {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.items, oldest.Value.(*lruEntry).key)
+	}
+}
+
+func (c *lruCache) drop(key string) {
+	c.Lock()
+	defer c.Unlock()
+
+	if element, ok := c.items[key]; ok {
+		c.lru.Remove(element)
+		delete(c.items, key)
+	}
+}
@@ -0,0 +1,32 @@
+package handlers
+
+import "testing"
+
+func TestLruCacheEvictsOldest(t *testing.T) {
+	c := newLruCache(2)
+	c.put("a", 1)
+	c.put("b", 2)
+	// a использован позже b, вытесняется b
+	if value, ok := c.get("a"); !ok || value.(int) != 1 {
+		t.Fatalf("Unexpected a: %v, %v", value, ok)
+	}
+	c.put("c", 3)
+
+	if _, ok := c.get("b"); ok {
+		t.Errorf("b is not evicted")
+	}
+	for key, expected := range map[string]int{"a": 1, "c": 3} {
+		if value, ok := c.get(key); !ok || value.(int) != expected {
+			t.Errorf("Unexpected %v: %v, %v", key, value, ok)
+		}
+	}
+
+	c.put("c", 4)
+	c.drop("a")
+	if _, ok := c.get("a"); ok || len(c.items) != 1 || c.lru.Len() != 1 {
+		t.Errorf("a is not dropped: %v items", len(c.items))
+	}
+	if value, _ := c.get("c"); value.(int) != 4 {
+		t.Errorf("c is not replaced: %v", value)
+	}
+}
@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"hypertube_storage/filesReader"
+	"hypertube_storage/model"
+	"hypertube_storage/subtitlesManager"
+	"hypertube_storage/subtitlesProvider"
+
+	"github.com/sirupsen/logrus"
+)
+
+// хеш считается по краям файла, пока видео качается, конец может быть еще не скачан
+const movieHashTimeout = time.Second * 30
+
+var movieHashCache = newFileCache(movieHashCacheSize)
+
+// внешние субтитры отдаем только из найденных для записи, иначе по ее ссылке
+// можно скачать через наш ключ любой файл провайдера
+var externalIds = struct {
+	sync.Mutex
+	found *lruCache
+}{found: newLruCache(externalIdsCacheSize)}
+
+// rememberExternalIds adds ids found for the record, searches with other
+// languages add to the earlier ones
+func rememberExternalIds(fileId string, found []model.ExternalSubtitles) {
+	externalIds.Lock()
+	defer externalIds.Unlock()
+
+	ids := make(map[string]struct{}, len(found))
+	if known, ok := externalIds.found.get(fileId); ok {
+		for id := range known.(map[string]struct{}) {
+			ids[id] = struct{}{}
+		}
+	}
+	for _, subs := range found {
+		ids[subs.Id] = struct{}{}
+	}
+	externalIds.found.put(fileId, ids)
+}
+
+func isFoundExternalId(fileId, subtitlesId string) bool {
+	externalIds.Lock()
+	defer externalIds.Unlock()
+
+	known, ok := externalIds.found.get(fileId)
+	if !ok {
+		return false
+	}
+	_, found := known.(map[string]struct{})[subtitlesId]
+	return found
+}
+
+func getMovieHash(ctx context.Context, fileId string, videoFile model.FileInfo, info model.LoadInfo) (string, error) {
+	if hash, cached := movieHashCache.get(videoFile.Name); cached {
+		return hash.(string), nil
+	}
+
+	hashCtx, hashCancel := context.WithTimeout(ctx, movieHashTimeout)
+	defer hashCancel()
+	stream, err := filesReader.GetManager().OpenStream(hashCtx, fileId, videoFile, info.IsLoaded)
+	if err != nil {
+		return "", err
+	}
+	defer stream.Close()
+	hash, err := subtitlesProvider.MovieHash(stream, videoFile.Length)
+	if err != nil {
+		return "", err
+	}
+
+	movieHashCache.put(videoFile.Name, hash)
+	return hash, nil
+}
+
+func searchExternalSubtitles(ctx context.Context, r *http.Request, fileId string, videoFile model.FileInfo, info model.LoadInfo) []subtitlesManager.EmbeddedSubtitles {
+	query := model.SubtitlesQuery{MovieSize: videoFile.Length, ImdbId: r.URL.Query().Get("imdb")}
+	if languages := r.URL.Query().Get("languages"); languages != "" {
+		query.Languages = strings.Split(languages, ",")
+	}
+	// ради хеша не отвлекаем загрузку на конец файла, если есть imdb id
+	if info.IsLoaded || query.ImdbId == "" {
+		hash, err := getMovieHash(ctx, fileId, videoFile, info)
+		if err != nil {
+			logrus.Errorf("Error calculating movie hash of %v: %v", videoFile.Name, err)
+		}
+		query.MovieHash = hash
+	}
+	if query.MovieHash == "" && query.ImdbId == "" {
+		return nil
+	}
+
+	found := subtitlesProvider.GetManager().Search(ctx, query)
+	rememberExternalIds(fileId, found)
+	result := make([]subtitlesManager.EmbeddedSubtitles, 0, len(found))
+	for _, subs := range found {
+		result = append(result, subtitlesManager.EmbeddedSubtitles{
+			Id:       subs.Id,
+			Source:   subtitlesManager.SourceExternal,
+			Provider: subs.Provider,
+			Format:   subs.Format,
+			Language: subs.Language,
+			Name:     subs.Release,
+		})
+	}
+	return result
+}
+
+// uploadExternalSubtitles serves only subtitles returned by the search for the
+// record, the list of tracks has to be requested first
+func uploadExternalSubtitles(w http.ResponseWriter, r *http.Request, fileId, subtitlesId string, timing subtitlesManager.Timing) {
+	if !isFoundExternalId(fileId, subtitlesId) {
+		SendFailResponseWithCode(w, fmt.Sprintf("Subtitles %v are not found for %v", subtitlesId, fileId), http.StatusNotFound)
+		return
+	}
+
+	var vtt bytes.Buffer
+	if cached, _ := subtitlesManager.GetManager().GetCachedVtt(subtitlesId, timing, &vtt); !cached {
+		downloadCtx, downloadCancel := context.WithTimeout(r.Context(), time.Second * 60)
+		defer downloadCancel()
+
+		data, err := subtitlesProvider.GetManager().Download(downloadCtx, subtitlesId)
+		if err != nil {
+			logrus.Errorf("Error downloading external subtitles %v: %v", subtitlesId, err)
+			SendFailResponseWithCode(w, fmt.Sprintf("Failed to download subtitles: %v", err), http.StatusBadGateway)
+			return
+		}
+		if err := subtitlesManager.GetManager().ConvertFileToVtt(subtitlesId, data, timing, &vtt); err != nil {
+			SendFailResponseWithCode(w, fmt.Sprintf("Failed to convert subtitles to vtt: %v", err.Error()), http.StatusInternalServerError)
+			return
+		}
+	}
+	writeVttResponse(w, fileId, &vtt)
+}
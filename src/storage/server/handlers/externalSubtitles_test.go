@@ -0,0 +1,27 @@
+package handlers
+
+import (
+	"testing"
+
+	"hypertube_storage/model"
+)
+
+func TestExternalIdsOfRecord(t *testing.T) {
+	rememberExternalIds("record", []model.ExternalSubtitles{{Id: "ext_os_1"}})
+	rememberExternalIds("record", []model.ExternalSubtitles{{Id: "ext_os_2"}})
+	rememberExternalIds("other", []model.ExternalSubtitles{{Id: "ext_os_3"}})
+
+	for _, test := range []struct {
+		fileId, id string
+		expected   bool
+	}{
+		{"record", "ext_os_1", true},
+		{"record", "ext_os_2", true},
+		{"record", "ext_os_3", false},
+		{"unknown", "ext_os_1", false},
+	} {
+		if found := isFoundExternalId(test.fileId, test.id); found != test.expected {
+			t.Errorf("%v of %v: expected %v, got %v", test.id, test.fileId, test.expected, found)
+		}
+	}
+}
@@ -6,12 +6,16 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"strconv"
 	"time"
 
+	"hypertube_storage/dao"
 	"hypertube_storage/db"
 	"hypertube_storage/filesReader"
+	"hypertube_storage/metrics"
 	"hypertube_storage/model"
 	"hypertube_storage/subtitlesManager"
+	"hypertube_storage/subtitlesProvider"
 
 	"github.com/gorilla/mux"
 	"github.com/sirupsen/logrus"
@@ -22,154 +26,254 @@ const (
 	subtitlesRequest = "srt"
 )
 
+// UploadFilePartHandler serves the video with RFC 7233 ranges: single, suffix and
+// multiple ranges, If-Range and conditional requests. Not downloaded parts are
+// prioritized in the loader and streamed as soon as they are written
 func UploadFilePartHandler(w http.ResponseWriter, r *http.Request) {
+	w = NewStatusRecorder(w)
+	defer ObserveRangeRequest(w, time.Now())
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		SendFailResponseWithCode(w, "Incorrect method", http.StatusMethodNotAllowed)
+		return
+	}
+	fileId := mux.Vars(r)["file_id"]
+
+	info, ok := getStartedFileInfo(w, fileId)
+	if !ok {
+		return
+	}
+	videoFile, err := resolveVideoFile(r, fileId, info)
+	if err != nil {
+		SendFailResponseWithCode(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	logrus.Debugf("Got %v request with range=%#v. Info: %v, file: %v", r.Method, r.Header.Get("Range"), info, videoFile)
+
+	openStream := func() (dao.FileStream, error) {
+		return filesReader.GetManager().OpenStream(r.Context(), fileId, videoFile, info.IsLoaded)
+	}
+	startsAt := func(offset int64) {
+		recordRangePosition(r, fileId, videoFile, info, offset)
+	}
+	written, streamed := serveFileRanges(w, r, videoFile, videoContentType(videoFile, info), fileLastModified(videoFile, info), openStream, startsAt)
+	if !streamed {
+		return
+	}
+	metrics.BytesServed.Add(float64(written), videoRequest)
+
+	go db.GetLoadedFilesManager().UpdateLastWatchedDate(fileId)
+}
+
+// resolveVideoFile returns the file chosen by file_index or the main video of the record
+func resolveVideoFile(r *http.Request, fileId string, info model.LoadInfo) (model.FileInfo, error) {
+	rawIndex, exists := mux.Vars(r)["file_index"]
+	if !exists {
+		return info.VideoFile, nil
+	}
+
+	fileIndex, err := strconv.Atoi(rawIndex)
+	if err != nil {
+		return model.FileInfo{}, fmt.Errorf("invalid file index: %v", rawIndex)
+	}
+	videoFile, err := GetTorrentFileByIndex(fileId, fileIndex)
+	if err != nil {
+		logrus.Errorf("Error getting file %v of %v: %v", fileIndex, fileId, err)
+		return model.FileInfo{}, fmt.Errorf("file %v of %v not found: %v", fileIndex, fileId, err)
+	}
+	return videoFile, nil
+}
+
+func UploadSubtitlesFileHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodGet {
 		fileId := mux.Vars(r)["file_id"]
-		fileRange := model.FileRangeDescription{}
-		if err := fileRange.ParseHeader(r.Header.Get("range")); err != nil {
+		subtitlesId := mux.Vars(r)["subtitles_id"]
+
+		timing, err := subtitlesManager.ParseTiming(r.URL.Query().Get("offset"), r.URL.Query().Get("fps"))
+		if err != nil {
 			SendFailResponseWithCode(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 
-		info, err := db.GetLoadedFilesManager().GetFileInfoById(fileId)
-		if err != nil {
-			logrus.Errorf("Err loading file '%v' info, err: %v", fileId, err)
-			SendFailResponseWithCode(w,fmt.Sprintf("File %s not found by id: %s", fileId, err.Error()), http.StatusNotFound)
+		if videoFileName, trackId, isEmbedded := subtitlesManager.ParseEmbeddedSubtitlesId(subtitlesId); isEmbedded {
+			uploadEmbeddedSubtitles(w, fileId, videoFileName, trackId, timing)
 			return
 		}
-		logrus.Debugf("Got request with start=%v. Info: %v",
-			fileRange.Start, info)
-
-		if (info.IsLoaded || info.InProgress) && fileRange.Start >= info.VideoFile.Length {
-			SendFailResponseWithCode(w, fmt.Sprintf(
-				"Start byte (%v) in Content-Range exceeds file length (%v); info: %v",
-					fileRange.Start, info.VideoFile.Length, info), http.StatusBadRequest)
+		if subtitlesManager.IsUserSubtitlesId(subtitlesId) {
+			uploadUserSubtitles(w, fileId, subtitlesId, timing)
+			return
+		}
+		if _, _, isExternal := subtitlesProvider.ParseExternalId(subtitlesId); isExternal {
+			uploadExternalSubtitles(w, r, fileId, subtitlesId, timing)
 			return
 		}
 
-		logrus.Debugf("Trying to upload video from %v", info)
-		var filePart []byte
-
-		if info.IsLoaded {
-			filePart, _, err = filesReader.GetManager().GetFileInRange(info.VideoFile.Name, fileRange.Start, info.VideoFile.Length)
-		} else if info.InProgress {
-			filePart, _, err = filesReader.GetManager().GetFileInRange(info.VideoFile.Name, fileRange.Start, info.VideoFile.Length)
-			if !filesReader.GetManager().IsPartWritten(info.VideoFile.Name, filePart, fileRange.Start) || err != nil {
-				db.GetLoadedStateDb().PubPriorityByteIdx(fileId, info.VideoFile.Name, fileRange.Start)
+		info, ok := getStartedFileInfo(w, fileId)
+		if !ok {
+			return
+		}
+		subtitlesFileInfo, err := findTorrentFileByName(fileId, info, subtitlesId)
+		if err != nil {
+			SendFailResponseWithCode(w, err.Error(), http.StatusNotFound)
+			return
+		}
 
-				readCtx, readCancel := context.WithTimeout(context.TODO(), time.Second * 1800)
-				defer readCancel()
+		var vtt bytes.Buffer
+		if cached, _ := subtitlesManager.GetManager().GetCachedVtt(subtitlesFileInfo.Name, timing, &vtt); !cached {
+			readCtx, readCancel := context.WithTimeout(r.Context(), time.Second * 600)
+			defer readCancel()
 
-				logrus.Debugf("Got file inProgress=true from db: %v, waiting for data (%v %v %v)", info.VideoFile.Name, filesReader.GetManager().HasNullBytes(filePart), filePart == nil, err)
-				filePart, _, err = filesReader.GetManager().WaitForFilePart(readCtx, info.VideoFile.Name, fileRange.Start, info.VideoFile.Length)
-				logrus.Debugf("Wait success (start=%v, len=%v) from disk", fileRange.Start, len(filePart))
-			} else {
-				logrus.Debugf("Read part (start=%v, len=%v) from disk", fileRange.Start, len(filePart))
-			}
-		} else {
-			ok := SendTaskToTorrentClient(fileId)
-			if !ok {
-				SendFailResponseWithCode(w, "Failed to call torrent client", http.StatusInternalServerError)
+			stream, err := filesReader.GetManager().OpenStream(readCtx, fileId, subtitlesFileInfo, info.IsLoaded)
+			if err != nil {
+				SendFailResponseWithCode(w, err.Error(), http.StatusInternalServerError)
 				return
 			}
-			info, err = db.GetLoadedFilesManager().GetFileInfoById(fileId)
+			defer stream.Close()
+			subtitlesFile, err := io.ReadAll(stream)
+			logrus.Debugf("Read subtitles file, len=%v", len(subtitlesFile))
 			if err != nil {
-				SendFailResponseWithCode(w,fmt.Sprintf("File %s not found by id: %s", fileId, err.Error()), http.StatusNotFound)
+				SendFailResponseWithCode(w, err.Error(), http.StatusInternalServerError)
 				return
 			}
-			readCtx, readCancel := context.WithTimeout(context.TODO(), time.Second * 600)
-			defer readCancel()
-
-			db.GetLoadedStateDb().PubPriorityByteIdx(fileId, info.VideoFile.Name, fileRange.Start)
-
-			filePart, _, err = filesReader.GetManager().WaitForFilePart(readCtx, info.VideoFile.Name, fileRange.Start, info.VideoFile.Length)
-		}
-		logrus.Debugf("Writing response, part len=%v", len(filePart))
-
-		if err != nil {
-			SendFailResponseWithCode(w, err.Error(), http.StatusInternalServerError)
-		} else {
-			fileRange.End = fileRange.Start + int64(len(filePart)) - 1
-			contentLen := int64(len(filePart))
-			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", fileRange.Start, fileRange.End, info.VideoFile.Length))
-			w.Header().Set("Content-Length", fmt.Sprint(contentLen))
-			w.Header().Set("Accept-Ranges", "bytes")
-			w.Header().Set("Content-Type", GetContentTypeForReqType("video"))
-			w.WriteHeader(GetResponseStatusForReqType("video"))
-			if _, err := io.Copy(w, bytes.NewReader(filePart)); err != nil {
-				logrus.Errorf("Error piping response: %v", err)
+			if err := subtitlesManager.GetManager().ConvertFileToVtt(subtitlesFileInfo.Name, subtitlesFile, timing, &vtt); err != nil {
+				SendFailResponseWithCode(w, fmt.Sprintf("Failed to convert subtitles to vtt: %v", err.Error()), http.StatusInternalServerError)
+				return
 			}
-
-			go db.GetLoadedFilesManager().UpdateLastWatchedDate(fileId)
 		}
+
+		writeVttResponse(w, fileId, &vtt)
 	} else {
 		SendFailResponseWithCode(w, "Incorrect method", http.StatusMethodNotAllowed)
 	}
 }
 
-func UploadSubtitlesFileHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method == http.MethodGet {
-		fileId := mux.Vars(r)["file_id"]
-		subtitlesId := mux.Vars(r)["subtitles_id"]
+// EmbeddedSubtitlesHandler lists text tracks inside mkv or mp4 video of the record
+// and subtitles found by external providers, "imdb" and "languages" (ru,en) query
+// params narrow the external search
+func EmbeddedSubtitlesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		SendFailResponseWithCode(w, "Incorrect method", http.StatusMethodNotAllowed)
+		return
+	}
+	fileId := mux.Vars(r)["file_id"]
 
-		info, err := db.GetLoadedFilesManager().GetFileInfoById(fileId)
-		if err != nil {
-			logrus.Errorf("Err loading file '%v' info, err: %v", fileId, err)
-			SendFailResponseWithCode(w,fmt.Sprintf("File %s not found by id: %s", fileId, err.Error()), http.StatusNotFound)
+	info, ok := getStartedFileInfo(w, fileId)
+	if !ok {
+		return
+	}
+	videoFile, err := resolveVideoFile(r, fileId, info)
+	if err != nil {
+		SendFailResponseWithCode(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	readCtx, readCancel := context.WithTimeout(context.TODO(), time.Second * 600)
+	defer readCancel()
+
+	tracks, err := subtitlesManager.GetManager().GetEmbeddedSubtitles(readCtx, fileId, videoFile, info.IsLoaded)
+	if err != nil {
+		logrus.Errorf("Error reading embedded subtitles of %v: %v", videoFile.Name, err)
+		// у avi и ts своих дорожек нет, но внешние найти можно
+		if !subtitlesProvider.GetManager().Enabled() {
+			SendFailResponseWithCode(w, fmt.Sprintf("Failed to read video tracks: %v", err), http.StatusUnprocessableEntity)
 			return
 		}
+		tracks = make([]subtitlesManager.EmbeddedSubtitles, 0)
+	}
+	if subtitlesProvider.GetManager().Enabled() {
+		tracks = append(tracks, searchExternalSubtitles(readCtx, r, fileId, videoFile, info)...)
+	}
+	SendDataResponse(w, tracks)
+}
 
-		var subtitlesFile []byte
+func uploadEmbeddedSubtitles(w http.ResponseWriter, fileId string, videoFileName string, trackId int, timing subtitlesManager.Timing) {
+	info, ok := getStartedFileInfo(w, fileId)
+	if !ok {
+		return
+	}
+	videoFile, err := findTorrentFileByName(fileId, info, videoFileName)
+	if err != nil {
+		SendFailResponseWithCode(w, err.Error(), http.StatusNotFound)
+		return
+	}
 
-		if info.IsLoaded {
-			subtitlesFile, err = filesReader.GetManager().ReadWholeFile(subtitlesId)
-		} else if info.InProgress {
-			subtitlesFile, err = filesReader.GetManager().ReadWholeFile(subtitlesId)
-			if !filesReader.GetManager().IsPartWritten(subtitlesId, subtitlesFile, 0) || err != nil {
-				db.GetLoadedStateDb().PubPriorityByteIdx(fileId, subtitlesId, 0)
+	readCtx, readCancel := context.WithTimeout(context.TODO(), time.Second * 600)
+	defer readCancel()
 
-				readCtx, readCancel := context.WithTimeout(context.TODO(), time.Second * 600)
-				defer readCancel()
+	var vtt bytes.Buffer
+	complete, err := subtitlesManager.GetManager().ConvertEmbeddedToVtt(readCtx, fileId, videoFile, trackId, info.IsLoaded, timing, &vtt)
+	if err != nil {
+		logrus.Errorf("Error extracting track %v of %v: %v", trackId, videoFile.Name, err)
+		SendFailResponseWithCode(w, fmt.Sprintf("Failed to extract subtitles: %v", err), http.StatusUnprocessableEntity)
+		return
+	}
 
-				logrus.Debugf("Got file inProgress=true from db: %v, waiting for data (%v %v %v %v)", info, len(subtitlesFile), filesReader.GetManager().HasNullBytes(subtitlesFile), subtitlesFile == nil, err)
-				subtitlesFile, err = filesReader.GetManager().WaitForWholeFileWritten(readCtx, subtitlesId)
-				logrus.Debugf("Wait srt success (len=%v) from disk", len(subtitlesFile))
-			} else {
-				logrus.Debugf("Read srt file (len=%v) from disk", len(subtitlesFile))
-			}
-		} else {
-			ok := SendTaskToTorrentClient(fileId)
-			if !ok {
-				SendFailResponseWithCode(w, "Failed to call torrent client", http.StatusInternalServerError)
-				return
-			}
-			info, err = db.GetLoadedFilesManager().GetFileInfoById(fileId)
-			if err != nil {
-				SendFailResponseWithCode(w,fmt.Sprintf("File %s not found by id: %s", fileId, err.Error()), http.StatusNotFound)
-				return
-			}
-			readCtx, readCancel := context.WithTimeout(context.TODO(), time.Second * 600)
-			defer readCancel()
+	// пока видео качается, часть реплик может быть еще не скачана
+	if !complete {
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("X-Subtitles-Complete", "false")
+	}
+	writeVttResponse(w, fileId, &vtt)
+}
 
-			db.GetLoadedStateDb().PubPriorityByteIdx(fileId, subtitlesId, 0)
+func writeVttResponse(w http.ResponseWriter, fileId string, vtt *bytes.Buffer) {
+	w.Header().Set("Content-Type", GetContentTypeForReqType(subtitlesRequest))
+	w.WriteHeader(GetResponseStatusForReqType(subtitlesRequest))
+	counter := &CountingWriter{Writer: w}
+	if _, err := io.Copy(counter, vtt); err != nil {
+		logrus.Errorf("Error piping response: %v", err)
+	}
+	metrics.BytesServed.Add(float64(counter.Written), subtitlesRequest)
 
-			subtitlesFile, err = filesReader.GetManager().WaitForWholeFileWritten(readCtx, subtitlesId)
-		}
-		logrus.Debugf("Writing response, part len=%v", len(subtitlesFile))
+	go db.GetLoadedFilesManager().UpdateLastWatchedDate(fileId)
+}
 
-		if err != nil {
-			SendFailResponseWithCode(w, err.Error(), http.StatusInternalServerError)
-		} else {
-			w.Header().Set("Content-Type", GetContentTypeForReqType("srt"))
-			w.WriteHeader(GetResponseStatusForReqType("srt"))
-			if err := subtitlesManager.GetManager().ConvertSrtToVtt(bytes.NewReader(subtitlesFile), w); err != nil {
-				SendFailResponseWithCode(w, fmt.Sprintf("Failed to convert srt to vtt: %v", err.Error()), http.StatusInternalServerError)
-			}
+// getStartedFileInfo asks the loader to start download of the record if it is not
+// loaded or in progress yet
+func getStartedFileInfo(w http.ResponseWriter, fileId string) (model.LoadInfo, bool) {
+	info, err := db.GetLoadedFilesManager().GetFileInfoById(fileId)
+	if err != nil {
+		logrus.Errorf("Err loading file '%v' info, err: %v", fileId, err)
+		SendFailResponseWithCode(w, fmt.Sprintf("File %s not found by id: %s", fileId, err.Error()), http.StatusNotFound)
+		return info, false
+	}
+	if info.IsLoaded || info.InProgress {
+		return info, true
+	}
 
-			go db.GetLoadedFilesManager().UpdateLastWatchedDate(fileId)
+	if ok := SendTaskToTorrentClient(fileId); !ok {
+		SendFailResponseWithCode(w, "Failed to call torrent client", http.StatusInternalServerError)
+		return info, false
+	}
+	if info, err = db.GetLoadedFilesManager().GetFileInfoById(fileId); err != nil {
+		SendFailResponseWithCode(w, fmt.Sprintf("File %s not found by id: %s", fileId, err.Error()), http.StatusNotFound)
+		return info, false
+	}
+	return info, true
+}
+
+func findTorrentFileByName(fileId string, info model.LoadInfo, fileName string) (model.FileInfo, error) {
+	if info.VideoFile.Name == fileName {
+		return info.VideoFile, nil
+	}
+	files, err := GetTorrentFilesFromLoader(fileId)
+	if err != nil {
+		return model.FileInfo{}, err
+	}
+	for _, file := range files {
+		if file.FileName == fileName {
+			return file.ToFileInfo(), nil
 		}
+	}
+	return model.FileInfo{}, fmt.Errorf("file %v not found in %v", fileName, fileId)
+}
+
+func RangeCacheStatsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		SendDataResponse(w, filesReader.GetManager().RangeCacheStats())
 	} else {
-		SendFailResponseWithCode(w, "Incorrect method", http.StatusMethodNotAllowed)
+		SendFailResponseWithCode(w, "Not allowed", http.StatusMethodNotAllowed)
 	}
 }
 
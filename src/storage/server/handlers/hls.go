@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"hypertube_storage/dao"
+	"hypertube_storage/db"
+	"hypertube_storage/filesReader"
+	"hypertube_storage/hls"
+	"hypertube_storage/metrics"
+	"hypertube_storage/model"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	hlsRequest      = "hls"
+	hlsPlaylistType = "application/vnd.apple.mpegurl"
+	hlsSegmentType  = "video/mp2t"
+)
+
+// индекс строится по началу и концу файла, которые не меняются
+var hlsIndexCache = newFileCache(hlsIndexCacheSize)
+
+// openHlsStream checks that the video is mpeg-ts and returns its index, the
+// caller closes the stream
+func openHlsStream(ctx context.Context, w http.ResponseWriter, r *http.Request) (string, dao.FileStream, *hls.Index, bool) {
+	if r.Method != http.MethodGet {
+		SendFailResponseWithCode(w, "Incorrect method", http.StatusMethodNotAllowed)
+		return "", nil, nil, false
+	}
+	fileId := mux.Vars(r)["file_id"]
+
+	info, ok := getStartedFileInfo(w, fileId)
+	if !ok {
+		return "", nil, nil, false
+	}
+	videoFile, err := resolveVideoFile(r, fileId, info)
+	if err != nil {
+		SendFailResponseWithCode(w, err.Error(), http.StatusNotFound)
+		return "", nil, nil, false
+	}
+	if contentType := videoContentType(videoFile, info); contentType != hlsSegmentType {
+		SendFailResponseWithCode(w, fmt.Sprintf("HLS is available for mpeg-ts only, the video is %v", contentType),
+			http.StatusUnsupportedMediaType)
+		return "", nil, nil, false
+	}
+
+	stream, err := filesReader.GetManager().OpenStream(ctx, fileId, videoFile, info.IsLoaded)
+	if err != nil {
+		SendFailResponseWithCode(w, fmt.Sprintf("Failed to open %v: %v", videoFile.Name, err), http.StatusInternalServerError)
+		return "", nil, nil, false
+	}
+	index, err := getHlsIndex(ctx, stream, videoFile)
+	if err != nil {
+		stream.Close()
+		logrus.Errorf("Error indexing %v of %v: %v", videoFile.Name, fileId, err)
+		SendFailResponseWithCode(w, fmt.Sprintf("Failed to index the stream: %v", err), http.StatusUnprocessableEntity)
+		return "", nil, nil, false
+	}
+	return fileId, stream, index, true
+}
+
+func getHlsIndex(ctx context.Context, stream dao.FileStream, videoFile model.FileInfo) (*hls.Index, error) {
+	if index, cached := hlsIndexCache.get(videoFile.Name); cached {
+		return index.(*hls.Index), nil
+	}
+
+	index, err := hls.BuildIndex(ctx, stream, videoFile.Length)
+	if err != nil {
+		return nil, err
+	}
+	hlsIndexCache.put(videoFile.Name, index)
+	return index, nil
+}
+
+func HlsMasterPlaylistHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second * 600)
+	defer cancel()
+
+	_, stream, index, ok := openHlsStream(ctx, w, r)
+	if !ok {
+		return
+	}
+	defer stream.Close()
+
+	w.Header().Set("Content-Type", hlsPlaylistType)
+	if _, err := w.Write([]byte(index.MasterPlaylist(signedQuery(r)))); err != nil {
+		logrus.Errorf("Error sending master playlist: %v", err)
+	}
+}
+
+func HlsMediaPlaylistHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second * 600)
+	defer cancel()
+
+	_, stream, index, ok := openHlsStream(ctx, w, r)
+	if !ok {
+		return
+	}
+	defer stream.Close()
+
+	w.Header().Set("Content-Type", hlsPlaylistType)
+	if _, err := w.Write([]byte(index.MediaPlaylist(ctx, stream, signedQuery(r)))); err != nil {
+		logrus.Errorf("Error sending media playlist: %v", err)
+	}
+}
+
+// HlsSegmentHandler cuts the segment from the file, not downloaded pieces are
+// prioritized and the response waits for them
+func HlsSegmentHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second * 1800)
+	defer cancel()
+
+	fileId, stream, index, ok := openHlsStream(ctx, w, r)
+	if !ok {
+		return
+	}
+	defer stream.Close()
+
+	segment, err := strconv.Atoi(mux.Vars(r)["segment"])
+	if err != nil || segment >= index.Segments {
+		SendFailResponseWithCode(w, fmt.Sprintf("Segment %v not found", mux.Vars(r)["segment"]), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", hlsSegmentType)
+	counter := &CountingWriter{Writer: w}
+	if err := index.WriteSegment(ctx, stream, segment, counter); err != nil {
+		logrus.Errorf("Error streaming segment %v of %v: %v", segment, fileId, err)
+		if counter.Written == 0 {
+			SendFailResponseWithCode(w, fmt.Sprintf("Failed to cut segment: %v", err), http.StatusInternalServerError)
+			return
+		}
+		// заголовки уже ушли, клиент увидит оборванный сегмент
+	}
+	metrics.BytesServed.Add(float64(counter.Written), hlsRequest)
+
+	go db.GetLoadedFilesManager().UpdateLastWatchedDate(fileId)
+}
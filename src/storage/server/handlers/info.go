@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"hypertube_storage/db"
+	"hypertube_storage/demuxer"
+	"hypertube_storage/filesReader"
+	"hypertube_storage/model"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+)
+
+// по первым байтам различаются все поддерживаемые контейнеры, mpeg-ts требует 189
+const contentTypeSniffLen = 512
+
+type videoInfoResponse struct {
+	FileName    string `json:"fileName,omitempty"`
+	Extension   string `json:"extension,omitempty"`
+	ContentType string `json:"contentType"`
+	*demuxer.MediaInfo
+}
+
+// содержимое файлов не меняется, заголовки контейнера разбираем один раз
+var mediaInfoCache = struct {
+	sync.Mutex
+	contentTypes map[string]string
+	probes       map[string]*demuxer.MediaInfo
+}{contentTypes: make(map[string]string), probes: make(map[string]*demuxer.MediaInfo)}
+
+// videoContentType sniffs the container when the file start is written, until
+// then the type is guessed by the original extension
+func videoContentType(file model.FileInfo, info model.LoadInfo) string {
+	mediaInfoCache.Lock()
+	contentType, cached := mediaInfoCache.contentTypes[file.Name]
+	mediaInfoCache.Unlock()
+	if cached {
+		return contentType
+	}
+
+	headLen := int64(contentTypeSniffLen)
+	if headLen > file.Length {
+		headLen = file.Length
+	}
+	if !info.IsLoaded && !db.GetLoadedStateDb().GetCompletedRanges(file.Name).Covers(0, headLen) {
+		return demuxer.ContentType(nil, file.Extension)
+	}
+
+	osFile, err := filesReader.GetManager().OpenFile(file.Name)
+	if err != nil {
+		return demuxer.ContentType(nil, file.Extension)
+	}
+	defer osFile.Close()
+	head := make([]byte, headLen)
+	n, err := osFile.ReadAt(head, 0)
+	if err != nil && err != io.EOF {
+		logrus.Errorf("Error reading %v head: %v", file.Name, err)
+		return demuxer.ContentType(nil, file.Extension)
+	}
+
+	contentType = demuxer.ContentType(head[:n], file.Extension)
+	mediaInfoCache.Lock()
+	mediaInfoCache.contentTypes[file.Name] = contentType
+	mediaInfoCache.Unlock()
+	return contentType
+}
+
+func probeVideo(ctx context.Context, fileId string, file model.FileInfo, info model.LoadInfo) (*demuxer.MediaInfo, error) {
+	mediaInfoCache.Lock()
+	probe, cached := mediaInfoCache.probes[file.Name]
+	mediaInfoCache.Unlock()
+	if cached {
+		return probe, nil
+	}
+
+	stream, err := filesReader.GetManager().OpenStream(ctx, fileId, file, info.IsLoaded)
+	if err != nil {
+		return nil, err
+	}
+	defer stream.Close()
+	if probe, err = demuxer.Probe(stream, file.Length); err != nil {
+		return nil, err
+	}
+
+	mediaInfoCache.Lock()
+	mediaInfoCache.probes[file.Name] = probe
+	mediaInfoCache.Unlock()
+	return probe, nil
+}
+
+// VideoInfoHandler describes the video: container, duration, codecs, resolution
+// and bitrate. Only matroska and mp4 are probed, other containers get the
+// content type only
+func VideoInfoHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		SendFailResponseWithCode(w, "Incorrect method", http.StatusMethodNotAllowed)
+		return
+	}
+	fileId := mux.Vars(r)["file_id"]
+
+	info, ok := getStartedFileInfo(w, fileId)
+	if !ok {
+		return
+	}
+	videoFile, err := resolveVideoFile(r, fileId, info)
+	if err != nil {
+		SendFailResponseWithCode(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	response := videoInfoResponse{
+		FileName:    videoFile.OriginalName,
+		Extension:   videoFile.Extension,
+		ContentType: videoContentType(videoFile, info),
+		MediaInfo:   &demuxer.MediaInfo{Streams: []demuxer.StreamInfo{}},
+	}
+
+	probeCtx, probeCancel := context.WithTimeout(r.Context(), time.Second * 600)
+	defer probeCancel()
+
+	probe, err := probeVideo(probeCtx, fileId, videoFile, info)
+	switch {
+	case err == nil:
+		response.MediaInfo = probe
+	case errors.Is(err, demuxer.ErrUnsupportedContainer):
+		logrus.Debugf("Container of %v is not probed: %v", videoFile.Name, err)
+	default:
+		logrus.Errorf("Error probing %v of %v: %v", videoFile.Name, fileId, err)
+		SendFailResponseWithCode(w, fmt.Sprintf("Failed to read video info: %v", err), http.StatusUnprocessableEntity)
+		return
+	}
+	SendDataResponse(w, response)
+}
@@ -0,0 +1,208 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"hypertube_storage/db"
+	"hypertube_storage/model"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// дальше обычно идут титры
+	completedRatio = 0.95
+
+	rangePositionInterval = time.Second * 15
+	// подсказка живет, пока идет поиск ключевого кадра
+	resumeHintInterval    = time.Second * 60
+	defaultRecentLimit    = 20
+	maxRecentLimit        = 100
+)
+
+type progressRequest struct {
+	Position      float64 `json:"position"`
+	Duration      float64 `json:"duration"`
+	PositionBytes int64   `json:"positionBytes"`
+	Completed     bool    `json:"completed"`
+}
+
+// keyThrottle lets an action for the key run not more often than the interval
+type keyThrottle struct {
+	sync.Mutex
+	interval time.Duration
+	last     map[string]time.Time
+}
+
+func newKeyThrottle(interval time.Duration) *keyThrottle {
+	return &keyThrottle{interval: interval, last: make(map[string]time.Time)}
+}
+
+// allow remembers the time of the allowed action, old keys are forgotten
+func (t *keyThrottle) allow(key string) bool {
+	now := time.Now()
+	t.Lock()
+	defer t.Unlock()
+
+	if now.Sub(t.last[key]) < t.interval {
+		return false
+	}
+	for lastKey, last := range t.last {
+		if now.Sub(last) >= t.interval {
+			delete(t.last, lastKey)
+		}
+	}
+	t.last[key] = now
+	return true
+}
+
+// плеер делает range запросы часто, позицию по ним пишем не чаще интервала
+var rangePositionThrottle = newKeyThrottle(rangePositionInterval)
+
+// страницу записи открывают и обновляют часто, подсказку шлем одну на интервал
+var resumeHintThrottle = newKeyThrottle(resumeHintInterval)
+
+// ProgressHandler returns the playback state of the record for the user (GET)
+// and saves player heartbeats (POST) with position and duration in seconds.
+// GET also asks the loader to download the resume position in advance, once a
+// minute for the user and record
+func ProgressHandler(w http.ResponseWriter, r *http.Request) {
+	fileId := mux.Vars(r)["file_id"]
+	userId, err := requestUserId(r)
+	if err != nil {
+		SendFailResponseWithCode(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		progress, err := db.GetWatchProgressManager().GetProgress(userId, fileId)
+		if err == sql.ErrNoRows {
+			SendFailResponseWithCode(w, fmt.Sprintf("No progress of %v", fileId), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			logrus.Errorf("Error getting progress of %v for user %v: %v", fileId, userId, err)
+			SendFailResponseWithCode(w, fmt.Sprintf("Failed to get progress: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if !progress.Completed && resumeHintThrottle.allow(fmt.Sprintf("%d:%s", userId, fileId)) {
+			go prioritizeResumePosition(progress)
+		}
+		SendDataResponse(w, progress)
+	case http.MethodPost, http.MethodPut:
+		var request progressRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			SendFailResponseWithCode(w, fmt.Sprintf("Invalid body: %v", err), http.StatusBadRequest)
+			return
+		}
+		if request.Position < 0 || request.Duration < 0 || request.PositionBytes < 0 {
+			SendFailResponseWithCode(w, "Position and duration can't be negative", http.StatusBadRequest)
+			return
+		}
+		progress := model.WatchProgress{
+			UserId:        userId,
+			FileId:        fileId,
+			Position:      request.Position,
+			PositionBytes: request.PositionBytes,
+			Duration:      request.Duration,
+			Completed:     request.Completed || (request.Duration > 0 && request.Position >= request.Duration * completedRatio),
+		}
+		if err := db.GetWatchProgressManager().SaveProgress(progress); err != nil {
+			logrus.Errorf("Error saving progress of %v for user %v: %v", fileId, userId, err)
+			SendFailResponseWithCode(w, fmt.Sprintf("Failed to save progress: %v", err), http.StatusInternalServerError)
+			return
+		}
+		SendDataResponse(w, progress)
+	default:
+		SendFailResponseWithCode(w, "Incorrect method", http.StatusMethodNotAllowed)
+	}
+}
+
+// RecentProgressHandler lists records watched by the user, the latest first
+func RecentProgressHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		SendFailResponseWithCode(w, "Incorrect method", http.StatusMethodNotAllowed)
+		return
+	}
+	userId, err := requestUserId(r)
+	if err != nil {
+		SendFailResponseWithCode(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	limit := defaultRecentLimit
+	if raw := r.URL.Query().Get("limit"); raw != "" {
+		if limit, err = strconv.Atoi(raw); err != nil || limit <= 0 || limit > maxRecentLimit {
+			SendFailResponseWithCode(w, fmt.Sprintf("Invalid limit %#v, expected 1-%v", raw, maxRecentLimit), http.StatusBadRequest)
+			return
+		}
+	}
+
+	recent, err := db.GetWatchProgressManager().GetRecentProgress(userId, limit)
+	if err != nil {
+		logrus.Errorf("Error getting recent progress of user %v: %v", userId, err)
+		SendFailResponseWithCode(w, fmt.Sprintf("Failed to get progress: %v", err), http.StatusInternalServerError)
+		return
+	}
+	SendDataResponse(w, recent)
+}
+
+// recordRangePosition saves the start of the player range request as the byte
+// position. The first request from zero and requests of the index at the end
+// are the player probing the file, not watching
+func recordRangePosition(r *http.Request, fileId string, videoFile model.FileInfo, info model.LoadInfo, start int64) {
+	userId, ok := r.Context().Value(userIdKey{}).(uint)
+	if !ok || videoFile.Name != info.VideoFile.Name || start <= 0 || start >= videoFile.Length / 100 * 98 {
+		return
+	}
+
+	if !rangePositionThrottle.allow(fmt.Sprintf("%d:%s", userId, fileId)) {
+		return
+	}
+
+	go func() {
+		if err := db.GetWatchProgressManager().SaveBytePosition(userId, fileId, start); err != nil {
+			logrus.Errorf("Error saving byte position of %v for user %v: %v", fileId, userId, err)
+		}
+	}()
+}
+
+// prioritizeResumePosition sends the priority hint for the keyframe of the saved
+// position while the user is on the record page, so playback resumes without
+// waiting for the loader
+func prioritizeResumePosition(progress model.WatchProgress) {
+	info, err := db.GetLoadedFilesManager().GetFileInfoById(progress.FileId)
+	if err != nil || info.IsLoaded || !info.InProgress {
+		return
+	}
+	videoFile := info.VideoFile
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second * 60)
+	defer cancel()
+
+	offset := progress.PositionBytes
+	if progress.Position > 0 {
+		if index, err := getSeekIndex(ctx, progress.FileId, videoFile, info); err == nil {
+			point, _ := index.Find(time.Duration(progress.Position * float64(time.Second)))
+			offset = point.Offset
+		} else {
+			logrus.Debugf("No keyframes index of %v for resume hint: %v", videoFile.Name, err)
+		}
+	}
+	if offset <= 0 || offset >= videoFile.Length {
+		return
+	}
+	if db.GetLoadedStateDb().GetCompletedRanges(videoFile.Name).Covers(offset, offset + 1) {
+		return
+	}
+	logrus.Debugf("Prioritizing resume position %v (%v bytes) of %v", progress.Position, offset, progress.FileId)
+	db.GetLoadedStateDb().PubPriorityByteIdx(progress.FileId, videoFile.Name, offset)
+}
@@ -0,0 +1,28 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+)
+
+func TestKeyThrottle(t *testing.T) {
+	throttle := newKeyThrottle(time.Minute)
+	if !throttle.allow("1:a") {
+		t.Fatalf("First action is not allowed")
+	}
+	if throttle.allow("1:a") {
+		t.Errorf("Repeated action is allowed within the interval")
+	}
+	if !throttle.allow("2:a") {
+		t.Errorf("Action of another key is not allowed")
+	}
+
+	// старые ключи забываются при следующем действии
+	throttle.last["1:a"] = time.Now().Add(-time.Minute)
+	if !throttle.allow("3:a") || !throttle.allow("1:a") {
+		t.Errorf("Action is not allowed after the interval")
+	}
+	if len(throttle.last) != 3 {
+		t.Errorf("Unexpected keys: %v", throttle.last)
+	}
+}
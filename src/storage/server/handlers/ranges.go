@@ -0,0 +1,216 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strings"
+	"time"
+
+	"hypertube_storage/dao"
+	"hypertube_storage/filesReader"
+	"hypertube_storage/model"
+
+	"github.com/sirupsen/logrus"
+)
+
+// содержимое файла торрента не меняется, поэтому имя и длина - сильный ETag
+func fileETag(file model.FileInfo) string {
+	return fmt.Sprintf("\"%s-%d\"", file.Name, file.Length)
+}
+
+// fileLastModified is known only for loaded files, parts of downloading files
+// change all the time
+func fileLastModified(file model.FileInfo, info model.LoadInfo) time.Time {
+	if !info.IsLoaded {
+		return time.Time{}
+	}
+	osFile, err := filesReader.GetManager().OpenFile(file.Name)
+	if err != nil {
+		return time.Time{}
+	}
+	defer osFile.Close()
+	stat, err := osFile.Stat()
+	if err != nil {
+		return time.Time{}
+	}
+	return stat.ModTime().UTC().Truncate(time.Second)
+}
+
+func etagMatches(header string, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// isNotModified checks If-None-Match and If-Modified-Since of GET and HEAD
+func isNotModified(r *http.Request, etag string, lastModified time.Time) bool {
+	if header := r.Header.Get("If-None-Match"); header != "" {
+		return etagMatches(header, etag)
+	}
+	if header := r.Header.Get("If-Modified-Since"); header != "" && !lastModified.IsZero() {
+		since, err := http.ParseTime(header)
+		return err == nil && !lastModified.After(since)
+	}
+	return false
+}
+
+// ifRangeMatches tells whether Range may be applied, If-Range requires strong
+// ETag comparison or exact date
+func ifRangeMatches(r *http.Request, etag string, lastModified time.Time) bool {
+	header := strings.TrimSpace(r.Header.Get("If-Range"))
+	switch {
+	case header == "":
+		return true
+	case strings.HasPrefix(header, "W/"):
+		return false
+	case strings.HasPrefix(header, "\""):
+		return header == etag
+	}
+	date, err := http.ParseTime(header)
+	return err == nil && !lastModified.IsZero() && lastModified.Equal(date)
+}
+
+func newMultipartBoundary() string {
+	buf := make([]byte, 15)
+	if _, err := rand.Read(buf); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(buf)
+}
+
+func rangePartHeader(fileRange model.FileRangeDescription, contentType string, size int64) textproto.MIMEHeader {
+	return textproto.MIMEHeader{
+		"Content-Type":  {contentType},
+		"Content-Range": {fileRange.ContentRange(size)},
+	}
+}
+
+// multipartRangesLength counts multipart/byteranges body size, so the response
+// has Content-Length like single range one
+func multipartRangesLength(ranges []model.FileRangeDescription, boundary string, contentType string, size int64) int64 {
+	counter := &CountingWriter{Writer: io.Discard}
+	writer := multipart.NewWriter(counter)
+	if err := writer.SetBoundary(boundary); err != nil {
+		return -1
+	}
+	var total int64
+	for _, fileRange := range ranges {
+		if _, err := writer.CreatePart(rangePartHeader(fileRange, contentType, size)); err != nil {
+			return -1
+		}
+		total += fileRange.Length()
+	}
+	writer.Close()
+	return total + counter.Written
+}
+
+// writeFileRange copies the range from the stream, the stream waits for pieces
+// which are not downloaded yet
+func writeFileRange(w io.Writer, stream dao.FileStream, fileRange model.FileRangeDescription) error {
+	written, err := stream.WriteRange(w, fileRange.Start, fileRange.Length())
+	if err == nil && written < fileRange.Length() {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// serveFileRanges answers GET and HEAD by conditional and Range headers. The
+// stream is opened only to send the body, startsAt gets the position of single
+// range requests. Returns the number of written body bytes and false when the
+// body wasn't sent
+func serveFileRanges(w http.ResponseWriter, r *http.Request, file model.FileInfo, contentType string, lastModified time.Time,
+	openStream func() (dao.FileStream, error), startsAt func(offset int64)) (int64, bool) {
+	etag := fileETag(file)
+	w.Header().Set("Accept-Ranges", "bytes")
+	w.Header().Set("ETag", etag)
+	if !lastModified.IsZero() {
+		w.Header().Set("Last-Modified", lastModified.Format(http.TimeFormat))
+	}
+	if isNotModified(r, etag, lastModified) {
+		w.WriteHeader(http.StatusNotModified)
+		return 0, false
+	}
+
+	ranges := []model.FileRangeDescription{{Start: 0, End: file.Length - 1}}
+	status := http.StatusOK
+	if header := r.Header.Get("Range"); header != "" && ifRangeMatches(r, etag, lastModified) {
+		parsed, err := model.ParseRangeHeader(header, file.Length)
+		switch err {
+		case nil:
+			ranges, status = parsed, http.StatusPartialContent
+		case model.ErrRangeNotSatisfiable:
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", file.Length))
+			SendFailResponseWithCode(w, fmt.Sprintf("Range %v is out of file length %v", header, file.Length),
+				http.StatusRequestedRangeNotSatisfiable)
+			return 0, false
+		default:
+			logrus.Debugf("Ignoring range header %#v: %v", header, err)
+		}
+	}
+
+	var boundary string
+	if len(ranges) == 1 {
+		if status == http.StatusPartialContent {
+			w.Header().Set("Content-Range", ranges[0].ContentRange(file.Length))
+		}
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Length", fmt.Sprint(ranges[0].Length()))
+	} else {
+		boundary = newMultipartBoundary()
+		w.Header().Set("Content-Type", "multipart/byteranges; boundary=" + boundary)
+		if length := multipartRangesLength(ranges, boundary, contentType, file.Length); length >= 0 {
+			w.Header().Set("Content-Length", fmt.Sprint(length))
+		}
+	}
+	if r.Method == http.MethodHead {
+		w.WriteHeader(status)
+		return 0, false
+	}
+	if status == http.StatusPartialContent && len(ranges) == 1 {
+		startsAt(ranges[0].Start)
+	}
+
+	stream, err := openStream()
+	if err != nil {
+		w.Header().Del("Content-Length")
+		w.Header().Del("Content-Range")
+		SendFailResponseWithCode(w, fmt.Sprintf("Failed to open %v: %v", file.Name, err), http.StatusInternalServerError)
+		return 0, false
+	}
+	defer stream.Close()
+	w.WriteHeader(status)
+
+	counter := &CountingWriter{Writer: w}
+	if boundary == "" {
+		err = writeFileRange(counter, stream, ranges[0])
+	} else {
+		parts := multipart.NewWriter(counter)
+		_ = parts.SetBoundary(boundary)
+		for _, fileRange := range ranges {
+			var part io.Writer
+			if part, err = parts.CreatePart(rangePartHeader(fileRange, contentType, file.Length)); err != nil {
+				break
+			}
+			if err = writeFileRange(part, stream, fileRange); err != nil {
+				break
+			}
+		}
+		if err == nil {
+			err = parts.Close()
+		}
+	}
+	// заголовки уже отправлены, клиент увидит оборванный ответ
+	if err != nil {
+		logrus.Errorf("Error streaming %v: %v", file.Name, err)
+	}
+	return counter.Written, true
+}
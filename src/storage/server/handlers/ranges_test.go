@@ -0,0 +1,167 @@
+package handlers
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"hypertube_storage/dao"
+	"hypertube_storage/model"
+)
+
+// memStream is a completely downloaded file in memory
+type memStream struct {
+	*bytes.Reader
+	data []byte
+}
+
+func (m *memStream) WaitRange(start, end int64) error {
+	return nil
+}
+
+func (m *memStream) WriteRange(w io.Writer, start, length int64) (int64, error) {
+	return io.Copy(w, io.NewSectionReader(m.Reader, start, length))
+}
+
+func (m *memStream) NextDownloaded(offset int64) (int64, int64) {
+	return offset, int64(len(m.data))
+}
+
+func (m *memStream) Close() error {
+	return nil
+}
+
+func TestServeFileRanges(t *testing.T) {
+	data := make([]byte, 1000)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	file := model.FileInfo{Name: "video.mp4", Length: int64(len(data))}
+	etag := fileETag(file)
+	lastModified := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	for _, test := range []struct {
+		name         string
+		method       string
+		headers      map[string]string
+		status       int
+		contentRange string
+		body         []byte
+		parts        []model.FileRangeDescription
+		startsAt     int64
+	}{
+		{name: "whole file", status: http.StatusOK, body: data, startsAt: -1},
+		{name: "single", headers: map[string]string{"Range": "bytes=10-19"}, status: http.StatusPartialContent,
+			contentRange: "bytes 10-19/1000", body: data[10:20], startsAt: 10},
+		{name: "suffix", headers: map[string]string{"Range": "bytes=-100"}, status: http.StatusPartialContent,
+			contentRange: "bytes 900-999/1000", body: data[900:], startsAt: 900},
+		{name: "open-ended", headers: map[string]string{"Range": "bytes=990-"}, status: http.StatusPartialContent,
+			contentRange: "bytes 990-999/1000", body: data[990:], startsAt: 990},
+		{name: "multiple", headers: map[string]string{"Range": "bytes=0-9,500-509"}, status: http.StatusPartialContent,
+			parts: []model.FileRangeDescription{{Start: 0, End: 9}, {Start: 500, End: 509}}, startsAt: -1},
+		{name: "overlapping", headers: map[string]string{"Range": "bytes=0-99,50-149"}, status: http.StatusPartialContent,
+			parts: []model.FileRangeDescription{{Start: 0, End: 99}, {Start: 50, End: 149}}, startsAt: -1},
+		{name: "unsatisfiable", headers: map[string]string{"Range": "bytes=1000-"}, status: http.StatusRequestedRangeNotSatisfiable,
+			contentRange: "bytes */1000", startsAt: -1},
+		{name: "malformed", headers: map[string]string{"Range": "bytes=5-1"}, status: http.StatusOK, body: data, startsAt: -1},
+		{name: "if-range mismatch", headers: map[string]string{"Range": "bytes=10-19", "If-Range": "\"other\""},
+			status: http.StatusOK, body: data, startsAt: -1},
+		{name: "if-range match", headers: map[string]string{"Range": "bytes=10-19", "If-Range": etag},
+			status: http.StatusPartialContent, contentRange: "bytes 10-19/1000", body: data[10:20], startsAt: 10},
+		{name: "if-range old date", headers: map[string]string{"Range": "bytes=10-19", "If-Range": lastModified.Add(-time.Hour).Format(http.TimeFormat)},
+			status: http.StatusOK, body: data, startsAt: -1},
+		{name: "not modified", headers: map[string]string{"If-None-Match": etag}, status: http.StatusNotModified, startsAt: -1},
+		{name: "head", method: http.MethodHead, headers: map[string]string{"Range": "bytes=10-19"}, status: http.StatusPartialContent,
+			contentRange: "bytes 10-19/1000", startsAt: -1},
+	} {
+		method := test.method
+		if method == "" {
+			method = http.MethodGet
+		}
+		r := httptest.NewRequest(method, "/video", nil)
+		for key, value := range test.headers {
+			r.Header.Set(key, value)
+		}
+		w := httptest.NewRecorder()
+		opened := false
+		openStream := func() (dao.FileStream, error) {
+			opened = true
+			return &memStream{Reader: bytes.NewReader(data), data: data}, nil
+		}
+		startsAt := int64(-1)
+
+		written, streamed := serveFileRanges(w, r, file, "video/mp4", lastModified, openStream, func(offset int64) { startsAt = offset })
+		response := w.Result()
+		body, _ := ioutil.ReadAll(response.Body)
+
+		if response.StatusCode != test.status {
+			t.Errorf("%v: expected status %v, got %v", test.name, test.status, response.StatusCode)
+			continue
+		}
+		if contentRange := response.Header.Get("Content-Range"); contentRange != test.contentRange {
+			t.Errorf("%v: expected Content-Range %#v, got %#v", test.name, test.contentRange, contentRange)
+		}
+		if startsAt != test.startsAt {
+			t.Errorf("%v: expected position %v, got %v", test.name, test.startsAt, startsAt)
+		}
+		if response.Header.Get("ETag") != etag || response.Header.Get("Accept-Ranges") != "bytes" {
+			t.Errorf("%v: no ETag or Accept-Ranges in %v", test.name, response.Header)
+		}
+		// тело отправляется только для GET с 200 и 206
+		shouldStream := method == http.MethodGet && (test.status == http.StatusOK || test.status == http.StatusPartialContent)
+		if opened != shouldStream || streamed != shouldStream {
+			t.Errorf("%v: stream opened %v, streamed %v", test.name, opened, streamed)
+		}
+		if streamed && written != int64(len(body)) {
+			t.Errorf("%v: counted %v bytes of %v", test.name, written, len(body))
+		}
+		if length := response.Header.Get("Content-Length"); streamed && length != strconv.Itoa(len(body)) {
+			t.Errorf("%v: Content-Length %v of %v bytes body", test.name, length, len(body))
+		}
+
+		switch {
+		case test.parts != nil:
+			checkMultipartRanges(t, test.name, response, body, data, test.parts)
+		case test.body != nil && !bytes.Equal(body, test.body):
+			t.Errorf("%v: unexpected body of %v bytes", test.name, len(body))
+		case test.body == nil && streamed:
+			t.Errorf("%v: unexpected body %q", test.name, body)
+		}
+		if method == http.MethodHead && len(body) != 0 {
+			t.Errorf("%v: HEAD response has body", test.name)
+		}
+	}
+}
+
+func checkMultipartRanges(t *testing.T, name string, response *http.Response, body []byte, data []byte, parts []model.FileRangeDescription) {
+	mediaType, params, err := mime.ParseMediaType(response.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/byteranges" {
+		t.Errorf("%v: unexpected Content-Type %v", name, response.Header.Get("Content-Type"))
+		return
+	}
+	reader := multipart.NewReader(bytes.NewReader(body), params["boundary"])
+	for _, expected := range parts {
+		part, err := reader.NextPart()
+		if err != nil {
+			t.Errorf("%v: error reading part %v: %v", name, expected, err)
+			return
+		}
+		partBody, _ := ioutil.ReadAll(part)
+		if part.Header.Get("Content-Range") != expected.ContentRange(int64(len(data))) || part.Header.Get("Content-Type") != "video/mp4" {
+			t.Errorf("%v: unexpected part headers %v", name, part.Header)
+		}
+		if !bytes.Equal(partBody, data[expected.Start:expected.End + 1]) {
+			t.Errorf("%v: unexpected part %v body", name, expected)
+		}
+	}
+	if _, err := reader.NextPart(); err != io.EOF {
+		t.Errorf("%v: expected %v parts, got more: %v", name, len(parts), err)
+	}
+}
@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"hypertube_storage/db"
+	"hypertube_storage/demuxer"
+	"hypertube_storage/filesReader"
+	"hypertube_storage/metrics"
+	"hypertube_storage/remuxer"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	remuxRequest     = "mp4"
+	remuxContentType = "video/mp4"
+)
+
+// RemuxedVideoHandler streams matroska video as fragmented mp4 which browsers
+// can play. The position to start from is set by t in seconds and is moved
+// back to the nearest keyframe, the chosen one is returned in X-Start-Time.
+// The audio track is chosen by audio, the default one otherwise
+func RemuxedVideoHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		SendFailResponseWithCode(w, "Incorrect method", http.StatusMethodNotAllowed)
+		return
+	}
+	fileId := mux.Vars(r)["file_id"]
+
+	var position time.Duration
+	if raw := r.URL.Query().Get("t"); raw != "" {
+		seconds, err := strconv.ParseFloat(raw, 64)
+		if err != nil || seconds < 0 {
+			SendFailResponseWithCode(w, fmt.Sprintf("Invalid position %#v", raw), http.StatusBadRequest)
+			return
+		}
+		position = time.Duration(seconds * float64(time.Second))
+	}
+	audioTrack := 0
+	if raw := r.URL.Query().Get("audio"); raw != "" {
+		var err error
+		if audioTrack, err = strconv.Atoi(raw); err != nil || audioTrack <= 0 {
+			SendFailResponseWithCode(w, fmt.Sprintf("Invalid audio track %#v", raw), http.StatusBadRequest)
+			return
+		}
+	}
+
+	info, ok := getStartedFileInfo(w, fileId)
+	if !ok {
+		return
+	}
+	videoFile, err := resolveVideoFile(r, fileId, info)
+	if err != nil {
+		SendFailResponseWithCode(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if contentType := videoContentType(videoFile, info); contentType != "video/x-matroska" && contentType != "video/webm" {
+		SendFailResponseWithCode(w, fmt.Sprintf("Remuxing is available for matroska only, the video is %v", contentType),
+			http.StatusUnsupportedMediaType)
+		return
+	}
+
+	// длительность ответа не ограничиваем, каждое ожидание куска ограничено в потоке
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	stream, err := filesReader.GetManager().OpenStream(ctx, fileId, videoFile, info.IsLoaded)
+	if err != nil {
+		SendFailResponseWithCode(w, fmt.Sprintf("Failed to open %v: %v", videoFile.Name, err), http.StatusInternalServerError)
+		return
+	}
+	defer stream.Close()
+
+	mkv, err := demuxer.OpenMatroska(stream, videoFile.Length)
+	if err != nil {
+		logrus.Errorf("Error reading matroska headers of %v: %v", videoFile.Name, err)
+		SendFailResponseWithCode(w, fmt.Sprintf("Failed to read the video: %v", err), http.StatusUnprocessableEntity)
+		return
+	}
+	remux, err := remuxer.New(mkv, audioTrack)
+	if err != nil {
+		code := http.StatusNotFound
+		if errors.Is(err, remuxer.ErrUnsupportedCodec) {
+			code = http.StatusUnsupportedMediaType
+		}
+		SendFailResponseWithCode(w, err.Error(), code)
+		return
+	}
+	offset, start, err := remux.Seek(position)
+	if err != nil {
+		logrus.Errorf("Error seeking %v of %v to %v: %v", videoFile.Name, fileId, position, err)
+		SendFailResponseWithCode(w, fmt.Sprintf("Failed to seek: %v", err), http.StatusUnprocessableEntity)
+		return
+	}
+
+	// длина ответа неизвестна, перемотка - новым запросом с t
+	w.Header().Set("Content-Type", remuxContentType)
+	w.Header().Set("Accept-Ranges", "none")
+	w.Header().Set("X-Start-Time", strconv.FormatFloat(start.Seconds(), 'f', 3, 64))
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodHead {
+		return
+	}
+	logrus.Debugf("Remuxing %v of %v from %v at %v", videoFile.Name, fileId, start, offset)
+
+	counter := &CountingWriter{Writer: FlushingWriter{ResponseWriter: w}}
+	if err := remux.Stream(ctx, counter, offset); err != nil && ctx.Err() == nil {
+		// заголовки уже ушли, клиент увидит оборванное видео
+		logrus.Errorf("Error remuxing %v of %v: %v", videoFile.Name, fileId, err)
+	}
+	metrics.BytesServed.Add(float64(counter.Written), remuxRequest)
+
+	go db.GetLoadedFilesManager().UpdateLastWatchedDate(fileId)
+}
@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"hypertube_storage/db"
+	"hypertube_storage/demuxer"
+	"hypertube_storage/filesReader"
+	"hypertube_storage/model"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+)
+
+type seekResponse struct {
+	Time   float64 `json:"time"` // секунды, время ключевого кадра
+	Offset int64   `json:"offset"`
+	Exact  bool    `json:"exact"` // false, если в файле нет индекса и смещение оценено
+}
+
+// индекс ключевых кадров лежит в заголовках и не меняется
+var seekIndexCache = newFileCache(seekIndexCacheSize)
+
+func getSeekIndex(ctx context.Context, fileId string, videoFile model.FileInfo, info model.LoadInfo) (*demuxer.SeekIndex, error) {
+	if index, cached := seekIndexCache.get(videoFile.Name); cached {
+		return index.(*demuxer.SeekIndex), nil
+	}
+
+	stream, err := filesReader.GetManager().OpenStream(ctx, fileId, videoFile, info.IsLoaded)
+	if err != nil {
+		return nil, err
+	}
+	defer stream.Close()
+
+	index, err := demuxer.BuildSeekIndex(stream, videoFile.Length)
+	if err != nil {
+		return nil, err
+	}
+	seekIndexCache.put(videoFile.Name, index)
+	return index, nil
+}
+
+// SeekHandler maps the time t in seconds to the offset of the nearest earlier
+// keyframe by matroska Cues or mp4 sample tables. The loader is asked to
+// download from this offset, so the following range request doesn't wait
+func SeekHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		SendFailResponseWithCode(w, "Incorrect method", http.StatusMethodNotAllowed)
+		return
+	}
+	fileId := mux.Vars(r)["file_id"]
+
+	seconds, err := strconv.ParseFloat(r.URL.Query().Get("t"), 64)
+	if err != nil || seconds < 0 {
+		SendFailResponseWithCode(w, fmt.Sprintf("Invalid position %#v", r.URL.Query().Get("t")), http.StatusBadRequest)
+		return
+	}
+	position := time.Duration(seconds * float64(time.Second))
+
+	info, ok := getStartedFileInfo(w, fileId)
+	if !ok {
+		return
+	}
+	videoFile, err := resolveVideoFile(r, fileId, info)
+	if err != nil {
+		SendFailResponseWithCode(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second * 600)
+	defer cancel()
+
+	index, err := getSeekIndex(ctx, fileId, videoFile, info)
+	switch {
+	case errors.Is(err, demuxer.ErrUnsupportedContainer):
+		SendFailResponseWithCode(w, fmt.Sprintf("Seeking is available for matroska and mp4 only, the video is %v",
+			videoContentType(videoFile, info)), http.StatusUnsupportedMediaType)
+		return
+	case err != nil:
+		logrus.Errorf("Error reading keyframes index of %v: %v", videoFile.Name, err)
+		SendFailResponseWithCode(w, fmt.Sprintf("Failed to read keyframes index: %v", err), http.StatusUnprocessableEntity)
+		return
+	}
+
+	point, exact := index.Find(position)
+	if !info.IsLoaded && !db.GetLoadedStateDb().GetCompletedRanges(videoFile.Name).Covers(point.Offset, point.Offset + 1) {
+		db.GetLoadedStateDb().PubPriorityByteIdx(fileId, videoFile.Name, point.Offset)
+	}
+	logrus.Debugf("Seek %v of %v to %v: keyframe %v at %v, exact=%v", videoFile.Name, fileId, position, point.Time, point.Offset, exact)
+
+	SendDataResponse(w, seekResponse{Time: point.Time.Seconds(), Offset: point.Offset, Exact: exact})
+}
@@ -0,0 +1,218 @@
+package handlers
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"hypertube_storage/db"
+	"hypertube_storage/filesReader"
+	"hypertube_storage/model"
+	"hypertube_storage/subtitlesManager"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+)
+
+const maxUserSubtitlesLabel = 64
+
+// загруженные пользователями субтитры лежат в postgres
+var userSubtitlesDb = db.GetUserSubtitlesManager()
+
+// UserSubtitlesHandler lists subtitles uploaded by users for the record (GET)
+// and accepts a new srt, ass or vtt file from the authorized user as multipart
+// form (POST) with fields "file", "language" and "label"
+func UserSubtitlesHandler(w http.ResponseWriter, r *http.Request) {
+	fileId := mux.Vars(r)["file_id"]
+
+	switch r.Method {
+	case http.MethodGet:
+		subs, err := userSubtitlesDb.GetUserSubtitles(fileId)
+		if err != nil {
+			logrus.Errorf("Error getting user subtitles of %v: %v", fileId, err)
+			SendFailResponseWithCode(w, fmt.Sprintf("Failed to get subtitles: %v", err), http.StatusInternalServerError)
+			return
+		}
+		SendDataResponse(w, subs)
+	case http.MethodPost:
+		addUserSubtitles(w, r, fileId)
+	default:
+		SendFailResponseWithCode(w, "Incorrect method", http.StatusMethodNotAllowed)
+	}
+}
+
+func parseUserId(raw string) (uint, error) {
+	userId, err := strconv.ParseUint(raw, 10, 64)
+	if err != nil || userId == 0 {
+		return 0, fmt.Errorf("invalid user id %#v", raw)
+	}
+	return uint(userId), nil
+}
+
+func addUserSubtitles(w http.ResponseWriter, r *http.Request, fileId string) {
+	if _, err := db.GetLoadedFilesManager().GetFileInfoById(fileId); err != nil {
+		SendFailResponseWithCode(w, fmt.Sprintf("File %s not found by id: %s", fileId, err.Error()), http.StatusNotFound)
+		return
+	}
+
+	// запас на поля формы и заголовки multipart
+	maxBody := int64(subtitlesManager.MaxUserSubtitlesSize + 64 << 10)
+	if r.ContentLength > maxBody {
+		SendFailResponseWithCode(w, fmt.Sprintf("Subtitles file is larger than %v bytes", subtitlesManager.MaxUserSubtitlesSize), http.StatusRequestEntityTooLarge)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxBody)
+	if err := r.ParseMultipartForm(maxBody); err != nil {
+		SendFailResponseWithCode(w, fmt.Sprintf("Invalid form: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	userId, err := requestUserId(r)
+	if err != nil {
+		SendFailResponseWithCode(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	language := strings.TrimSpace(r.FormValue("language"))
+	if err := subtitlesManager.ValidateLanguage(language); err != nil {
+		SendFailResponseWithCode(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	label := strings.TrimSpace(r.FormValue("label"))
+	if utf8.RuneCountInString(label) > maxUserSubtitlesLabel {
+		SendFailResponseWithCode(w, fmt.Sprintf("Label is longer than %v characters", maxUserSubtitlesLabel), http.StatusBadRequest)
+		return
+	}
+
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		SendFailResponseWithCode(w, fmt.Sprintf("No subtitles file in form: %v", err), http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+	data, err := io.ReadAll(io.LimitReader(file, subtitlesManager.MaxUserSubtitlesSize + 1))
+	if err != nil {
+		SendFailResponseWithCode(w, fmt.Sprintf("Failed to read subtitles file: %v", err), http.StatusBadRequest)
+		return
+	}
+	if len(data) > subtitlesManager.MaxUserSubtitlesSize {
+		SendFailResponseWithCode(w, fmt.Sprintf("Subtitles file is larger than %v bytes", subtitlesManager.MaxUserSubtitlesSize), http.StatusRequestEntityTooLarge)
+		return
+	}
+	format, err := subtitlesManager.GetManager().ValidateUserSubtitles(data)
+	if err != nil {
+		SendFailResponseWithCode(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	subtitlesId, err := subtitlesManager.NewUserSubtitlesId()
+	if err != nil {
+		SendFailResponseWithCode(w, fmt.Sprintf("Failed to generate subtitles id: %v", err), http.StatusInternalServerError)
+		return
+	}
+	subs := model.UserSubtitles{
+		Id:         subtitlesId,
+		FileId:     fileId,
+		Language:   language,
+		Label:      label,
+		Format:     format,
+		UploaderId: userId,
+		Size:       int64(len(data)),
+	}
+	if err := filesReader.GetManager().WriteFile(subtitlesId, data); err != nil {
+		SendFailResponseWithCode(w, fmt.Sprintf("Failed to save subtitles: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if err := userSubtitlesDb.AddUserSubtitles(subs); err != nil {
+		logrus.Errorf("Error saving user subtitles %v of %v: %v", subtitlesId, fileId, err)
+		filesReader.GetManager().RemoveFile(subtitlesId)
+		SendFailResponseWithCode(w, fmt.Sprintf("Failed to save subtitles: %v", err), http.StatusInternalServerError)
+		return
+	}
+	logrus.Infof("User %v uploaded %v subtitles %v for %v", userId, format, subtitlesId, fileId)
+
+	if saved, err := userSubtitlesDb.GetUserSubtitlesById(fileId, subtitlesId); err == nil {
+		subs = saved
+	}
+	SendDataResponse(w, subs)
+}
+
+// DeleteUserSubtitlesHandler removes uploaded subtitles, only the uploader can
+// delete them
+func DeleteUserSubtitlesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		SendFailResponseWithCode(w, "Incorrect method", http.StatusMethodNotAllowed)
+		return
+	}
+	fileId := mux.Vars(r)["file_id"]
+	subtitlesId := mux.Vars(r)["subtitles_id"]
+
+	userId, err := requestUserId(r)
+	if err != nil {
+		SendFailResponseWithCode(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	subs, ok := getUserSubtitles(w, fileId, subtitlesId)
+	if !ok {
+		return
+	}
+	if subs.UploaderId != userId {
+		SendFailResponseWithCode(w, "Subtitles can be deleted only by the uploader", http.StatusForbidden)
+		return
+	}
+
+	if err := userSubtitlesDb.DeleteUserSubtitles(fileId, subtitlesId); err != nil {
+		logrus.Errorf("Error deleting user subtitles %v of %v: %v", subtitlesId, fileId, err)
+		SendFailResponseWithCode(w, fmt.Sprintf("Failed to delete subtitles: %v", err), http.StatusInternalServerError)
+		return
+	}
+	filesReader.GetManager().RemoveFile(subtitlesId)
+	subtitlesManager.GetManager().DropCachedVtt(subtitlesId)
+	SendDataResponse(w, subs)
+}
+
+func getUserSubtitles(w http.ResponseWriter, fileId, subtitlesId string) (model.UserSubtitles, bool) {
+	subs, err := userSubtitlesDb.GetUserSubtitlesById(fileId, subtitlesId)
+	if err == sql.ErrNoRows {
+		SendFailResponseWithCode(w, fmt.Sprintf("Subtitles %v not found in %v", subtitlesId, fileId), http.StatusNotFound)
+		return subs, false
+	}
+	if err != nil {
+		logrus.Errorf("Error getting user subtitles %v of %v: %v", subtitlesId, fileId, err)
+		SendFailResponseWithCode(w, fmt.Sprintf("Failed to get subtitles: %v", err), http.StatusInternalServerError)
+		return subs, false
+	}
+	return subs, true
+}
+
+// uploadUserSubtitles serves uploaded subtitles the same way as the torrent ones,
+// the file is already complete so there is nothing to wait for
+func uploadUserSubtitles(w http.ResponseWriter, fileId, subtitlesId string, timing subtitlesManager.Timing) {
+	if _, ok := getUserSubtitles(w, fileId, subtitlesId); !ok {
+		return
+	}
+
+	var vtt bytes.Buffer
+	if cached, _ := subtitlesManager.GetManager().GetCachedVtt(subtitlesId, timing, &vtt); !cached {
+		file, err := filesReader.GetManager().OpenFile(subtitlesId)
+		if err != nil {
+			SendFailResponseWithCode(w, fmt.Sprintf("Failed to open subtitles: %v", err), http.StatusInternalServerError)
+			return
+		}
+		data, err := io.ReadAll(file)
+		_ = file.Close()
+		if err != nil {
+			SendFailResponseWithCode(w, fmt.Sprintf("Failed to read subtitles: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if err := subtitlesManager.GetManager().ConvertFileToVtt(subtitlesId, data, timing, &vtt); err != nil {
+			SendFailResponseWithCode(w, fmt.Sprintf("Failed to convert subtitles to vtt: %v", err.Error()), http.StatusInternalServerError)
+			return
+		}
+	}
+	writeVttResponse(w, fileId, &vtt)
+}
@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"hypertube_storage/model"
+	"hypertube_storage/subtitlesManager"
+
+	"github.com/gorilla/mux"
+)
+
+type testUserSubtitlesDb struct {
+	subs    map[string]model.UserSubtitles
+	deleted []string
+}
+
+func (d *testUserSubtitlesDb) AddUserSubtitles(subs model.UserSubtitles) error {
+	d.subs[subs.Id] = subs
+	return nil
+}
+
+func (d *testUserSubtitlesDb) GetUserSubtitles(fileId string) (result []model.UserSubtitles, err error) {
+	for _, subs := range d.subs {
+		if subs.FileId == fileId {
+			result = append(result, subs)
+		}
+	}
+	return result, nil
+}
+
+func (d *testUserSubtitlesDb) GetUserSubtitlesById(fileId, subtitlesId string) (model.UserSubtitles, error) {
+	subs, ok := d.subs[subtitlesId]
+	if !ok || subs.FileId != fileId {
+		return model.UserSubtitles{}, sql.ErrNoRows
+	}
+	return subs, nil
+}
+
+func (d *testUserSubtitlesDb) DeleteUserSubtitles(fileId, subtitlesId string) error {
+	delete(d.subs, subtitlesId)
+	d.deleted = append(d.deleted, subtitlesId)
+	return nil
+}
+
+func deleteUserSubtitles(fileId, subtitlesId string, userId uint) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodDelete, "/subtitles/" + fileId + "/user/" + subtitlesId, nil)
+	r = r.WithContext(context.WithValue(r.Context(), userIdKey{}, userId))
+	r = mux.SetURLVars(r, map[string]string{"file_id": fileId, "subtitles_id": subtitlesId})
+	w := httptest.NewRecorder()
+	DeleteUserSubtitlesHandler(w, r)
+	return w
+}
+
+func TestDeleteUserSubtitlesOnlyByUploader(t *testing.T) {
+	subtitlesId, err := subtitlesManager.NewUserSubtitlesId()
+	if err != nil {
+		t.Fatal(err)
+	}
+	testDb := &testUserSubtitlesDb{subs: map[string]model.UserSubtitles{
+		subtitlesId: {Id: subtitlesId, FileId: "file-id", Language: "ru", Format: subtitlesManager.FormatSrt, UploaderId: 1},
+	}}
+	userSubtitlesDb = testDb
+
+	if w := deleteUserSubtitles("file-id", subtitlesId, 2); w.Code != http.StatusForbidden {
+		t.Errorf("Another user got %v: %v", w.Code, w.Body)
+	}
+	if len(testDb.deleted) != 0 {
+		t.Fatalf("Subtitles are deleted by another user: %v", testDb.deleted)
+	}
+
+	// субтитры другой записи не находятся по чужому file_id
+	if w := deleteUserSubtitles("other-file-id", subtitlesId, 1); w.Code != http.StatusNotFound {
+		t.Errorf("Subtitles of another record got %v: %v", w.Code, w.Body)
+	}
+
+	if w := deleteUserSubtitles("file-id", subtitlesId, 1); w.Code != http.StatusOK {
+		t.Errorf("Uploader got %v: %v", w.Code, w.Body)
+	}
+	if len(testDb.deleted) != 1 || testDb.deleted[0] != subtitlesId {
+		t.Errorf("Subtitles are not deleted by the uploader: %v", testDb.deleted)
+	}
+
+	if w := deleteUserSubtitles("file-id", subtitlesId, 1); w.Code != http.StatusNotFound {
+		t.Errorf("Deleted subtitles got %v: %v", w.Code, w.Body)
+	}
+}
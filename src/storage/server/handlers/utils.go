@@ -5,7 +5,10 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"strconv"
+	"time"
 
+	"hypertube_storage/metrics"
 	"hypertube_storage/model"
 	"hypertube_storage/parser/env"
 
@@ -28,6 +31,22 @@ func SendFailResponseWithCode(w http.ResponseWriter, text string, code int) {
 	}
 }
 
+func SendDataResponse(w http.ResponseWriter, data interface{}) {
+	var packet []byte
+	var err error
+
+	response := &model.DataResponse{Status: true, Data: data}
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	if packet, err = json.Marshal(response); err != nil {
+		logrus.Error("Error marshalling response: ", err)
+	}
+	if _, err = w.Write(packet); err != nil {
+		logrus.Error("Error sending response: ", err)
+	}
+}
+
 func GetContentTypeForReqType(reqType string) string {
 	switch reqType {
 	case videoRequest:
@@ -78,4 +97,112 @@ func SendTaskToTorrentClient(fileId string) bool {
 	return true
 }
 
+// список файлов торрента не меняется, незачем каждый range запрос ходить в лоадер
+var torrentFilesCache = newLruCache(torrentFilesCacheSize)
+
+func GetTorrentFilesFromLoader(fileId string) ([]model.TorrentFileEntry, error) {
+	if files, cached := torrentFilesCache.get(fileId); cached {
+		return files.([]model.TorrentFileEntry), nil
+	}
+
+	req, err := http.Get(fmt.Sprintf("http://%s/files/%s", env.GetParser().GetLoaderServiceHost(), fileId))
+	if err != nil {
+		return nil, fmt.Errorf("error calling loader service: %v", err)
+	}
+	defer req.Body.Close()
+
+	if req.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("not ok status from torrent client: %v", req.Status)
+	}
+
+	info := model.LoaderFilesResponse{}
+	if err := json.NewDecoder(req.Body).Decode(&info); err != nil {
+		return nil, fmt.Errorf("error unmarshal body from loader: %v", err)
+	}
+
+	torrentFilesCache.put(fileId, info.Data.Files)
+	return info.Data.Files, nil
+}
+
+func GetTorrentFileByIndex(fileId string, fileIndex int) (model.FileInfo, error) {
+	files, err := GetTorrentFilesFromLoader(fileId)
+	if err != nil {
+		return model.FileInfo{}, err
+	}
+	if fileIndex < 0 || fileIndex >= len(files) {
+		return model.FileInfo{}, fmt.Errorf("file index %v out of range", fileIndex)
+	}
+	return files[fileIndex].ToFileInfo(), nil
+}
+
+type StatusRecorder struct {
+	http.ResponseWriter
+	Status int
+}
+
+func NewStatusRecorder(w http.ResponseWriter) *StatusRecorder {
+	return &StatusRecorder{ResponseWriter: w, Status: http.StatusOK}
+}
+
+func (r *StatusRecorder) WriteHeader(code int) {
+	r.Status = code
+	r.ResponseWriter.WriteHeader(code)
+}
+
+// ReadFrom passes the file to the response, which sends it with sendfile
+func (r *StatusRecorder) ReadFrom(src io.Reader) (int64, error) {
+	if readerFrom, ok := r.ResponseWriter.(io.ReaderFrom); ok {
+		return readerFrom.ReadFrom(src)
+	}
+	return io.Copy(struct{ io.Writer }{r.ResponseWriter}, src)
+}
+
+func (r *StatusRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func ObserveRangeRequest(w http.ResponseWriter, start time.Time) {
+	status := http.StatusOK
+	if recorder, ok := w.(*StatusRecorder); ok {
+		status = recorder.Status
+	}
+	metrics.RangeRequestSeconds.Observe(time.Since(start).Seconds(), strconv.Itoa(status))
+}
+
+type CountingWriter struct {
+	io.Writer
+	Written int64
+}
+
+func (c *CountingWriter) Write(p []byte) (int, error) {
+	n, err := c.Writer.Write(p)
+	c.Written += int64(n)
+	return n, err
+}
+
+// ReadFrom keeps io.ReaderFrom of the response, so copying from a file is done
+// with sendfile
+func (c *CountingWriter) ReadFrom(r io.Reader) (int64, error) {
+	if readerFrom, ok := c.Writer.(io.ReaderFrom); ok {
+		n, err := readerFrom.ReadFrom(r)
+		c.Written += n
+		return n, err
+	}
+	return io.Copy(struct{ io.Writer }{c}, r)
+}
 
+// FlushingWriter sends every write to the client at once, for responses which
+// are generated while the file downloads
+type FlushingWriter struct {
+	http.ResponseWriter
+}
+
+func (f FlushingWriter) Write(p []byte) (int, error) {
+	n, err := f.ResponseWriter.Write(p)
+	if flusher, ok := f.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	return n, err
+}
@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type readerFromResponse struct {
+	*httptest.ResponseRecorder
+	readFromCalls int
+}
+
+func (r *readerFromResponse) ReadFrom(src io.Reader) (int64, error) {
+	r.readFromCalls++
+	return io.Copy(r.ResponseRecorder, src)
+}
+
+func TestStatusRecorderKeepsReadFrom(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "video")
+	if err := os.WriteFile(path, []byte("0123456789"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	response := &readerFromResponse{ResponseRecorder: httptest.NewRecorder()}
+	recorder := NewStatusRecorder(response)
+	recorder.WriteHeader(http.StatusPartialContent)
+	counter := &CountingWriter{Writer: recorder}
+
+	// так файл копирует filesReader, буфер нужен только без ReadFrom
+	n, err := io.CopyBuffer(counter, &io.LimitedReader{R: file, N: 6}, make([]byte, 4))
+	if err != nil || n != 6 {
+		t.Fatalf("Copied %v bytes: %v", n, err)
+	}
+	if response.readFromCalls != 1 {
+		t.Errorf("ReadFrom of the response called %v times", response.readFromCalls)
+	}
+	if counter.Written != 6 || response.Body.String() != "012345" || recorder.Status != http.StatusPartialContent {
+		t.Errorf("Written %v, body %q, status %v", counter.Written, response.Body.String(), recorder.Status)
+	}
+
+	recorder.Flush()
+	if !response.Flushed {
+		t.Errorf("Flush is not passed to the response")
+	}
+}
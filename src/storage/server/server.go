@@ -3,6 +3,7 @@ package server
 import (
 	"net/http"
 
+	"hypertube_storage/metrics"
 	"hypertube_storage/server/handlers"
 
 	"github.com/gorilla/mux"
@@ -12,9 +13,38 @@ import (
 func Start() {
 	router := mux.NewRouter()
 
+	// все, что отдает содержимое записи или запускает ее загрузку, только для
+	// авторизованных пользователей
+	load := router.PathPrefix("/load/{file_id}").Subrouter()
+	load.Use(handlers.AuthMiddleware)
+
 	//router.HandleFunc("/load/{file_id}", handlers.UploadFilePartHandler)
-	router.HandleFunc("/load/{file_id}/video", handlers.UploadFilePartHandler)
-	router.HandleFunc("/load/{file_id}/subtitles/{subtitles_id}", handlers.UploadSubtitlesFileHandler)
+	load.HandleFunc("/video", handlers.UploadFilePartHandler)
+	load.HandleFunc("/video/{file_index:[0-9]+}", handlers.UploadFilePartHandler)
+	load.HandleFunc("/video.mp4", handlers.RemuxedVideoHandler)
+	load.HandleFunc("/video/{file_index:[0-9]+}/video.mp4", handlers.RemuxedVideoHandler)
+	load.HandleFunc("/seek", handlers.SeekHandler)
+	load.HandleFunc("/video/{file_index:[0-9]+}/seek", handlers.SeekHandler)
+	load.HandleFunc("/info", handlers.VideoInfoHandler)
+	load.HandleFunc("/video/{file_index:[0-9]+}/info", handlers.VideoInfoHandler)
+	load.HandleFunc("/hls/master.m3u8", handlers.HlsMasterPlaylistHandler)
+	load.HandleFunc("/hls/index.m3u8", handlers.HlsMediaPlaylistHandler)
+	load.HandleFunc("/hls/{segment:[0-9]+}.ts", handlers.HlsSegmentHandler)
+	load.HandleFunc("/video/{file_index:[0-9]+}/hls/master.m3u8", handlers.HlsMasterPlaylistHandler)
+	load.HandleFunc("/video/{file_index:[0-9]+}/hls/index.m3u8", handlers.HlsMediaPlaylistHandler)
+	load.HandleFunc("/video/{file_index:[0-9]+}/hls/{segment:[0-9]+}.ts", handlers.HlsSegmentHandler)
+	load.HandleFunc("/subtitles", handlers.EmbeddedSubtitlesHandler)
+	load.HandleFunc("/video/{file_index:[0-9]+}/subtitles", handlers.EmbeddedSubtitlesHandler)
+	load.HandleFunc("/subtitles/{subtitles_id}", handlers.UploadSubtitlesFileHandler)
+	load.HandleFunc("/user_subtitles", handlers.UserSubtitlesHandler)
+	load.HandleFunc("/user_subtitles/{subtitles_id}", handlers.DeleteUserSubtitlesHandler)
+	load.HandleFunc("/sign", handlers.SignUrlHandler)
+	progress := router.PathPrefix("/progress").Subrouter()
+	progress.Use(handlers.AuthMiddleware)
+	progress.HandleFunc("", handlers.RecentProgressHandler)
+	progress.HandleFunc("/{file_id}", handlers.ProgressHandler)
+	router.HandleFunc("/admin/cache", handlers.RangeCacheStatsHandler)
+	router.Handle("/metrics", metrics.Handler())
 	router.PathPrefix("/").HandlerFunc(handlers.CatchAllHandler)
 
 	logrus.Info("Listening localhost:2222")
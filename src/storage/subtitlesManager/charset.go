@@ -0,0 +1,164 @@
+package subtitlesManager
+
+import (
+	"bytes"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+const (
+	CharsetUtf8    = "utf-8"
+	CharsetUtf16LE = "utf-16le"
+	CharsetUtf16BE = "utf-16be"
+	CharsetCp1251  = "windows-1251"
+	CharsetKoi8r   = "koi8-r"
+	CharsetCp1252  = "windows-1252"
+)
+
+// single byte code pages, runes of bytes 0x80-0xFF
+var (
+	cp1251Table = []rune("ЂЃ‚ѓ„…†‡€‰Љ‹ЊЌЋЏ" +
+		"ђ‘’“”•–—\uFFFD™љ›њќћџ" +
+		"\u00A0ЎўЈ¤Ґ¦§Ё©Є«¬\u00AD®Ї" +
+		"°±Ііґµ¶·ё№є»јЅѕї" +
+		"АБВГДЕЖЗИЙКЛМНОП" +
+		"РСТУФХЦЧШЩЪЫЬЭЮЯ" +
+		"абвгдежзийклмноп" +
+		"рстуфхцчшщъыьэюя")
+	koi8rTable = []rune("─│┌┐└┘├┤┬┴┼▀▄█▌▐" +
+		"░▒▓⌠■∙√≈≤≥\u00A0⌡°²·÷" +
+		"═║╒ё╓╔╕╖╗╘╙╚╛╜╝╞" +
+		"╟╠╡Ё╢╣╤╥╦╧╨╩╪╫╬©" +
+		"юабцдефгхийклмно" +
+		"пярстужвьызшэщчъ" +
+		"ЮАБЦДЕФГХИЙКЛМНО" +
+		"ПЯРСТУЖВЬЫЗШЭЩЧЪ")
+	cp1252Table = []rune("€\uFFFD‚ƒ„…†‡ˆ‰Š‹Œ\uFFFDŽ\uFFFD" +
+		"\uFFFD‘’“”•–—˜™š›œ\uFFFDžŸ" +
+		"\u00A0¡¢£¤¥¦§¨©ª«¬\u00AD®¯" +
+		"°±²³´µ¶·¸¹º»¼½¾¿" +
+		"ÀÁÂÃÄÅÆÇÈÉÊËÌÍÎÏ" +
+		"ÐÑÒÓÔÕÖ×ØÙÚÛÜÝÞß" +
+		"àáâãäåæçèéêëìíîï" +
+		"ðñòóôõö÷øùúûüýþÿ")
+)
+
+// DetectCharset guesses the encoding of subtitles: BOM, zero bytes of UTF-16,
+// valid UTF-8, otherwise one of russian single byte code pages or latin cp1252
+func DetectCharset(data []byte) string {
+	switch {
+	case bytes.HasPrefix(data, []byte{0xEF, 0xBB, 0xBF}):
+		return CharsetUtf8
+	case bytes.HasPrefix(data, []byte{0xFF, 0xFE}):
+		return CharsetUtf16LE
+	case bytes.HasPrefix(data, []byte{0xFE, 0xFF}):
+		return CharsetUtf16BE
+	}
+
+	// без BOM: в UTF-16 у ascii символов старший байт нулевой
+	sample := data
+	if len(sample) > 4096 {
+		sample = sample[:4096]
+	}
+	evenZeros, oddZeros := 0, 0
+	for i, b := range sample {
+		if b == 0 {
+			if i % 2 == 0 {
+				evenZeros++
+			} else {
+				oddZeros++
+			}
+		}
+	}
+	if half := len(sample) / 2; half > 0 {
+		if oddZeros > half / 4 && evenZeros < oddZeros / 8 {
+			return CharsetUtf16LE
+		}
+		if evenZeros > half / 4 && oddZeros < evenZeros / 8 {
+			return CharsetUtf16BE
+		}
+	}
+
+	if utf8.Valid(data) {
+		return CharsetUtf8
+	}
+	return detectSingleByteCharset(data)
+}
+
+// detectSingleByteCharset compares letters of the text: cyrillic subtitles are
+// almost all high bytes, in cp1251 lowercase letters are 0xE0-0xFF while in
+// KOI8-R these are uppercase
+func detectSingleByteCharset(data []byte) string {
+	latin, upperHalf, lowerHalf := 0, 0, 0
+	for _, b := range data {
+		switch {
+		case (b >= 'a' && b <= 'z') || (b >= 'A' && b <= 'Z'):
+			latin++
+		case b >= 0xE0:
+			upperHalf++
+		case b >= 0xC0:
+			lowerHalf++
+		}
+	}
+	if upperHalf + lowerHalf <= latin {
+		return CharsetCp1252
+	}
+	if upperHalf >= lowerHalf {
+		return CharsetCp1251
+	}
+	return CharsetKoi8r
+}
+
+// ToUtf8 converts subtitles to UTF-8 without BOM, the detected charset is
+// returned for logging
+func ToUtf8(data []byte) ([]byte, string) {
+	charset := DetectCharset(data)
+	switch charset {
+	case CharsetUtf8:
+		return bytes.TrimPrefix(data, []byte{0xEF, 0xBB, 0xBF}), charset
+	case CharsetUtf16LE, CharsetUtf16BE:
+		return decodeUtf16(data, charset == CharsetUtf16BE), charset
+	case CharsetCp1251:
+		return decodeSingleByte(data, cp1251Table), charset
+	case CharsetKoi8r:
+		return decodeSingleByte(data, koi8rTable), charset
+	default:
+		return decodeSingleByte(data, cp1252Table), charset
+	}
+}
+
+func decodeUtf16(data []byte, bigEndian bool) []byte {
+	units := make([]uint16, 0, len(data) / 2)
+	for i := 0; i + 1 < len(data); i += 2 {
+		if bigEndian {
+			units = append(units, uint16(data[i]) << 8 | uint16(data[i + 1]))
+		} else {
+			units = append(units, uint16(data[i + 1]) << 8 | uint16(data[i]))
+		}
+	}
+	if len(units) > 0 && units[0] == 0xFEFF {
+		units = units[1:]
+	}
+
+	result := make([]byte, 0, len(units) * 2)
+	buf := make([]byte, utf8.UTFMax)
+	for _, r := range utf16.Decode(units) {
+		n := utf8.EncodeRune(buf, r)
+		result = append(result, buf[:n]...)
+	}
+	return result
+}
+
+func decodeSingleByte(data []byte, table []rune) []byte {
+	result := make([]byte, 0, len(data) * 2)
+	buf := make([]byte, utf8.UTFMax)
+	for _, b := range data {
+		if b < 0x80 {
+			result = append(result, b)
+			continue
+		}
+		n := utf8.EncodeRune(buf, table[b - 0x80])
+		result = append(result, buf[:n]...)
+	}
+	return result
+}
@@ -0,0 +1,86 @@
+package subtitlesManager
+
+import (
+	"testing"
+	"unicode/utf16"
+)
+
+const (
+	testRussian = "1\n00:00:01,000 --> 00:00:02,000\nПривет, как дела? Это тестовые субтитры.\n"
+	testLatin   = "1\n00:00:01,000 --> 00:00:02,000\nCafé, déjà vu, naïve garçon.\n"
+)
+
+// encodeSingleByte is the reverse of decodeSingleByte
+func encodeSingleByte(t *testing.T, text string, table []rune) []byte {
+	var result []byte
+	for _, r := range text {
+		if r < 0x80 {
+			result = append(result, byte(r))
+			continue
+		}
+		found := false
+		for idx, tableRune := range table {
+			if tableRune == r {
+				result, found = append(result, byte(0x80 + idx)), true
+				break
+			}
+		}
+		if !found {
+			t.Fatalf("No %q in the code page", r)
+		}
+	}
+	return result
+}
+
+func encodeUtf16(text string, bigEndian bool, bom bool) []byte {
+	units := utf16.Encode([]rune(text))
+	if bom {
+		units = append([]uint16{0xFEFF}, units...)
+	}
+	result := make([]byte, 0, len(units) * 2)
+	for _, unit := range units {
+		if bigEndian {
+			result = append(result, byte(unit >> 8), byte(unit))
+		} else {
+			result = append(result, byte(unit), byte(unit >> 8))
+		}
+	}
+	return result
+}
+
+func TestToUtf8(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		data    []byte
+		charset string
+		text    string
+	}{
+		{"utf-8", []byte(testRussian), CharsetUtf8, testRussian},
+		{"utf-8 bom", append([]byte{0xEF, 0xBB, 0xBF}, testRussian...), CharsetUtf8, testRussian},
+		{"utf-16le bom", encodeUtf16(testRussian, false, true), CharsetUtf16LE, testRussian},
+		{"utf-16be bom", encodeUtf16(testRussian, true, true), CharsetUtf16BE, testRussian},
+		{"utf-16le", encodeUtf16(testLatin, false, false), CharsetUtf16LE, testLatin},
+		{"utf-16be", encodeUtf16(testLatin, true, false), CharsetUtf16BE, testLatin},
+		{"windows-1251", encodeSingleByte(t, testRussian, cp1251Table), CharsetCp1251, testRussian},
+		{"koi8-r", encodeSingleByte(t, testRussian, koi8rTable), CharsetKoi8r, testRussian},
+		{"windows-1252", encodeSingleByte(t, testLatin, cp1252Table), CharsetCp1252, testLatin},
+	} {
+		data, charset := ToUtf8(test.data)
+		if charset != test.charset {
+			t.Errorf("%v: detected %v", test.name, charset)
+			continue
+		}
+		if string(data) != test.text {
+			t.Errorf("%v: unexpected text %q", test.name, data)
+		}
+	}
+}
+
+func TestDetectCharsetShortData(t *testing.T) {
+	if charset := DetectCharset(nil); charset != CharsetUtf8 {
+		t.Errorf("Empty data detected as %v", charset)
+	}
+	if charset := DetectCharset([]byte{0xE0}); charset != CharsetCp1251 {
+		t.Errorf("Single cyrillic byte detected as %v", charset)
+	}
+}
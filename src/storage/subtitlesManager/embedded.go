@@ -0,0 +1,138 @@
+package subtitlesManager
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"sync"
+
+	"hypertube_storage/demuxer"
+	"hypertube_storage/filesReader"
+	"hypertube_storage/model"
+)
+
+// embedded subtitles id is "<video file name>.<track id>", sidecar files ids
+// are plain md5 names without dot
+var embeddedIdPattern = regexp.MustCompile(`^([0-9a-f]{32})\.(\d+)$`)
+
+const (
+	SourceEmbedded = "embedded"
+	SourceExternal = "external"
+)
+
+// EmbeddedSubtitles is an item of the subtitles listing, external provider results
+// are listed the same way with Source "external" and without track fields
+type EmbeddedSubtitles struct {
+	Id       string `json:"id"`
+	Source   string `json:"source"`
+	Provider string `json:"provider,omitempty"`
+	TrackId  int    `json:"trackId"`
+	Codec    string `json:"codec"`
+	Format   string `json:"format,omitempty"`
+	Language string `json:"language,omitempty"`
+	Name     string `json:"name,omitempty"`
+	Default  bool   `json:"default"`
+	Forced   bool   `json:"forced"`
+}
+
+// скачанный файл не меняется, поэтому кешируем списки дорожек, полностью
+// извлеченные субтитры лежат в vttCache
+var embeddedCache = struct {
+	sync.Mutex
+	tracks map[string][]demuxer.Track
+}{tracks: make(map[string][]demuxer.Track)}
+
+func ParseEmbeddedSubtitlesId(subtitlesId string) (videoFileName string, trackId int, ok bool) {
+	match := embeddedIdPattern.FindStringSubmatch(subtitlesId)
+	if match == nil {
+		return "", 0, false
+	}
+	trackId, err := strconv.Atoi(match[2])
+	return match[1], trackId, err == nil
+}
+
+// GetEmbeddedSubtitles lists text tracks of mkv or mp4 video, the loader is asked
+// to prioritize pieces with container headers when they are not downloaded yet
+func (m *SubtitlesManager) GetEmbeddedSubtitles(ctx context.Context, fileId string, video model.FileInfo, isLoaded bool) ([]EmbeddedSubtitles, error) {
+	tracks, err := m.getEmbeddedTracks(ctx, fileId, video, isLoaded)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]EmbeddedSubtitles, 0, len(tracks))
+	for _, track := range tracks {
+		result = append(result, EmbeddedSubtitles{
+			Id:       fmt.Sprintf("%s.%d", video.Name, track.Id),
+			Source:   SourceEmbedded,
+			TrackId:  track.Id,
+			Codec:    track.Codec,
+			Format:   track.Format,
+			Language: track.Language,
+			Name:     track.Name,
+			Default:  track.Default,
+			Forced:   track.Forced,
+		})
+	}
+	return result, nil
+}
+
+func (m *SubtitlesManager) getEmbeddedTracks(ctx context.Context, fileId string, video model.FileInfo, isLoaded bool) ([]demuxer.Track, error) {
+	embeddedCache.Lock()
+	tracks, cached := embeddedCache.tracks[video.Name]
+	embeddedCache.Unlock()
+	if cached {
+		return tracks, nil
+	}
+
+	stream, err := filesReader.GetManager().OpenStream(ctx, fileId, video, isLoaded)
+	if err != nil {
+		return nil, err
+	}
+	defer stream.Close()
+	if tracks, err = demuxer.ListSubtitleTracks(stream, video.Length); err != nil {
+		return nil, err
+	}
+
+	embeddedCache.Lock()
+	embeddedCache.tracks[video.Name] = tracks
+	embeddedCache.Unlock()
+	return tracks, nil
+}
+
+// ConvertEmbeddedToVtt extracts the track from downloaded parts of the video,
+// complete is false when some cues are in pieces which are not loaded yet
+func (m *SubtitlesManager) ConvertEmbeddedToVtt(ctx context.Context, fileId string, video model.FileInfo, trackId int, isLoaded bool, timing Timing, dest io.Writer) (complete bool, err error) {
+	cacheKey := fmt.Sprintf("%s.%d", video.Name, trackId)
+	if cached, err := m.GetCachedVtt(cacheKey, timing, dest); cached {
+		return true, err
+	}
+
+	stream, err := filesReader.GetManager().OpenStream(ctx, fileId, video, isLoaded)
+	if err != nil {
+		return false, err
+	}
+	defer stream.Close()
+	subs, err := demuxer.ExtractSubtitles(stream, video.Length, trackId)
+	if err != nil {
+		return false, err
+	}
+	if len(subs.Cues) == 0 {
+		if subs.Complete {
+			return true, fmt.Errorf("subtitles track %v is empty", trackId)
+		}
+		return false, fmt.Errorf("subtitles track %v is not downloaded yet", trackId)
+	}
+
+	var buf bytes.Buffer
+	if err := m.ConvertToVtt(subs.Document(), subs.Track.Format, &buf); err != nil {
+		return false, err
+	}
+	if subs.Complete {
+		m.cacheVtt(cacheKey, buf.Bytes())
+	}
+	_, err = dest.Write(timing.applyToVtt(buf.Bytes()))
+	return subs.Complete, err
+}
@@ -0,0 +1,47 @@
+package subtitlesManager
+
+import (
+	"bytes"
+	"regexp"
+)
+
+const (
+	FormatSrt      = "srt"
+	FormatSsa      = "ssa"
+	FormatAss      = "ass"
+	FormatVtt      = "vtt"
+	FormatTtml     = "ttml"
+	FormatDfxp     = "dfxp"
+	FormatStl      = "stl"
+	FormatMicroDVD = "sub"
+)
+
+var microDVDLine = regexp.MustCompile(`^\{\d+\}\{\d*\}`)
+
+// DetectFormat sniffs subtitles format by content, the extension of a file from
+// a torrent is not reliable (.sub and .txt may contain anything)
+func DetectFormat(data []byte) string {
+	data = bytes.TrimPrefix(data, []byte{0xEF, 0xBB, 0xBF})
+
+	// EBU STL: двоичный заголовок GSI с кодом формата на 3-10 байтах
+	if len(data) >= 1024 && (bytes.Equal(data[3:11], []byte("STL25.01")) || bytes.Equal(data[3:11], []byte("STL30.01"))) {
+		return FormatStl
+	}
+
+	head := bytes.TrimSpace(data)
+	if len(head) > 4096 {
+		head = head[:4096]
+	}
+	switch {
+	case bytes.HasPrefix(head, []byte("WEBVTT")):
+		return FormatVtt
+	case bytes.Contains(head, []byte("[Script Info]")) || bytes.Contains(head, []byte("[V4+ Styles]")) ||
+		bytes.Contains(head, []byte("[V4 Styles]")) || bytes.Contains(head, []byte("\nDialogue:")):
+		return FormatSsa
+	case bytes.HasPrefix(head, []byte("<?xml")) || bytes.HasPrefix(head, []byte("<tt")):
+		return FormatTtml
+	case microDVDLine.Match(head):
+		return FormatMicroDVD
+	}
+	return FormatSrt
+}
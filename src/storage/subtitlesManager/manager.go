@@ -1,7 +1,10 @@
 package subtitlesManager
 
 import (
+	"bytes"
+	"fmt"
 	"io"
+	"sync"
 
 	subtitles "github.com/asticode/go-astisub"
 	"github.com/sirupsen/logrus"
@@ -10,18 +13,94 @@ import (
 type SubtitlesManager struct {
 }
 
+// сконвертированные без сдвига времени субтитры по имени файла (или дорожки),
+// сдвиг применяется к копии на каждый запрос. Попадают сюда только полностью
+// скачанные
+var vttCache = struct {
+	sync.Mutex
+	items map[string][]byte
+}{items: make(map[string][]byte)}
+
 func GetManager() *SubtitlesManager {
 	return &SubtitlesManager{}
 }
 
-func (m *SubtitlesManager) ConvertSrtToVtt(src io.Reader, dest io.Writer) error {
-	srt, err := subtitles.ReadFromSRT(src)
+// GetCachedVtt writes subtitles of the file converted earlier, shifted by timing
+func (m *SubtitlesManager) GetCachedVtt(fileName string, timing Timing, dest io.Writer) (bool, error) {
+	vttCache.Lock()
+	vtt, cached := vttCache.items[fileName]
+	vttCache.Unlock()
+	if !cached {
+		return false, nil
+	}
+	_, err := dest.Write(timing.applyToVtt(vtt))
+	return true, err
+}
+
+// ConvertFileToVtt converts completely downloaded subtitles file and caches the result
+func (m *SubtitlesManager) ConvertFileToVtt(fileName string, data []byte, timing Timing, dest io.Writer) error {
+	var buf bytes.Buffer
+	if err := m.ConvertToVtt(data, "", &buf); err != nil {
+		return err
+	}
+	m.cacheVtt(fileName, buf.Bytes())
+	_, err := dest.Write(timing.applyToVtt(buf.Bytes()))
+	return err
+}
+
+func (m *SubtitlesManager) cacheVtt(fileName string, vtt []byte) {
+	vttCache.Lock()
+	vttCache.items[fileName] = vtt
+	vttCache.Unlock()
+}
+
+// ConvertToVtt reads subtitles in any supported format and charset and writes them
+// as UTF-8 WebVTT, format is detected by content when it is empty
+func (m *SubtitlesManager) ConvertToVtt(data []byte, format string, dest io.Writer) error {
+	// STL двоичный, у него своя кодировка внутри
+	if format == "" && DetectFormat(data) == FormatStl {
+		format = FormatStl
+	}
+	if format != FormatStl {
+		var charset string
+		data, charset = ToUtf8(data)
+		if charset != CharsetUtf8 {
+			logrus.Debugf("Converted subtitles from %v to utf-8", charset)
+		}
+	}
+	if format == "" {
+		format = DetectFormat(data)
+	}
+
+	var (
+		subs *subtitles.Subtitles
+		err  error
+	)
+	src := bytes.NewReader(data)
+	switch format {
+	case FormatSrt:
+		subs, err = subtitles.ReadFromSRT(src)
+	case FormatSsa, FormatAss:
+		subs, err = subtitles.ReadFromSSA(bytes.NewReader(prepareSSA(data)))
+	case FormatVtt:
+		subs, err = subtitles.ReadFromWebVTT(src)
+	case FormatTtml, FormatDfxp:
+		subs, err = subtitles.ReadFromTTML(src)
+	case FormatStl:
+		subs, err = subtitles.ReadFromSTL(src)
+	case FormatMicroDVD:
+		subs, err = ReadFromMicroDVD(src, defaultMicroDVDFps)
+	default:
+		err = fmt.Errorf("unsupported subtitles format %#v", format)
+	}
 	if err != nil {
-		logrus.Errorf("Failed to read srt file: %v", err)
+		logrus.Errorf("Failed to read %v subtitles: %v", format, err)
 		return err
 	}
-	if err := srt.WriteToWebVTT(dest); err != nil {
-		logrus.Errorf("Error converting to vtt: %v", err)
+
+	convertStyles(subs)
+	if err := subs.WriteToWebVTT(dest); err != nil {
+		logrus.Errorf("Error converting %v to vtt: %v", format, err)
 		return err
 	}
 	return nil
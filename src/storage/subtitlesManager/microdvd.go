@@ -0,0 +1,108 @@
+package subtitlesManager
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	subtitles "github.com/asticode/go-astisub"
+)
+
+// MicroDVD считает время в кадрах, без указания fps берем самый частый
+const defaultMicroDVDFps = 23.976
+
+// cue without end frame is shown this long
+const microDVDDefaultDuration = 3 * time.Second
+
+var (
+	microDVDCue   = regexp.MustCompile(`^\{(\d+)\}\{(\d*)\}(.*)$`)
+	microDVDStyle = regexp.MustCompile(`\{[^{}]*\}`)
+)
+
+// ReadFromMicroDVD parses "{start}{end}text|second line" subtitles. The first cue
+// "{1}{1}25.000" overrides fps, {y:i} and {y:b} style tags become italics and bold
+func ReadFromMicroDVD(src io.Reader, fps float64) (*subtitles.Subtitles, error) {
+	subs := subtitles.NewSubtitles()
+	scanner := bufio.NewScanner(src)
+	scanner.Buffer(make([]byte, 64 << 10), 1 << 20)
+
+	frameToTime := func(frame int) time.Duration {
+		return time.Duration(float64(frame) / fps * float64(time.Second))
+	}
+
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(strings.TrimPrefix(scanner.Text(), "\uFEFF"))
+		if line == "" {
+			continue
+		}
+		match := microDVDCue.FindStringSubmatch(line)
+		if match == nil {
+			return nil, fmt.Errorf("line %v: not a MicroDVD cue: %#v", lineNum, line)
+		}
+		start, _ := strconv.Atoi(match[1])
+		end, _ := strconv.Atoi(match[2])
+
+		if len(subs.Items) == 0 && start <= 1 && end <= 1 {
+			if declared, err := strconv.ParseFloat(strings.TrimSpace(match[3]), 64); err == nil && declared > 0 {
+				fps = declared
+				continue
+			}
+		}
+
+		item := &subtitles.Item{StartAt: frameToTime(start)}
+		if match[2] == "" || end < start {
+			item.EndAt = item.StartAt + microDVDDefaultDuration
+		} else {
+			item.EndAt = frameToTime(end)
+		}
+
+		// теги в начале cue действуют на весь cue, в начале строки - только на строку
+		text := match[3]
+		cueStyle := &subtitles.StyleAttributes{}
+		text = applyMicroDVDTags(text, cueStyle)
+		for _, lineText := range strings.Split(text, "|") {
+			lineStyle := *cueStyle
+			lineText = applyMicroDVDTags(lineText, &lineStyle)
+			item.Lines = append(item.Lines, subtitles.Line{Items: []subtitles.LineItem{{
+				Text:        lineText,
+				InlineStyle: &lineStyle,
+			}}})
+		}
+		subs.Items = append(subs.Items, item)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading MicroDVD subtitles: %v", err)
+	}
+	return subs, nil
+}
+
+// applyMicroDVDTags strips style tags from the text start and applies them to
+// the style, unknown tags in the middle of the text are removed
+func applyMicroDVDTags(text string, style *subtitles.StyleAttributes) string {
+	for strings.HasPrefix(text, "{") {
+		end := strings.Index(text, "}")
+		if end < 0 {
+			break
+		}
+		tag := strings.ToLower(text[1:end])
+		text = text[end + 1:]
+
+		if !strings.HasPrefix(tag, "y:") {
+			continue
+		}
+		for _, value := range strings.Split(tag[2:], ",") {
+			switch strings.TrimSpace(value) {
+			case "i":
+				style.WebVTTItalics = true
+			case "b":
+				bold := true
+				style.SSABold = &bold
+			}
+		}
+	}
+	return microDVDStyle.ReplaceAllString(text, "")
+}
@@ -0,0 +1,192 @@
+package subtitlesManager
+
+import (
+	"bytes"
+	"strings"
+
+	subtitles "github.com/asticode/go-astisub"
+)
+
+// WebVTT writer of astisub understands only italics and a few colors, so SSA and
+// TTML styles are converted to them, bold is written as <b> tag into the text
+type textStyle struct {
+	italic  bool
+	bold    bool
+	color   string
+	drawing bool
+}
+
+func convertStyles(subs *subtitles.Subtitles) {
+	items := subs.Items[:0]
+	for _, item := range subs.Items {
+		base := textStyle{}
+		base.apply(item.Style)
+		base.applyAttributes(item.InlineStyle)
+
+		lines := item.Lines[:0]
+		for _, line := range item.Lines {
+			current := base
+			lineItems := line.Items[:0]
+			for _, lineItem := range line.Items {
+				style := current
+				style.apply(lineItem.Style)
+				style.applyAttributes(lineItem.InlineStyle)
+				// SSA теги действуют до конца строки или до \r
+				if lineItem.InlineStyle != nil && lineItem.InlineStyle.SSAEffect != "" {
+					current.applySSAOverrides(lineItem.InlineStyle.SSAEffect, base)
+					style = current
+				}
+
+				text := cleanSSAText(lineItem.Text)
+				if style.drawing || strings.TrimSpace(text) == "" {
+					continue
+				}
+				if style.bold {
+					text = "<b>" + text + "</b>"
+				}
+				lineItems = append(lineItems, subtitles.LineItem{
+					Text: text,
+					InlineStyle: &subtitles.StyleAttributes{
+						WebVTTItalics: style.italic,
+						TTMLColor:     style.color,
+					},
+				})
+			}
+			if len(lineItems) > 0 {
+				line.Items = lineItems
+				lines = append(lines, line)
+			}
+		}
+		if len(lines) > 0 {
+			item.Lines = lines
+			items = append(items, item)
+		}
+	}
+	subs.Items = items
+}
+
+func (s *textStyle) apply(style *subtitles.Style) {
+	// стили могут наследоваться, сначала применяем родительский
+	if style == nil {
+		return
+	}
+	s.apply(style.Style)
+	s.applyAttributes(style.InlineStyle)
+}
+
+func (s *textStyle) applyAttributes(attrs *subtitles.StyleAttributes) {
+	if attrs == nil {
+		return
+	}
+	if attrs.SSAItalic != nil {
+		s.italic = *attrs.SSAItalic
+	}
+	if attrs.SSABold != nil {
+		s.bold = *attrs.SSABold
+	}
+	if attrs.SSAPrimaryColour != nil {
+		s.color = "#" + attrs.SSAPrimaryColour.TTMLString()
+	}
+	if attrs.TTMLFontStyle != "" {
+		s.italic = attrs.TTMLFontStyle == "italic" || attrs.TTMLFontStyle == "oblique"
+	}
+	if attrs.TTMLFontWeight != "" {
+		s.bold = attrs.TTMLFontWeight == "bold"
+	}
+	if attrs.TTMLColor != "" {
+		s.color = attrs.TTMLColor
+	}
+	if attrs.WebVTTItalics {
+		s.italic = true
+	}
+}
+
+// applySSAOverrides handles override block like {\i1\b1\c&H00FFFF&\pos(10,10)},
+// unsupported tags are ignored
+func (s *textStyle) applySSAOverrides(block string, base textStyle) {
+	block = strings.TrimSuffix(strings.TrimPrefix(block, "{"), "}")
+	for _, tag := range strings.Split(block, "\\") {
+		switch {
+		case tag == "":
+		case tag == "r":
+			*s = base
+		case tag == "i0" || tag == "i1":
+			s.italic = tag == "i1"
+		case strings.HasPrefix(tag, "b") && isDigits(tag[1:]) && len(tag) > 1:
+			s.bold = tag[1:] != "0"
+		case strings.HasPrefix(tag, "c&H") || strings.HasPrefix(tag, "1c&H"):
+			if color, ok := parseSSAColor(tag[strings.Index(tag, "&H") + 2:]); ok {
+				s.color = color
+			}
+		case strings.HasPrefix(tag, "p") && isDigits(tag[1:]) && len(tag) > 1:
+			s.drawing = tag[1:] != "0"
+		}
+	}
+}
+
+// parseSSAColor converts BBGGRR (optionally with alpha AABBGGRR) hex to #rrggbb
+func parseSSAColor(value string) (string, bool) {
+	value = strings.ToLower(strings.TrimRight(value, "&"))
+	if len(value) > 6 {
+		value = value[len(value) - 6:]
+	}
+	for len(value) < 6 {
+		value = "0" + value
+	}
+	for _, r := range value {
+		if !strings.ContainsRune("0123456789abcdef", r) {
+			return "", false
+		}
+	}
+	return "#" + value[4:6] + value[2:4] + value[0:2], true
+}
+
+// prepareSSA works around astisub dropping the text before the first override
+// block: an empty block is added in front of every dialogue text
+func prepareSSA(data []byte) []byte {
+	lines := bytes.Split(data, []byte("\n"))
+	textField := 9 // Layer, Start, End, Style, Name, MarginL, MarginR, MarginV, Effect, Text
+	inEvents := false
+
+	for i, line := range lines {
+		trimmed := bytes.TrimSpace(line)
+		switch {
+		case bytes.HasPrefix(trimmed, []byte("[")):
+			inEvents = bytes.EqualFold(trimmed, []byte("[Events]"))
+		case inEvents && bytes.HasPrefix(trimmed, []byte("Format:")):
+			textField = bytes.Count(trimmed, []byte(","))
+		case bytes.HasPrefix(trimmed, []byte("Dialogue:")):
+			fields := bytes.SplitN(line, []byte(","), textField + 1)
+			if len(fields) <= textField {
+				continue
+			}
+			text := bytes.ReplaceAll(fields[textField], []byte("\\n"), []byte("\\N"))
+			if !bytes.HasPrefix(text, []byte("{")) {
+				text = append([]byte("{\\}"), text...)
+			}
+			fields[textField] = text
+			lines[i] = bytes.Join(fields, []byte(","))
+		}
+	}
+	return bytes.Join(lines, []byte("\n"))
+}
+
+func cleanSSAText(text string) string {
+	text = strings.ReplaceAll(text, "\\N", "\n")
+	text = strings.ReplaceAll(text, "\\n", "\n")
+	text = strings.ReplaceAll(text, "\\h", " ")
+	// пустая строка внутри cue закончит его в WebVTT
+	for strings.Contains(text, "\n\n") {
+		text = strings.ReplaceAll(text, "\n\n", "\n")
+	}
+	return strings.Trim(text, "\n")
+}
+
+func isDigits(value string) bool {
+	for _, r := range value {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,136 @@
+package subtitlesManager
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Timing fixes out of sync subtitles: times are rescaled from the frame rate the
+// subtitles were made for to the frame rate of the video, then shifted by offset
+type Timing struct {
+	Offset  time.Duration
+	FpsFrom float64
+	FpsTo   float64
+}
+
+// ParseTiming reads "offset" in milliseconds (may be negative) and "fps" as
+// "<subtitles fps>:<video fps>", e.g. "25:23.976", empty values are ignored
+func ParseTiming(offset, fps string) (Timing, error) {
+	var timing Timing
+	if offset != "" {
+		ms, err := strconv.ParseInt(offset, 10, 64)
+		if err != nil {
+			return Timing{}, fmt.Errorf("invalid offset %#v: expected milliseconds", offset)
+		}
+		timing.Offset = time.Duration(ms) * time.Millisecond
+	}
+	if fps != "" {
+		parts := strings.Split(fps, ":")
+		if len(parts) != 2 {
+			return Timing{}, fmt.Errorf("invalid fps %#v: expected <subtitles fps>:<video fps>", fps)
+		}
+		from, errFrom := strconv.ParseFloat(parts[0], 64)
+		to, errTo := strconv.ParseFloat(parts[1], 64)
+		if errFrom != nil || errTo != nil || from <= 0 || to <= 0 {
+			return Timing{}, fmt.Errorf("invalid fps %#v: expected positive numbers", fps)
+		}
+		if from != to {
+			timing.FpsFrom, timing.FpsTo = from, to
+		}
+	}
+	return timing, nil
+}
+
+func (t Timing) IsZero() bool {
+	return t.Offset == 0 && t.FpsFrom == 0
+}
+
+func (t Timing) shift(at time.Duration) time.Duration {
+	ratio := 1.0
+	if t.FpsFrom > 0 && t.FpsTo > 0 {
+		// субтитры для 25 кадров идут быстрее, на 23.976 их надо растянуть
+		ratio = t.FpsFrom / t.FpsTo
+	}
+	return time.Duration(float64(at) * ratio) + t.Offset
+}
+
+// applyToVtt shifts all cues of converted WebVTT, the ones which end before the
+// start of the video are dropped and the ones crossing it are cut. Only cue
+// timings are changed, so styles written by the converter are kept as is
+func (t Timing) applyToVtt(vtt []byte) []byte {
+	if t.IsZero() {
+		return vtt
+	}
+	blocks := bytes.Split(vtt, []byte("\n\n"))
+	result := make([][]byte, 0, len(blocks))
+	for _, block := range blocks {
+		lines := bytes.Split(block, []byte("\n"))
+		cue := -1
+		for i, line := range lines {
+			if bytes.Contains(line, []byte("-->")) {
+				cue = i
+				break
+			}
+		}
+		if cue < 0 {
+			result = append(result, block)
+			continue
+		}
+
+		// "start --> end [настройки]"
+		fields := strings.Fields(string(lines[cue]))
+		if len(fields) < 3 || fields[1] != "-->" {
+			result = append(result, block)
+			continue
+		}
+		start, errStart := parseVttTime(fields[0])
+		end, errEnd := parseVttTime(fields[2])
+		if errStart != nil || errEnd != nil {
+			result = append(result, block)
+			continue
+		}
+		start, end = t.shift(start), t.shift(end)
+		if end <= 0 {
+			continue
+		}
+		if start < 0 {
+			start = 0
+		}
+		fields[0], fields[2] = formatVttTime(start), formatVttTime(end)
+		lines[cue] = []byte(strings.Join(fields, " "))
+		result = append(result, bytes.Join(lines, []byte("\n")))
+	}
+	return bytes.Join(result, []byte("\n\n"))
+}
+
+// parseVttTime reads "hh:mm:ss.ttt" or "mm:ss.ttt"
+func parseVttTime(value string) (time.Duration, error) {
+	parts := strings.Split(value, ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return 0, fmt.Errorf("invalid vtt time %#v", value)
+	}
+	seconds, err := strconv.ParseFloat(parts[len(parts) - 1], 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid vtt time %#v", value)
+	}
+	at := time.Duration(math.Round(seconds * 1000)) * time.Millisecond
+	multiplier := time.Minute
+	for i := len(parts) - 2; i >= 0; i-- {
+		n, err := strconv.Atoi(parts[i])
+		if err != nil {
+			return 0, fmt.Errorf("invalid vtt time %#v", value)
+		}
+		at += time.Duration(n) * multiplier
+		multiplier *= 60
+	}
+	return at, nil
+}
+
+func formatVttTime(at time.Duration) string {
+	ms := at.Milliseconds()
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", ms / 3600000, ms / 60000 % 60, ms / 1000 % 60, ms % 1000)
+}
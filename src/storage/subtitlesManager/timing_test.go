@@ -0,0 +1,96 @@
+package subtitlesManager
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+const testSrt = `1
+00:00:01,000 --> 00:00:02,500
+<i>first</i>
+
+2
+00:00:10,000 --> 00:00:12,000
+second
+
+3
+00:01:00,000 --> 00:01:30,000
+third
+`
+
+func TestParseTiming(t *testing.T) {
+	timing, err := ParseTiming("-1500", "25:23.976")
+	if err != nil {
+		t.Fatalf("Error parsing: %v", err)
+	}
+	if timing.Offset != -1500 * time.Millisecond || timing.FpsFrom != 25 || timing.FpsTo != 23.976 {
+		t.Errorf("Unexpected timing: %+v", timing)
+	}
+
+	if timing, err := ParseTiming("", "25:25"); err != nil || !timing.IsZero() {
+		t.Errorf("Equal fps should give zero timing, got %+v, %v", timing, err)
+	}
+	for _, params := range [][2]string{{"1.5", ""}, {"", "25"}, {"", "0:25"}, {"", "a:b"}} {
+		if _, err := ParseTiming(params[0], params[1]); err == nil {
+			t.Errorf("Expected error for %v", params)
+		}
+	}
+}
+
+func TestApplyToVtt(t *testing.T) {
+	var vtt bytes.Buffer
+	if err := GetManager().ConvertToVtt([]byte(testSrt), "", &vtt); err != nil {
+		t.Fatalf("Error converting: %v", err)
+	}
+
+	for _, test := range []struct {
+		name     string
+		timing   Timing
+		expected []string
+	}{
+		{"zero", Timing{}, []string{"00:00:01.000 --> 00:00:02.500", "00:00:10.000 --> 00:00:12.000", "00:01:00.000 --> 00:01:30.000"}},
+		{"forward", Timing{Offset: 2 * time.Second}, []string{"00:00:03.000 --> 00:00:04.500", "00:00:12.000 --> 00:00:14.000", "00:01:02.000 --> 00:01:32.000"}},
+		// первая реплика целиком до начала видео, вторая обрезается
+		{"back", Timing{Offset: -11 * time.Second}, []string{"00:00:00.000 --> 00:00:01.000", "00:00:49.000 --> 00:01:19.000"}},
+		{"fps", Timing{FpsFrom: 25, FpsTo: 20}, []string{"00:00:01.250 --> 00:00:03.125", "00:00:12.500 --> 00:00:15.000", "00:01:15.000 --> 00:01:52.500"}},
+	} {
+		shifted := string(test.timing.applyToVtt(vtt.Bytes()))
+		if count := bytes.Count([]byte(shifted), []byte("-->")); count != len(test.expected) {
+			t.Errorf("%v: expected %v cues, got:\n%v", test.name, len(test.expected), shifted)
+			continue
+		}
+		for _, cue := range test.expected {
+			if !bytes.Contains([]byte(shifted), []byte(cue)) {
+				t.Errorf("%v: no %v in:\n%v", test.name, cue, shifted)
+			}
+		}
+	}
+}
+
+func TestCachedVttIsShiftedPerRequest(t *testing.T) {
+	manager := GetManager()
+	var first, second, cached bytes.Buffer
+	if err := manager.ConvertFileToVtt("timing_test.srt", []byte(testSrt), Timing{Offset: time.Second}, &first); err != nil {
+		t.Fatalf("Error converting: %v", err)
+	}
+	if !bytes.Contains(first.Bytes(), []byte("00:00:02.000 --> 00:00:03.500")) {
+		t.Errorf("Timing is not applied:\n%v", first.String())
+	}
+
+	if ok, err := manager.GetCachedVtt("timing_test.srt", Timing{}, &cached); !ok || err != nil {
+		t.Fatalf("Conversion is not cached: %v", err)
+	}
+	if !bytes.Contains(cached.Bytes(), []byte("00:00:01.000 --> 00:00:02.500")) {
+		t.Errorf("Cached without zero timing:\n%v", cached.String())
+	}
+	if _, err := manager.GetCachedVtt("timing_test.srt", Timing{Offset: -time.Second}, &second); err != nil {
+		t.Fatalf("Error reading cache: %v", err)
+	}
+	if !bytes.Contains(second.Bytes(), []byte("00:00:00.000 --> 00:00:01.500")) {
+		t.Errorf("Timing of the request is not applied:\n%v", second.String())
+	}
+	if len(vttCache.items) != 1 {
+		t.Errorf("Expected one cached conversion, got %v", len(vttCache.items))
+	}
+}
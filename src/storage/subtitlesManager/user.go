@@ -0,0 +1,91 @@
+package subtitlesManager
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strings"
+
+	subtitles "github.com/asticode/go-astisub"
+)
+
+// субтитры больше пары мегабайт - это не субтитры
+const MaxUserSubtitlesSize = 2 << 20
+
+var (
+	userSubtitlesIdPattern = regexp.MustCompile(`^user_[0-9a-f]{32}$`)
+	languagePattern        = regexp.MustCompile(`^[a-z]{2,3}(-[A-Za-z0-9]{2,8})?$`)
+)
+
+// NewUserSubtitlesId generates id of uploaded subtitles, it is also the name of
+// the file in FILES_DIR
+func NewUserSubtitlesId() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return "user_" + hex.EncodeToString(buf), nil
+}
+
+func IsUserSubtitlesId(subtitlesId string) bool {
+	return userSubtitlesIdPattern.MatchString(subtitlesId)
+}
+
+// ValidateLanguage accepts ISO 639 codes with an optional region: "ru", "eng", "pt-BR"
+func ValidateLanguage(language string) error {
+	if !languagePattern.MatchString(language) {
+		return fmt.Errorf("invalid language %#v: expected ISO 639 code", language)
+	}
+	return nil
+}
+
+// ValidateUserSubtitles checks that the uploaded file is srt, ssa/ass or vtt with
+// at least one cue, the detected format is returned
+func (m *SubtitlesManager) ValidateUserSubtitles(data []byte) (string, error) {
+	if len(data) == 0 {
+		return "", fmt.Errorf("subtitles file is empty")
+	}
+	if len(data) > MaxUserSubtitlesSize {
+		return "", fmt.Errorf("subtitles file is larger than %v bytes", MaxUserSubtitlesSize)
+	}
+
+	data, _ = ToUtf8(data)
+	format := DetectFormat(data)
+	var (
+		subs *subtitles.Subtitles
+		err  error
+	)
+	switch format {
+	case FormatSrt:
+		subs, err = subtitles.ReadFromSRT(bytes.NewReader(data))
+	case FormatSsa:
+		subs, err = subtitles.ReadFromSSA(bytes.NewReader(prepareSSA(data)))
+		if bytes.Contains(data, []byte("[V4+ Styles]")) {
+			format = FormatAss
+		}
+	case FormatVtt:
+		subs, err = subtitles.ReadFromWebVTT(bytes.NewReader(data))
+	default:
+		return "", fmt.Errorf("unsupported subtitles format %v: only srt, ass and vtt can be uploaded", format)
+	}
+	if err != nil {
+		return "", fmt.Errorf("invalid %v subtitles: %v", format, err)
+	}
+	if len(subs.Items) == 0 {
+		return "", fmt.Errorf("%v subtitles have no cues", format)
+	}
+	return format, nil
+}
+
+// DropCachedVtt forgets all converted variants of the file
+func (m *SubtitlesManager) DropCachedVtt(fileName string) {
+	vttCache.Lock()
+	for key := range vttCache.items {
+		if key == fileName || strings.HasPrefix(key, fileName + "@") {
+			delete(vttCache.items, key)
+		}
+	}
+	vttCache.Unlock()
+}
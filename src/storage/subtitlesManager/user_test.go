@@ -0,0 +1,92 @@
+package subtitlesManager
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+const testAss = `[Script Info]
+ScriptType: v4.00+
+
+[V4+ Styles]
+Format: Name, Fontname, Fontsize
+Style: Default,Arial,20
+
+[Events]
+Format: Layer, Start, End, Style, Text
+Dialogue: 0,0:00:01.00,0:00:02.50,Default,first
+`
+
+const testVtt = `WEBVTT
+
+00:00:01.000 --> 00:00:02.500
+first
+`
+
+func TestValidateUserSubtitles(t *testing.T) {
+	manager := GetManager()
+	for name, data := range map[string]string{FormatSrt: testSrt, FormatAss: testAss, FormatVtt: testVtt} {
+		format, err := manager.ValidateUserSubtitles([]byte(data))
+		if err != nil {
+			t.Errorf("Error validating %v: %v", name, err)
+		} else if format != name {
+			t.Errorf("Expected format %v, got %v", name, format)
+		}
+	}
+
+	// BOM не мешает определить формат
+	if format, err := manager.ValidateUserSubtitles(append([]byte{0xEF, 0xBB, 0xBF}, testSrt...)); err != nil || format != FormatSrt {
+		t.Errorf("Srt with BOM: %v, %v", format, err)
+	}
+
+	for _, test := range []struct {
+		name string
+		data []byte
+		err  string
+	}{
+		{"empty", []byte{}, "empty"},
+		{"oversized", append([]byte(testSrt), bytes.Repeat([]byte("\n"), MaxUserSubtitlesSize)...), "larger"},
+		{"microdvd", []byte("{10}{20}first\n{30}{40}second\n"), "unsupported"},
+		// текст без таймингов разбирается как srt и отбрасывается без реплик
+		{"plain text", []byte("just some text\nwithout timings\n"), "no cues"},
+		{"vtt without cues", []byte("WEBVTT\n\n"), "no cues"},
+		{"ass without cues", []byte(strings.SplitAfter(testAss, "Format: Layer, Start, End, Style, Text\n")[0]), "no cues"},
+	} {
+		format, err := manager.ValidateUserSubtitles(test.data)
+		if err == nil {
+			t.Errorf("Expected error for %v, got format %v", test.name, format)
+		} else if !strings.Contains(err.Error(), test.err) {
+			t.Errorf("Unexpected error for %v: %v", test.name, err)
+		}
+	}
+}
+
+func TestIsUserSubtitlesId(t *testing.T) {
+	id, err := NewUserSubtitlesId()
+	if err != nil {
+		t.Fatalf("Error generating id: %v", err)
+	}
+	if !IsUserSubtitlesId(id) {
+		t.Errorf("Generated id %v is not valid", id)
+	}
+	for _, id := range []string{"", "user_", "user_0123", "user_0123456789ABCDEF0123456789ABCDEF",
+		"2_eng", "../user_0123456789abcdef0123456789abcdef", "user_0123456789abcdef0123456789abcdef.srt"} {
+		if IsUserSubtitlesId(id) {
+			t.Errorf("Id %#v should not be valid", id)
+		}
+	}
+}
+
+func TestValidateLanguage(t *testing.T) {
+	for _, language := range []string{"ru", "eng", "pt-BR", "zh-Hant"} {
+		if err := ValidateLanguage(language); err != nil {
+			t.Errorf("Language %v: %v", language, err)
+		}
+	}
+	for _, language := range []string{"", "r", "russian", "r1", "RU", "pt_BR", "pt-", "en-toolongtag"} {
+		if ValidateLanguage(language) == nil {
+			t.Errorf("Language %#v should not be valid", language)
+		}
+	}
+}
@@ -0,0 +1,29 @@
+package subtitlesProvider
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+const movieHashChunk = 64 << 10
+
+// MovieHash is the OpenSubtitles hash: file size plus sum of little endian uint64
+// words of the first and the last 64KB, so only the edges of the video are read
+func MovieHash(r io.ReaderAt, size int64) (string, error) {
+	if size < movieHashChunk {
+		return "", fmt.Errorf("file is too small for movie hash: %v bytes", size)
+	}
+
+	hash := uint64(size)
+	buf := make([]byte, movieHashChunk)
+	for _, offset := range []int64{0, size - movieHashChunk} {
+		if _, err := r.ReadAt(buf, offset); err != nil {
+			return "", err
+		}
+		for i := 0; i < movieHashChunk; i += 8 {
+			hash += binary.LittleEndian.Uint64(buf[i:])
+		}
+	}
+	return fmt.Sprintf("%016x", hash), nil
+}
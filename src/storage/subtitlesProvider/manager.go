@@ -0,0 +1,183 @@
+package subtitlesProvider
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"hypertube_storage/dao"
+	"hypertube_storage/model"
+	"hypertube_storage/parser/env"
+
+	"github.com/sirupsen/logrus"
+)
+
+// новые субтитры к фильму появляются, поиск иногда повторяем, скачанные файлы
+// не меняются
+const searchCacheTtl = time.Hour * 24
+
+// external subtitles id is "ext_<provider>_<provider id>", it is enough to
+// download the file again without the search results
+var externalIdPattern = regexp.MustCompile(`^ext_([a-z0-9]+)_([0-9A-Za-z]+)$`)
+
+// Manager searches all configured providers and caches search results and
+// downloaded files on disk
+type Manager struct {
+	providers []dao.SubtitleProvider
+	cacheDir  string
+}
+
+var (
+	manager     *Manager
+	managerOnce sync.Once
+)
+
+func GetManager() *Manager {
+	managerOnce.Do(func() {
+		parser := env.GetParser()
+		providers := make([]dao.SubtitleProvider, 0, 1)
+		if apiKey := parser.GetOpenSubtitlesApiKey(); apiKey != "" {
+			providers = append(providers, NewOpenSubtitles(parser.GetOpenSubtitlesApiUrl(), apiKey))
+		}
+		manager = NewManager(path.Join(parser.GetFilesDir(), "external_subtitles"), providers...)
+	})
+	return manager
+}
+
+func NewManager(cacheDir string, providers ...dao.SubtitleProvider) *Manager {
+	return &Manager{providers: providers, cacheDir: cacheDir}
+}
+
+// Enabled is false when no provider is configured
+func (m *Manager) Enabled() bool {
+	return len(m.providers) > 0
+}
+
+func ExternalId(provider, providerId string) string {
+	return fmt.Sprintf("ext_%s_%s", provider, providerId)
+}
+
+func ParseExternalId(subtitlesId string) (provider, providerId string, ok bool) {
+	match := externalIdPattern.FindStringSubmatch(subtitlesId)
+	if match == nil {
+		return "", "", false
+	}
+	return match[1], match[2], true
+}
+
+// Search asks every provider, failed ones are logged and skipped
+func (m *Manager) Search(ctx context.Context, query model.SubtitlesQuery) []model.ExternalSubtitles {
+	result := make([]model.ExternalSubtitles, 0)
+	for _, provider := range m.providers {
+		found, err := m.searchProvider(ctx, provider, query)
+		if err != nil {
+			logrus.Errorf("Error searching subtitles in %v: %v", provider.Name(), err)
+			continue
+		}
+		result = append(result, found...)
+	}
+	return result
+}
+
+func (m *Manager) searchProvider(ctx context.Context, provider dao.SubtitleProvider, query model.SubtitlesQuery) ([]model.ExternalSubtitles, error) {
+	cacheFile := path.Join(m.cacheDir, "search_" + searchCacheKey(provider.Name(), query) + ".json")
+	if stat, err := os.Stat(cacheFile); err == nil && time.Since(stat.ModTime()) < searchCacheTtl {
+		if data, err := os.ReadFile(cacheFile); err == nil {
+			var cached []model.ExternalSubtitles
+			if err := json.Unmarshal(data, &cached); err == nil {
+				return cached, nil
+			}
+		}
+	}
+
+	found, err := provider.Search(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]model.ExternalSubtitles, 0, len(found))
+	for _, subs := range found {
+		subs.Provider = provider.Name()
+		subs.Id = ExternalId(subs.Provider, subs.ProviderId)
+		if _, _, ok := ParseExternalId(subs.Id); !ok {
+			logrus.Debugf("Skipping %v subtitles with unsupported id %#v", subs.Provider, subs.ProviderId)
+			continue
+		}
+		result = append(result, subs)
+	}
+
+	if data, err := json.Marshal(result); err == nil {
+		m.writeCacheFile(cacheFile, data)
+	}
+	return result, nil
+}
+
+func searchCacheKey(provider string, query model.SubtitlesQuery) string {
+	languages := append([]string{}, query.Languages...)
+	sort.Strings(languages)
+	sum := sha1.Sum([]byte(fmt.Sprintf("%s|%s|%d|%s|%s", provider, query.MovieHash, query.MovieSize,
+		query.ImdbId, strings.ToLower(strings.Join(languages, ",")))))
+	return hex.EncodeToString(sum[:])
+}
+
+// Download returns the subtitles file by the external id, it is read from the
+// provider only once
+func (m *Manager) Download(ctx context.Context, subtitlesId string) ([]byte, error) {
+	providerName, providerId, ok := ParseExternalId(subtitlesId)
+	if !ok {
+		return nil, fmt.Errorf("invalid external subtitles id %#v", subtitlesId)
+	}
+	var provider dao.SubtitleProvider
+	for _, configured := range m.providers {
+		if configured.Name() == providerName {
+			provider = configured
+		}
+	}
+	if provider == nil {
+		return nil, fmt.Errorf("subtitles provider %v is not configured", providerName)
+	}
+
+	cacheFile := path.Join(m.cacheDir, subtitlesId)
+	if data, err := os.ReadFile(cacheFile); err == nil {
+		return data, nil
+	}
+	data, err := provider.Download(ctx, providerId)
+	if err != nil {
+		return nil, err
+	}
+	m.writeCacheFile(cacheFile, data)
+	return data, nil
+}
+
+// writeCacheFile renames complete file into place, parallel requests may write
+// the same one
+func (m *Manager) writeCacheFile(fileName string, data []byte) {
+	if err := os.MkdirAll(m.cacheDir, 0755); err != nil {
+		logrus.Errorf("Error creating subtitles cache dir: %v", err)
+		return
+	}
+	tmp, err := os.CreateTemp(m.cacheDir, path.Base(fileName) + ".*.tmp")
+	if err != nil {
+		logrus.Errorf("Error caching %v: %v", fileName, err)
+		return
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), fileName)
+	}
+	if err != nil {
+		logrus.Errorf("Error caching %v: %v", fileName, err)
+		_ = os.Remove(tmp.Name())
+	}
+}
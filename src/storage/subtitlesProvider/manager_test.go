@@ -0,0 +1,72 @@
+package subtitlesProvider
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"os"
+	"path"
+	"testing"
+
+	"hypertube_storage/model"
+)
+
+func TestManagerCachesOnDisk(t *testing.T) {
+	fake := newFakeOpenSubtitles(t)
+	cacheDir := t.TempDir()
+	manager := NewManager(cacheDir, fake.provider())
+	query := model.SubtitlesQuery{ImdbId: "tt0012345", Languages: []string{"ru"}}
+
+	found := manager.Search(context.Background(), query)
+	if len(found) != 2 || found[0].Id != "ext_opensubtitles_202" {
+		t.Fatalf("unexpected search result %+v", found)
+	}
+	// второй поиск, в том числе новым менеджером после рестарта, читается с диска
+	if again := NewManager(cacheDir, fake.provider()).Search(context.Background(), query); len(again) != 2 || again[0] != found[0] {
+		t.Errorf("unexpected cached result %+v", again)
+	}
+	if len(fake.searches) != 1 {
+		t.Errorf("provider searched %v times, expected 1", len(fake.searches))
+	}
+
+	for i := 0; i < 2; i++ {
+		data, err := manager.Download(context.Background(), found[0].Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Contains(data, []byte("Привет")) {
+			t.Errorf("unexpected file %q", data)
+		}
+	}
+	if fake.downloads != 1 {
+		t.Errorf("file downloaded %v times, expected 1", fake.downloads)
+	}
+	if _, err := os.Stat(path.Join(cacheDir, "ext_opensubtitles_202")); err != nil {
+		t.Errorf("file is not cached: %v", err)
+	}
+
+	if _, err := manager.Download(context.Background(), "ext_unknown_1"); err == nil {
+		t.Error("expected error for not configured provider")
+	}
+	if _, err := manager.Download(context.Background(), "ext_opensubtitles_../../etc"); err == nil {
+		t.Error("expected error for invalid id")
+	}
+}
+
+func TestMovieHash(t *testing.T) {
+	data := make([]byte, 3 * movieHashChunk)
+	binary.LittleEndian.PutUint64(data, 1)
+	binary.LittleEndian.PutUint64(data[movieHashChunk:], 100) // середина не учитывается
+	binary.LittleEndian.PutUint64(data[len(data) - 8:], 2)
+
+	hash, err := MovieHash(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "0000000000030003"; hash != expected {
+		t.Errorf("got %v, expected %v", hash, expected)
+	}
+	if _, err := MovieHash(bytes.NewReader(data[:100]), 100); err == nil {
+		t.Error("expected error for small file")
+	}
+}
@@ -0,0 +1,186 @@
+package subtitlesProvider
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"hypertube_storage/model"
+)
+
+const openSubtitlesName = "opensubtitles"
+
+// субтитры не бывают больше нескольких мегабайт, больше не читаем
+const maxDownloadSize = 8 << 20
+
+// OpenSubtitles is a client of OpenSubtitles REST API: search by moviehash and
+// imdb_id, download by file_id through a temporary link
+type OpenSubtitles struct {
+	BaseUrl string
+	ApiKey  string
+	Client  *http.Client
+}
+
+func NewOpenSubtitles(baseUrl string, apiKey string) *OpenSubtitles {
+	return &OpenSubtitles{
+		BaseUrl: strings.TrimSuffix(baseUrl, "/"),
+		ApiKey:  apiKey,
+		Client:  &http.Client{Timeout: time.Second * 30},
+	}
+}
+
+type openSubtitlesSearchResponse struct {
+	Data []struct {
+		Id         string `json:"id"`
+		Attributes struct {
+			Language       string `json:"language"`
+			Release        string `json:"release"`
+			Format         string `json:"format"`
+			DownloadCount  int    `json:"download_count"`
+			MovieHashMatch bool   `json:"moviehash_match"`
+			Files          []struct {
+				FileId   int64  `json:"file_id"`
+				FileName string `json:"file_name"`
+			} `json:"files"`
+		} `json:"attributes"`
+	} `json:"data"`
+}
+
+type openSubtitlesDownloadResponse struct {
+	Link     string `json:"link"`
+	FileName string `json:"file_name"`
+	Message  string `json:"message"`
+}
+
+func (o *OpenSubtitles) Name() string {
+	return openSubtitlesName
+}
+
+func (o *OpenSubtitles) newRequest(ctx context.Context, method, path string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, o.BaseUrl + path, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Api-Key", o.ApiKey)
+	req.Header.Set("User-Agent", "hypertube v1.0")
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return req, nil
+}
+
+func (o *OpenSubtitles) doJson(req *http.Request, dest interface{}) error {
+	resp, err := o.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%v %v: status %v: %s", req.Method, req.URL.Path, resp.StatusCode, message)
+	}
+	return json.NewDecoder(resp.Body).Decode(dest)
+}
+
+// Search returns one result per subtitles file, moviehash matches go first
+func (o *OpenSubtitles) Search(ctx context.Context, query model.SubtitlesQuery) ([]model.ExternalSubtitles, error) {
+	params := url.Values{}
+	if query.MovieHash != "" {
+		params.Set("moviehash", query.MovieHash)
+	}
+	if imdbId := strings.TrimLeft(strings.TrimPrefix(query.ImdbId, "tt"), "0"); imdbId != "" {
+		params.Set("imdb_id", imdbId)
+	}
+	if len(params) == 0 {
+		return nil, fmt.Errorf("nothing to search by: no movie hash and imdb id")
+	}
+	if len(query.Languages) > 0 {
+		languages := make([]string, len(query.Languages))
+		for i, language := range query.Languages {
+			languages[i] = strings.ToLower(language)
+		}
+		sort.Strings(languages)
+		params.Set("languages", strings.Join(languages, ","))
+	}
+
+	req, err := o.newRequest(ctx, http.MethodGet, "/subtitles?" + params.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	var response openSubtitlesSearchResponse
+	if err := o.doJson(req, &response); err != nil {
+		return nil, err
+	}
+
+	result := make([]model.ExternalSubtitles, 0, len(response.Data))
+	for _, item := range response.Data {
+		for _, file := range item.Attributes.Files {
+			result = append(result, model.ExternalSubtitles{
+				Provider:   openSubtitlesName,
+				ProviderId: strconv.FormatInt(file.FileId, 10),
+				Language:   item.Attributes.Language,
+				Release:    item.Attributes.Release,
+				Format:     item.Attributes.Format,
+				Downloads:  item.Attributes.DownloadCount,
+				HashMatch:  item.Attributes.MovieHashMatch,
+			})
+		}
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		if result[i].HashMatch != result[j].HashMatch {
+			return result[i].HashMatch
+		}
+		return result[i].Downloads > result[j].Downloads
+	})
+	return result, nil
+}
+
+// Download asks for a temporary link to the file and reads it
+func (o *OpenSubtitles) Download(ctx context.Context, providerId string) ([]byte, error) {
+	fileId, err := strconv.ParseInt(providerId, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %v file id %#v", openSubtitlesName, providerId)
+	}
+	body, _ := json.Marshal(map[string]int64{"file_id": fileId})
+	req, err := o.newRequest(ctx, http.MethodPost, "/download", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	var response openSubtitlesDownloadResponse
+	if err := o.doJson(req, &response); err != nil {
+		return nil, err
+	}
+	if response.Link == "" {
+		return nil, fmt.Errorf("no download link for file %v: %v", providerId, response.Message)
+	}
+
+	req, err = http.NewRequestWithContext(ctx, http.MethodGet, response.Link, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := o.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("download of file %v: status %v", providerId, resp.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxDownloadSize + 1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxDownloadSize {
+		return nil, fmt.Errorf("file %v is larger than %v bytes", providerId, maxDownloadSize)
+	}
+	return data, nil
+}
@@ -0,0 +1,123 @@
+package subtitlesProvider
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+
+	"hypertube_storage/model"
+)
+
+const fakeApiKey = "test-key"
+
+// fakeOpenSubtitles serves a tiny catalog in the format of OpenSubtitles REST API
+type fakeOpenSubtitles struct {
+	*httptest.Server
+	t         *testing.T
+	mu        sync.Mutex
+	searches  []string
+	downloads int
+}
+
+func newFakeOpenSubtitles(t *testing.T) *fakeOpenSubtitles {
+	fake := &fakeOpenSubtitles{t: t}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/subtitles", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Api-Key") != fakeApiKey || r.Header.Get("User-Agent") == "" {
+			http.Error(w, `{"message":"unauthorized"}`, http.StatusUnauthorized)
+			return
+		}
+		fake.mu.Lock()
+		fake.searches = append(fake.searches, r.URL.RawQuery)
+		fake.mu.Unlock()
+
+		_, _ = fmt.Fprint(w, `{"total_count": 2, "data": [
+			{"id": "10", "type": "subtitle", "attributes": {"language": "en", "release": "Movie.2010.720p",
+				"format": "srt", "download_count": 500, "moviehash_match": false,
+				"files": [{"file_id": 101, "file_name": "movie.en.srt"}]}},
+			{"id": "11", "type": "subtitle", "attributes": {"language": "ru", "release": "Movie.2010.1080p",
+				"format": "srt", "download_count": 20, "moviehash_match": true,
+				"files": [{"file_id": 202, "file_name": "movie.ru.srt"}]}}
+		]}`)
+	})
+	mux.HandleFunc("/api/v1/download", func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			FileId int64 `json:"file_id"`
+		}
+		if r.Method != http.MethodPost || json.NewDecoder(r.Body).Decode(&body) != nil {
+			http.Error(w, `{"message":"bad request"}`, http.StatusBadRequest)
+			return
+		}
+		if body.FileId != 202 {
+			http.Error(w, `{"message":"not found"}`, http.StatusNotFound)
+			return
+		}
+		_, _ = fmt.Fprintf(w, `{"link": "%s/files/202.srt", "file_name": "movie.ru.srt", "remaining": 99}`, fake.URL)
+	})
+	mux.HandleFunc("/files/202.srt", func(w http.ResponseWriter, r *http.Request) {
+		fake.mu.Lock()
+		fake.downloads++
+		fake.mu.Unlock()
+		_, _ = fmt.Fprint(w, "1\n00:00:01,000 --> 00:00:02,000\nПривет\n")
+	})
+	fake.Server = httptest.NewServer(mux)
+	t.Cleanup(fake.Close)
+	return fake
+}
+
+func (f *fakeOpenSubtitles) provider() *OpenSubtitles {
+	return NewOpenSubtitles(f.URL + "/api/v1/", fakeApiKey)
+}
+
+func TestOpenSubtitlesSearch(t *testing.T) {
+	fake := newFakeOpenSubtitles(t)
+
+	result, err := fake.provider().Search(context.Background(), model.SubtitlesQuery{
+		MovieHash: "8e245d9679d31e12",
+		ImdbId:    "tt0012345",
+		Languages: []string{"RU", "en"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []model.ExternalSubtitles{
+		{Provider: "opensubtitles", ProviderId: "202", Language: "ru", Release: "Movie.2010.1080p", Format: "srt", Downloads: 20, HashMatch: true},
+		{Provider: "opensubtitles", ProviderId: "101", Language: "en", Release: "Movie.2010.720p", Format: "srt", Downloads: 500},
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("got %+v, expected %+v", result, expected)
+	}
+	if query := fake.searches[0]; query != "imdb_id=12345&languages=en%2Cru&moviehash=8e245d9679d31e12" {
+		t.Errorf("unexpected search query %v", query)
+	}
+
+	if _, err := NewOpenSubtitles(fake.URL + "/api/v1", "wrong").Search(context.Background(), model.SubtitlesQuery{ImdbId: "1"}); err == nil {
+		t.Error("expected error for wrong api key")
+	}
+	if _, err := fake.provider().Search(context.Background(), model.SubtitlesQuery{}); err == nil {
+		t.Error("expected error for empty query")
+	}
+}
+
+func TestOpenSubtitlesDownload(t *testing.T) {
+	fake := newFakeOpenSubtitles(t)
+
+	data, err := fake.provider().Download(context.Background(), "202")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "1\n00:00:01,000 --> 00:00:02,000\nПривет\n" {
+		t.Errorf("unexpected file %q", data)
+	}
+	if _, err := fake.provider().Download(context.Background(), "303"); err == nil {
+		t.Error("expected error for unknown file")
+	}
+	if _, err := fake.provider().Download(context.Background(), "abc"); err == nil {
+		t.Error("expected error for invalid id")
+	}
+}
@@ -5,11 +5,13 @@ import (
 	"context"
 	"fmt"
 	"net"
+	"sync/atomic"
 	"time"
 
 	"torrentClient/bitfield"
 	"torrentClient/handshake"
 	"torrentClient/message"
+	"torrentClient/metrics"
 	"torrentClient/peers"
 
 	"github.com/sirupsen/logrus"
@@ -66,7 +68,7 @@ func New(peer peers.Peer, peerID, infoHash [20]byte) (*Client, error) {
 		logrus.Debugf("Connected to Peer on %v", peer.GetAddr())
 	}
 
-	_, err = completeHandshake(conn, infoHash, peerID)
+	hs, err := completeHandshake(conn, infoHash, peerID)
 	if err != nil {
 		conn.Close()
 		return nil, fmt.Errorf("handshake error: %v", err)
@@ -83,6 +85,7 @@ func New(peer peers.Peer, peerID, infoHash [20]byte) (*Client, error) {
 		Choked:   true,
 		Bitfield: bf,
 		Peer:     peer,
+		RemotePeerId:	hs.PeerID,
 		infoHash: infoHash,
 		peerID:   peerID,
 	}, nil
@@ -90,6 +93,9 @@ func New(peer peers.Peer, peerID, infoHash [20]byte) (*Client, error) {
 
 func (c *Client) Read() (*message.Message, error) {
 	msg, err := message.Read(c.Conn)
+	if err == nil {
+		metrics.BytesIn.Add(float64(msg.WireLength()))
+	}
 	return msg, err
 }
 
@@ -138,6 +144,10 @@ func (c *Client) WaitForUnchoke(ctx context.Context) (bool, error) {
 				return true, nil
 			case message.MsgChoke:
 				c.Choked = true
+			case message.MsgInterested:
+				c.PeerInterested = true
+			case message.MsgNotInterested:
+				c.PeerInterested = false
 			case message.MsgHave:
 				index, err := message.ParseHave(msg)
 				if err != nil {
@@ -151,15 +161,22 @@ func (c *Client) WaitForUnchoke(ctx context.Context) (bool, error) {
 	}
 }
 
+func (c *Client) write(buf []byte) (int, error) {
+	n, err := c.Conn.Write(buf)
+	atomic.AddInt64(&c.writtenBytes, int64(n))
+	metrics.BytesOut.Add(float64(n))
+	return n, err
+}
+
 func (c *Client) SendRequest(index, begin, length int) error {
 	req := message.FormatRequest(index, begin, length)
-	_, err := c.Conn.Write(req.Serialize())
+	_, err := c.write(req.Serialize())
 	return err
 }
 
 func (c *Client) SendInterested() error {
 	msg := message.Message{ID: message.MsgInterested}
-	_, err := c.Conn.Write(msg.Serialize())
+	_, err := c.write(msg.Serialize())
 	if err != nil {
 		logrus.Errorf("Error sending interested msg: %v", err)
 	}
@@ -167,7 +184,7 @@ func (c *Client) SendInterested() error {
 }
 
 func (c *Client) SendKeepAlive() error {
-	if _, err := c.Conn.Write(make([]byte, 4)); err != nil {
+	if _, err := c.write(make([]byte, 4)); err != nil {
 		logrus.Errorf("Error sending keep alive: %v", err)
 		return err
 	}
@@ -176,7 +193,7 @@ func (c *Client) SendKeepAlive() error {
 
 func (c *Client) SendNotInterested() error {
 	msg := message.Message{ID: message.MsgNotInterested}
-	_, err := c.Conn.Write(msg.Serialize())
+	_, err := c.write(msg.Serialize())
 	if err != nil {
 		logrus.Errorf("Error sending not interested msg: %v", err)
 	}
@@ -185,7 +202,7 @@ func (c *Client) SendNotInterested() error {
 
 func (c *Client) SendUnchoke() error {
 	msg := message.Message{ID: message.MsgUnchoke}
-	_, err := c.Conn.Write(msg.Serialize())
+	_, err := c.write(msg.Serialize())
 	if err != nil {
 		logrus.Errorf("Error sending unchoke msg: %v", err)
 	}
@@ -194,7 +211,7 @@ func (c *Client) SendUnchoke() error {
 
 func (c *Client) SendHave(index int) error {
 	msg := message.FormatHave(index)
-	_, err := c.Conn.Write(msg.Serialize())
+	_, err := c.write(msg.Serialize())
 	if err != nil {
 		logrus.Errorf("Error sending have msg: %v", err)
 	}
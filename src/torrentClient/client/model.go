@@ -3,6 +3,7 @@ package client
 import (
 	"fmt"
 	"net"
+	"sync/atomic"
 
 	"torrentClient/bitfield"
 	"torrentClient/peers"
@@ -12,10 +13,14 @@ type Client struct {
 	//Mu       sync.Mutex
 	Conn     net.Conn
 	Choked   bool
+	PeerInterested	bool
 	Bitfield bitfield.Bitfield
 	Peer     peers.Peer
+	RemotePeerId	[20]byte
 	infoHash [20]byte
 	peerID   [20]byte
+
+	writtenBytes	int64
 }
 
 func (c *Client) GetClientInfo() string {
@@ -29,3 +34,12 @@ func (c *Client) GetShortInfo() string {
 func (c *Client) GetPeer() peers.Peer {
 	return c.Peer
 }
+
+func (c *Client) GetClientName() string {
+	return peers.DecodeClientName(c.RemotePeerId)
+}
+
+// PopWrittenBytes returns number of bytes sent to the peer since the previous call
+func (c *Client) PopWrittenBytes() int {
+	return int(atomic.SwapInt64(&c.writtenBytes, 0))
+}
@@ -3,7 +3,6 @@ package db
 import (
 	"context"
 	"os"
-	"time"
 
 	"torrentClient/db/postgres"
 	"torrentClient/db/redis"
@@ -13,8 +12,9 @@ type LoaderStateDbManager interface {
 	InitConnection()
 	CloseConnection()
 
-	AddSliceIndexForFile(fileName string, sliceByteIdx ...int64)
-	DeleteSliceIndexesSet(fileName string)
+	GetCompletedRanges(fileName string) string
+	SetCompletedRanges(fileName string, ranges string)
+	DeleteCompletedRanges(fileName string)
 	GetLoadPriorityUpdatesChan(ctx context.Context, fileId string) chan redis.PriorityUpdateMsg
 }
 
@@ -30,6 +30,8 @@ type FilesDbManager interface {
 	SetFileNameForRecord(fileId, name string)
 	SetFileLengthForRecord(fileId string, length int64)
 	SetVideoFileNameAndLengthForRecord(fileId, fileName string, length int64)
+	SetOriginalFileNameForRecord(fileId, originalName, extension string)
+	SetDiskFilesForRecord(fileId string, fileNames []string)
 	SetSrtFileNameAndLengthForRecord(fileId, fileName string, length int64)
 	SetInProgressStatusForRecord(fileId string, status bool)
 	SetLoadedStatusForRecord(fileId string, status bool)
@@ -37,7 +39,12 @@ type FilesDbManager interface {
 	GetInProgressFileIds() (fileIds []string, ok bool)
 
 	GetLoadedIndexesForFile(fileId string) []int
-	GetFileIdsWithWatchedUnder(under time.Time) (ids []string)
+	GetEvictionCandidates() (records []postgres.LoadedRecord)
+	ResetLoadedStateForRecord(fileId string) bool
+	AddLoadedFileRecord(fileId, infoHash string, torrentFile []byte, comment, fileName string, length int64) bool
+	AddTorrentRecord(fileId, infoHash string, torrentFile []byte, magnetLink, comment string) bool
+	GetFileIdByInfoHash(infoHash string) (fileId string, ok bool)
+	SetInfoHashForRecord(fileId, infoHash string)
 	DeleteLoadedFileInfo(id string) error
 	GetPartDataByIdx(fileId string, idx int) ([]byte, int64, int64, bool)
 	SaveFilePartsToFile(dest *os.File, fileId string, start int, length int) error
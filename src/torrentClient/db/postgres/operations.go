@@ -1,14 +1,19 @@
 package postgres
 
 import (
+	"database/sql"
 	"fmt"
-	"time"
+	"strings"
 
 	"github.com/sirupsen/logrus"
 )
 
 const (
 	defaultPieceSize = 1e4
+	// каждый просмотр продлевает жизнь записи на день, но не больше чем на месяц
+	maxHitsBonusDays = 30
+	// имена файлов на диске - md5 в hex, запятой в них нет
+	diskFilesSeparator = ","
 )
 
 func (d *manager) GetTorrentOrMagnetForByFileId(fileId string) ([]byte, string, bool) {
@@ -31,28 +36,113 @@ WHERE file_id LIKE $1 AND (torrent_file is not null OR magnet_link is not null)`
 	return torrentFile, magnetLink, true
 }
 
-func (d *manager) GetFileIdsWithWatchedUnder(under time.Time) (ids []string) {
+// GetEvictionCandidates returns records with files on disk by the score of last
+// watched date moved by a day for every hit (up to maxHitsBonusDays), so an old
+// popular record outlives a fresh one watched once. Equal scores go less
+// popular first, then by file_id to keep the plan stable. Records created from
+// local media are never returned, there is nowhere to download them again
+func (d *manager) GetEvictionCandidates() (records []LoadedRecord) {
 	query := `
-SELECT file_id FROM %s WHERE last_watched < $1`
+SELECT file_id, file_name, last_watched, hits, in_progress, is_loaded, disk_files FROM %s
+WHERE file_name != '' AND NOT is_local
+ORDER BY last_watched + least(hits, %d) * interval '1 day', hits, file_id`
 
-	rows, err := d.conn.Query(fmt.Sprintf(query, d.LoadedFilesTablePath()), under)
+	rows, err := d.conn.Query(fmt.Sprintf(query, d.LoadedFilesTablePath(), maxHitsBonusDays))
 	if err != nil {
-		logrus.Errorf("Error getting in progress files: %v", err)
+		logrus.Errorf("Error getting eviction candidates: %v", err)
 		return nil
 	}
+	defer rows.Close()
 
-	ids = make([]string, 0, 10)
+	records = make([]LoadedRecord, 0, 10)
 
 	for rows.Next() {
-		var dest1 string
-		if err := rows.Scan(&dest1); err != nil {
+		var record LoadedRecord
+		var diskFiles string
+		if err := rows.Scan(&record.FileId, &record.FileName, &record.LastWatched, &record.Hits, &record.InProgress, &record.IsLoaded, &diskFiles); err != nil {
 			logrus.Errorf("Scan error: %v", err)
 			continue
 		}
-		ids = append(ids, dest1)
+		if diskFiles != "" {
+			record.DiskFiles = strings.Split(diskFiles, diskFilesSeparator)
+		}
+		records = append(records, record)
+	}
+
+	return records
+}
+
+// AddLoadedFileRecord saves a record for a torrent which data is already
+// on disk, e.g. created from local media
+func (d *manager) AddLoadedFileRecord(fileId, infoHash string, torrentFile []byte, comment, fileName string, length int64) bool {
+	query := `
+INSERT INTO %s (file_id, info_hash, torrent_file, comment, file_name, file_length, in_progress, is_loaded, is_local)
+VALUES ($1, $2, $3, $4, $5, $6, false, true, true)
+ON CONFLICT (file_id) DO UPDATE
+SET info_hash=excluded.info_hash, torrent_file=excluded.torrent_file, comment=excluded.comment,
+    file_name=excluded.file_name, file_length=excluded.file_length, in_progress=false, is_loaded=true, is_local=true`
+
+	if _, err := d.conn.Exec(fmt.Sprintf(query, d.LoadedFilesTablePath()), fileId, infoHash, torrentFile, comment, fileName, length); err != nil {
+		logrus.Errorf("Error adding loaded file record: %v", err)
+		return false
+	}
+	return true
+}
+
+// AddTorrentRecord saves a new not loaded record, returns false if the record
+// already exists
+func (d *manager) AddTorrentRecord(fileId, infoHash string, torrentFile []byte, magnetLink, comment string) bool {
+	query := `
+INSERT INTO %s (file_id, info_hash, torrent_file, magnet_link, comment)
+VALUES ($1, $2, $3, $4, $5)
+ON CONFLICT (file_id) DO NOTHING`
+
+	res, err := d.conn.Exec(fmt.Sprintf(query, d.LoadedFilesTablePath()), fileId, infoHash, torrentFile, magnetLink, comment)
+	if err != nil {
+		logrus.Errorf("Error adding torrent record: %v", err)
+		return false
+	}
+	affected, err := res.RowsAffected()
+	return err == nil && affected > 0
+}
+
+func (d *manager) GetFileIdByInfoHash(infoHash string) (fileId string, ok bool) {
+	query := `
+SELECT file_id FROM %s WHERE info_hash=$1 LIMIT 1`
+
+	if err := d.conn.QueryRow(fmt.Sprintf(query, d.LoadedFilesTablePath()), infoHash).Scan(&fileId); err != nil {
+		if err != sql.ErrNoRows {
+			logrus.Errorf("Error getting record by info hash: %v", err)
+		}
+		return "", false
 	}
+	return fileId, true
+}
+
+func (d *manager) SetInfoHashForRecord(fileId, infoHash string) {
+	query := `
+UPDATE %s SET info_hash=$1 WHERE file_id=$2 AND info_hash=''`
+
+	if _, err := d.conn.Exec(fmt.Sprintf(query, d.LoadedFilesTablePath()), infoHash, fileId); err != nil {
+		logrus.Errorf("Error saving info hash: %v", err)
+	}
+}
 
-	return ids
+// ResetLoadedStateForRecord forgets the files of the evicted record, the next
+// load fills all of them again. The record is not reset and false is returned
+// when its load has started since the eviction was planned
+func (d *manager) ResetLoadedStateForRecord(fileId string) bool {
+	query := `
+UPDATE %s SET is_loaded=false, in_progress=false, file_name='', file_length=0,
+    original_file_name='', file_extension='', disk_files='' WHERE file_id=$1 AND NOT in_progress`
+
+	res, err := d.conn.Exec(fmt.Sprintf(query, d.LoadedFilesTablePath()), fileId)
+	if err != nil {
+		logrus.Errorf("Error resetting loaded state: %v", err)
+		return false
+	}
+	affected, err := res.RowsAffected()
+	return err == nil && affected > 0
 }
 
 func (d *manager) DeleteLoadedFileInfo(id string) error  {
@@ -123,6 +213,28 @@ UPDATE %s SET file_name=$1, file_length=$2 WHERE file_id=$3`
 	}
 }
 
+// SetDiskFilesForRecord saves names of all files the load writes to FILES_DIR,
+// eraser removes them without reading the torrent
+func (d *manager) SetDiskFilesForRecord(fileId string, fileNames []string) {
+	query := `
+UPDATE %s SET disk_files=$1 WHERE file_id=$2`
+
+	if _, err := d.conn.Exec(fmt.Sprintf(query, d.LoadedFilesTablePath()), strings.Join(fileNames, diskFilesSeparator), fileId); err != nil {
+		logrus.Errorf("Error saving disk files: %v", err)
+	}
+}
+
+// SetOriginalFileNameForRecord saves the name of the video inside the torrent,
+// files on disk are named by md5 of the path and lose the extension
+func (d *manager) SetOriginalFileNameForRecord(fileId, originalName, extension string) {
+	query := `
+UPDATE %s SET original_file_name=$1, file_extension=$2 WHERE file_id=$3`
+
+	if _, err := d.conn.Exec(fmt.Sprintf(query, d.LoadedFilesTablePath()), originalName, extension, fileId); err != nil {
+		logrus.Errorf("Error saving original file name: %v", err)
+	}
+}
+
 func (d *manager) SetSrtFileNameAndLengthForRecord(fileId, fileName string, length int64) {
 	query := `
 UPDATE %s SET srt_file_name=$1, srt_file_length=$2 WHERE file_id=$3`
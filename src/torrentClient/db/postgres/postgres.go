@@ -2,6 +2,7 @@ package postgres
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/jmoiron/sqlx"
 	_ "github.com/lib/pq"
@@ -19,6 +20,16 @@ type manager struct {
 
 const tableNamePrefix = "loaded_pieces_"
 
+type LoadedRecord struct {
+	FileId		string
+	FileName	string
+	LastWatched	time.Time
+	Hits		int64
+	InProgress	bool
+	IsLoaded	bool
+	DiskFiles	[]string
+}
+
 func (d *manager) InitTables() {
 	query := `create schema if not exists %v`
 
@@ -34,7 +45,7 @@ func (d *manager) InitConnection(connStr string) {
 	}
 	conn, err := sqlx.Open("postgres", connStr)
 	if err != nil {
-		logrus.Fatalf("Error connecting to database: ", err)
+		logrus.Fatalf("Error connecting to database: %v", err)
 	}
 	if err := conn.Ping(); err != nil {
 		logrus.Fatalf("Error pinging db: %v; dsn: %v", err, connStr)
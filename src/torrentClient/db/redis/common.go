@@ -13,7 +13,7 @@ import (
 )
 
 const (
-	fileSliceIndexes       = "slices"
+	completedRanges       = "ranges"
 )
 
 type manager struct {
@@ -28,23 +28,37 @@ type PriorityUpdateMsg struct {
 
 var Manager manager
 
-func (m *manager) GetSliceIndexesKey(fileName string) string {
-	return fmt.Sprintf("%s:%s", fileSliceIndexes, fileName)
+func (m *manager) GetCompletedRangesKey(fileName string) string {
+	return fmt.Sprintf("%s:%s", completedRanges, fileName)
 }
 
-func (m *manager) AddSliceIndexForFile(fileName string, sliceByteIdx ...int64) {
-	for _, idx := range sliceByteIdx {
-		_, err := m.conn.SAdd(m.GetSliceIndexesKey(fileName), idx).Result()
-		if err != nil {
-			logrus.Errorf("Error AddSliceIndexForFile: %v", err)
-		} else {
-			logrus.Debugf("Added slices for file %v: %v", fileName, idx)
-		}
+func (m *manager) GetCompletedRanges(fileName string) string {
+	ranges, err := m.conn.Get(m.GetCompletedRangesKey(fileName)).Result()
+	if err != nil && err != redis.Nil {
+		logrus.Errorf("Error GetCompletedRanges: %v", err)
+	}
+	return ranges
+}
+
+// SetCompletedRanges stores ranges and notifies readers waiting for the file
+func (m *manager) SetCompletedRanges(fileName string, ranges string) {
+	key := m.GetCompletedRangesKey(fileName)
+	pipe := m.conn.TxPipeline()
+	pipe.Set(key, ranges, 0)
+	pipe.Publish(key, ranges)
+	if _, err := pipe.Exec(); err != nil {
+		logrus.Errorf("Error SetCompletedRanges: %v", err)
 	}
 }
 
-func (m *manager) DeleteSliceIndexesSet(fileName string) {
-	if _, err := m.conn.Del(m.GetSliceIndexesKey(fileName)).Result(); err != nil {
+// DeleteCompletedRanges removes ranges of the erased file, the empty message
+// tells storage to drop the cached parts of it
+func (m *manager) DeleteCompletedRanges(fileName string) {
+	key := m.GetCompletedRangesKey(fileName)
+	pipe := m.conn.TxPipeline()
+	pipe.Del(key)
+	pipe.Publish(key, "")
+	if _, err := pipe.Exec(); err != nil {
 		logrus.Errorf("Error deleting key: %v", err)
 	}
 }
@@ -1,15 +1,22 @@
 package eraser
 
 import (
+	"fmt"
+	"os"
+	"path/filepath"
 	"time"
 
 	"torrentClient/db"
+	"torrentClient/db/postgres"
 	"torrentClient/fsWriter"
-	"torrentClient/torrentfile"
+	"torrentClient/loadMaster"
+	"torrentClient/parser/env"
 
 	"github.com/sirupsen/logrus"
 )
 
+const unwatchedTimeout = time.Hour * 24 * 30
+
 type EraseManager struct {
 }
 
@@ -17,36 +24,149 @@ var manager EraseManager
 
 type RecordsEraseManager interface {
 	StartCheckingForRecords()
+	PlanEviction() EvictionPlan
+}
+
+type EvictionItem struct {
+	FileId      string    `json:"fileId"`
+	Bytes       int64     `json:"bytes"`
+	LastWatched time.Time `json:"lastWatched"`
+	Hits        int64     `json:"hits"`
+	Reason      string    `json:"reason"`
+
+	files []string
+}
+
+type EvictionPlan struct {
+	DryRun      bool           `json:"dryRun"`
+	BudgetBytes int64          `json:"budgetBytes"`
+	UsedBytes   int64          `json:"usedBytes"`
+	UsedAfter   int64          `json:"usedAfter"`
+	Items       []EvictionItem `json:"items"`
 }
 
 func GetEraser() RecordsEraseManager {
 	return &manager
 }
 
-func (e *EraseManager) StartCheckingForRecords()  {
+func (e *EraseManager) StartCheckingForRecords() {
 	ticker := time.NewTicker(time.Second * 30)
 
 	for {
-		<- ticker.C
+		<-ticker.C
 
-		unwatchedIds := db.GetFilesManagerDb().GetFileIdsWithWatchedUnder(time.Now().AddDate(0, -1, 0))
+		plan := e.PlanEviction()
+		if len(plan.Items) == 0 {
+			continue
+		}
 
-		for _, id := range unwatchedIds {
-			logrus.Debugf("Deleting files for %v", id)
-			torrent, err := torrentfile.GetManager().LoadTorrentFileFromDB(id)
-			
-			if err != nil || torrent == nil {
-				logrus.Errorf("Error loading torrent: %v", err)
-				continue
+		if plan.DryRun {
+			for _, item := range plan.Items {
+				logrus.Infof("Dry run, would evict %v (%v bytes): %v", item.FileId, item.Bytes, item.Reason)
 			}
+			continue
+		}
+
+		for _, item := range plan.Items {
+			logrus.Infof("Evicting %v (%v bytes): %v", item.FileId, item.Bytes, item.Reason)
+			e.evict(item)
+		}
+	}
+}
 
-			db.GetFilesManagerDb().RemoveFilePartsPlace(id)
+// PlanEviction doesn't delete anything, it only decides what has to go: records
+// not watched for a month and, while FILES_DIR is over budget, the ones with the
+// lowest score of last watched date and hits. Loads in progress are never touched.
+func (e *EraseManager) PlanEviction() EvictionPlan {
+	plan := EvictionPlan{
+		DryRun:      env.GetParser().IsEraserDryRun(),
+		BudgetBytes: env.GetParser().GetFilesDiskBudget(),
+		UsedBytes:   dirSize(env.GetParser().GetFilesDir()),
+		Items:       make([]EvictionItem, 0, 10),
+	}
+	plan.UsedAfter = plan.UsedBytes
 
-			files := torrent.GetFiles()
-			for _, file := range files {
-				logrus.Debugf("Removing file %v", file.EncodeFileName())
-				fsWriter.GetWriter().RemoveFile(file.EncodeFileName())
-			}
+	unwatchedBorder := time.Now().Add(-unwatchedTimeout)
+
+	for _, record := range db.GetFilesManagerDb().GetEvictionCandidates() {
+		if record.InProgress || isLoadActive(record.FileId) {
+			continue
+		}
+
+		var reason string
+		if record.LastWatched.Before(unwatchedBorder) {
+			reason = fmt.Sprintf("not watched since %v", record.LastWatched.Format(time.RFC3339))
+		} else if plan.BudgetBytes > 0 && plan.UsedAfter > plan.BudgetBytes {
+			reason = fmt.Sprintf("disk budget exceeded (%v of %v bytes used), last watched %v, hits %v",
+				plan.UsedAfter, plan.BudgetBytes, record.LastWatched.Format(time.RFC3339), record.Hits)
+		} else {
+			// популярная запись может стоять после свежих, но не смотреться месяц
+			continue
+		}
+
+		files, size := recordFiles(record)
+
+		plan.Items = append(plan.Items, EvictionItem{
+			FileId:      record.FileId,
+			Bytes:       size,
+			LastWatched: record.LastWatched,
+			Hits:        record.Hits,
+			Reason:      reason,
+			files:       files,
+		})
+		plan.UsedAfter -= size
+	}
+	return plan
+}
+
+// evict claims the record first, a load started after the plan was made keeps
+// its files
+func (e *EraseManager) evict(item EvictionItem) {
+	if isLoadActive(item.FileId) || !db.GetFilesManagerDb().ResetLoadedStateForRecord(item.FileId) {
+		logrus.Infof("Record %v is loading again or can't be reset, skipping eviction", item.FileId)
+		return
+	}
+	db.GetFilesManagerDb().RemoveFilePartsPlace(item.FileId)
+
+	for _, fileName := range item.files {
+		logrus.Debugf("Removing file %v", fileName)
+		fsWriter.GetWriter().RemoveFile(fileName)
+		fsWriter.GetWriter().ForgetCompletedRanges(fileName)
+	}
+}
+
+func isLoadActive(fileId string) bool {
+	entry, exists := loadMaster.GetMaster().GetEntry(fileId)
+	return exists && !entry.IsFinished()
+}
+
+// recordFiles takes names saved at the load start and sizes from FILES_DIR.
+// Records loaded before the names were saved know only the video file
+func recordFiles(record postgres.LoadedRecord) (files []string, size int64) {
+	files = record.DiskFiles
+	if len(files) == 0 {
+		files = []string{record.FileName}
+	}
+	for _, fileName := range files {
+		if info, err := os.Stat(filepath.Join(env.GetParser().GetFilesDir(), fileName)); err == nil {
+			size += info.Size()
+		}
+	}
+	return files, size
+}
+
+func dirSize(dir string) (size int64) {
+	err := filepath.Walk(dir, func(_ string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if info.Mode().IsRegular() {
+			size += info.Size()
 		}
+		return nil
+	})
+	if err != nil {
+		logrus.Errorf("Error calculating %v size: %v", dir, err)
 	}
+	return size
 }
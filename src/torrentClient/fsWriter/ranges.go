@@ -0,0 +1,106 @@
+package fsWriter
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"torrentClient/db"
+)
+
+type ByteRange struct {
+	Start	int64
+	End		int64 // не включительно
+}
+
+// ByteRanges is a sorted list of not overlapping and not adjacent ranges
+type ByteRanges []ByteRange
+
+// Add inserts [start, end) merging it with overlapping and adjacent ranges
+func (r ByteRanges) Add(start, end int64) ByteRanges {
+	if end <= start {
+		return r
+	}
+	// первый диапазон, который может слиться с новым
+	idx := sort.Search(len(r), func(i int) bool { return r[i].End >= start })
+	last := idx
+	for last < len(r) && r[last].Start <= end {
+		if r[last].Start < start {
+			start = r[last].Start
+		}
+		if r[last].End > end {
+			end = r[last].End
+		}
+		last++
+	}
+
+	res := make(ByteRanges, 0, len(r) - (last - idx) + 1)
+	res = append(res, r[:idx]...)
+	res = append(res, ByteRange{Start: start, End: end})
+	return append(res, r[last:]...)
+}
+
+// String encodes ranges as "0-1048576,2097152-3145728", storage parses the same format
+func (r ByteRanges) String() string {
+	parts := make([]string, len(r))
+	for i, byteRange := range r {
+		parts[i] = fmt.Sprintf("%d-%d", byteRange.Start, byteRange.End)
+	}
+	return strings.Join(parts, ",")
+}
+
+func ParseByteRanges(encoded string) (ByteRanges, error) {
+	res := make(ByteRanges, 0, 8)
+	if encoded == "" {
+		return res, nil
+	}
+	for _, part := range strings.Split(encoded, ",") {
+		bounds := strings.Split(part, "-")
+		if len(bounds) != 2 {
+			return nil, fmt.Errorf("invalid range %#v", part)
+		}
+		start, err := strconv.ParseInt(bounds[0], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid range start %#v: %v", part, err)
+		}
+		end, err := strconv.ParseInt(bounds[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid range end %#v: %v", part, err)
+		}
+		res = res.Add(start, end)
+	}
+	return res, nil
+}
+
+// записанные на диск куски, пишет их только горутина писателя, а стирает эрейзер
+var completed = struct {
+	sync.Mutex
+	files map[string]ByteRanges
+}{files: make(map[string]ByteRanges)}
+
+// markCompleted is called after verified piece data is written to the file, the
+// whole map is published so readers never see a range before its bytes
+func markCompleted(fileName string, start, end int64) {
+	completed.Lock()
+	ranges, known := completed.files[fileName]
+	if !known {
+		// после рестарта продолжаем с сохраненного состояния
+		ranges, _ = ParseByteRanges(db.GetLoadedStateDb().GetCompletedRanges(fileName))
+	}
+	ranges = ranges.Add(start, end)
+	completed.files[fileName] = ranges
+	encoded := ranges.String()
+	completed.Unlock()
+
+	db.GetLoadedStateDb().SetCompletedRanges(fileName, encoded)
+}
+
+func (w *FsWriter) ForgetCompletedRanges(fileName string) {
+	completed.Lock()
+	delete(completed.files, fileName)
+	completed.Unlock()
+
+	db.GetLoadedStateDb().DeleteCompletedRanges(fileName)
+}
@@ -0,0 +1,39 @@
+package fsWriter
+
+import "testing"
+
+func TestByteRangesAdd(t *testing.T) {
+	var ranges ByteRanges
+	ranges = ranges.Add(100, 200)
+	ranges = ranges.Add(300, 400)
+	ranges = ranges.Add(0, 50)
+	ranges = ranges.Add(200, 250) // примыкает слева
+	ranges = ranges.Add(290, 300) // примыкает справа
+	ranges = ranges.Add(10, 20)   // внутри
+	ranges = ranges.Add(5, 5)     // пустой
+
+	if encoded := ranges.String(); encoded != "0-50,100-250,290-400" {
+		t.Fatalf("Unexpected ranges: %v", encoded)
+	}
+
+	ranges = ranges.Add(40, 295)
+	if encoded := ranges.String(); encoded != "0-400" {
+		t.Errorf("Expected all ranges merged, got %v", encoded)
+	}
+}
+
+func TestParseByteRanges(t *testing.T) {
+	ranges, err := ParseByteRanges("300-400,0-50,40-100")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if encoded := ranges.String(); encoded != "0-100,300-400" {
+		t.Errorf("Unexpected ranges: %v", encoded)
+	}
+	if _, err := ParseByteRanges("0-10,abc"); err == nil {
+		t.Errorf("Expected error for malformed ranges")
+	}
+	if ranges, err := ParseByteRanges(""); err != nil || len(ranges) != 0 {
+		t.Errorf("Expected empty ranges, got %v %v", ranges, err)
+	}
+}
@@ -4,7 +4,6 @@ import (
 	"os"
 	"path"
 
-	"torrentClient/db"
 	"torrentClient/parser/env"
 
 	"github.com/sirupsen/logrus"
@@ -34,6 +33,10 @@ func (w *FsWriter) StartWaitingForData() {
 	}
 }
 
+func (w *FsWriter) QueueLength() int {
+	return len(w.DataChan)
+}
+
 func (w *FsWriter) AddToWriteQue(fileName string, data []byte, offset int64) {
 	task := WriteTask{Data: data, Offset: offset, FileName: fileName}
 
@@ -63,11 +66,11 @@ func (w *FsWriter) WriteDataToFile(fileName string, data []byte, offset int64) e
 	defer file.Close()
 	dataLen := len(data)
 
-	db.GetLoadedStateDb().AddSliceIndexForFile(fileName, offset, offset + int64(dataLen))
-
 	if _, err := file.WriteAt(data, offset); err != nil {
-		logrus.Error("Error writing to file: %v", err)
+		logrus.Errorf("Error writing to file: %v", err)
+		return err
 	}
+	markCompleted(fileName, offset, offset + int64(dataLen))
 
 	logrus.Debugf("Wrote %v bytes to file %v starting from %v", dataLen, fileName, offset)
 	return nil
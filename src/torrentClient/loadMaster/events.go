@@ -0,0 +1,148 @@
+package loadMaster
+
+import (
+	"context"
+	"time"
+)
+
+const (
+	EventPieceDone    = "piece_done"
+	EventPeersChanged = "peers_changed"
+	EventProgress     = "progress"
+	EventCompleted    = "completed"
+	EventFailed       = "failed"
+)
+
+const subscriberBufferSize = 64
+
+type LoadEvent struct {
+	Type         string  `json:"type"`
+	PieceIdx     int     `json:"pieceIdx"`
+	ActivePeers  int     `json:"activePeers"`
+	DonePieces   int     `json:"donePieces"`
+	TotalPieces  int     `json:"totalPieces"`
+	DownloadRate float64 `json:"downloadRate"`
+	Eta          int64   `json:"eta"`
+	Error        string  `json:"error,omitempty"`
+}
+
+// Subscribe returns a channel with load events and a func to stop receiving them.
+// Slow subscribers lose events instead of blocking the download, the periodic
+// progress event is enough to catch up.
+func (l *LoadEntry) Subscribe() (<- chan LoadEvent, func()) {
+	events := make(chan LoadEvent, subscriberBufferSize)
+
+	l.mu.Lock()
+	if l.finalEvent != nil {
+		events <- *l.finalEvent
+		close(events)
+		l.mu.Unlock()
+		return events, func() {}
+	}
+	l.subscribers[events] = struct{}{}
+	l.mu.Unlock()
+
+	unsubscribe := func() {
+		l.mu.Lock()
+		if _, exists := l.subscribers[events]; exists {
+			delete(l.subscribers, events)
+			close(events)
+		}
+		l.mu.Unlock()
+	}
+	return events, unsubscribe
+}
+
+// Finish notifies subscribers that the load is over and closes their channels
+func (l *LoadEntry) Finish(loadErr error) {
+	event := l.makeEvent(EventCompleted)
+	if loadErr != nil {
+		event.Type = EventFailed
+		event.Error = loadErr.Error()
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.finalEvent != nil {
+		return
+	}
+	l.finalEvent = &event
+	for sub := range l.subscribers {
+		select {
+		case sub <- event:
+		default:
+		}
+		close(sub)
+		delete(l.subscribers, sub)
+	}
+}
+
+func (l *LoadEntry) IsFinished() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.finalEvent != nil
+}
+
+func (l *LoadEntry) StartProgressReporting(ctx context.Context) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <- ctx.Done():
+			return
+		case <- ticker.C:
+			if l.hasSubscribers() {
+				l.publish(l.makeEvent(EventProgress))
+			}
+		}
+	}
+}
+
+func (l *LoadEntry) MakeProgressEvent() LoadEvent {
+	return l.makeEvent(EventProgress)
+}
+
+func (l *LoadEntry) makeEvent(eventType string) LoadEvent {
+	done := l.CountDone()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	rate := l.downloadRate.rate(time.Now())
+	return LoadEvent{
+		Type:         eventType,
+		ActivePeers:  l.NumOfActivePeers,
+		DonePieces:   done,
+		TotalPieces:  l.totalPieces,
+		DownloadRate: rate,
+		Eta:          l.eta(done, rate),
+	}
+}
+
+func (l *LoadEntry) eta(done int, rate float64) int64 {
+	if rate <= 0 || l.totalPieces == 0 {
+		return -1
+	}
+	leftBytes := float64(l.totalPieces - done) * float64(l.totalLength) / float64(l.totalPieces)
+	return int64(leftBytes / rate)
+}
+
+func (l *LoadEntry) hasSubscribers() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.subscribers) > 0
+}
+
+func (l *LoadEntry) publish(event LoadEvent) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for sub := range l.subscribers {
+		select {
+		case sub <- event:
+		default:
+		}
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"sync"
+	"time"
 
 	"github.com/sirupsen/logrus"
 )
@@ -28,13 +29,29 @@ type LoadEntry struct {
 	
 	DonePieces []int
 	InProgressPieces []int
+
+	totalLength     int
+	downloadedBytes int64
+	uploadedBytes   int64
+	wastedBytes     int64
+	downloadRate    rateCounter
+	uploadRate      rateCounter
+	peers           map[string]*PeerStat
+	subscribers     map[chan LoadEvent]struct{}
+	finalEvent      *LoadEvent
 }
 
 type LoadStat struct {
 	NumOfActivePeers	int
 	TotalPieces		int
+	LoadedPercent	int
 	DonePieces []int
 	InProgressPieces []int
+	DownloadRate	float64
+	UploadRate	float64
+	DownloadedBytes	int64
+	WastedBytes	int64
+	Eta	int64
 }
 
 func (m *LoadsMaster) Init() {
@@ -43,7 +60,7 @@ func (m *LoadsMaster) Init() {
 	m.mu.Unlock()
 }
 
-func (m *LoadsMaster) AddLoadEntry(fileId string, ctxCancel context.CancelFunc, totalPieces int) (*LoadEntry, bool) {
+func (m *LoadsMaster) AddLoadEntry(fileId string, ctxCancel context.CancelFunc, totalPieces int, totalLength int) (*LoadEntry, bool) {
 	m.mu.Lock()
 	_, exists := m.loads[fileId]
 	m.mu.Unlock()
@@ -56,6 +73,9 @@ func (m *LoadsMaster) AddLoadEntry(fileId string, ctxCancel context.CancelFunc,
 		ExecutionCtxCancel: ctxCancel,
 		totalPieces:        totalPieces,
 		ProcessedPieces:    make(map[int]bool, totalPieces),
+		totalLength:        totalLength,
+		peers:              make(map[string]*PeerStat),
+		subscribers:        make(map[chan LoadEvent]struct{}),
 	}
 	logrus.Debugf("Added load entry for %v", fileId)
 
@@ -83,6 +103,30 @@ func (m *LoadsMaster) StopLoad(fileId string) bool {
 	}
 }
 
+func (m *LoadsMaster) GetEntry(fileId string) (*LoadEntry, bool) {
+	m.mu.Lock()
+	entry, exists := m.loads[fileId]
+	m.mu.Unlock()
+
+	return entry, exists
+}
+
+func (m *LoadsMaster) CountActiveLoads() (count int) {
+	m.mu.Lock()
+	entries := make([]*LoadEntry, 0, len(m.loads))
+	for _, entry := range m.loads {
+		entries = append(entries, entry)
+	}
+	m.mu.Unlock()
+
+	for _, entry := range entries {
+		if !entry.IsFinished() {
+			count++
+		}
+	}
+	return count
+}
+
 func (m *LoadsMaster) GetStatsForEntry(fileId string) (result LoadStat, ok bool) {
 	logrus.Debugf("Getting stats for %v", fileId)
 	m.mu.Lock()
@@ -90,27 +134,40 @@ func (m *LoadsMaster) GetStatsForEntry(fileId string) (result LoadStat, ok bool)
 	m.mu.Unlock()
 
 	if exists {
-		logrus.Debugf("fuck1 stats for %v", fileId)
 		totalPieces := entry.TotalPieces()
-		logrus.Debugf("fuck2 stats for %v", fileId)
 		nDone := entry.GetLoadedPieces()
-		logrus.Debugf("fuck3 stats for %v", fileId)
 		inProgress := entry.GetInProgressPieces()
+		progress := entry.MakeProgressEvent()
+
+		entry.mu.Lock()
+		uploadRate := entry.uploadRate.rate(time.Now())
+		downloaded := entry.downloadedBytes
+		wasted := entry.wastedBytes
+		entry.mu.Unlock()
 
 		result, ok = LoadStat{
-			NumOfActivePeers: entry.NumOfActivePeers, 
+			NumOfActivePeers: entry.GetNumOfActivePeers(),
 			DonePieces:       nDone,
 			InProgressPieces: inProgress,
-			TotalPieces:      totalPieces}, true
+			TotalPieces:      totalPieces,
+			LoadedPercent:    entry.GetLoadedPercent(),
+			DownloadRate:     progress.DownloadRate,
+			UploadRate:       uploadRate,
+			DownloadedBytes:  downloaded,
+			WastedBytes:      wasted,
+			Eta:              progress.Eta}, true
 	}
 	return result, ok
 }
 
 func (l *LoadEntry) GetLoadedPercent() int {
-	done := float64(l.CountDone())
-	total := float64(l.TotalPieces())
+	done := l.CountDone()
+	total := l.TotalPieces()
+	if total == 0 {
+		return 0
+	}
 
-	return int(done / total) * 100
+	return done * 100 / total
 }
 
 func (l *LoadEntry) GetLoadedPieces() (res []int) {
@@ -151,7 +208,6 @@ func (l *LoadEntry) GetNumOfActivePeers() (res int) {
 
 func (l *LoadEntry) CountDone() (count int) {
 	l.mu.Lock()
-	l.GetInProgressPieces()
 	defer l.mu.Unlock()
 
 	for _, v := range l.ProcessedPieces {
@@ -176,10 +232,15 @@ func (l *LoadEntry) SetDone(idx int) (err error) {
 		l.ProcessedPieces[idx] = true
 		l.mu.Unlock()
 		err = nil
+
+		event := l.makeEvent(EventPieceDone)
+		event.PieceIdx = idx
+		l.publish(event)
 	}
 	return err
 }
 
+
 func (l *LoadEntry) ForceSetDone(idx int) {
 	l.mu.Lock()
 	l.ProcessedPieces[idx] = true
@@ -238,11 +299,15 @@ func (l *LoadEntry) IncrActivePeers() {
 	l.mu.Lock()
 	l.NumOfActivePeers ++
 	l.mu.Unlock()
+
+	l.publish(l.makeEvent(EventPeersChanged))
 }
 
 func (l *LoadEntry) DecrActivePeers() {
 	l.mu.Lock()
 	l.NumOfActivePeers --
 	l.mu.Unlock()
+
+	l.publish(l.makeEvent(EventPeersChanged))
 }
 
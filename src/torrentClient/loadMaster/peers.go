@@ -0,0 +1,112 @@
+package loadMaster
+
+import (
+	"sort"
+	"time"
+)
+
+type PeerStat struct {
+	Addr              string    `json:"addr"`
+	Client            string    `json:"client"`
+	PeerChoking       bool      `json:"peerChoking"`
+	PeerInterested    bool      `json:"peerInterested"`
+	PiecesContributed int       `json:"piecesContributed"`
+	DownloadedBytes   int64     `json:"downloadedBytes"`
+	UploadedBytes     int64     `json:"uploadedBytes"`
+	WastedBytes       int64     `json:"wastedBytes"`
+	DownloadRate      float64   `json:"downloadRate"`
+	UploadRate        float64   `json:"uploadRate"`
+	ConnectedAt       time.Time `json:"connectedAt"`
+
+	downloadRate rateCounter
+	uploadRate   rateCounter
+}
+
+func (l *LoadEntry) RegisterPeer(addr, clientName string) {
+	l.mu.Lock()
+	l.peers[addr] = &PeerStat{
+		Addr:        addr,
+		Client:      clientName,
+		PeerChoking: true,
+		ConnectedAt: time.Now(),
+	}
+	l.mu.Unlock()
+}
+
+func (l *LoadEntry) RemovePeer(addr string) {
+	l.mu.Lock()
+	delete(l.peers, addr)
+	l.mu.Unlock()
+}
+
+func (l *LoadEntry) SetPeerState(addr string, choking, interested bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if peer, exists := l.peers[addr]; exists {
+		peer.PeerChoking = choking
+		peer.PeerInterested = interested
+	}
+}
+
+// AddPeerDownloaded accounts a verified piece loaded from the peer
+func (l *LoadEntry) AddPeerDownloaded(addr string, n int) {
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.downloadedBytes += int64(n)
+	l.downloadRate.add(int64(n), now)
+	if peer, exists := l.peers[addr]; exists {
+		peer.PiecesContributed++
+		peer.DownloadedBytes += int64(n)
+		peer.downloadRate.add(int64(n), now)
+	}
+}
+
+// AddPeerUploaded accounts bytes sent to the peer. We don't seed from
+// this service, so it is protocol traffic only (requests, haves, etc.)
+func (l *LoadEntry) AddPeerUploaded(addr string, n int) {
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.uploadedBytes += int64(n)
+	l.uploadRate.add(int64(n), now)
+	if peer, exists := l.peers[addr]; exists {
+		peer.UploadedBytes += int64(n)
+		peer.uploadRate.add(int64(n), now)
+	}
+}
+
+// AddWastedBytes accounts a piece that failed the hash check
+func (l *LoadEntry) AddWastedBytes(addr string, n int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.wastedBytes += int64(n)
+	if peer, exists := l.peers[addr]; exists {
+		peer.WastedBytes += int64(n)
+	}
+}
+
+func (l *LoadEntry) GetPeersStats() []PeerStat {
+	now := time.Now()
+
+	l.mu.Lock()
+	res := make([]PeerStat, 0, len(l.peers))
+	for _, peer := range l.peers {
+		stat := *peer
+		stat.DownloadRate = peer.downloadRate.rate(now)
+		stat.UploadRate = peer.uploadRate.rate(now)
+		res = append(res, stat)
+	}
+	l.mu.Unlock()
+
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].DownloadRate > res[j].DownloadRate
+	})
+	return res
+}
@@ -0,0 +1,36 @@
+package loadMaster
+
+import "time"
+
+const rateWindowSeconds = 10
+
+// rateCounter keeps per-second byte counts for the last rateWindowSeconds
+// seconds. It is not thread safe, LoadEntry guards it with its own mutex.
+type rateCounter struct {
+	buckets [rateWindowSeconds]int64
+	seconds [rateWindowSeconds]int64
+}
+
+func (r *rateCounter) add(n int64, now time.Time) {
+	sec := now.Unix()
+	idx := sec % rateWindowSeconds
+
+	if r.seconds[idx] != sec {
+		r.seconds[idx] = sec
+		r.buckets[idx] = 0
+	}
+	r.buckets[idx] += n
+}
+
+// rate returns bytes per second averaged over the window
+func (r *rateCounter) rate(now time.Time) float64 {
+	sec := now.Unix()
+	var sum int64
+
+	for i := range r.buckets {
+		if sec - r.seconds[i] < rateWindowSeconds {
+			sum += r.buckets[i]
+		}
+	}
+	return float64(sum) / rateWindowSeconds
+}
@@ -14,10 +14,11 @@ const (
 	address = "magnet-converter:50051"
 )
 
-func ConvertMagnetToTorrent(magnet string) []byte {
+func ConvertMagnetToTorrent(magnet string) ([]byte, error) {
 	conn, err := grpc.Dial(address, grpc.WithInsecure())
 	if err != nil {
-		logrus.Fatalf("did not connect: %v", err)
+		logrus.Errorf("did not connect: %v", err)
+		return nil, err
 	}
 	defer conn.Close()
 	c := pb.NewMagnet2TorrentClient(conn)
@@ -26,7 +27,8 @@ func ConvertMagnetToTorrent(magnet string) []byte {
 	defer cancel()
 	r, err := c.Magnet2Torrent(ctx, &pb.Magnet2TorrentRequest{Magnet: magnet})
 	if err != nil {
-		logrus.Fatalf("could not load torrent err=%s", err)
+		logrus.Errorf("could not load torrent err=%s", err)
+		return nil, err
 	}
-	return r.GetTorrent()
+	return r.GetTorrent(), nil
 }
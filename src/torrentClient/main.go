@@ -5,6 +5,7 @@ import (
 	"torrentClient/eraser"
 	"torrentClient/fsWriter"
 	"torrentClient/loadMaster"
+	"torrentClient/metrics"
 	"torrentClient/parser/env"
 	"torrentClient/server"
 	"torrentClient/torrentfile"
@@ -30,6 +31,7 @@ func main() {
 	}
 
 	loadMaster.GetMaster().Init()
+	registerGaugeMetrics()
 
 	go fsWriter.GetWriter().StartWaitingForData()
 
@@ -38,6 +40,17 @@ func main() {
 	server.Start()
 }
 
+func registerGaugeMetrics() {
+	metrics.NewGaugeFunc(
+		"hypertube_loader_active_loads",
+		"Loads which are still in progress",
+		func() float64 { return float64(loadMaster.GetMaster().CountActiveLoads()) })
+	metrics.NewGaugeFunc(
+		"hypertube_loader_fs_writer_queue_length",
+		"Write tasks waiting in fsWriter queue",
+		func() float64 { return float64(fsWriter.GetWriter().QueueLength()) })
+}
+
 func restartInProgressLoads()  {
 	fileIds, ok := db.GetFilesManagerDb().GetInProgressFileIds()
 	if !ok {
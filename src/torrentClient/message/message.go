@@ -65,6 +65,14 @@ func (m *Message) Serialize() []byte {
 	return buf
 }
 
+// WireLength returns size of the serialized message including the length prefix
+func (m *Message) WireLength() int {
+	if m == nil { // keep-alive
+		return 4
+	}
+	return 4 + 1 + len(m.Payload)
+}
+
 func Read(r io.Reader) (*Message, error) {
 	lengthBuf := make([]byte, 4)
 	_, err := io.ReadFull(r, lengthBuf)
@@ -0,0 +1,28 @@
+package metrics
+
+var (
+	ConnectedPeers = NewGauge(
+		"hypertube_loader_connected_peers",
+		"Peers with running download workers")
+	HalfOpenPeers = NewGauge(
+		"hypertube_loader_half_open_peers",
+		"Peers in handshake or waiting for unchoke")
+	BytesIn = NewCounter(
+		"hypertube_loader_peer_bytes_in_total",
+		"Bytes received from peers")
+	BytesOut = NewCounter(
+		"hypertube_loader_peer_bytes_out_total",
+		"Bytes sent to peers")
+	PieceHashFailures = NewCounter(
+		"hypertube_loader_piece_hash_failures_total",
+		"Pieces which failed the integrity check")
+	TrackerResponseSeconds = NewHistogram(
+		"hypertube_loader_tracker_response_seconds",
+		"Tracker announce latency",
+		[]float64{.05, .1, .25, .5, 1, 2.5, 5, 10, 15},
+		"tracker")
+	TrackerErrors = NewCounter(
+		"hypertube_loader_tracker_errors_total",
+		"Failed tracker announces",
+		"tracker")
+)
@@ -0,0 +1,72 @@
+package metrics
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandlerOutput(t *testing.T) {
+	counter := NewCounter("test_requests_total", "Test requests", "code")
+	counter.Inc("200")
+	counter.Add(2, "500")
+
+	histogram := NewHistogram("test_latency_seconds", "Test latency", []float64{0.1, 1})
+	histogram.Observe(0.5)
+
+	rec := httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := io.ReadAll(rec.Body)
+
+	expected := []string{
+		"# TYPE test_requests_total counter",
+		`test_requests_total{code="200"} 1`,
+		`test_requests_total{code="500"} 2`,
+		`test_latency_seconds_bucket{le="0.1"} 0`,
+		`test_latency_seconds_bucket{le="1"} 1`,
+		`test_latency_seconds_bucket{le="+Inf"} 1`,
+		"test_latency_seconds_sum 0.5",
+		"test_latency_seconds_count 1",
+	}
+	for _, line := range expected {
+		if !strings.Contains(string(body), line + "\n") {
+			t.Errorf("Line %q not found in output:\n%s", line, body)
+		}
+	}
+}
+
+func TestWrongLabelsDropped(t *testing.T) {
+	counter := NewCounter("test_dropped_total", "Test dropped samples", "code")
+	histogram := NewHistogram("test_dropped_seconds", "Test dropped samples", []float64{1}, "code")
+
+	counter.Inc()
+	counter.Inc("200", "extra")
+	histogram.Observe(0.5)
+	counter.Inc("200")
+
+	rec := httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := io.ReadAll(rec.Body)
+
+	if !strings.Contains(string(body), `test_dropped_total{code="200"} 1`+"\n") {
+		t.Errorf("Valid sample not found in output:\n%s", body)
+	}
+	if strings.Contains(string(body), "test_dropped_seconds_count") || strings.Count(string(body), "test_dropped_total{") != 1 {
+		t.Errorf("Samples with wrong labels are written:\n%s", body)
+	}
+}
+
+func TestLabelValuesEscaped(t *testing.T) {
+	counter := NewCounter("test_escaped_total", "Test escaped labels", "tracker")
+	counter.Inc("a\\b\"c\nd — é")
+
+	rec := httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := io.ReadAll(rec.Body)
+
+	// в текстовом формате экранируются только \\, \" и перевод строки
+	if !strings.Contains(string(body), `test_escaped_total{tracker="a\\b\"c\nd — é"} 1`+"\n") {
+		t.Errorf("Label is not escaped by the text format:\n%s", body)
+	}
+}
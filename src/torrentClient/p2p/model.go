@@ -22,6 +22,9 @@ type TorrentMeta struct {
 	FileId                   string
 	ResultsChan              chan LoadedPiece
 	LoadStats				*loadMaster.LoadEntry
+	WantedPieces			[]bool // nil - нужны все куски
+	StartPiece				int // первый кусок видео, с него начинаем качать
+	PrefetchPieces			<- chan []int // куски индекса контейнера, качаются вне очереди
 }
 
 type LoadedPiece struct {
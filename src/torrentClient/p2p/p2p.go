@@ -12,6 +12,7 @@ import (
 	"torrentClient/fsWriter"
 	"torrentClient/limiter"
 	"torrentClient/message"
+	"torrentClient/metrics"
 
 	"github.com/sirupsen/logrus"
 )
@@ -33,6 +34,10 @@ func (piece *pieceProgress) readMessage() (error, error) {
 		logrus.Infof("Got UNCHOKE from %v", piece.client.GetShortInfo())
 	case message.MsgChoke:
 		piece.client.Choked = true
+	case message.MsgInterested:
+		piece.client.PeerInterested = true
+	case message.MsgNotInterested:
+		piece.client.PeerInterested = false
 	case message.MsgHave:
 		index, err := message.ParseHave(msg)
 		if err != nil {
@@ -119,6 +124,7 @@ func checkIntegrity(pw *pieceWork, buf []byte) error {
 
 	hash := sha1.Sum(buf)
 	if !bytes.Equal(hash[:], pw.hash[:]) {
+		metrics.PieceHashFailures.Inc()
 		fsWriter.GetWriter().DataChan <- fsWriter.WriteTask{FileName: fmt.Sprintf("piece_%d_failed_buf", pw.index), Data: buf}
 		if pw.progress != nil {
 			fsWriter.GetWriter().DataChan <- fsWriter.WriteTask{FileName: fmt.Sprintf("piece_%d_failed_prog", pw.index), Data: pw.progress.buf}
@@ -156,6 +162,9 @@ func (t *TorrentMeta) startDownloadWorker(ctx context.Context, c *client.Client,
 				continue
 			}
 			loadState, err := attemptDownloadPiece(c, pw)
+			peerAddr := c.Peer.GetAddr()
+			t.LoadStats.SetPeerState(peerAddr, c.Choked, c.PeerInterested)
+			t.LoadStats.AddPeerUploaded(peerAddr, c.PopWrittenBytes())
 			if err != nil {
 				if deleteErr := t.LoadStats.DeleteProcessed(pw.index); deleteErr != nil {
 					logrus.Errorf("%v Failed to delete piece idx=%v from processed: %v", err, pw.index, deleteErr)
@@ -169,6 +178,7 @@ func (t *TorrentMeta) startDownloadWorker(ctx context.Context, c *client.Client,
 			err = checkIntegrity(pw, loadState.buf)
 			if err != nil {
 				logrus.Errorf("Piece hash check err: %v", err)
+				t.LoadStats.AddWastedBytes(peerAddr, pw.length)
 				if deleteErr := t.LoadStats.DeleteProcessed(pw.index); deleteErr != nil {
 					logrus.Errorf("%v Failed to delete piece idx=%v from processed: %v", err, pw.index, deleteErr)
 					return deleteErr
@@ -178,6 +188,7 @@ func (t *TorrentMeta) startDownloadWorker(ctx context.Context, c *client.Client,
 				continue
 			}
 
+			t.LoadStats.AddPeerDownloaded(peerAddr, pw.length)
 			if setErr := t.LoadStats.SetDone(pw.index); setErr != nil {
 				logrus.Errorf("Failed to set piece idx=%v as done: %v", setErr, pw.index)
 			}
@@ -202,6 +213,10 @@ func (t *TorrentMeta) calculatePieceSize(index int) int {
 	return end - begin
 }
 
+func (t *TorrentMeta) isPieceWanted(index int) bool {
+	return t.WantedPieces == nil || (index < len(t.WantedPieces) && t.WantedPieces[index])
+}
+
 func (t *TorrentMeta) Download(ctx context.Context) error {
 	logrus.Infof("starting download %v parts, file.len=%v, p.length=%v for %v",
 		len(t.PieceHashes), t.Length, t.PieceLength, t.Name)
@@ -212,11 +227,19 @@ func (t *TorrentMeta) Download(ctx context.Context) error {
 	priorityManager := prioritySorter{
 		Pieces: make([]*pieceWork, 0, len(t.PieceHashes)),
 		PriorityUpdates: t.PieceLoadPriorityUpdates,
+		Prefetch: t.PrefetchPieces,
+		StartIdx: t.StartPiece,
 	}
 
 	done := 0
+	total := 0
 
 	for index, hash := range t.PieceHashes {
+		if !t.isPieceWanted(index) {
+			continue
+		}
+		total++
+
 		length := t.calculatePieceSize(index)
 		piece := &pieceWork{index, hash, length, nil}
 
@@ -266,11 +289,15 @@ func (t *TorrentMeta) Download(ctx context.Context) error {
 				limiterObj.Add()
 				go func(workerClient *client.Client) {
 					logrus.Debugf("Starting worker. Total workers=%d of %v", limiterObj.GetVal(), maxWorkers)
+					t.LoadStats.RegisterPeer(workerClient.Peer.GetAddr(), workerClient.GetClientName())
 					t.LoadStats.IncrActivePeers()
+					metrics.ConnectedPeers.Inc()
 					if err := t.startDownloadWorker(ctx, workerClient, topPriorityPieceChan, results, recyclePiecesChan); err != nil {
 						logrus.Errorf("Throwing dead peer %v cause err: %v", workerClient.GetShortInfo(), err)
+						t.LoadStats.RemovePeer(workerClient.Peer.GetAddr())
 						t.DeadPeersChan <- workerClient
 						t.LoadStats.DecrActivePeers()
+						metrics.ConnectedPeers.Dec()
 						limiterObj.Pop()
 					}
 				}(activeClient)
@@ -278,7 +305,7 @@ func (t *TorrentMeta) Download(ctx context.Context) error {
 		}
 	}()
 
-	for done < len(t.PieceHashes) {
+	for done < total {
 		select {
 		case <- ctx.Done():
 			logrus.Debugf("Got DONE in Download, exiting")
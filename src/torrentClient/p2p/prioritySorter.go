@@ -12,16 +12,19 @@ type prioritySorter struct {
 	mu sync.Mutex
 	Pieces	[]*pieceWork
 	PriorityUpdates	<- chan int
+	Prefetch	<- chan []int
+	StartIdx	int
 
 	topPiece *pieceWork
 	topPieceIdx	int
+	prefetch	[]int // отдаются раньше всех, пока не кончатся
 }
 
 func (s *prioritySorter) InitSorter(ctx context.Context) (topPriorityPieceChan, returnedPiecesChan chan *pieceWork) {
 	topPriorityPieceChan = make(chan *pieceWork)
 	returnedPiecesChan = make(chan *pieceWork, 50)
 
-	s.UpdateTopPieceIndex(0)
+	s.UpdateTopPieceIndex(s.StartIdx)
 	s.RecalculateTopPiece()
 	
 	go func() {
@@ -37,6 +40,10 @@ func (s *prioritySorter) InitSorter(ctx context.Context) (topPriorityPieceChan,
 				s.RecalculateTopPiece()
 				logrus.Infof("Priority que after priority update new=%v:", newTopIdx)
 				//s.PrintPieces()
+			case prefetch := <- s.Prefetch:
+				s.AddPrefetch(prefetch)
+				s.RecalculateTopPiece()
+				logrus.Infof("Priority que after prefetch of %v pieces", len(prefetch))
 			case returnedPiece := <- returnedPiecesChan: // нам вернули часть, которую не получилось скачать
 				s.InsertPieceByIdx(returnedPiece)
 				s.RecalculateTopPiece()
@@ -68,7 +75,37 @@ func (s *prioritySorter) UpdateTopPieceIndex(newTopIdx int) {
 	s.mu.Unlock()
 }
 
+// AddPrefetch puts pieces ahead of the sequential order and priority updates,
+// the player can't start without the container index anyway
+func (s *prioritySorter) AddPrefetch(pieces []int) {
+	s.mu.Lock()
+	s.prefetch = append(s.prefetch, pieces...)
+	s.mu.Unlock()
+}
+
+// nextPrefetchPiece returns the first prefetch piece which is not given yet,
+// given ones are forgotten
+func (s *prioritySorter) nextPrefetchPiece() *pieceWork {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for len(s.prefetch) > 0 {
+		if piece, distance := s.findClosestPiece(s.Pieces, s.prefetch[0]); piece != nil && distance == 0 {
+			return piece
+		}
+		s.prefetch = s.prefetch[1:]
+	}
+	return nil
+}
+
 func (s *prioritySorter) RecalculateTopPiece() {
+	if prefetchPiece := s.nextPrefetchPiece(); prefetchPiece != nil {
+		s.mu.Lock()
+		s.topPiece = prefetchPiece
+		s.mu.Unlock()
+		return
+	}
+
 	s.mu.Lock()
 	pieces := s.Pieces
 	topIdx := s.topPieceIdx
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"strconv"
+	"strings"
 
 	"github.com/sirupsen/logrus"
 )
@@ -44,6 +45,41 @@ func (p *Parser) GetTorrentPeerPort() uint16 {
 	}
 }
 
+// GetFilesDiskBudget returns max size of FILES_DIR in bytes, 0 means no limit.
+// Accepts plain bytes or K, M, G, T suffixes: "500G", "1T"
+func (p *Parser) GetFilesDiskBudget() int64 {
+	src := strings.ToUpper(strings.TrimSpace(os.Getenv("FILES_DISK_BUDGET")))
+	if src == "" {
+		return 0
+	}
+
+	multiplier := int64(1)
+	suffixes := map[string]int64{"K": 1 << 10, "M": 1 << 20, "G": 1 << 30, "T": 1 << 40}
+	for suffix, value := range suffixes {
+		if strings.HasSuffix(src, suffix) {
+			multiplier = value
+			src = strings.TrimSuffix(src, suffix)
+			break
+		}
+	}
+
+	budget, err := strconv.ParseInt(src, 10, 64)
+	if err != nil || budget < 0 {
+		logrus.Errorf("Error parsing files disk budget: %v; src: %v", err, os.Getenv("FILES_DISK_BUDGET"))
+		return 0
+	}
+	return budget * multiplier
+}
+
+func (p *Parser) IsEraserDryRun() bool {
+	return os.Getenv("ERASER_DRY_RUN") == "true"
+}
+
+// GetSeedSourceDir returns the only dir local media can be turned into torrents from
+func (p *Parser) GetSeedSourceDir() string {
+	return os.Getenv("SEED_SOURCE_DIR")
+}
+
 func (p *Parser) GetPostgresDbDsn() string {
 	return fmt.Sprintf(
 		"host=%v port=%v user=%v password=%v dbname=%v sslmode=disable",
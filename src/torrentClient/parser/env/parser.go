@@ -17,6 +17,9 @@ type Parser interface {
 	GetPostgresDbDsn() string
 	DoRestartInProgressLoads() bool
 	GetTorrentPeerPort() uint16
+	GetFilesDiskBudget() int64
+	IsEraserDryRun() bool
+	GetSeedSourceDir() string
 }
 
 func GetParser() Parser {
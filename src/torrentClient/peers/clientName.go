@@ -0,0 +1,92 @@
+package peers
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Azureus-style ids look like "-qB4250-<random>"
+var azureusClients = map[string]string{
+	"AG": "Ares",
+	"AZ": "Vuze",
+	"BC": "BitComet",
+	"BI": "BiglyBT",
+	"BT": "BitTorrent",
+	"DE": "Deluge",
+	"FD": "Free Download Manager",
+	"FW": "FrostWire",
+	"KT": "KTorrent",
+	"LT": "libtorrent",
+	"lt": "libTorrent (rakshasa)",
+	"qB": "qBittorrent",
+	"SD": "Thunder",
+	"TR": "Transmission",
+	"TX": "Tixati",
+	"UM": "uTorrent for Mac",
+	"UT": "uTorrent",
+	"UW": "uTorrent Web",
+	"WW": "WebTorrent",
+	"XL": "Xunlei",
+}
+
+// Shadow-style ids start with a single letter followed by the version
+var shadowClients = map[byte]string{
+	'A': "ABC",
+	'M': "Mainline",
+	'O': "Osprey Permaseed",
+	'Q': "BTQueue",
+	'R': "Tribler",
+	'S': "Shadow",
+	'T': "BitTornado",
+	'U': "UPnP NAT Bit Torrent",
+}
+
+func DecodeClientName(peerId [20]byte) string {
+	id := peerId[:]
+
+	if id[0] == '-' && id[7] == '-' {
+		code := string(id[1:3])
+		name, known := azureusClients[code]
+		if !known {
+			name = "unknown " + printable(id[1:3])
+		}
+		return fmt.Sprintf("%s %s", name, strings.Join(versionParts(id[3:7]), "."))
+	}
+
+	if name, known := shadowClients[id[0]]; known {
+		version := strings.Trim(strings.ReplaceAll(printable(id[1:8]), "-", "."), ".")
+		if len(version) > 0 {
+			return fmt.Sprintf("%s %s", name, version)
+		}
+		return name
+	}
+
+	return fmt.Sprintf("unknown (%s)", printable(id[:8]))
+}
+
+func versionParts(src []byte) []string {
+	parts := make([]string, 0, len(src))
+	for _, b := range src {
+		switch {
+		case b >= '0' && b <= '9':
+			parts = append(parts, string(b))
+		case b >= 'A' && b <= 'Z':
+			parts = append(parts, fmt.Sprint(int(b - 'A') + 10))
+		case b >= 'a' && b <= 'z':
+			parts = append(parts, fmt.Sprint(int(b - 'a') + 36))
+		}
+	}
+	return parts
+}
+
+func printable(src []byte) string {
+	res := make([]byte, len(src))
+	for i, b := range src {
+		if b >= 0x20 && b < 0x7f {
+			res[i] = b
+		} else {
+			res[i] = '?'
+		}
+	}
+	return string(res)
+}
@@ -0,0 +1,22 @@
+package peers
+
+import "testing"
+
+func TestDecodeClientName(t *testing.T) {
+	cases := map[string]string{
+		"-qB4250-abcdefghijkl": "qBittorrent 4.2.5.0",
+		"-TR3000-abcdefghijkl": "Transmission 3.0.0.0",
+		"-ZZ1200-abcdefghijkl": "unknown ZZ 1.2.0.0",
+		"M4-3-6--abcdefghijkl": "Mainline 4.3.6",
+		"\x00\x01xxxxxxxxxxxxxxxxxx": "unknown (??xxxxxx)",
+	}
+
+	for src, expected := range cases {
+		var id [20]byte
+		copy(id[:], src)
+
+		if res := DecodeClientName(id); res != expected {
+			t.Errorf("DecodeClientName(%q) = %q, expected %q", src, res, expected)
+		}
+	}
+}
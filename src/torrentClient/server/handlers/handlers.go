@@ -2,19 +2,28 @@ package handlers
 
 import (
 	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"path/filepath"
 	"strings"
+	"time"
 
 	"torrentClient/db"
+	"torrentClient/eraser"
 	"torrentClient/loadMaster"
 	"torrentClient/magnetToTorrent"
+	"torrentClient/parser/env"
 	"torrentClient/torrentfile"
 
 	"github.com/gorilla/mux"
 	"github.com/sirupsen/logrus"
 )
 
+const maxTorrentUploadSize = 10 << 20
+
 func DownloadRequestsHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodGet {
 		fileId := mux.Vars(r)["file_id"]
@@ -64,12 +73,23 @@ func SubtitlesInfoHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodGet {
 		fileId := mux.Vars(r)["file_id"]
 
-		type SubtitleRecord struct {
-			Language string `json:"language"`
-			Id       string `json:"id"`
-			FileName	string	`json:"fileName"`
+		torrent, err := torrentfile.GetManager().LoadTorrentFileFromDB(fileId)
+		if err != nil || torrent == nil {
+			logrus.Errorf("Error loading torrent: %v", err)
+			SendFailResponseWithCode(w, fmt.Sprintf("Error loading torrent from db: %v", err), http.StatusBadRequest)
+			return
 		}
 
+		SendDataResponse(w, torrent.GetSubtitles())
+	}
+}
+
+// FilesInfoHandler lists all files of the torrent with media types and the
+// ordered list of episodes
+func FilesInfoHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		fileId := mux.Vars(r)["file_id"]
+
 		torrent, err := torrentfile.GetManager().LoadTorrentFileFromDB(fileId)
 		if err != nil || torrent == nil {
 			logrus.Errorf("Error loading torrent: %v", err)
@@ -77,19 +97,16 @@ func SubtitlesInfoHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		files := torrent.GetFiles()
-		result := make([]SubtitleRecord, 0, len(files))
-		for _, file := range files {
-			if file.Extension() == "srt" {
-				result = append(result, SubtitleRecord{
-					Id: file.EncodeFileName(),
-					Language: "unknown",
-					FileName: strings.Join(file.Path, "_"),
-				})
-			}
+		response := struct {
+			Files		[]torrentfile.FileEntry	`json:"files"`
+			Episodes	[]torrentfile.FileEntry	`json:"episodes"`
+		}{
+			Files:    torrent.GetFileEntries(),
+			Episodes: torrent.GetEpisodes(),
 		}
-
-		SendDataResponse(w, result)
+		SendDataResponse(w, response)
+	} else {
+		SendFailResponseWithCode(w, "Not allowed", http.StatusMethodNotAllowed)
 	}
 }
 
@@ -104,7 +121,12 @@ func WriteLoadedPartsHandler(w http.ResponseWriter, r *http.Request) {
 		}
 
 		if (torrentBytes == nil || len(torrentBytes) == 0) && len(magnetLink) > 0 {
-			torrentBytes = magnetToTorrent.ConvertMagnetToTorrent(magnetLink)
+			var err error
+			torrentBytes, err = magnetToTorrent.ConvertMagnetToTorrent(magnetLink)
+			if err != nil {
+				SendFailResponseWithCode(w, fmt.Sprintf("Error converting magnet: %v", err), http.StatusBadGateway)
+				return
+			}
 			logrus.Info("Converted! ", len(torrentBytes))
 		}
 
@@ -151,11 +173,21 @@ func LoadingStatsHandler(w http.ResponseWriter, r *http.Request) {
 				LoadedPercent	int `json:"loadedPercent"`
 				DonePieces	[]int `json:"donePieces"`
 				InProgressPieces	[]int `json:"inProgressPieces"`
+				DownloadRate	float64	`json:"downloadRate"`
+				UploadRate	float64	`json:"uploadRate"`
+				DownloadedBytes	int64	`json:"downloadedBytes"`
+				WastedBytes	int64	`json:"wastedBytes"`
+				Eta	int64	`json:"eta"`
 			}{
 				ActivePeers: stats.NumOfActivePeers,
-				LoadedPercent: len(stats.DonePieces) * 100 / stats.TotalPieces,
+				LoadedPercent: stats.LoadedPercent,
 				DonePieces: stats.DonePieces,
 				InProgressPieces: stats.InProgressPieces,
+				DownloadRate: stats.DownloadRate,
+				UploadRate: stats.UploadRate,
+				DownloadedBytes: stats.DownloadedBytes,
+				WastedBytes: stats.WastedBytes,
+				Eta: stats.Eta,
 			})
 		}
 	} else {
@@ -163,6 +195,241 @@ func LoadingStatsHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func PeersStatsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		fileId := mux.Vars(r)["file_id"]
+
+		entry, ok := loadMaster.GetMaster().GetEntry(fileId)
+		if !ok {
+			SendFailResponseWithCode(w, "Load not found", http.StatusBadRequest)
+			return
+		}
+		SendDataResponse(w, entry.GetPeersStats())
+	} else {
+		SendFailResponseWithCode(w, "Not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func LoadingEventsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		fileId := mux.Vars(r)["file_id"]
+
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			SendFailResponseWithCode(w, "Streaming is not supported", http.StatusInternalServerError)
+			return
+		}
+
+		_, isLoaded, _ := db.GetFilesManagerDb().GetFileStatus(fileId)
+		if isLoaded {
+			PrepareEventStream(w)
+			SendEvent(w, flusher, loadMaster.LoadEvent{Type: loadMaster.EventCompleted})
+			return
+		}
+
+		entry, ok := loadMaster.GetMaster().GetEntry(fileId)
+		if !ok {
+			SendFailResponseWithCode(w, "Load not found", http.StatusBadRequest)
+			return
+		}
+
+		events, unsubscribe := entry.Subscribe()
+		defer unsubscribe()
+
+		PrepareEventStream(w)
+		SendEvent(w, flusher, entry.MakeProgressEvent())
+
+		keepAlive := time.NewTicker(time.Second * 15)
+		defer keepAlive.Stop()
+
+		for {
+			select {
+			case <- r.Context().Done():
+				logrus.Debugf("Events client for %v disconnected", fileId)
+				return
+			case <- keepAlive.C:
+				SendKeepAliveComment(w, flusher)
+			case event, ok := <- events:
+				if !ok {
+					return
+				}
+				SendEvent(w, flusher, event)
+			}
+		}
+	} else {
+		SendFailResponseWithCode(w, "Not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func EvictionPlanHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		SendDataResponse(w, eraser.GetEraser().PlanEviction())
+	} else {
+		SendFailResponseWithCode(w, "Not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// readTorrentUpload reads one byte over the limit to tell a too big file from a
+// broken one
+func readTorrentUpload(w http.ResponseWriter, r io.Reader) ([]byte, bool) {
+	torrentBytes, err := io.ReadAll(io.LimitReader(r, maxTorrentUploadSize + 1))
+	if err != nil {
+		SendFailResponseWithCode(w, fmt.Sprintf("Error reading torrent: %v", err), http.StatusBadRequest)
+		return nil, false
+	}
+	if len(torrentBytes) > maxTorrentUploadSize {
+		SendFailResponseWithCode(w, fmt.Sprintf("Torrent file is larger than %v bytes", maxTorrentUploadSize), http.StatusRequestEntityTooLarge)
+		return nil, false
+	}
+	return torrentBytes, true
+}
+
+// AddTorrentHandler accepts .torrent as multipart "torrent" field or raw body,
+// or json with magnet or url
+func AddTorrentHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		var result *torrentfile.IngestResult
+		var err error
+
+		contentType := r.Header.Get("Content-Type")
+		switch {
+		case strings.HasPrefix(contentType, "multipart/form-data"):
+			// запас на поля формы и заголовки multipart, больше тела не читаем
+			maxBody := int64(maxTorrentUploadSize + 64 << 10)
+			if r.ContentLength > maxBody {
+				SendFailResponseWithCode(w, fmt.Sprintf("Torrent file is larger than %v bytes", maxTorrentUploadSize), http.StatusRequestEntityTooLarge)
+				return
+			}
+			r.Body = http.MaxBytesReader(w, r.Body, maxBody)
+			if formErr := r.ParseMultipartForm(maxBody); formErr != nil {
+				SendFailResponseWithCode(w, fmt.Sprintf("Invalid form: %v", formErr), http.StatusBadRequest)
+				return
+			}
+			file, _, formErr := r.FormFile("torrent")
+			if formErr != nil {
+				SendFailResponseWithCode(w, fmt.Sprintf("Error reading torrent field: %v", formErr), http.StatusBadRequest)
+				return
+			}
+			defer file.Close()
+
+			torrentBytes, ok := readTorrentUpload(w, file)
+			if !ok {
+				return
+			}
+			result, err = torrentfile.GetManager().AddTorrent(torrentBytes)
+		case strings.HasPrefix(contentType, "application/json"):
+			request := struct {
+				Magnet	string	`json:"magnet"`
+				Url		string	`json:"url"`
+			}{}
+			if decodeErr := json.NewDecoder(r.Body).Decode(&request); decodeErr != nil {
+				SendFailResponseWithCode(w, fmt.Sprintf("Error decoding body: %v", decodeErr), http.StatusBadRequest)
+				return
+			}
+
+			if request.Magnet != "" {
+				result, err = torrentfile.GetManager().AddMagnet(request.Magnet)
+			} else if request.Url != "" {
+				torrentBytes, fetchErr := torrentfile.GetManager().FetchTorrentFile(request.Url)
+				switch {
+				case errors.Is(fetchErr, torrentfile.ErrTorrentUrlNotAllowed):
+					SendFailResponseWithCode(w, fetchErr.Error(), http.StatusBadRequest)
+					return
+				case errors.Is(fetchErr, torrentfile.ErrTorrentFileTooBig):
+					SendFailResponseWithCode(w, fetchErr.Error(), http.StatusRequestEntityTooLarge)
+					return
+				case fetchErr != nil:
+					SendFailResponseWithCode(w, fetchErr.Error(), http.StatusBadGateway)
+					return
+				}
+				result, err = torrentfile.GetManager().AddTorrent(torrentBytes)
+			} else {
+				SendFailResponseWithCode(w, "Magnet or url expected", http.StatusBadRequest)
+				return
+			}
+		default:
+			torrentBytes, ok := readTorrentUpload(w, r.Body)
+			if !ok {
+				return
+			}
+			result, err = torrentfile.GetManager().AddTorrent(torrentBytes)
+		}
+
+		if err != nil {
+			logrus.Errorf("Error adding torrent: %v", err)
+			SendFailResponseWithCode(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if result.Existing {
+			SendDataResponse(w, result)
+		} else {
+			SendDataResponseWithCode(w, result, http.StatusCreated)
+		}
+	} else {
+		SendFailResponseWithCode(w, "Not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// CreateTorrentHandler hashes a file or directory from SEED_SOURCE_DIR, registers
+// it as loaded and responds with the created .torrent
+func CreateTorrentHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		request := struct {
+			Path		string		`json:"path"`
+			PieceLength	int			`json:"pieceLength"`
+			Announce	[]string	`json:"announce"`
+			Comment		string		`json:"comment"`
+		}{}
+
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			SendFailResponseWithCode(w, fmt.Sprintf("Error decoding body: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		if request.PieceLength != 0 && !torrentfile.ValidPieceLength(request.PieceLength) {
+			SendFailResponseWithCode(w, "Piece length must be 0 or a power of two from 16 KiB to 16 MiB", http.StatusBadRequest)
+			return
+		}
+
+		sourceDir := env.GetParser().GetSeedSourceDir()
+		if sourceDir == "" {
+			SendFailResponseWithCode(w, "Seed source dir is not configured", http.StatusForbidden)
+			return
+		}
+		srcPath := filepath.Join(sourceDir, filepath.Clean("/" + request.Path))
+
+		builder := torrentfile.Builder{PieceLength: request.PieceLength, Comment: request.Comment}
+		for _, announce := range request.Announce {
+			builder.AnnounceList = append(builder.AnnounceList, []string{announce})
+		}
+
+		created, err := builder.CreateFromPath(srcPath)
+		if err != nil {
+			logrus.Errorf("Error creating torrent from %v: %v", srcPath, err)
+			SendFailResponseWithCode(w, fmt.Sprintf("Error creating torrent: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		fileId, err := created.Register()
+		if err != nil {
+			logrus.Errorf("Error registering torrent: %v", err)
+			SendFailResponseWithCode(w, fmt.Sprintf("Error registering torrent: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/x-bittorrent")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", created.Torrent.GetName() + ".torrent"))
+		w.Header().Set("X-File-Id", fileId)
+		w.WriteHeader(http.StatusCreated)
+		if _, err := created.WriteTo(w); err != nil {
+			logrus.Errorf("Error writing torrent: %v", err)
+		}
+	} else {
+		SendFailResponseWithCode(w, "Not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
 func TerminateLoadHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodGet {
 		fileId := mux.Vars(r)["file_id"]
@@ -2,9 +2,12 @@ package handlers
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"time"
 
+	"torrentClient/loadMaster"
+
 	"github.com/sirupsen/logrus"
 )
 
@@ -41,11 +44,16 @@ func SendSuccessResponse(w http.ResponseWriter) {
 }
 
 func SendDataResponse(w http.ResponseWriter, data interface{}) {
+	SendDataResponseWithCode(w, data, http.StatusOK)
+}
+
+func SendDataResponseWithCode(w http.ResponseWriter, data interface{}, code int) {
 	var packet []byte
 	var err error
 
 	response := &DataResponse{Status: true, Data: data}
 	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(code)
 
 	if packet, err = json.Marshal(response); err != nil {
 		logrus.Error("Error marshalling response: ", err)
@@ -55,6 +63,35 @@ func SendDataResponse(w http.ResponseWriter, data interface{}) {
 	}
 }
 
+func PrepareEventStream(w http.ResponseWriter) {
+	w.Header().Set("content-type", "text/event-stream")
+	w.Header().Set("cache-control", "no-cache")
+	w.Header().Set("connection", "keep-alive")
+	w.Header().Set("x-accel-buffering", "no") // иначе nginx копит события в буфере
+	w.WriteHeader(http.StatusOK)
+}
+
+func SendEvent(w http.ResponseWriter, flusher http.Flusher, event loadMaster.LoadEvent) {
+	packet, err := json.Marshal(event)
+	if err != nil {
+		logrus.Error("Error marshalling event: ", err)
+		return
+	}
+	if _, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, packet); err != nil {
+		logrus.Error("Error sending event: ", err)
+		return
+	}
+	flusher.Flush()
+}
+
+func SendKeepAliveComment(w http.ResponseWriter, flusher http.Flusher) {
+	if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+		logrus.Error("Error sending keep alive: ", err)
+		return
+	}
+	flusher.Flush()
+}
+
 func SetCookieForHour(w http.ResponseWriter, cookieName, value string) {
 	c := http.Cookie{
 		Name:     cookieName,
@@ -3,6 +3,7 @@ package server
 import (
 	"net/http"
 
+	"torrentClient/metrics"
 	"torrentClient/parser/env"
 	"torrentClient/server/handlers"
 
@@ -30,8 +31,16 @@ func Start() {
 	router.HandleFunc("/save/{file_id}", handlers.WriteLoadedPartsHandler)
 
 	router.HandleFunc("/stats/{file_id}", handlers.LoadingStatsHandler)
+	router.HandleFunc("/stats/{file_id}/events", handlers.LoadingEventsHandler)
+	router.HandleFunc("/stats/{file_id}/peers", handlers.PeersStatsHandler)
 	router.HandleFunc("/subtitles/{file_id}", handlers.SubtitlesInfoHandler)
+	router.HandleFunc("/files/{file_id}", handlers.FilesInfoHandler)
 	router.HandleFunc("/stop/{file_id}", handlers.TerminateLoadHandler)
+	router.HandleFunc("/torrents", handlers.AddTorrentHandler)
+	router.HandleFunc("/create", handlers.CreateTorrentHandler)
+
+	router.HandleFunc("/admin/eviction", handlers.EvictionPlanHandler)
+	router.Handle("/metrics", metrics.Handler())
 
 	logrus.Info("Listening localhost:2222")
 	if err := http.ListenAndServe(":2222", router); err != nil {
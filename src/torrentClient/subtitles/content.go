@@ -0,0 +1,226 @@
+package subtitles
+
+import (
+	"bytes"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+const (
+	EncodingUtf8    = "utf-8"
+	EncodingUtf8Bom = "utf-8-bom"
+	EncodingUtf16LE = "utf-16le"
+	EncodingUtf16BE = "utf-16be"
+	EncodingCp1251  = "windows-1251"
+	EncodingCp1252  = "windows-1252"
+)
+
+// minimal amount of stop words to trust the statistics
+const minStopWordHits = 5
+
+var stopWords = map[string][]string{
+	"en": {"the", "and", "you", "that", "this", "what", "is", "are", "have", "not", "it", "to", "of", "was", "with"},
+	"es": {"que", "de", "el", "la", "los", "es", "no", "por", "qué", "una", "con", "para", "está", "pero", "lo"},
+	"fr": {"le", "la", "les", "est", "que", "je", "vous", "pas", "une", "et", "c'est", "il", "ne", "qui", "dans"},
+	"de": {"der", "die", "und", "ich", "nicht", "das", "ist", "sie", "du", "es", "ein", "was", "wir", "mit", "den"},
+	"it": {"che", "non", "il", "di", "la", "è", "sono", "per", "una", "mi", "ho", "ma", "questo", "come", "gli"},
+	"pt": {"que", "não", "de", "o", "é", "um", "uma", "você", "eu", "se", "para", "com", "os", "isso", "está"},
+	"nl": {"de", "het", "een", "ik", "je", "niet", "dat", "is", "en", "van", "wat", "we", "hij", "zijn", "maar"},
+	"pl": {"nie", "to", "się", "że", "jest", "na", "co", "jak", "ale", "tak", "mnie", "czy", "już", "ja", "jestem"},
+	"sv": {"och", "att", "det", "är", "jag", "inte", "du", "som", "en", "på", "har", "vi", "med", "för", "den"},
+	"tr": {"bir", "ve", "bu", "ne", "için", "değil", "ben", "sen", "çok", "da", "de", "mi", "var", "gibi", "ama"},
+	"ru": {"и", "не", "что", "в", "я", "ты", "он", "на", "это", "как", "мы", "с", "так", "да", "вы"},
+	"uk": {"і", "не", "що", "в", "я", "ти", "він", "на", "це", "як", "ми", "з", "так", "та", "ви"},
+	"bg": {"и", "не", "да", "се", "на", "това", "е", "ли", "какво", "ще", "съм", "си", "за", "ти", "ме"},
+}
+
+// DetectEncoding guesses text encoding of subtitles file
+func DetectEncoding(data []byte) string {
+	switch {
+	case bytes.HasPrefix(data, []byte{0xEF, 0xBB, 0xBF}):
+		return EncodingUtf8Bom
+	case bytes.HasPrefix(data, []byte{0xFF, 0xFE}):
+		return EncodingUtf16LE
+	case bytes.HasPrefix(data, []byte{0xFE, 0xFF}):
+		return EncodingUtf16BE
+	case utf8.Valid(data):
+		return EncodingUtf8
+	}
+
+	// однобайтные кодировки: в cp1251 русский текст почти целиком в 0xC0-0xFF,
+	// в западных языках такие байты редкие
+	high, cyrillicRange := 0, 0
+	for _, b := range data {
+		if b >= 0x80 {
+			high++
+			if b >= 0xC0 {
+				cyrillicRange++
+			}
+		}
+	}
+	if high > 0 && cyrillicRange * 100 / high > 80 && high * 100 / len(data) > 20 {
+		return EncodingCp1251
+	}
+	return EncodingCp1252
+}
+
+// DecodeText converts subtitles to utf-8 string using DetectEncoding result
+func DecodeText(data []byte, encoding string) string {
+	switch encoding {
+	case EncodingUtf8Bom:
+		return string(data[3:])
+	case EncodingUtf16LE, EncodingUtf16BE:
+		data = data[2:]
+		units := make([]uint16, len(data) / 2)
+		for i := range units {
+			if encoding == EncodingUtf16LE {
+				units[i] = uint16(data[2 * i]) | uint16(data[2 * i + 1]) << 8
+			} else {
+				units[i] = uint16(data[2 * i]) << 8 | uint16(data[2 * i + 1])
+			}
+		}
+		return string(utf16.Decode(units))
+	case EncodingCp1251:
+		var builder strings.Builder
+		for _, b := range data {
+			builder.WriteRune(decodeCp1251(b))
+		}
+		return builder.String()
+	case EncodingCp1252:
+		var builder strings.Builder
+		for _, b := range data {
+			builder.WriteRune(rune(b)) // latin1 совпадает с cp1252 на буквах
+		}
+		return builder.String()
+	}
+	return string(data)
+}
+
+func decodeCp1251(b byte) rune {
+	switch {
+	case b < 0x80:
+		return rune(b)
+	case b >= 0xC0:
+		return rune(0x0410 + int(b) - 0xC0)
+	case b == 0xA8:
+		return 'Ё'
+	case b == 0xB8:
+		return 'ё'
+	case b == 0xB2:
+		return 'І'
+	case b == 0xB3:
+		return 'і'
+	case b == 0xAA:
+		return 'Є'
+	case b == 0xBA:
+		return 'є'
+	case b == 0xAF:
+		return 'Ї'
+	case b == 0xBF:
+		return 'ї'
+	}
+	return unicode.ReplacementChar
+}
+
+// DetectFromText detects language by script and, for latin and cyrillic texts,
+// by the most frequent stop words
+func DetectFromText(text string) string {
+	if lang := detectByScript(text); lang != "" {
+		return lang
+	}
+
+	counts := make(map[string]int, 64)
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && r != '\''
+	}) {
+		counts[word]++
+	}
+
+	bestLang, bestScore := Unknown, 0
+	for lang, words := range stopWords {
+		score := 0
+		for _, word := range words {
+			score += counts[word]
+		}
+		if score > bestScore || (score == bestScore && lang < bestLang) {
+			bestLang, bestScore = lang, score
+		}
+	}
+	if bestScore < minStopWordHits {
+		return Unknown
+	}
+	return bestLang
+}
+
+// detectByScript returns language for scripts used mostly by one language
+func detectByScript(text string) string {
+	counts := make(map[string]int, 8)
+	letters := 0
+
+	for _, r := range text {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		letters++
+		switch {
+		case unicode.Is(unicode.Hangul, r):
+			counts["ko"]++
+		case unicode.Is(unicode.Hiragana, r) || unicode.Is(unicode.Katakana, r):
+			counts["ja"]++
+		case unicode.Is(unicode.Han, r):
+			counts["zh"]++
+		case unicode.Is(unicode.Arabic, r):
+			counts["ar"]++
+		case unicode.Is(unicode.Hebrew, r):
+			counts["he"]++
+		case unicode.Is(unicode.Greek, r):
+			counts["el"]++
+		case unicode.Is(unicode.Thai, r):
+			counts["th"]++
+		}
+	}
+	if letters == 0 {
+		return ""
+	}
+
+	// японский текст всегда содержит иероглифы, кана - признак японского
+	if counts["ja"] * 10 > letters {
+		return "ja"
+	}
+	for lang, count := range counts {
+		if count * 2 > letters {
+			return lang
+		}
+	}
+	return ""
+}
+
+// DetectSDH checks if noticeable part of lines are sound descriptions like
+// "[door creaks]" or "(LAUGHING)"
+func DetectSDH(text string) bool {
+	textLines, descriptions := 0, 0
+
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.Contains(line, "-->") || isNumber(line) {
+			continue
+		}
+		textLines++
+		if (strings.HasPrefix(line, "[") && strings.Contains(line, "]")) ||
+			(strings.HasPrefix(line, "(") && strings.HasSuffix(line, ")")) {
+			descriptions++
+		}
+	}
+	return textLines > 0 && descriptions * 100 / textLines >= 5
+}
+
+func isNumber(line string) bool {
+	for _, r := range line {
+		if !unicode.IsDigit(r) {
+			return false
+		}
+	}
+	return true
+}
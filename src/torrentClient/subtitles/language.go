@@ -0,0 +1,119 @@
+package subtitles
+
+import (
+	"path"
+	"strings"
+	"unicode"
+)
+
+const Unknown = "unknown"
+
+// languages maps ISO 639-1, ISO 639-2 (both B and T codes) and language names
+// in english and native form to ISO 639-1 code
+var languages = map[string]string{}
+
+func init() {
+	for code, aliases := range map[string][]string{
+		"en": {"eng", "english"},
+		"ru": {"rus", "russian", "русский", "рус"},
+		"uk": {"ukr", "ukrainian", "українська", "укр"},
+		"be": {"bel", "belarusian"},
+		"bg": {"bul", "bulgarian"},
+		"es": {"spa", "spanish", "español", "espanol", "castellano"},
+		"pt": {"por", "portuguese", "português", "portugues", "pob", "ptbr", "brazilian"},
+		"fr": {"fre", "fra", "french", "français", "francais"},
+		"de": {"ger", "deu", "german", "deutsch"},
+		"it": {"ita", "italian", "italiano"},
+		"nl": {"dut", "nld", "dutch", "nederlands"},
+		"pl": {"pol", "polish", "polski"},
+		"cs": {"cze", "ces", "czech"},
+		"sk": {"slo", "slk", "slovak"},
+		"sv": {"swe", "swedish", "svenska"},
+		"no": {"nor", "nob", "norwegian", "norsk"},
+		"da": {"dan", "danish", "dansk"},
+		"fi": {"fin", "finnish", "suomi"},
+		"tr": {"tur", "turkish", "türkçe", "turkce"},
+		"el": {"gre", "ell", "greek"},
+		"hu": {"hun", "hungarian", "magyar"},
+		"ro": {"rum", "ron", "romanian", "română"},
+		"hr": {"hrv", "croatian", "hrvatski"},
+		"sr": {"srp", "serbian", "srpski"},
+		"he": {"heb", "hebrew"},
+		"ar": {"ara", "arabic"},
+		"fa": {"per", "fas", "persian", "farsi"},
+		"hi": {"hin", "hindi"},
+		"th": {"tha", "thai"},
+		"vi": {"vie", "vietnamese"},
+		"id": {"ind", "indonesian"},
+		"ja": {"jpn", "japanese"},
+		"ko": {"kor", "korean"},
+		"zh": {"chi", "zho", "chinese", "chs", "cht"},
+	} {
+		languages[code] = code
+		for _, alias := range aliases {
+			languages[alias] = code
+		}
+	}
+}
+
+// двухбуквенные коды часто совпадают с обычными словами в названии, поэтому
+// их принимаем только рядом с расширением, а имена языков - где угодно
+const maxTrailingCodeTokens = 3
+
+type PathInfo struct {
+	Language string
+	Forced   bool
+	SDH      bool
+}
+
+// DetectFromPath uses usual naming conventions: "Movie.en.srt", "Movie.eng.forced.srt",
+// "Subs/Russian.srt", "Subs/2_English.srt", "Movie [SDH].ru.srt"
+func DetectFromPath(filePath []string) PathInfo {
+	info := PathInfo{Language: Unknown}
+	if len(filePath) == 0 {
+		return info
+	}
+
+	name := filePath[len(filePath) - 1]
+	name = strings.TrimSuffix(name, path.Ext(name))
+	tokens := tokenize(name)
+
+	for i := len(tokens) - 1; i >= 0; i-- {
+		token := tokens[i]
+		switch token {
+		case "forced", "foreign":
+			info.Forced = true
+			continue
+		case "sdh", "cc", "hi", "hearing":
+			// "hi" также код хинди, но в именах субтитров почти всегда значит SDH
+			info.SDH = true
+			continue
+		}
+
+		if info.Language != Unknown {
+			continue
+		}
+		code, known := languages[token]
+		if !known {
+			continue
+		}
+		if len(token) <= 3 && len(tokens) - i > maxTrailingCodeTokens && len(tokens) > 1 {
+			continue
+		}
+		info.Language = code
+	}
+
+	// Subs/English/1.srt
+	for i := len(filePath) - 2; i >= 0 && info.Language == Unknown; i-- {
+		if code, known := languages[strings.ToLower(filePath[i])]; known && len(filePath[i]) > 3 {
+			info.Language = code
+		}
+	}
+	return info
+}
+
+func tokenize(name string) []string {
+	return strings.FieldsFunc(strings.ToLower(name), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
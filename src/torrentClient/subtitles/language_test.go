@@ -0,0 +1,70 @@
+package subtitles
+
+import "testing"
+
+func TestDetectFromPath(t *testing.T) {
+	cases := []struct {
+		path     []string
+		expected PathInfo
+	}{
+		{[]string{"Movie.2019.1080p.en.srt"}, PathInfo{Language: "en"}},
+		{[]string{"Movie.2019.eng.forced.srt"}, PathInfo{Language: "en", Forced: true}},
+		{[]string{"Movie", "Subs", "Russian.srt"}, PathInfo{Language: "ru"}},
+		{[]string{"Movie", "Subs", "2_English.srt"}, PathInfo{Language: "en"}},
+		{[]string{"Movie [SDH].pt-BR.srt"}, PathInfo{Language: "pt", SDH: true}},
+		{[]string{"Movie", "Subs", "French", "1.srt"}, PathInfo{Language: "fr"}},
+		{[]string{"No.Country.For.Old.Men.2007.srt"}, PathInfo{Language: Unknown}},
+		{[]string{"The.It.Crowd.S01E01.720p.srt"}, PathInfo{Language: Unknown}},
+	}
+
+	for _, c := range cases {
+		if got := DetectFromPath(c.path); got != c.expected {
+			t.Errorf("%v: expected %+v, got %+v", c.path, c.expected, got)
+		}
+	}
+}
+
+func TestDetectFromText(t *testing.T) {
+	cases := map[string]string{
+		"I don't know what you are talking about. This is not the way to do it, and you know that.": "en",
+		"Я не знаю, что ты говоришь. Это не так, и мы это знаем. Да, как ты и он.":                 "ru",
+		"No sé qué pasa con el perro. Es que la casa no es para los niños, pero lo está.":          "es",
+		"Ich weiß nicht, was das ist. Sie ist nicht mit der Frau und den Kindern, du und ich.":     "de",
+		"お元気ですか。私は元気です。ありがとうございます。":                                                               "ja",
+		"short text": Unknown,
+	}
+
+	for text, expected := range cases {
+		if got := DetectFromText(text); got != expected {
+			t.Errorf("%q: expected %v, got %v", text, expected, got)
+		}
+	}
+}
+
+func TestDetectEncoding(t *testing.T) {
+	cp1251 := []byte{0xcf, 0xf0, 0xe8, 0xe2, 0xe5, 0xf2, 0x20, 0xec, 0xe8, 0xf0} // "Привет мир"
+	if enc := DetectEncoding(cp1251); enc != EncodingCp1251 {
+		t.Errorf("Expected cp1251, got %v", enc)
+	}
+	if text := DecodeText(cp1251, EncodingCp1251); text != "Привет мир" {
+		t.Errorf("Unexpected cp1251 decoding: %v", text)
+	}
+
+	utf16 := []byte{0xff, 0xfe, 'H', 0, 'i', 0}
+	if enc := DetectEncoding(utf16); enc != EncodingUtf16LE || DecodeText(utf16, enc) != "Hi" {
+		t.Errorf("Unexpected utf-16 handling: %v", enc)
+	}
+	if enc := DetectEncoding([]byte("plain text")); enc != EncodingUtf8 {
+		t.Errorf("Expected utf-8, got %v", enc)
+	}
+}
+
+func TestDetectSDH(t *testing.T) {
+	text := "1\n00:00:01,000 --> 00:00:02,000\n[door creaks]\n\n2\n00:00:03,000 --> 00:00:04,000\nWho's there?\n"
+	if !DetectSDH(text) {
+		t.Errorf("SDH not detected")
+	}
+	if DetectSDH("1\n00:00:01,000 --> 00:00:02,000\nHello\n") {
+		t.Errorf("Unexpected SDH")
+	}
+}
@@ -0,0 +1,312 @@
+package torrentfile
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"torrentClient/db"
+	"torrentClient/parser/env"
+
+	"github.com/jackpal/bencode-go"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	minPieceLength     = 1 << 14 // 16 KiB, размер блока в протоколе
+	maxPieceLength     = 1 << 24 // 16 MiB
+	minAutoPieceLength = 1 << 18 // 256 KiB
+	maxAutoPieceLength = 1 << 24 // 16 MiB
+	autoPiecesTarget   = 1500
+	builderCreatedBy   = "hypertube torrentClient"
+)
+
+// ValidPieceLength tells if the piece length may be set explicitly: a power of
+// two from 16 KiB to 16 MiB
+func ValidPieceLength(pieceLength int) bool {
+	return pieceLength >= minPieceLength && pieceLength <= maxPieceLength && pieceLength & (pieceLength - 1) == 0
+}
+
+// Builder creates v1 .torrent files from local files or directories
+type Builder struct {
+	PieceLength  int // 0 - choose depending on total size
+	AnnounceList [][]string
+	Comment      string
+	Private      bool
+	Source       string
+}
+
+type CreatedTorrent struct {
+	Torrent *TorrentFile
+	Raw     []byte
+
+	sourcePaths []string
+}
+
+type bencodeCreatedTorrent struct {
+	Announce     string      `bencode:"announce,omitempty"`
+	AnnounceList [][]string  `bencode:"announce-list,omitempty"`
+	Comment      string      `bencode:"comment,omitempty"`
+	CreatedBy    string      `bencode:"created by"`
+	CreationDate int64       `bencode:"creation date"`
+	Info         interface{} `bencode:"info"`
+}
+
+type sourceFile struct {
+	path   string
+	parts  []string
+	length int
+}
+
+func (b *Builder) CreateFromPath(srcPath string) (*CreatedTorrent, error) {
+	info, err := os.Stat(srcPath)
+	if err != nil {
+		return nil, err
+	}
+
+	name := filepath.Base(srcPath)
+	var sources []sourceFile
+
+	if info.IsDir() {
+		sources, err = collectSourceFiles(srcPath)
+		if err != nil {
+			return nil, err
+		}
+		if len(sources) == 0 {
+			return nil, fmt.Errorf("no files found in %v", srcPath)
+		}
+	} else {
+		sources = []sourceFile{{path: srcPath, parts: []string{name}, length: int(info.Size())}}
+	}
+
+	totalLength := 0
+	for _, src := range sources {
+		totalLength += src.length
+	}
+	if totalLength == 0 {
+		return nil, fmt.Errorf("nothing to hash, total length is 0")
+	}
+
+	pieceLength := b.PieceLength
+	if pieceLength == 0 {
+		pieceLength = autoPieceLength(totalLength)
+	} else if !ValidPieceLength(pieceLength) {
+		return nil, fmt.Errorf("invalid piece length %v: expected a power of two from %v to %v", pieceLength, minPieceLength, maxPieceLength)
+	}
+
+	pieces, err := hashPieces(sources, pieceLength)
+	if err != nil {
+		return nil, err
+	}
+
+	private := 0
+	if b.Private {
+		private = 1
+	}
+
+	var infoDict interface{}
+	if info.IsDir() {
+		files := make([]bencodeTorrentFile, len(sources))
+		for i, src := range sources {
+			files[i] = bencodeTorrentFile{Length: src.length, Path: src.parts}
+		}
+		infoDict = bencodeInfoMultiFiles{Pieces: pieces, PieceLength: pieceLength, Files: files, Name: name, Private: private, Source: b.Source}
+	} else {
+		infoDict = bencodeInfoSingleFile{Pieces: pieces, PieceLength: pieceLength, Length: totalLength, Name: name, Private: private, Source: b.Source}
+	}
+
+	meta := bencodeCreatedTorrent{
+		AnnounceList: b.AnnounceList,
+		Comment:      b.Comment,
+		CreatedBy:    builderCreatedBy,
+		CreationDate: time.Now().Unix(),
+		Info:         infoDict,
+	}
+	if len(b.AnnounceList) > 0 && len(b.AnnounceList[0]) > 0 {
+		meta.Announce = b.AnnounceList[0][0]
+	}
+
+	var buf bytes.Buffer
+	if err := bencode.Marshal(&buf, meta); err != nil {
+		return nil, fmt.Errorf("error marshalling torrent: %v", err)
+	}
+
+	torrent, err := GetManager().ReadTorrentFileFromBytes(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		return nil, fmt.Errorf("error parsing created torrent: %v", err)
+	}
+
+	result := &CreatedTorrent{Torrent: torrent, Raw: buf.Bytes()}
+	for _, src := range sources {
+		result.sourcePaths = append(result.sourcePaths, src.path)
+	}
+	return result, nil
+}
+
+func (c *CreatedTorrent) WriteTo(w io.Writer) (int64, error) {
+	n, err := w.Write(c.Raw)
+	return int64(n), err
+}
+
+func (c *CreatedTorrent) FileId() string {
+	infoHash := c.Torrent.GetInfoHash()
+	return hex.EncodeToString(infoHash[:])
+}
+
+// Register places source files to FILES_DIR the same way a completed load
+// would have written them and saves the record as already loaded
+func (c *CreatedTorrent) Register() (string, error) {
+	fileId := c.FileId()
+	files := c.Torrent.GetFiles()
+
+	for i, file := range files {
+		dest := filepath.Join(env.GetParser().GetFilesDir(), file.EncodeFileName())
+		if err := linkOrCopy(c.sourcePaths[i], dest); err != nil {
+			return "", fmt.Errorf("error placing %v: %v", c.sourcePaths[i], err)
+		}
+	}
+
+	c.Torrent.SysInfo.FileId = fileId
+	videoFile := c.Torrent.getHeaviestFile()
+
+	if !db.GetFilesManagerDb().AddLoadedFileRecord(fileId, fileId, c.Raw, c.Torrent.GetName(), videoFile.EncodeFileName(), int64(videoFile.Length)) {
+		return "", fmt.Errorf("failed to save record %v", fileId)
+	}
+	db.GetFilesManagerDb().SetOriginalFileNameForRecord(fileId, videoFile.OriginalName(), strings.ToLower(videoFile.Extension()))
+	logrus.Infof("Registered created torrent %v (%v) as loaded", fileId, c.Torrent.GetName())
+	return fileId, nil
+}
+
+func collectSourceFiles(root string) ([]sourceFile, error) {
+	sources := make([]sourceFile, 0, 10)
+
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		sources = append(sources, sourceFile{
+			path:   path,
+			parts:  strings.Split(filepath.ToSlash(rel), "/"),
+			length: int(info.Size()),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(sources, func(i, j int) bool {
+		return strings.Join(sources[i].parts, "/") < strings.Join(sources[j].parts, "/")
+	})
+	return sources, nil
+}
+
+func autoPieceLength(totalLength int) int {
+	pieceLength := minAutoPieceLength
+	for pieceLength < maxAutoPieceLength && totalLength / pieceLength > autoPiecesTarget {
+		pieceLength *= 2
+	}
+	return pieceLength
+}
+
+// hashPieces reads files one after another as a single stream, pieces may
+// cross file borders
+func hashPieces(sources []sourceFile, pieceLength int) (string, error) {
+	var pieces bytes.Buffer
+	buf := make([]byte, pieceLength)
+	filled := 0
+
+	for _, src := range sources {
+		file, err := os.Open(src.path)
+		if err != nil {
+			return "", err
+		}
+
+		for {
+			n, err := io.ReadFull(file, buf[filled:])
+			filled += n
+			if filled == pieceLength {
+				hash := sha1.Sum(buf)
+				pieces.Write(hash[:])
+				filled = 0
+			}
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				break
+			}
+			if err != nil {
+				file.Close()
+				return "", err
+			}
+		}
+		file.Close()
+	}
+
+	if filled > 0 {
+		hash := sha1.Sum(buf[:filled])
+		pieces.Write(hash[:])
+	}
+	return pieces.String(), nil
+}
+
+// linkOrCopy places src at dest. The same path may be created twice, then dest
+// is already the link to src and opening it for writing would empty the source
+func linkOrCopy(src, dest string) error {
+	srcInfo, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	if destInfo, err := os.Stat(dest); err == nil {
+		if os.SameFile(srcInfo, destInfo) {
+			return nil
+		}
+		if err := os.Remove(dest); err != nil {
+			return err
+		}
+	}
+
+	if err := os.Link(src, dest); err == nil {
+		return nil
+	}
+
+	// копируем во временный файл рядом, чтобы не оставить полфайла под именем dest
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := ioutil.TempFile(filepath.Dir(dest), filepath.Base(dest) + ".part")
+	if err != nil {
+		return err
+	}
+	if err := out.Chmod(srcInfo.Mode().Perm()); err != nil {
+		out.Close()
+		os.Remove(out.Name())
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(out.Name())
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(out.Name())
+		return err
+	}
+	return os.Rename(out.Name(), dest)
+}
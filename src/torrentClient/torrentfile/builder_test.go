@@ -0,0 +1,127 @@
+package torrentfile
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBuilderMultiFile(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "movie")
+	if err := os.MkdirAll(filepath.Join(root, "subs"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	video := bytes.Repeat([]byte("v"), 50000)
+	subs := bytes.Repeat([]byte("s"), 3000)
+	_ = os.WriteFile(filepath.Join(root, "movie.mkv"), video, 0644)
+	_ = os.WriteFile(filepath.Join(root, "subs", "en.srt"), subs, 0644)
+
+	builder := Builder{PieceLength: 16384, AnnounceList: [][]string{{"udp://tracker.example:80"}}}
+	created, err := builder.CreateFromPath(root)
+	if err != nil {
+		t.Fatalf("Error creating torrent: %v", err)
+	}
+
+	torrent := created.Torrent
+	if torrent.Announce != "udp://tracker.example:80" {
+		t.Errorf("Unexpected announce: %v", torrent.Announce)
+	}
+	if torrent.GetName() != "movie" || torrent.GetLength() != len(video) + len(subs) {
+		t.Errorf("Unexpected name or length: %v, %v", torrent.GetName(), torrent.GetLength())
+	}
+
+	files := torrent.GetFiles()
+	if len(files) != 2 || files[0].Path[0] != "movie.mkv" || files[1].Path[1] != "en.srt" {
+		t.Fatalf("Unexpected files: %v", files)
+	}
+
+	// pieces are hashed over concatenated files
+	data := append(append([]byte{}, video...), subs...)
+	hashes := torrent.GetPieceHashes()
+	if len(hashes) != (len(data) + 16383) / 16384 {
+		t.Fatalf("Unexpected pieces count: %v", len(hashes))
+	}
+	for i, hash := range hashes {
+		end := (i + 1) * 16384
+		if end > len(data) {
+			end = len(data)
+		}
+		if hash != sha1.Sum(data[i * 16384:end]) {
+			t.Errorf("Piece %v hash mismatch", i)
+		}
+	}
+
+	parsed, err := GetManager().ReadTorrentFileFromBytes(bytes.NewReader(created.Raw))
+	if err != nil {
+		t.Fatalf("Error parsing created torrent: %v", err)
+	}
+	if parsed.GetInfoHash() != torrent.GetInfoHash() {
+		t.Errorf("Info hash differs after parsing")
+	}
+}
+
+func TestAutoPieceLength(t *testing.T) {
+	if autoPieceLength(1 << 20) != minAutoPieceLength {
+		t.Errorf("Small files should use min piece length")
+	}
+	if autoPieceLength(4 << 30) != 4 << 20 {
+		t.Errorf("Unexpected piece length for 4GiB: %v", autoPieceLength(4 << 30))
+	}
+	if autoPieceLength(1 << 40) != maxAutoPieceLength {
+		t.Errorf("Huge files should use max piece length")
+	}
+}
+
+func TestValidPieceLength(t *testing.T) {
+	for _, pieceLength := range []int{16 << 10, 256 << 10, 16 << 20} {
+		if !ValidPieceLength(pieceLength) {
+			t.Errorf("%v should be valid", pieceLength)
+		}
+	}
+	for _, pieceLength := range []int{-1, 0, 1, 8 << 10, 100000, 3 << 20, 32 << 20} {
+		if ValidPieceLength(pieceLength) {
+			t.Errorf("%v should be invalid", pieceLength)
+		}
+	}
+
+	builder := Builder{PieceLength: 100000}
+	if _, err := builder.CreateFromPath("builder_test.go"); err == nil {
+		t.Errorf("Expected error for piece length %v", builder.PieceLength)
+	}
+}
+
+func TestLinkOrCopyTwice(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "movie.mkv")
+	dest := filepath.Join(dir, "placed.mkv")
+	data := bytes.Repeat([]byte("v"), 1000)
+	if err := os.WriteFile(src, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// повторная регистрация того же пути не должна обнулять исходник
+	for i := 0; i < 2; i++ {
+		if err := linkOrCopy(src, dest); err != nil {
+			t.Fatalf("Error placing file %v time: %v", i + 1, err)
+		}
+	}
+	for _, path := range []string{src, dest} {
+		if placed, _ := os.ReadFile(path); !bytes.Equal(placed, data) {
+			t.Errorf("Unexpected %v of %v bytes", path, len(placed))
+		}
+	}
+
+	other := filepath.Join(dir, "other.mkv")
+	if err := os.WriteFile(other, []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := linkOrCopy(src, other); err != nil {
+		t.Fatalf("Error replacing file: %v", err)
+	}
+	if placed, _ := os.ReadFile(other); !bytes.Equal(placed, data) {
+		t.Errorf("Existing file is not replaced: %q", placed)
+	}
+}
@@ -1,40 +1,53 @@
 package torrentfile
 
 import (
-	"encoding/json"
+	"context"
+	"net"
 	"net/url"
 	"testing"
-
-	"torrentClient/parser/env"
-
-	"github.com/sirupsen/logrus"
+	"time"
 )
 
-type testMsg struct {
-	PeerPort	uint16	`json:"peer_port"`
-	Data		string	`json:"data"`
-}
-
-func TestOpenUdpSocket(t *testing.T) {
-	tUrl, _ := url.Parse("udp://18.219.47.231:1194")
-	conn, err := OpenUdpSocket(tUrl)
+func startUdpEchoServer(t *testing.T) *net.UDPConn {
+	server, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
 	if err != nil {
-		t.Errorf("Error opening socket: %v", err)
+		t.Fatalf("Error listening udp: %v", err)
 	}
-	testMsg, _ := json.Marshal(testMsg{PeerPort: env.GetParser().GetTorrentPeerPort(), Data: "Hello!"})
 
-	defer func() {
-		t.Log("Exiting!")
-		conn.ExitChan <- 1
+	go func() {
+		buffer := make([]byte, 1024)
+		for {
+			n, addr, err := server.ReadFromUDP(buffer)
+			if err != nil {
+				return
+			}
+			_, _ = server.WriteToUDP(buffer[:n], addr)
+		}
 	}()
+	return server
+}
 
-	conn.Send <- testMsg
-	res := <- conn.Receive
+func TestOpenUdpSocket(t *testing.T) {
+	server := startUdpEchoServer(t)
+	defer server.Close()
 
-	logrus.Infof("Got msg: %v", string(res))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
-	if string(res) != string("Hello!") {
-		t.Errorf("Got not echo msg: %v", string(res))
+	tUrl, _ := url.Parse("udp://" + server.LocalAddr().String())
+	conn, err := OpenUdpSocket(ctx, tUrl)
+	if err != nil {
+		t.Fatalf("Error opening socket: %v", err)
 	}
-}
 
+	conn.Send <- []byte("Hello!")
+
+	select {
+	case res := <- conn.Receive:
+		if string(res) != "Hello!" {
+			t.Errorf("Got not echo msg: %v", string(res))
+		}
+	case <- time.After(time.Second * 5):
+		t.Errorf("No response from echo server")
+	}
+}
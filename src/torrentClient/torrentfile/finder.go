@@ -4,8 +4,6 @@ import (
 	"bytes"
 	"fmt"
 	"io"
-	"log"
-	"net/url"
 	"os"
 
 	"torrentClient/db"
@@ -22,6 +20,9 @@ type TorrentFilesManager interface {
 	ReadTorrentFileFromFS(path string) (*TorrentFile, error)
 	ReadTorrentFileFromBytes(body io.Reader) (*TorrentFile, error)
 	LoadTorrentFileFromDB(fileId string) (*TorrentFile, error)
+	AddTorrent(torrentBytes []byte) (*IngestResult, error)
+	AddMagnet(magnet string) (*IngestResult, error)
+	FetchTorrentFile(torrentUrl string) ([]byte, error)
 }
 
 func (t *torrentsManager) ReadTorrentFileFromFS(path string) (*TorrentFile, error) {
@@ -44,11 +45,16 @@ func (t *torrentsManager) LoadTorrentFileFromDB(fileId string) (*TorrentFile, er
 		return nil, fmt.Errorf("record not found")
 	}
 
-	doChangeAnnounce := false
-
+	var magnet *Magnet
 	if (torrentBytes == nil || len(torrentBytes) == 0) && len(magnetLink) > 0 {
-		torrentBytes = magnetToTorrent.ConvertMagnetToTorrent(magnetLink)
-		doChangeAnnounce = true
+		var err error
+		if magnet, err = ParseMagnet(magnetLink); err != nil {
+			return nil, fmt.Errorf("error parsing magnet: %v", err)
+		}
+		torrentBytes, err = magnetToTorrent.ConvertMagnetToTorrent(magnetLink)
+		if err != nil {
+			return nil, fmt.Errorf("error converting magnet: %v", err)
+		}
 	}
 
 	torrent, err := t.ReadTorrentFileFromBytes(bytes.NewBuffer(torrentBytes))
@@ -57,20 +63,42 @@ func (t *torrentsManager) LoadTorrentFileFromDB(fileId string) (*TorrentFile, er
 	}
 	torrent.SysInfo.FileId = fileId
 
-	if doChangeAnnounce {
-		trackerUrl := t.GetTrackersFromMagnet(magnetLink)
-		logrus.Infof("Tracker url: %v", trackerUrl)
-		torrent.Announce = trackerUrl
+	if magnet != nil {
+		torrent.ApplyMagnet(magnet)
 	}
 
 	return torrent, nil
 }
 
-func (t *torrentsManager) GetTrackersFromMagnet(magnet string) string {
-	decoded, err := url.ParseQuery(magnet)
-	if err != nil {
-		log.Fatal(err)
-		return ""
+// addWebSeeds keeps url-list of the torrent first, ws of the magnet often
+// repeat it
+func (t *TorrentFile) addWebSeeds(seeds []string) {
+	for _, seed := range seeds {
+		if StrArrayIdx(t.WebSeeds, seed) < 0 {
+			t.WebSeeds = append(t.WebSeeds, seed)
+		}
+	}
+}
+
+// ApplyMagnet adds trackers, web seeds, direct peers and files selection from
+// magnet to the torrent converted from it
+func (t *TorrentFile) ApplyMagnet(magnet *Magnet) {
+	if len(magnet.Trackers) > 0 {
+		logrus.Infof("Magnet trackers: %v", magnet.Trackers)
+		t.Announce = magnet.Trackers[0]
+		for _, tracker := range magnet.Trackers[1:] {
+			if StrArrayIdx(t.AnnounceList, tracker) < 0 {
+				t.AnnounceList = append(t.AnnounceList, tracker)
+			}
+		}
+	}
+	t.addWebSeeds(magnet.WebSeeds)
+	t.DirectPeers = append(t.DirectPeers, magnet.ResolvePeers()...)
+
+	filesNum := len(t.GetFiles())
+	for _, idx := range magnet.SelectOnly {
+		if idx < filesNum {
+			t.SelectedFiles = append(t.SelectedFiles, idx)
+		}
 	}
-	return decoded.Get("tr")
 }
@@ -0,0 +1,253 @@
+package torrentfile
+
+import (
+	"encoding/binary"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// moov и Cues обычно меньше, дальше не качаем вне очереди
+const maxIndexPrefetch = 16 << 20
+
+// matroska element ids needed to find the index
+const (
+	mkvIdEbml         = 0x1A45DFA3
+	mkvIdSegment      = 0x18538067
+	mkvIdSeekHead     = 0x114D9B74
+	mkvIdSeek         = 0x4DBB
+	mkvIdSeekId       = 0x53AB
+	mkvIdSeekPosition = 0x53AC
+	mkvIdInfo         = 0x1549A966
+	mkvIdTracks       = 0x1654AE6B
+	mkvIdCues         = 0x1C53BB6B
+	mkvIdCluster      = 0x1F43B675
+)
+
+type indexRange struct {
+	Start int64
+	End   int64
+}
+
+// indexPrefetcher looks at the first piece of the video file and asks the
+// sorter to download pieces of the container index before the sequential ones
+type indexPrefetcher struct {
+	file        FileBoundaries
+	pieceLength int64
+	PiecesChan  chan []int
+	once        sync.Once
+}
+
+func newIndexPrefetcher(t *TorrentFile, fileName string) *indexPrefetcher {
+	prefetcher := &indexPrefetcher{pieceLength: int64(t.GetPieceLength()), PiecesChan: make(chan []int, 1)}
+	for _, file := range t.GetFileBoundariesMapping() {
+		if file.FileName == fileName {
+			prefetcher.file = file
+		}
+	}
+	return prefetcher
+}
+
+// FirstPiece is the piece with the start of the video file
+func (p *indexPrefetcher) FirstPiece() int {
+	if p.pieceLength == 0 {
+		return 0
+	}
+	return int(p.file.Start / p.pieceLength)
+}
+
+// OnFileData is called for every written part, only the head of the video file
+// is parsed
+func (p *indexPrefetcher) OnFileData(fileName string, offset int64, data []byte) {
+	if fileName != p.file.FileName || offset != 0 || p.pieceLength == 0 {
+		return
+	}
+	p.once.Do(func() {
+		ranges := findIndexRanges(data, p.file.End - p.file.Start)
+		if len(ranges) == 0 {
+			return
+		}
+		pieces := make([]int, 0, 8)
+		for _, r := range ranges {
+			for idx := (p.file.Start + r.Start) / p.pieceLength; idx <= (p.file.Start + r.End - 1) / p.pieceLength; idx++ {
+				pieces = append(pieces, int(idx))
+			}
+		}
+		logrus.Infof("Prefetching container index of %v: ranges %v, pieces %v", fileName, ranges, pieces)
+		select {
+		case p.PiecesChan <- pieces:
+		default:
+		}
+	})
+}
+
+// findIndexRanges returns byte ranges of the file with the index which is not
+// in the head: mp4 moov after mdat, matroska Info, Tracks and Cues found by
+// SeekHead
+func findIndexRanges(head []byte, fileLength int64) []indexRange {
+	if len(head) >= 8 && string(head[4:8]) == "ftyp" {
+		return findMp4Index(head, fileLength)
+	}
+	if len(head) >= 4 && binary.BigEndian.Uint32(head) == mkvIdEbml {
+		return findMatroskaIndex(head, fileLength)
+	}
+	return nil
+}
+
+func findMp4Index(head []byte, fileLength int64) []indexRange {
+	for offset := int64(0); offset + 8 <= int64(len(head)); {
+		size := int64(binary.BigEndian.Uint32(head[offset:]))
+		boxType := string(head[offset + 4:offset + 8])
+		headerSize := int64(8)
+		switch size {
+		case 0:
+			size = fileLength - offset
+		case 1:
+			if offset + 16 > int64(len(head)) {
+				return nil
+			}
+			size, headerSize = int64(binary.BigEndian.Uint64(head[offset + 8:])), 16
+		}
+		if size < headerSize {
+			return nil
+		}
+
+		switch boxType {
+		case "moov":
+			// индекс в начале, его скачают первым по порядку
+			return nil
+		case "mdat":
+			moovStart := offset + size
+			if moovStart >= fileLength {
+				return nil
+			}
+			return []indexRange{{Start: moovStart, End: minInt64(fileLength, moovStart + maxIndexPrefetch)}}
+		}
+		offset += size
+	}
+	return nil
+}
+
+func findMatroskaIndex(head []byte, fileLength int64) []indexRange {
+	_, dataOffset, size, ok := readMkvElement(head, 0)
+	if !ok {
+		return nil
+	}
+	id, segmentData, segmentSize, ok := readMkvElement(head, dataOffset + size)
+	if !ok || id != mkvIdSegment {
+		return nil
+	}
+	segmentEnd := fileLength
+	if segmentSize >= 0 && segmentData + segmentSize < fileLength {
+		segmentEnd = segmentData + segmentSize
+	}
+
+	seeks := make(map[uint32]int64)
+	for offset := segmentData; offset < int64(len(head)); {
+		id, elementData, elementSize, ok := readMkvElement(head, offset)
+		if !ok || id == mkvIdCluster || elementSize < 0 {
+			break
+		}
+		if id == mkvIdSeekHead && elementData + elementSize <= int64(len(head)) {
+			parseMkvSeekHead(head[elementData:elementData + elementSize], seeks)
+		}
+		offset = elementData + elementSize
+	}
+
+	ranges := make([]indexRange, 0, 3)
+	for _, id := range []uint32{mkvIdInfo, mkvIdTracks, mkvIdCues} {
+		position, known := seeks[id]
+		start := segmentData + position
+		if !known || start < int64(len(head)) || start >= segmentEnd {
+			continue
+		}
+		// элемент кончается не дальше следующего из SeekHead
+		end := minInt64(segmentEnd, start + maxIndexPrefetch)
+		for _, other := range seeks {
+			if otherStart := segmentData + other; otherStart > start && otherStart < end {
+				end = otherStart
+			}
+		}
+		ranges = append(ranges, indexRange{Start: start, End: end})
+	}
+	return ranges
+}
+
+func parseMkvSeekHead(data []byte, seeks map[uint32]int64) {
+	for offset := int64(0); offset < int64(len(data)); {
+		id, seekData, seekSize, ok := readMkvElement(data, offset)
+		if !ok || seekSize < 0 || seekData + seekSize > int64(len(data)) {
+			return
+		}
+		if id == mkvIdSeek {
+			var seekId uint32
+			position := int64(-1)
+			for child := seekData; child < seekData + seekSize; {
+				childId, valueOffset, valueSize, ok := readMkvElement(data, child)
+				if !ok || valueSize < 0 || valueOffset + valueSize > seekData + seekSize {
+					break
+				}
+				value := uint64(0)
+				for _, b := range data[valueOffset:valueOffset + valueSize] {
+					value = value << 8 | uint64(b)
+				}
+				switch childId {
+				case mkvIdSeekId:
+					seekId = uint32(value)
+				case mkvIdSeekPosition:
+					position = int64(value)
+				}
+				child = valueOffset + valueSize
+			}
+			if _, exists := seeks[seekId]; !exists && position >= 0 {
+				seeks[seekId] = position
+			}
+		}
+		offset = seekData + seekSize
+	}
+}
+
+// readMkvElement reads EBML element header, size is -1 for unknown size
+func readMkvElement(data []byte, offset int64) (id uint32, dataOffset int64, size int64, ok bool) {
+	if offset >= int64(len(data)) {
+		return 0, 0, 0, false
+	}
+	idLen := mkvVintLength(data[offset])
+	if idLen == 0 || idLen > 4 || offset + int64(idLen) >= int64(len(data)) {
+		return 0, 0, 0, false
+	}
+	for _, b := range data[offset:offset + int64(idLen)] {
+		id = id << 8 | uint32(b)
+	}
+
+	sizeOffset := offset + int64(idLen)
+	sizeLen := mkvVintLength(data[sizeOffset])
+	if sizeLen == 0 || sizeOffset + int64(sizeLen) > int64(len(data)) {
+		return 0, 0, 0, false
+	}
+	value := uint64(data[sizeOffset] & (0xFF >> uint(sizeLen)))
+	for _, b := range data[sizeOffset + 1:sizeOffset + int64(sizeLen)] {
+		value = value << 8 | uint64(b)
+	}
+	size = int64(value)
+	if value == 1 << uint(7 * sizeLen) - 1 {
+		size = -1
+	}
+	return id, sizeOffset + int64(sizeLen), size, true
+}
+
+func mkvVintLength(first byte) int {
+	for i := 0; i < 8; i++ {
+		if first & (0x80 >> uint(i)) != 0 {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+func minInt64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
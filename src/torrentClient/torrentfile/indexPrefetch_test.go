@@ -0,0 +1,61 @@
+package torrentfile
+
+import (
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+func mp4Box(boxType string, size int) []byte {
+	box := make([]byte, 8)
+	binary.BigEndian.PutUint32(box, uint32(size))
+	copy(box[4:], boxType)
+	return box
+}
+
+func mkvElement(id []byte, payload ...byte) []byte {
+	return append(append(append([]byte{}, id...), 0x80 | byte(len(payload))), payload...)
+}
+
+func TestFindMp4Index(t *testing.T) {
+	ftyp := append(mp4Box("ftyp", 16), []byte("isom\x00\x00\x02\x00")...)
+
+	moovAtEnd := append(append([]byte{}, ftyp...), mp4Box("mdat", 1000)...)
+	expected := []indexRange{{Start: 1016, End: 1500}}
+	if ranges := findIndexRanges(moovAtEnd, 1500); !reflect.DeepEqual(ranges, expected) {
+		t.Errorf("moov at end: got %v, expected %v", ranges, expected)
+	}
+
+	moovAtStart := append(append([]byte{}, ftyp...), mp4Box("moov", 100)...)
+	if ranges := findIndexRanges(moovAtStart, 1500); len(ranges) != 0 {
+		t.Errorf("moov at start: got %v", ranges)
+	}
+
+	huge := append(append([]byte{}, ftyp...), mp4Box("mdat", 16)...)
+	expected = []indexRange{{Start: 32, End: 32 + maxIndexPrefetch}}
+	if ranges := findIndexRanges(huge, 1 << 30); !reflect.DeepEqual(ranges, expected) {
+		t.Errorf("long tail: got %v, expected %v", ranges, expected)
+	}
+}
+
+func TestFindMatroskaIndex(t *testing.T) {
+	seek := func(id []byte, position uint16) []byte {
+		return mkvElement([]byte{0x4D, 0xBB}, append(mkvElement([]byte{0x53, 0xAB}, id...),
+			mkvElement([]byte{0x53, 0xAC}, byte(position >> 8), byte(position))...)...)
+	}
+	seekHead := mkvElement([]byte{0x11, 0x4D, 0x9B, 0x74}, append(append(
+		seek([]byte{0x15, 0x49, 0xA9, 0x66}, 0x0000),    // Info в начале
+		seek([]byte{0x1C, 0x53, 0xBB, 0x6B}, 0x0F00)...), // Cues
+		seek([]byte{0x12, 0x54, 0xC3, 0x67}, 0x0F80)...)...) // Tags после Cues
+
+	head := mkvElement([]byte{0x1A, 0x45, 0xDF, 0xA3}, 0x42, 0x82, 0x81, 'm')
+	head = append(head, 0x18, 0x53, 0x80, 0x67, 0x01, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF) // Segment неизвестной длины
+	segmentData := int64(len(head))
+	head = append(head, seekHead...)
+
+	ranges := findIndexRanges(head, 0x10000)
+	expected := []indexRange{{Start: segmentData + 0x0F00, End: segmentData + 0x0F80}}
+	if !reflect.DeepEqual(ranges, expected) {
+		t.Errorf("got %v, expected %v", ranges, expected)
+	}
+}
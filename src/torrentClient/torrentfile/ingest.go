@@ -0,0 +1,253 @@
+package torrentfile
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"torrentClient/db"
+	"torrentClient/magnetToTorrent"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	maxTorrentFileSize = 10 << 20
+	torrentFetchTimeout = time.Second * 30
+	maxTorrentRedirects = 5
+)
+
+var (
+	ErrTorrentUrlNotAllowed = errors.New("torrent url is not allowed")
+	ErrTorrentFileTooBig    = errors.New("torrent file is too big")
+)
+
+// адреса внутренней сети docker и облачных метаданных, ссылка из запроса
+// пользователя не должна до них доставать
+var notPublicNets = func() (nets []*net.IPNet) {
+	for _, cidr := range []string{
+		"0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "127.0.0.0/8", "169.254.0.0/16",
+		"172.16.0.0/12", "192.168.0.0/16", "::/128", "::1/128", "fc00::/7", "fe80::/10",
+	} {
+		_, ipNet, _ := net.ParseCIDR(cidr)
+		nets = append(nets, ipNet)
+	}
+	return nets
+}()
+
+type IngestedFile struct {
+	Index	int			`json:"index"`
+	Path	[]string	`json:"path"`
+	Length	int			`json:"length"`
+}
+
+type IngestResult struct {
+	FileId		string			`json:"fileId"`
+	InfoHash	string			`json:"infoHash"`
+	Name		string			`json:"name"`
+	Length		int				`json:"length"`
+	Files		[]IngestedFile	`json:"files"`
+	Meta		TorrentMeta		`json:"meta"`
+	WebSeeds	[]string		`json:"webSeeds"`
+	Existing	bool			`json:"existing"`
+}
+
+// AddTorrent validates .torrent body and creates a record for it, if there is
+// no record with the same info hash yet
+func (t *torrentsManager) AddTorrent(torrentBytes []byte) (*IngestResult, error) {
+	torrent, err := t.ParseReaderToTorrent(bytes.NewReader(torrentBytes))
+	if err != nil || torrent == nil {
+		return nil, fmt.Errorf("invalid torrent file: %v", err)
+	}
+	if len(torrent.GetFiles()) == 0 {
+		return nil, fmt.Errorf("invalid torrent file: no files")
+	}
+
+	infoHash := torrent.GetInfoHash()
+	hexHash := hex.EncodeToString(infoHash[:])
+
+	if fileId, exists := db.GetFilesManagerDb().GetFileIdByInfoHash(hexHash); exists {
+		return makeIngestResult(fileId, hexHash, torrent, true), nil
+	}
+
+	isNew := db.GetFilesManagerDb().AddTorrentRecord(hexHash, hexHash, torrentBytes, "", torrent.GetName())
+	return makeIngestResult(hexHash, hexHash, torrent, !isNew), nil
+}
+
+// AddMagnet creates a record for the magnet link, the link is converted to get
+// files listing, but only the link itself is saved
+func (t *torrentsManager) AddMagnet(magnet string) (*IngestResult, error) {
+	parsed, err := ParseMagnet(magnet)
+	if err != nil {
+		return nil, fmt.Errorf("invalid magnet: %v", err)
+	}
+	if !parsed.HasInfoHash {
+		return nil, fmt.Errorf("v2 only magnets are not supported")
+	}
+	hexHash := parsed.InfoHashHex()
+
+	if fileId, exists := db.GetFilesManagerDb().GetFileIdByInfoHash(hexHash); exists {
+		torrent, err := t.LoadTorrentFileFromDB(fileId)
+		if err != nil {
+			return nil, err
+		}
+		return makeIngestResult(fileId, hexHash, torrent, true), nil
+	}
+
+	torrentBytes, err := magnetToTorrent.ConvertMagnetToTorrent(magnet)
+	if err != nil {
+		return nil, fmt.Errorf("error converting magnet: %v", err)
+	}
+	torrent, err := t.ParseReaderToTorrent(bytes.NewReader(torrentBytes))
+	if err != nil || torrent == nil {
+		return nil, fmt.Errorf("error parsing converted magnet: %v", err)
+	}
+
+	infoHash := torrent.GetInfoHash()
+	if hex.EncodeToString(infoHash[:]) != hexHash {
+		return nil, fmt.Errorf("converted torrent info hash doesn't match magnet")
+	}
+
+	torrent.addWebSeeds(parsed.WebSeeds)
+	isNew := db.GetFilesManagerDb().AddTorrentRecord(hexHash, hexHash, nil, magnet, torrent.GetName())
+	return makeIngestResult(hexHash, hexHash, torrent, !isNew), nil
+}
+
+func isPublicIp(ip net.IP) bool {
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	if ip.IsUnspecified() || ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsMulticast() {
+		return false
+	}
+	for _, ipNet := range notPublicNets {
+		if ipNet.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// resolvePublicHost returns addresses of the host, all of them must be public
+func resolvePublicHost(ctx context.Context, host string) ([]net.IP, error) {
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	ips := make([]net.IP, 0, len(addrs))
+	for _, addr := range addrs {
+		if !isPublicIp(addr.IP) {
+			return nil, fmt.Errorf("%w: %v resolves to %v", ErrTorrentUrlNotAllowed, host, addr.IP)
+		}
+		ips = append(ips, addr.IP)
+	}
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("no addresses for %v", host)
+	}
+	return ips, nil
+}
+
+// dialPublic connects only to the checked address, so dns can't answer with an
+// internal one between the check and the connection
+func dialPublic(ctx context.Context, network, address string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	ips, err := resolvePublicHost(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	dialer := net.Dialer{Timeout: torrentFetchTimeout}
+	for _, ip := range ips {
+		var conn net.Conn
+		if conn, err = dialer.DialContext(ctx, network, net.JoinHostPort(ip.String(), port)); err == nil {
+			return conn, nil
+		}
+	}
+	return nil, err
+}
+
+func checkTorrentUrl(ctx context.Context, torrentUrl *url.URL) error {
+	if torrentUrl.Scheme != "http" && torrentUrl.Scheme != "https" || torrentUrl.Hostname() == "" {
+		return fmt.Errorf("%w: %v", ErrTorrentUrlNotAllowed, torrentUrl)
+	}
+	_, err := resolvePublicHost(ctx, torrentUrl.Hostname())
+	return err
+}
+
+var torrentFetchClient = &http.Client{
+	Timeout: torrentFetchTimeout,
+	Transport: &http.Transport{
+		DialContext:         dialPublic,
+		TLSHandshakeTimeout: torrentFetchTimeout,
+	},
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if len(via) >= maxTorrentRedirects {
+			return fmt.Errorf("too many redirects")
+		}
+		return checkTorrentUrl(req.Context(), req.URL)
+	},
+}
+
+// FetchTorrentFile downloads .torrent from http(s) url, only public hosts are
+// allowed
+func (t *torrentsManager) FetchTorrentFile(torrentUrl string) ([]byte, error) {
+	parsed, err := url.Parse(torrentUrl)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrTorrentUrlNotAllowed, torrentUrl)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), torrentFetchTimeout)
+	defer cancel()
+	if err := checkTorrentUrl(ctx, parsed); err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, parsed.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrTorrentUrlNotAllowed, err)
+	}
+	resp, err := torrentFetchClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching torrent: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error fetching torrent: status %v", resp.Status)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxTorrentFileSize + 1))
+	if err != nil {
+		return nil, fmt.Errorf("error reading torrent: %v", err)
+	}
+	if len(body) > maxTorrentFileSize {
+		return nil, ErrTorrentFileTooBig
+	}
+	logrus.Debugf("Fetched torrent from %v: %v bytes", torrentUrl, len(body))
+	return body, nil
+}
+
+func makeIngestResult(fileId, infoHash string, torrent *TorrentFile, existing bool) *IngestResult {
+	result := &IngestResult{
+		FileId:   fileId,
+		InfoHash: infoHash,
+		Name:     torrent.GetName(),
+		Length:   torrent.GetLength(),
+		Meta:     torrent.Meta,
+		WebSeeds: torrent.WebSeeds,
+		Existing: existing,
+	}
+	for i, file := range torrent.GetFiles() {
+		result.Files = append(result.Files, IngestedFile{Index: i, Path: file.Path, Length: file.Length})
+	}
+	return result
+}
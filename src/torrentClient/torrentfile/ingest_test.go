@@ -0,0 +1,60 @@
+package torrentfile
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestIsPublicIp(t *testing.T) {
+	cases := map[string]bool{
+		"8.8.8.8":         true,
+		"2a00:1450::1":    true,
+		"127.0.0.1":       false,
+		"10.1.2.3":        false,
+		"172.20.0.5":      false,
+		"192.168.1.1":     false,
+		"169.254.169.254": false,
+		"100.64.0.1":      false,
+		"0.0.0.0":         false,
+		"::":              false,
+		"::1":             false,
+		"fd00::1":         false,
+		"fe80::1":         false,
+		"::ffff:10.0.0.1": false,
+	}
+	for src, expected := range cases {
+		if isPublicIp(net.ParseIP(src)) != expected {
+			t.Errorf("isPublicIp(%v) != %v", src, expected)
+		}
+	}
+}
+
+func TestFetchTorrentFileRejectsInternalHosts(t *testing.T) {
+	fetched := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetched = true
+	}))
+	defer server.Close()
+
+	for _, torrentUrl := range []string{server.URL, "http://localhost/x.torrent", "ftp://example.com/x.torrent", "http:///x"} {
+		if _, err := GetManager().FetchTorrentFile(torrentUrl); !errors.Is(err, ErrTorrentUrlNotAllowed) {
+			t.Errorf("%v: expected not allowed, got %v", torrentUrl, err)
+		}
+	}
+	if fetched {
+		t.Errorf("internal server was requested")
+	}
+
+	// сервер с публичным адресом может перенаправить на внутренний
+	req := httptest.NewRequest(http.MethodGet, "http://169.254.169.254/latest/meta-data", nil)
+	if err := torrentFetchClient.CheckRedirect(req, []*http.Request{req}); !errors.Is(err, ErrTorrentUrlNotAllowed) {
+		t.Errorf("redirect to internal host allowed: %v", err)
+	}
+	if _, err := dialPublic(context.Background(), "tcp", server.Listener.Addr().String()); !errors.Is(err, ErrTorrentUrlNotAllowed) {
+		t.Errorf("dial to internal address allowed: %v", err)
+	}
+}
@@ -0,0 +1,174 @@
+package torrentfile
+
+import (
+	"encoding/base32"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"torrentClient/peers"
+
+	"github.com/sirupsen/logrus"
+)
+
+// multihash prefix for sha2-256 with 32 bytes digest (BEP 52 btmh)
+const sha256MultihashPrefix = "1220"
+
+const maxSelectOnlyRange = 1e4
+
+type Magnet struct {
+	InfoHash    [20]byte
+	HasInfoHash bool
+	InfoHashV2  [32]byte
+	HasInfoHashV2 bool
+
+	DisplayName string
+	Trackers    []string
+	WebSeeds    []string // ws (BEP 19), отдаются вместе с файлами торрента
+	Peers       []string // host:port из x.pe
+	SelectOnly  []int    // индексы файлов из so (BEP 53), пусто - все файлы
+}
+
+// ParseMagnet reads magnet uri, keys may have numeric suffixes like tr.1 or xt.2
+func ParseMagnet(src string) (*Magnet, error) {
+	if !strings.HasPrefix(strings.ToLower(src), "magnet:?") {
+		return nil, fmt.Errorf("not a magnet uri")
+	}
+
+	magnet := &Magnet{}
+
+	for _, pair := range strings.Split(src[len("magnet:?"):], "&") {
+		if pair == "" {
+			continue
+		}
+		split := strings.SplitN(pair, "=", 2)
+		if len(split) != 2 {
+			continue
+		}
+		key := strings.ToLower(split[0])
+		if dot := strings.Index(key, "."); dot > 0 && key != "x.pe" {
+			if _, err := strconv.Atoi(key[dot + 1:]); err == nil {
+				key = key[:dot]
+			}
+		}
+		// "+" в tr и ws бывает частью адреса, пробел в значениях кодируют %20
+		value, err := url.PathUnescape(split[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid %v value: %v", key, err)
+		}
+
+		switch key {
+		case "xt":
+			if err := magnet.parseExactTopic(value); err != nil {
+				return nil, err
+			}
+		case "dn":
+			magnet.DisplayName = value
+		case "tr":
+			if StrArrayIdx(magnet.Trackers, value) < 0 {
+				magnet.Trackers = append(magnet.Trackers, value)
+			}
+		case "ws":
+			magnet.WebSeeds = append(magnet.WebSeeds, value)
+		case "x.pe":
+			if _, _, err := net.SplitHostPort(value); err != nil {
+				logrus.Debugf("Skipping invalid magnet peer %v: %v", value, err)
+				continue
+			}
+			magnet.Peers = append(magnet.Peers, value)
+		case "so":
+			selected, err := parseSelectOnly(value)
+			if err != nil {
+				return nil, err
+			}
+			magnet.SelectOnly = append(magnet.SelectOnly, selected...)
+		}
+	}
+
+	if !magnet.HasInfoHash && !magnet.HasInfoHashV2 {
+		return nil, fmt.Errorf("magnet has no btih or btmh")
+	}
+	return magnet, nil
+}
+
+func (m *Magnet) InfoHashHex() string {
+	return hex.EncodeToString(m.InfoHash[:])
+}
+
+// ResolvePeers converts x.pe addresses, host names are resolved
+func (m *Magnet) ResolvePeers() []peers.Peer {
+	res := make([]peers.Peer, 0, len(m.Peers))
+	for _, addr := range m.Peers {
+		tcpAddr, err := net.ResolveTCPAddr("tcp", addr)
+		if err != nil {
+			logrus.Errorf("Error resolving magnet peer %v: %v", addr, err)
+			continue
+		}
+		res = append(res, peers.Peer{IP: tcpAddr.IP, Port: uint16(tcpAddr.Port)})
+	}
+	return res
+}
+
+func (m *Magnet) parseExactTopic(value string) error {
+	switch {
+	case strings.HasPrefix(value, "urn:btih:"):
+		hash := strings.TrimPrefix(value, "urn:btih:")
+		var decoded []byte
+		var err error
+
+		switch len(hash) {
+		case 40:
+			decoded, err = hex.DecodeString(hash)
+		case 32:
+			decoded, err = base32.StdEncoding.DecodeString(strings.ToUpper(hash))
+		default:
+			err = fmt.Errorf("unexpected length %v", len(hash))
+		}
+		if err != nil {
+			return fmt.Errorf("invalid btih %v: %v", hash, err)
+		}
+		copy(m.InfoHash[:], decoded)
+		m.HasInfoHash = true
+	case strings.HasPrefix(value, "urn:btmh:"):
+		hash := strings.ToLower(strings.TrimPrefix(value, "urn:btmh:"))
+		if !strings.HasPrefix(hash, sha256MultihashPrefix) || len(hash) != len(sha256MultihashPrefix) + 64 {
+			return fmt.Errorf("unsupported btmh %v", hash)
+		}
+		decoded, err := hex.DecodeString(hash[len(sha256MultihashPrefix):])
+		if err != nil {
+			return fmt.Errorf("invalid btmh %v: %v", hash, err)
+		}
+		copy(m.InfoHashV2[:], decoded)
+		m.HasInfoHashV2 = true
+	}
+	return nil
+}
+
+// parseSelectOnly parses "0,2,4-6" into indexes
+func parseSelectOnly(value string) ([]int, error) {
+	res := make([]int, 0, 5)
+
+	for _, part := range strings.Split(value, ",") {
+		if part == "" {
+			continue
+		}
+		bounds := strings.SplitN(part, "-", 2)
+		start, err := strconv.Atoi(bounds[0])
+		if err != nil || start < 0 {
+			return nil, fmt.Errorf("invalid so value %v", part)
+		}
+		end := start
+		if len(bounds) == 2 {
+			if end, err = strconv.Atoi(bounds[1]); err != nil || end < start || end - start > maxSelectOnlyRange {
+				return nil, fmt.Errorf("invalid so value %v", part)
+			}
+		}
+		for idx := start; idx <= end; idx++ {
+			res = append(res, idx)
+		}
+	}
+	return res, nil
+}
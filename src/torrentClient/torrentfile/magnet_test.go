@@ -0,0 +1,80 @@
+package torrentfile
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseMagnet(t *testing.T) {
+	src := "magnet:?xt=urn:btih:c9e15763f722f23e98a29decdfae341b98d53056" +
+		"&xt=urn:btmh:1220caf1e1c30e81cb361b9ee167c4aa64228a7fa4fa9f6105232b28ad099f3a302e" +
+		"&dn=Big%20Buck%20Bunny" +
+		"&tr=udp%3A%2F%2Ftracker.one%3A80&tr.1=http%3A%2F%2Ftracker.two%2Fannounce%3Fkey%3Da+b&tr=udp%3A%2F%2Ftracker.one%3A80" +
+		"&ws=https%3A%2F%2Fseed.example%2Fbunny%2F" +
+		"&x.pe=10.0.0.1%3A6881&x.pe=%5B%3A%3A1%5D%3A51413&x.pe=broken" +
+		"&so=0,2,4-6"
+
+	magnet, err := ParseMagnet(src)
+	if err != nil {
+		t.Fatalf("Error parsing magnet: %v", err)
+	}
+
+	if !magnet.HasInfoHash || magnet.InfoHashHex() != "c9e15763f722f23e98a29decdfae341b98d53056" {
+		t.Errorf("Unexpected btih: %v", magnet.InfoHashHex())
+	}
+	if !magnet.HasInfoHashV2 || magnet.InfoHashV2[0] != 0xca {
+		t.Errorf("btmh not parsed")
+	}
+	if magnet.DisplayName != "Big Buck Bunny" {
+		t.Errorf("Unexpected dn: %v", magnet.DisplayName)
+	}
+	if !reflect.DeepEqual(magnet.Trackers, []string{"udp://tracker.one:80", "http://tracker.two/announce?key=a+b"}) {
+		t.Errorf("Unexpected trackers: %v", magnet.Trackers)
+	}
+	if !reflect.DeepEqual(magnet.WebSeeds, []string{"https://seed.example/bunny/"}) {
+		t.Errorf("Unexpected web seeds: %v", magnet.WebSeeds)
+	}
+	if !reflect.DeepEqual(magnet.Peers, []string{"10.0.0.1:6881", "[::1]:51413"}) {
+		t.Errorf("Unexpected peers: %v", magnet.Peers)
+	}
+	if !reflect.DeepEqual(magnet.SelectOnly, []int{0, 2, 4, 5, 6}) {
+		t.Errorf("Unexpected so: %v", magnet.SelectOnly)
+	}
+
+	resolved := magnet.ResolvePeers()
+	if len(resolved) != 2 || resolved[1].Port != 51413 {
+		t.Errorf("Unexpected resolved peers: %v", resolved)
+	}
+}
+
+func TestParseMagnetBase32(t *testing.T) {
+	magnet, err := ParseMagnet("magnet:?xt=urn:btih:ZHQVOY7XELZD5GFCTXWN7LRUDOMNKMCW")
+	if err != nil {
+		t.Fatalf("Error parsing magnet: %v", err)
+	}
+	if magnet.InfoHashHex() != "c9e15763f722f23e98a29decdfae341b98d53056" {
+		t.Errorf("Unexpected btih: %v", magnet.InfoHashHex())
+	}
+}
+
+func TestParseMagnetErrors(t *testing.T) {
+	for _, src := range []string{
+		"http://example.com",
+		"magnet:?dn=no+hash",
+		"magnet:?xt=urn:btih:123",
+		"magnet:?xt=urn:btih:c9e15763f722f23e98a29decdfae341b98d53056&so=3-1",
+	} {
+		if _, err := ParseMagnet(src); err == nil {
+			t.Errorf("Expected error for %v", src)
+		}
+	}
+}
+
+func TestApplyMagnetWebSeeds(t *testing.T) {
+	torrent := &TorrentFile{WebSeeds: []string{"https://seed.example/a"}}
+	torrent.ApplyMagnet(&Magnet{WebSeeds: []string{"https://seed.example/b", "https://seed.example/a"}})
+
+	if !reflect.DeepEqual(torrent.WebSeeds, []string{"https://seed.example/a", "https://seed.example/b"}) {
+		t.Errorf("Unexpected web seeds: %v", torrent.WebSeeds)
+	}
+}
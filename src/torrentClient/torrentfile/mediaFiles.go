@@ -0,0 +1,121 @@
+package torrentfile
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	MediaVideo     = "video"
+	MediaSubtitles = "subtitles"
+	MediaAudio     = "audio"
+	MediaImage     = "image"
+	MediaOther     = "other"
+)
+
+var mediaTypesByExtension = map[string]string{
+	"mkv": MediaVideo, "mp4": MediaVideo, "m4v": MediaVideo, "avi": MediaVideo, "mov": MediaVideo,
+	"webm": MediaVideo, "ts": MediaVideo, "m2ts": MediaVideo, "mpg": MediaVideo, "mpeg": MediaVideo,
+	"wmv": MediaVideo, "flv": MediaVideo, "ogv": MediaVideo,
+
+	"srt": MediaSubtitles, "ass": MediaSubtitles, "ssa": MediaSubtitles, "vtt": MediaSubtitles,
+	"sub": MediaSubtitles, "idx": MediaSubtitles, "ttml": MediaSubtitles, "dfxp": MediaSubtitles,
+
+	"mp3": MediaAudio, "flac": MediaAudio, "aac": MediaAudio, "ac3": MediaAudio, "dts": MediaAudio,
+	"mka": MediaAudio, "ogg": MediaAudio, "opus": MediaAudio, "wav": MediaAudio, "m4a": MediaAudio,
+
+	"jpg": MediaImage, "jpeg": MediaImage, "png": MediaImage, "gif": MediaImage, "webp": MediaImage,
+}
+
+var episodePatterns = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)s(\d{1,2})[ ._-]?e(\d{1,3})`),
+	regexp.MustCompile(`(?i)(?:^|[^a-z0-9])(\d{1,2})x(\d{2,3})(?:[^0-9]|$)`),
+	regexp.MustCompile(`(?i)season[ ._-]?(\d{1,2})[ ._-]*episode[ ._-]?(\d{1,3})`),
+}
+
+type FileEntry struct {
+	Index     int      `json:"index"`
+	Path      []string `json:"path"`
+	FileName  string   `json:"fileName"`
+	Length    int      `json:"length"`
+	MediaType string   `json:"mediaType"`
+	Season    int      `json:"season,omitempty"`
+	Episode   int      `json:"episode,omitempty"`
+}
+
+func (b *bencodeTorrentFile) MediaType() string {
+	// BEP 47 padding files only align pieces
+	if strings.Contains(b.Attr, "p") {
+		return MediaOther
+	}
+	if mediaType, known := mediaTypesByExtension[strings.ToLower(b.Extension())]; known {
+		return mediaType
+	}
+	return MediaOther
+}
+
+// parseEpisode looks for SxxEyy like patterns in the file name first and then
+// in the parent dirs
+func parseEpisode(path []string) (season int, episode int, ok bool) {
+	for i := len(path) - 1; i >= 0; i-- {
+		for _, pattern := range episodePatterns {
+			match := pattern.FindStringSubmatch(path[i])
+			if match == nil {
+				continue
+			}
+			season, _ = strconv.Atoi(match[1])
+			episode, _ = strconv.Atoi(match[2])
+			return season, episode, true
+		}
+	}
+	return 0, 0, false
+}
+
+func (t *TorrentFile) GetFileEntries() []FileEntry {
+	files := t.GetFiles()
+	res := make([]FileEntry, 0, len(files))
+
+	for idx, file := range files {
+		entry := FileEntry{
+			Index:     idx,
+			Path:      file.Path,
+			FileName:  file.EncodeFileName(),
+			Length:    file.Length,
+			MediaType: file.MediaType(),
+		}
+		if entry.MediaType == MediaVideo {
+			entry.Season, entry.Episode, _ = parseEpisode(file.Path)
+		}
+		res = append(res, entry)
+	}
+	return res
+}
+
+// GetEpisodes returns selected video files ordered by season and episode,
+// files without episode number go after them in path order
+func (t *TorrentFile) GetEpisodes() []FileEntry {
+	episodes := make([]FileEntry, 0, 10)
+	for _, entry := range t.GetFileEntries() {
+		if entry.MediaType == MediaVideo && t.IsFileSelected(entry.Index) {
+			episodes = append(episodes, entry)
+		}
+	}
+
+	sort.SliceStable(episodes, func(i, j int) bool {
+		a, b := episodes[i], episodes[j]
+		aHas, bHas := a.Episode > 0, b.Episode > 0
+		if aHas != bHas {
+			return aHas
+		}
+		if a.Season != b.Season {
+			return a.Season < b.Season
+		}
+		if a.Episode != b.Episode {
+			return a.Episode < b.Episode
+		}
+		return strings.Join(a.Path, "/") < strings.Join(b.Path, "/")
+	})
+	return episodes
+}
@@ -0,0 +1,48 @@
+package torrentfile
+
+import "testing"
+
+func TestParseEpisode(t *testing.T) {
+	cases := []struct {
+		path    []string
+		season  int
+		episode int
+		ok      bool
+	}{
+		{[]string{"Show.S02E05.1080p.mkv"}, 2, 5, true},
+		{[]string{"Show s1.e12 720p.mkv"}, 1, 12, true},
+		{[]string{"Show 3x07 HDTV.avi"}, 3, 7, true},
+		{[]string{"Show Season 4", "Show.Season.4.Episode.10.mp4"}, 4, 10, true},
+		{[]string{"Show.S01E01-E10", "part.mkv"}, 1, 1, true},
+		{[]string{"Movie.1920x1080.mkv"}, 0, 0, false},
+		{[]string{"Movie.2012.mkv"}, 0, 0, false},
+	}
+
+	for _, c := range cases {
+		season, episode, ok := parseEpisode(c.path)
+		if season != c.season || episode != c.episode || ok != c.ok {
+			t.Errorf("%v: got (%v, %v, %v)", c.path, season, episode, ok)
+		}
+	}
+}
+
+func TestGetEpisodesOrder(t *testing.T) {
+	torrent := TorrentFile{Files: []bencodeTorrentFile{
+		{Path: []string{"Show.S01E10.mkv"}, Length: 10},
+		{Path: []string{"sample.mkv"}, Length: 1},
+		{Path: []string{"Show.S01E02.mkv"}, Length: 30},
+		{Path: []string{"Show.S01E02.en.srt"}, Length: 1},
+		{Path: []string{"Show.S02E01.mkv"}, Length: 20},
+	}}
+
+	episodes := torrent.GetEpisodes()
+	expected := []int{2, 0, 4, 1}
+	if len(episodes) != len(expected) {
+		t.Fatalf("Unexpected episodes: %v", episodes)
+	}
+	for i, idx := range expected {
+		if episodes[i].Index != idx {
+			t.Errorf("Position %v: expected file %v, got %v", i, idx, episodes[i].Index)
+		}
+	}
+}
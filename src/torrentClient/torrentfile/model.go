@@ -6,6 +6,8 @@ import (
 	"strings"
 	"sync"
 	"time"
+
+	"torrentClient/peers"
 )
 
 type TorrentFile struct {
@@ -20,6 +22,11 @@ type TorrentFile struct {
 	Files		[]bencodeTorrentFile
 	FileBoundariesMapping	 []FileBoundaries
 	Name        string
+	RawInfo		[]byte
+	Meta		TorrentMeta
+	WebSeeds	[]string
+	DirectPeers	[]peers.Peer
+	SelectedFiles	[]int // пусто - грузим все файлы
 	SysInfo     SystemInfo
 	Download    DownloadUtils
 }
@@ -54,6 +61,28 @@ func (t *TorrentFile) SetFiles(src []bencodeTorrentFile) {
 	t.mu.Unlock()
 }
 
+func (t *TorrentFile) IsFileSelected(idx int) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if len(t.SelectedFiles) == 0 {
+		return true
+	}
+	for _, selected := range t.SelectedFiles {
+		if selected == idx {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *TorrentFile) IsPrivate() (res bool) {
+	t.mu.Lock()
+	res = t.Meta.Private
+	t.mu.Unlock()
+	return res
+}
+
 func (t *TorrentFile) GetName() (res string) {
 	t.mu.Lock()
 	res = t.Name
@@ -133,6 +162,9 @@ type bencodeInfoSingleFile struct {
 	PieceLength int    `bencode:"piece length"`
 	Length      int    `bencode:"length"`
 	Name        string `bencode:"name"`
+	Md5sum      string `bencode:"md5sum,omitempty"`
+	Private     int    `bencode:"private,omitempty"`
+	Source      string `bencode:"source,omitempty"`
 }
 
 type bencodeInfoMultiFiles struct {
@@ -140,11 +172,15 @@ type bencodeInfoMultiFiles struct {
 	PieceLength int    `bencode:"piece length"`
 	Files      []bencodeTorrentFile    `bencode:"files"`
 	Name        string `bencode:"name"`
+	Private     int    `bencode:"private,omitempty"`
+	Source      string `bencode:"source,omitempty"`
 }
 
 type bencodeTorrentFile struct {
 	Length int      `bencode:"length"`
 	Path   []string `bencode:"path"`
+	Md5sum string   `bencode:"md5sum,omitempty"`
+	Attr   string   `bencode:"attr,omitempty"`
 }
 
 func (b *bencodeTorrentFile) EncodeFileName() string {
@@ -152,6 +188,13 @@ func (b *bencodeTorrentFile) EncodeFileName() string {
 	return fmt.Sprintf("%x", hash[:])
 }
 
+func (b *bencodeTorrentFile) OriginalName() string {
+	if len(b.Path) == 0 {
+		return ""
+	}
+	return b.Path[len(b.Path) - 1]
+}
+
 func (b *bencodeTorrentFile) Extension() string {
 	if len(b.Path) == 0 {
 		return ""
@@ -167,15 +210,33 @@ func (b *bencodeTorrentFile) Extension() string {
 type bencodeTorrentSingleFile struct {
 	Announce     string                `bencode:"announce"`
 	AnnounceList [][]string            `bencode:"announce-list"`
+	Comment      string                `bencode:"comment"`
+	CreatedBy    string                `bencode:"created by"`
+	CreationDate int64                 `bencode:"creation date"`
+	Encoding     string                `bencode:"encoding"`
 	Info         bencodeInfoSingleFile `bencode:"info"`
 }
 
 type bencodeTorrentMultiFiles struct {
 	Announce     string                `bencode:"announce"`
 	AnnounceList [][]string            `bencode:"announce-list"`
+	Comment      string                `bencode:"comment"`
+	CreatedBy    string                `bencode:"created by"`
+	CreationDate int64                 `bencode:"creation date"`
+	Encoding     string                `bencode:"encoding"`
 	Info         bencodeInfoMultiFiles `bencode:"info"`
 }
 
+// TorrentMeta is the descriptive part of .torrent, not needed for loading
+type TorrentMeta struct {
+	Comment      string `json:"comment"`
+	CreatedBy    string `json:"createdBy"`
+	CreationDate int64  `json:"creationDate"`
+	Encoding     string `json:"encoding"`
+	Source       string `json:"source"`
+	Private      bool   `json:"private"`
+}
+
 func (bto *bencodeTorrentMultiFiles) SumFilesLength() int {
 	res := 0
 	for _, file := range bto.Info.Files {
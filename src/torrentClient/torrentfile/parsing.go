@@ -27,14 +27,18 @@ func (t *torrentsManager) ParseReaderToTorrent(body io.Reader) (*TorrentFile, er
 	if err != nil {
 		return nil, err
 	}
+	rawInfo, err := rawDictValue(readBody, "info")
+	if err != nil {
+		return nil, fmt.Errorf("error extracting info dict: %v", err)
+	}
 	logrus.Infof("Parsed torrent!")
 
 	var result *TorrentFile
 
 	if btoSingle.Info.Length == 0 {
-		result, err = btoMultiFile.toTorrentFile()
+		result, err = btoMultiFile.toTorrentFile(rawInfo)
 	} else {
-		result, err = btoSingle.toTorrentFile()
+		result, err = btoSingle.toTorrentFile(rawInfo)
 	}
 	if err != nil {
 		logrus.Errorf("Error creating torret from bto: %v", err)
@@ -45,11 +49,44 @@ func (t *torrentsManager) ParseReaderToTorrent(body io.Reader) (*TorrentFile, er
 		return nil, fmt.Errorf("failed to parse torrent")
 	}
 
-	logrus.Infof("Bto info: name='%v'; len=%v; files = %v; pieces = (%v)",
-		result.Name, result.Length, result.Files,
-		[]string{hex.EncodeToString(result.PieceHashes[0][:]),
-			hex.EncodeToString(result.PieceHashes[1][:])})
+	result.WebSeeds = parseUrlList(readBody)
+
+	if len(result.PieceHashes) == 0 {
+		return nil, fmt.Errorf("torrent has no pieces")
+	}
+
+	logrus.Infof("Bto info: name='%v'; len=%v; files = %v; pieces = %v, first = %v",
+		result.Name, result.Length, result.Files, len(result.PieceHashes),
+		hex.EncodeToString(result.PieceHashes[0][:]))
 	return result, nil
 }
 
 
+
+// parseUrlList reads BEP 19 url-list, which may be a single string or a list
+func parseUrlList(body []byte) []string {
+	raw, err := rawDictValue(body, "url-list")
+	if err != nil {
+		return nil
+	}
+	decoded, err := bencode.Decode(bytes.NewReader(raw))
+	if err != nil {
+		return nil
+	}
+
+	switch value := decoded.(type) {
+	case string:
+		if value != "" {
+			return []string{value}
+		}
+	case []interface{}:
+		res := make([]string, 0, len(value))
+		for _, item := range value {
+			if str, ok := item.(string); ok && str != "" {
+				res = append(res, str)
+			}
+		}
+		return res
+	}
+	return nil
+}
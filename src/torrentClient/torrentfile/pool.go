@@ -2,11 +2,13 @@ package torrentfile
 
 import (
 	"context"
+	"net/url"
 	"sync"
 	"time"
 
 	"torrentClient/client"
 	"torrentClient/limiter"
+	"torrentClient/metrics"
 	"torrentClient/peers"
 
 	"github.com/sirupsen/logrus"
@@ -35,6 +37,15 @@ type PeersInitializer struct {
 	infoHash [20]byte
 }
 
+// trackerLabel is the host of the announce url, the path and query may have the
+// passkey of a private tracker and /metrics is public
+func trackerLabel(announce string) string {
+	if parsed, err := url.Parse(announce); err == nil && parsed.Host != "" {
+		return parsed.Host
+	}
+	return "unknown"
+}
+
 func (p *PeersPool) SetTorrent(src *TorrentFile) {
 	p.torrent = src
 }
@@ -43,16 +54,32 @@ func (p *PeersPool) StartRefreshing(ctx context.Context)  {
 	go p.ClientMaker.ListenForRawPeers(ctx)
 	go p.ClientMaker.ListenForDeadPeers(ctx)
 
-	announceList := make([]string, len(p.torrent.AnnounceList) + 1 + len(generalTrackerList))
-	announceList[0] = p.torrent.Announce
-	copy(announceList[1:1 + len(p.torrent.AnnounceList)], p.torrent.AnnounceList)
-	copy(announceList[1 + len(p.torrent.AnnounceList):], generalTrackerList)
+	announceList := make([]string, 0, len(p.torrent.AnnounceList) + 1 + len(generalTrackerList))
+	announceList = append(announceList, p.torrent.Announce)
+	announceList = append(announceList, p.torrent.AnnounceList...)
+
+	// BEP 27: пиров приватного торрента берем только от его трекеров. DHT, PEX
+	// и LSD мы не поддерживаем и в handshake их не объявляем, так что
+	// достаточно не ходить в общие трекеры и не звонить пирам из magnet
+	isPrivate := p.torrent.IsPrivate()
+	if !isPrivate {
+		announceList = append(announceList, generalTrackerList...)
+	}
 
 	sysInfo := PoolSysInfo{calledPeers: make(map[string]bool, 50)}
 	trackersUsed := make([]string, 0, len(announceList))
 
+	for _, peer := range p.torrent.DirectPeers {
+		if isPrivate {
+			break
+		}
+		logrus.Debugf("Dialing direct peer %v", peer.GetAddr())
+		p.ClientMaker.RawPeersChan <- peer
+		sysInfo.AddCalledPeer(peer.GetAddr())
+	}
+
 	for _, announce := range announceList {
-		if StrArrayIdx(trackersUsed, announce) >= 0 {
+		if announce == "" || StrArrayIdx(trackersUsed, announce) >= 0 {
 			continue
 		} else {
 			trackersUsed = append(trackersUsed, announce)
@@ -79,8 +106,11 @@ func (p *PeersPool) StartRefreshing(ctx context.Context)  {
 					return
 				case <- timer.C:
 					logrus.Debugf("Calling %v after timer call (int=%v min=%v)", trackerInstance.Announce, trackerInstance.TrackerCallInterval, time.Minute)
+					callStart := time.Now()
 					rawPeers, err := trackerInstance.CallFittingScheme(ctx)
+					metrics.TrackerResponseSeconds.Observe(time.Since(callStart).Seconds(), trackerLabel(trackerInstance.Announce))
 					if err != nil {
+						metrics.TrackerErrors.Inc(trackerLabel(trackerInstance.Announce))
 						logrus.Errorf("Error requesting peers: %v", err)
 						return
 					}
@@ -103,6 +133,9 @@ func (p *PeersPool) StartRefreshing(ctx context.Context)  {
 }
 
 func (pi *PeersInitializer) InitPeer(ctx context.Context, peer *peers.Peer) (*client.Client, bool) {
+	metrics.HalfOpenPeers.Inc()
+	defer metrics.HalfOpenPeers.Dec()
+
 	c, err := client.New(*peer, pi.myId, pi.infoHash)
 	if err != nil {
 		logrus.Errorf("Could not handshake with %s. Err: %v", peer.GetAddr(), err)
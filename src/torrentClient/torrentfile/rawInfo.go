@@ -0,0 +1,86 @@
+package torrentfile
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+)
+
+const maxBencodeDepth = 64
+
+// rawDictValue returns bencoded value of the key from the top level dict as is.
+// Info hash has to be calculated over the original bytes, re-marshalling a struct
+// loses keys it doesn't know about
+func rawDictValue(data []byte, key string) ([]byte, error) {
+	if len(data) == 0 || data[0] != 'd' {
+		return nil, fmt.Errorf("top level value is not a dict")
+	}
+
+	pos := 1
+	for pos < len(data) && data[pos] != 'e' {
+		keyStart := pos
+		keyEnd, err := skipBencodeValue(data, pos, 0)
+		if err != nil {
+			return nil, err
+		}
+		valueEnd, err := skipBencodeValue(data, keyEnd, 0)
+		if err != nil {
+			return nil, err
+		}
+
+		colon := bytes.IndexByte(data[keyStart:keyEnd], ':')
+		if colon >= 0 && string(data[keyStart + colon + 1:keyEnd]) == key {
+			return data[keyEnd:valueEnd], nil
+		}
+		pos = valueEnd
+	}
+	return nil, fmt.Errorf("key %q not found", key)
+}
+
+// skipBencodeValue returns position right after the value starting at pos
+func skipBencodeValue(data []byte, pos int, depth int) (int, error) {
+	if depth > maxBencodeDepth {
+		return 0, fmt.Errorf("bencode nesting is too deep")
+	}
+	if pos >= len(data) {
+		return 0, fmt.Errorf("unexpected end of data")
+	}
+
+	switch c := data[pos]; {
+	case c == 'i':
+		end := bytes.IndexByte(data[pos:], 'e')
+		if end < 0 {
+			return 0, fmt.Errorf("unterminated integer at %v", pos)
+		}
+		return pos + end + 1, nil
+	case c == 'l' || c == 'd':
+		pos++
+		for pos < len(data) && data[pos] != 'e' {
+			next, err := skipBencodeValue(data, pos, depth + 1)
+			if err != nil {
+				return 0, err
+			}
+			pos = next
+		}
+		if pos >= len(data) {
+			return 0, fmt.Errorf("unterminated list or dict")
+		}
+		return pos + 1, nil
+	case c >= '0' && c <= '9':
+		colon := bytes.IndexByte(data[pos:], ':')
+		if colon < 0 {
+			return 0, fmt.Errorf("invalid string at %v", pos)
+		}
+		length, err := strconv.Atoi(string(data[pos:pos + colon]))
+		if err != nil || length < 0 {
+			return 0, fmt.Errorf("invalid string length at %v", pos)
+		}
+		end := pos + colon + 1 + length
+		if end > len(data) {
+			return 0, fmt.Errorf("string at %v is out of data", pos)
+		}
+		return end, nil
+	default:
+		return 0, fmt.Errorf("unexpected byte %q at %v", c, pos)
+	}
+}
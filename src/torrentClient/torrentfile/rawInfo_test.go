@@ -0,0 +1,46 @@
+package torrentfile
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"strings"
+	"testing"
+)
+
+func TestParseKeepsRawInfo(t *testing.T) {
+	pieces := strings.Repeat("a", 20)
+	// ключи x-unknown и source не входят в структуры, но должны попасть в info hash
+	info := "d6:lengthi100e4:name8:test.mkv12:piece lengthi16384e6:pieces20:" + pieces +
+		"7:privatei1e6:source3:XYZ9:x-unknownli1ei2eee"
+	body := "d8:announce39:http://tracker.example/announce?pk=abcd" +
+		"7:comment5:hello4:info" + info + "8:url-list22:https://seed.example/ae"
+
+	torrent, err := GetManager().ReadTorrentFileFromBytes(bytes.NewReader([]byte(body)))
+	if err != nil {
+		t.Fatalf("Error parsing torrent: %v", err)
+	}
+
+	if torrent.GetInfoHash() != sha1.Sum([]byte(info)) {
+		t.Errorf("Info hash is not calculated over raw info")
+	}
+	if !torrent.IsPrivate() || torrent.Meta.Source != "XYZ" || torrent.Meta.Comment != "hello" {
+		t.Errorf("Unexpected meta: %+v", torrent.Meta)
+	}
+	if len(torrent.WebSeeds) != 1 || torrent.WebSeeds[0] != "https://seed.example/a" {
+		t.Errorf("Unexpected web seeds: %v", torrent.WebSeeds)
+	}
+
+	tracker := Tracker{Announce: torrent.Announce, Length: torrent.GetLength()}
+	trackerUrl, err := tracker.buildHttpTrackerURL(torrent.Announce)
+	if err != nil || !strings.HasPrefix(trackerUrl, "http://tracker.example/announce?pk=abcd&") {
+		t.Errorf("Passkey lost in tracker url: %v, %v", trackerUrl, err)
+	}
+}
+
+func TestRawDictValueErrors(t *testing.T) {
+	for _, src := range []string{"", "le", "d4:info", "d4:info5:abe", "d3:foo3:bare"} {
+		if _, err := rawDictValue([]byte(src), "info"); err == nil {
+			t.Errorf("Expected error for %q", src)
+		}
+	}
+}
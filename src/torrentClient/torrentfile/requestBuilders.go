@@ -28,7 +28,12 @@ func (t *Tracker) buildHttpTrackerURL(announce string) (string, error) {
 		"compact":    []string{"1"},
 		"left":       []string{strconv.Itoa(t.Length)},
 	}
-	base.RawQuery = params.Encode()
+	// passkey трекера может быть в query, его надо сохранить
+	if base.RawQuery != "" {
+		base.RawQuery += "&" + params.Encode()
+	} else {
+		base.RawQuery = params.Encode()
+	}
 	return base.String(), nil
 }
 
@@ -0,0 +1,164 @@
+package torrentfile
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"torrentClient/db"
+	"torrentClient/parser/env"
+	"torrentClient/subtitles"
+)
+
+// сколько текста читаем для определения языка
+const subtitlesSampleSize = 256 << 10
+
+// текстовые форматы, которые storage умеет отдавать как WebVTT
+var supportedSubtitleFormats = map[string]bool{
+	"srt": true, "ass": true, "ssa": true, "vtt": true, "ttml": true, "dfxp": true, "stl": true, "sub": true,
+}
+
+type SubtitleInfo struct {
+	Id             string `json:"id"`
+	Index          int    `json:"index"`
+	FileName       string `json:"fileName"`
+	Format         string `json:"format"`
+	Size           int    `json:"size"`
+	Language       string `json:"language"`
+	LanguageSource string `json:"languageSource"` // filename, content или пусто
+	Encoding       string `json:"encoding,omitempty"`
+	Forced         bool   `json:"forced"`
+	SDH            bool   `json:"sdh"`
+	IsLoaded       bool   `json:"isLoaded"`
+}
+
+type contentInfo struct {
+	language string
+	encoding string
+	sdh      bool
+}
+
+// результат анализа скачанного файла не меняется
+var contentInfoCache = struct {
+	sync.Mutex
+	items map[string]contentInfo
+}{items: make(map[string]contentInfo)}
+
+func (t *TorrentFile) getSubtitlesFiles() []bencodeTorrentFile {
+	allFiles := t.GetFiles()
+	res := make([]bencodeTorrentFile, 0, len(allFiles))
+	for idx, file := range allFiles {
+		if t.isSubtitlesFile(allFiles, idx) {
+			res = append(res, file)
+		}
+	}
+
+	return res
+}
+
+func (t *TorrentFile) isSubtitlesFile(files []bencodeTorrentFile, idx int) bool {
+	format := strings.ToLower(files[idx].Extension())
+	if !supportedSubtitleFormats[format] || !t.IsFileSelected(idx) {
+		return false
+	}
+	// .sub рядом с .idx - картинки VobSub, а не текст MicroDVD
+	return format != "sub" || !hasVobSubIndex(files, files[idx].Path)
+}
+
+func hasVobSubIndex(files []bencodeTorrentFile, subPath []string) bool {
+	name := subPath[len(subPath) - 1]
+	idxName := strings.TrimSuffix(name, filepath.Ext(name)) + ".idx"
+
+	for _, file := range files {
+		if len(file.Path) != len(subPath) || !strings.EqualFold(file.Path[len(file.Path) - 1], idxName) {
+			continue
+		}
+		if strings.Join(file.Path[:len(file.Path) - 1], "/") == strings.Join(subPath[:len(subPath) - 1], "/") {
+			return true
+		}
+	}
+	return false
+}
+
+// GetSubtitles describes subtitles files, the language comes from the file name
+// and, when the file is downloaded, from its text
+func (t *TorrentFile) GetSubtitles() []SubtitleInfo {
+	_, isRecordLoaded, _ := db.GetFilesManagerDb().GetFileStatus(t.GetFileId())
+
+	files := t.GetFiles()
+	result := make([]SubtitleInfo, 0, 5)
+	for idx, file := range files {
+		if !t.isSubtitlesFile(files, idx) {
+			continue
+		}
+		format := strings.ToLower(file.Extension())
+
+		pathInfo := subtitles.DetectFromPath(file.Path)
+		info := SubtitleInfo{
+			Id:       file.EncodeFileName(),
+			Index:    idx,
+			FileName: strings.Join(file.Path, "_"),
+			Format:   format,
+			Size:     file.Length,
+			Language: pathInfo.Language,
+			Forced:   pathInfo.Forced,
+			SDH:      pathInfo.SDH,
+		}
+		if info.Language != subtitles.Unknown {
+			info.LanguageSource = "filename"
+		}
+
+		if content, ok := analyzeSubtitlesContent(info.Id, file.Length, isRecordLoaded); ok {
+			info.IsLoaded = true
+			info.Encoding = content.encoding
+			info.SDH = info.SDH || content.sdh
+			if info.Language == subtitles.Unknown && content.language != subtitles.Unknown {
+				info.Language = content.language
+				info.LanguageSource = "content"
+			}
+		}
+		result = append(result, info)
+	}
+	return result
+}
+
+func analyzeSubtitlesContent(fileName string, length int, isRecordLoaded bool) (contentInfo, bool) {
+	contentInfoCache.Lock()
+	cached, exists := contentInfoCache.items[fileName]
+	contentInfoCache.Unlock()
+	if exists {
+		return cached, true
+	}
+
+	file, err := os.Open(filepath.Join(env.GetParser().GetFilesDir(), fileName))
+	if err != nil {
+		return contentInfo{}, false
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, subtitlesSampleSize))
+	if err != nil || len(data) == 0 {
+		return contentInfo{}, false
+	}
+	// файл создается заранее и заполняется кусками, пока он не скачан в нем есть дыры из нулей
+	isComplete := len(data) >= length || len(data) == subtitlesSampleSize
+	if !isRecordLoaded && (!isComplete || bytes.Contains(data, make([]byte, 64))) {
+		return contentInfo{}, false
+	}
+
+	encoding := subtitles.DetectEncoding(data)
+	text := subtitles.DecodeText(data, encoding)
+	info := contentInfo{
+		language: subtitles.DetectFromText(text),
+		encoding: encoding,
+		sdh:      subtitles.DetectSDH(text),
+	}
+
+	contentInfoCache.Lock()
+	contentInfoCache.items[fileName] = info
+	contentInfoCache.Unlock()
+	return info, true
+}
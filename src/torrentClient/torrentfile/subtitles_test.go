@@ -0,0 +1,26 @@
+package torrentfile
+
+import "testing"
+
+func TestGetSubtitlesFiles(t *testing.T) {
+	torrent := TorrentFile{Files: []bencodeTorrentFile{
+		{Path: []string{"Movie.mkv"}, Length: 100},
+		{Path: []string{"Movie.en.srt"}, Length: 1},
+		{Path: []string{"Subs", "Movie.ru.ass"}, Length: 1},
+		{Path: []string{"Subs", "Movie.de.sub"}, Length: 1},
+		{Path: []string{"VobSub", "Movie.sub"}, Length: 1},
+		{Path: []string{"VobSub", "Movie.idx"}, Length: 1},
+		{Path: []string{"Movie.nfo"}, Length: 1},
+	}}
+
+	files := torrent.getSubtitlesFiles()
+	expected := []string{"Movie.en.srt", "Movie.ru.ass", "Movie.de.sub"}
+	if len(files) != len(expected) {
+		t.Fatalf("Expected %v subtitles files, got %v", len(expected), len(files))
+	}
+	for i, file := range files {
+		if name := file.Path[len(file.Path) - 1]; name != expected[i] {
+			t.Errorf("Expected %v at %v, got %v", expected[i], i, name)
+		}
+	}
+}
@@ -1,18 +1,11 @@
 package torrentfile
 
 import (
-	"bytes"
 	"crypto/sha1"
 	"fmt"
-
-	"github.com/jackpal/bencode-go"
 )
 
-func (bto *bencodeTorrentSingleFile) toTorrentFile() (*TorrentFile, error) {
-	infoHash, err := bto.Info.hash()
-	if err != nil {
-		return nil, err
-	}
+func (bto *bencodeTorrentSingleFile) toTorrentFile(rawInfo []byte) (*TorrentFile, error) {
 	pieceHashes, err := bto.Info.splitPieceHashes()
 	if err != nil {
 		return nil, err
@@ -20,23 +13,28 @@ func (bto *bencodeTorrentSingleFile) toTorrentFile() (*TorrentFile, error) {
 	t := TorrentFile{
 		Announce:    bto.Announce,
 		AnnounceList: UnfoldArray(bto.AnnounceList),
-		InfoHash:    infoHash,
+		InfoHash:    sha1.Sum(rawInfo),
 		PieceHashes: pieceHashes,
-		Files: 		[]bencodeTorrentFile{{Path: []string{bto.Info.Name}, Length: bto.Info.Length}},
+		Files: 		[]bencodeTorrentFile{{Path: []string{bto.Info.Name}, Length: bto.Info.Length, Md5sum: bto.Info.Md5sum}},
 		PieceLength: bto.Info.PieceLength,
 		Length:      bto.Info.Length,
 		Name:        bto.Info.Name,
+		RawInfo:     rawInfo,
+		Meta:        TorrentMeta{
+			Comment:      bto.Comment,
+			CreatedBy:    bto.CreatedBy,
+			CreationDate: bto.CreationDate,
+			Encoding:     bto.Encoding,
+			Source:       bto.Info.Source,
+			Private:      bto.Info.Private == 1,
+		},
 		SysInfo:      SystemInfo{},
 		Download:     DownloadUtils{},
 	}
 	return &t, nil
 }
 
-func (bto *bencodeTorrentMultiFiles) toTorrentFile() (*TorrentFile, error) {
-	infoHash, err := bto.Info.hash()
-	if err != nil {
-		return nil, err
-	}
+func (bto *bencodeTorrentMultiFiles) toTorrentFile(rawInfo []byte) (*TorrentFile, error) {
 	pieceHashes, err := bto.Info.splitPieceHashes()
 	if err != nil {
 		return nil, err
@@ -44,38 +42,27 @@ func (bto *bencodeTorrentMultiFiles) toTorrentFile() (*TorrentFile, error) {
 	t := TorrentFile{
 		Announce:     bto.Announce,
 		AnnounceList: UnfoldArray(bto.AnnounceList),
-		InfoHash:     infoHash,
+		InfoHash:     sha1.Sum(rawInfo),
 		PieceHashes:  pieceHashes,
 		PieceLength:  bto.Info.PieceLength,
 		Length:       bto.SumFilesLength(),
 		Files:        bto.Info.Files,
 		Name:         bto.Info.Name,
+		RawInfo:      rawInfo,
+		Meta:         TorrentMeta{
+			Comment:      bto.Comment,
+			CreatedBy:    bto.CreatedBy,
+			CreationDate: bto.CreationDate,
+			Encoding:     bto.Encoding,
+			Source:       bto.Info.Source,
+			Private:      bto.Info.Private == 1,
+		},
 		SysInfo:      SystemInfo{},
 		Download:     DownloadUtils{},
 	}
 	return &t, nil
 }
 
-func (i *bencodeInfoSingleFile) hash() ([20]byte, error) {
-	var buf bytes.Buffer
-	err := bencode.Marshal(&buf, *i)
-	if err != nil {
-		return [20]byte{}, err
-	}
-	h := sha1.Sum(buf.Bytes())
-	return h, nil
-}
-
-func (i *bencodeInfoMultiFiles) hash() ([20]byte, error) {
-	var buf bytes.Buffer
-	err := bencode.Marshal(&buf, *i)
-	if err != nil {
-		return [20]byte{}, err
-	}
-	h := sha1.Sum(buf.Bytes())
-	return h, nil
-}
-
 func (i *bencodeInfoSingleFile) splitPieceHashes() ([][20]byte, error) {
 	hashLen := 20 // Length of SHA-1 hash
 	buf := []byte(i.Pieces)
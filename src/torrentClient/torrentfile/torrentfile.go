@@ -3,7 +3,9 @@ package torrentfile
 import (
 	"context"
 	"crypto/rand"
+	"encoding/hex"
 	"fmt"
+	"strings"
 
 	"torrentClient/db"
 	"torrentClient/fsWriter"
@@ -25,11 +27,16 @@ func (t *TorrentFile) DownloadToFile() error {
 	db.GetFilesManagerDb().SetInProgressStatusForRecord(fileId, true)
 	defer db.GetFilesManagerDb().SetInProgressStatusForRecord(fileId, false)
 
-	loadEntry, ok := loadMaster.GetMaster().AddLoadEntry(fileId, downloadCancel, len(t.PieceHashes))
+	t.CreateFileBoundariesMapping()
+	wantedPieces := t.getWantedPieces()
+	totalPieces, totalLength := t.countWanted(wantedPieces)
+
+	loadEntry, ok := loadMaster.GetMaster().AddLoadEntry(fileId, downloadCancel, totalPieces, totalLength)
 	if !ok {
 		logrus.Debugf("Failed to add loading entry (propably, file is already in progress)")
 		return fmt.Errorf("failed to add loading entry")
 	}
+	go loadEntry.StartProgressReporting(downloadCtx)
 
 	t.InitMyPeerIDAndPort()
 
@@ -42,8 +49,8 @@ func (t *TorrentFile) DownloadToFile() error {
 	go peersPoolObj.StartRefreshing(poolCtx)
 
 	priorityManager := LoadPriority{torrentFile: t}
-
-	t.CreateFileBoundariesMapping()
+	videoFile := t.getHeaviestFile()
+	prefetcher := newIndexPrefetcher(t, videoFile.EncodeFileName())
 
 	torrent := p2p.TorrentMeta{
 		ActivatedClientsChan:     peersPoolObj.ClientMaker.InitializedPeersChan,
@@ -58,18 +65,22 @@ func (t *TorrentFile) DownloadToFile() error {
 		FileId:                   fileId,
 		ResultsChan:              make(chan p2p.LoadedPiece, 100),
 		LoadStats:                loadEntry,
+		WantedPieces:             wantedPieces,
+		StartPiece:               prefetcher.FirstPiece(),
+		PrefetchPieces:           prefetcher.PiecesChan,
 	}
 	db.GetFilesManagerDb().PreparePlaceForFile(torrent.FileId)
 
-	videoFile := t.getHeaviestFile()
 	db.GetFilesManagerDb().SetFileNameForRecord(fileId, videoFile.EncodeFileName())
 
-	go t.WaitForDataAndWriteToDisk(downloadCtx, torrent.ResultsChan)
+	go t.WaitForDataAndWriteToDisk(downloadCtx, torrent.ResultsChan, prefetcher)
 
 	if err := torrent.Download(downloadCtx); err != nil {
+		loadEntry.Finish(err)
 		return fmt.Errorf("file download error: %v", err)
 	}
 	db.GetFilesManagerDb().SetLoadedStatusForRecord(fileId, true)
+	loadEntry.Finish(nil)
 	logrus.Infof("Download for %v completed!", fileId)
 	return nil
 }
@@ -80,11 +91,29 @@ func (t *TorrentFile) PrepareDownload() (string, int64) {
 	for _, subFile := range subtitlesFiles {
 		fsWriter.GetWriter().CreateEmptyFile(subFile.EncodeFileName())
 	}
-	fsWriter.GetWriter().CreateEmptyFile(videoFile.EncodeFileName())
+	// любую серию можно запросить по индексу, поэтому готовим все видео
+	for _, episode := range t.GetEpisodes() {
+		fsWriter.GetWriter().CreateEmptyFile(episode.FileName)
+	}
 	db.GetFilesManagerDb().SetVideoFileNameAndLengthForRecord(t.GetFileId(), videoFile.EncodeFileName(), int64(videoFile.Length))
+	db.GetFilesManagerDb().SetOriginalFileNameForRecord(t.GetFileId(), videoFile.OriginalName(), strings.ToLower(videoFile.Extension()))
+	db.GetFilesManagerDb().SetDiskFilesForRecord(t.GetFileId(), t.diskFileNames())
+	infoHash := t.GetInfoHash()
+	db.GetFilesManagerDb().SetInfoHashForRecord(t.GetFileId(), hex.EncodeToString(infoHash[:]))
 	return videoFile.EncodeFileName(), int64(videoFile.Length)
 }
 
+// diskFileNames are names of all torrent files in FILES_DIR, not wanted ones
+// may be there too
+func (t *TorrentFile) diskFileNames() []string {
+	files := t.GetFiles()
+	names := make([]string, 0, len(files))
+	for _, file := range files {
+		names = append(names, file.EncodeFileName())
+	}
+	return names
+}
+
 func (t *TorrentFile) GetVideoFileName() string {
 	videoFile := t.getHeaviestFile()
 	return videoFile.EncodeFileName()
@@ -119,30 +148,28 @@ func (t *TorrentFile) getHeaviestFile() bencodeTorrentFile {
 		return allFiles[0]
 	}
 
-	longest := allFiles[0]
+	longest := bencodeTorrentFile{}
+	longestIsVideo := false
 
-	for _, file := range allFiles {
-		if file.Length > longest.Length {
+	// видео важнее любого размера, остальные файлы - только если видео нет
+	for idx, file := range allFiles {
+		if !t.IsFileSelected(idx) {
+			continue
+		}
+		isVideo := file.MediaType() == MediaVideo
+		if longest.Path == nil || (isVideo && !longestIsVideo) ||
+			(isVideo == longestIsVideo && file.Length > longest.Length) {
 			longest = file
+			longestIsVideo = isVideo
 		}
 	}
 
 	return longest
 }
 
-func (t *TorrentFile) getSubtitlesFiles() []bencodeTorrentFile {
-	allFiles := t.GetFiles()
-	res := make([]bencodeTorrentFile, len(allFiles))
-	for _, file := range allFiles {
-		if file.Extension() == "srt" {
-			res = append(res, file)
-		}
-	}
-
-	return res
-}
-
-func (t *TorrentFile) WaitForDataAndWriteToDisk(ctx context.Context, dataParts chan p2p.LoadedPiece) {
+// WaitForDataAndWriteToDisk splits loaded pieces by files, the prefetcher may be
+// nil when pieces are restored from db
+func (t *TorrentFile) WaitForDataAndWriteToDisk(ctx context.Context, dataParts chan p2p.LoadedPiece, prefetcher *indexPrefetcher) {
 	fileBoundariesMapping := t.GetFileBoundariesMapping()
 
 	for {
@@ -184,6 +211,9 @@ func (t *TorrentFile) WaitForDataAndWriteToDisk(ctx context.Context, dataParts c
 				//logrus.Debugf("Write task: name=%v, offset=%v, slice=(%v:%v)", writeTask.FileName, writeTask.Offset, sliceStart, sliceEnd)
 				data := loaded.Data[sliceStart:sliceEnd]
 				fsWriter.GetWriter().AddToWriteQue(file.FileName, data, offset)
+				if prefetcher != nil {
+					prefetcher.OnFileData(file.FileName, offset, data)
+				}
 			}
 		}
 	}
@@ -199,7 +229,7 @@ func (t *TorrentFile) SaveLoadedPiecesToFS() error {
 
 	loadCtx := context.TODO()
 
-	go t.WaitForDataAndWriteToDisk(loadCtx, writePartsChan)
+	go t.WaitForDataAndWriteToDisk(loadCtx, writePartsChan, nil)
 
 	for {
 		loadedData := <- loadChan
@@ -217,6 +247,45 @@ func (t *TorrentFile) SaveLoadedPiecesToFS() error {
 	return nil
 }
 
+// getWantedPieces returns nil if all files are selected, otherwise marks pieces
+// which cover selected files
+func (t *TorrentFile) getWantedPieces() []bool {
+	if len(t.SelectedFiles) == 0 {
+		return nil
+	}
+
+	pieceLength := int64(t.GetPieceLength())
+	wanted := make([]bool, len(t.GetPieceHashes()))
+
+	for _, file := range t.GetFileBoundariesMapping() {
+		if !t.IsFileSelected(file.Index) || file.End == file.Start {
+			continue
+		}
+		for idx := file.Start / pieceLength; idx <= (file.End - 1) / pieceLength && idx < int64(len(wanted)); idx++ {
+			wanted[idx] = true
+		}
+	}
+	return wanted
+}
+
+func (t *TorrentFile) countWanted(wantedPieces []bool) (pieces int, length int) {
+	if wantedPieces == nil {
+		return len(t.PieceHashes), t.Length
+	}
+	for idx, wanted := range wantedPieces {
+		if !wanted {
+			continue
+		}
+		pieces++
+		if pieceEnd := (idx + 1) * t.PieceLength; pieceEnd > t.Length {
+			length += t.Length - idx * t.PieceLength
+		} else {
+			length += t.PieceLength
+		}
+	}
+	return pieces, length
+}
+
 func (t *TorrentFile) CreateFileBoundariesMapping() {
 	files := t.GetFiles()
 	t.SetFileBoundariesMapping(make([]FileBoundaries, len(files)))